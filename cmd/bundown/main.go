@@ -29,6 +29,8 @@ const (
 	publicKeyPathFlag      = "pub"
 	tagsFlag               = "tags"
 	skipPresentBundlesFlag = "skip-present-bundles"
+	tlsConfigFlag          = "tls-config"
+	credentialsFlag        = "credentials"
 )
 
 const (
@@ -44,6 +46,8 @@ type Flags struct {
 	publicKeyPath        string
 	tags                 []string
 	skipPresentBundles   bool
+	tlsConfigPath        string
+	credentialsPath      string
 }
 
 func main() {
@@ -52,15 +56,33 @@ func main() {
 		resources.PublicRsaKeys = resources.ReadPublicRsaKeysAsset(string(system.MustReadFile(flags.publicKeyPath)))
 	}
 	deploymentConfig := config.ParseDeploymentConfig(mustReaderForFile(flags.deploymentConfigPath), flags.os, flags.arch)
-	downloadBundles(deploymentConfig, flags.outDirPath, flags.tags, flags.skipPresentBundles)
+	downloadBundles(deploymentConfig, flags.outDirPath, flags.tags, flags.skipPresentBundles, flags.tlsConfigPath, flags.credentialsPath)
 }
 
-func downloadBundles(deploymentConfig *config.DeploymentConfig, outDirPath string, tags []string, skipPresentBundles bool) {
+func downloadBundles(deploymentConfig *config.DeploymentConfig, outDirPath string, tags []string, skipPresentBundles bool, tlsConfigPath, credentialsPath string) {
 	outDirPathAbs, err := filepath.Abs(outDirPath)
 	if err != nil {
 		fatalf("%v", err)
 	}
 	downloader := fetching.NewDownloader(context.Background(), &fetching.ConsoleDownloadProgressHandler{})
+	if tlsConfigPath != "" {
+		tlsConfig, err := config.ReadTLSConfig(tlsConfigPath)
+		if err != nil {
+			fatalf("Could not read TLS settings: %v", err)
+		}
+		if err = downloader.SetTLSConfig(tlsConfig); err != nil {
+			fatalf("Could not apply TLS settings from \"%s\": %v", tlsConfigPath, err)
+		}
+	}
+	if credentialsPath != "" {
+		credentials, err := config.ReadCredentials(credentialsPath)
+		if err != nil {
+			fatalf("Could not read credentials: %v", err)
+		}
+		if err = downloader.SetCredentials(credentials); err != nil {
+			fatalf("Could not apply credentials from \"%s\": %v", credentialsPath, err)
+		}
+	}
 	for _, bundle := range deploymentConfig.Bundles {
 		if shouldDownloadBundle(bundle.Tags, tags) {
 			if skipPresentBundles && isFolder(filepath.Join(outDirPathAbs, bundle.LocalDirectory)) {
@@ -82,7 +104,13 @@ func downloadBundle(downloader *fetching.Downloader, fromURL string, bundleInfoU
 		fatalf("Could not download bundle info from \"%s\": %v", bundleInfoURL, err)
 	}
 	bundleInfo := config.ReadInfoFromByteSlice(bundleInfoData)
-	downloader.MustDownloadToDirectory(fromURL, bundleInfo.BundleFiles, toFolder)
+	downloader.MustDownloadToDirectory(fromURL, bundleInfo.GetFileHashes(), toFolder)
+	for _, directoryPath := range bundleInfo.Directories {
+		system.MustMakeDir(filepath.Join(toFolder, filepath.FromSlash(directoryPath)))
+	}
+	for linkPath, target := range bundleInfo.Symlinks {
+		system.MustCreateSymlink(filepath.Join(toFolder, filepath.FromSlash(linkPath)), filepath.FromSlash(target))
+	}
 }
 
 func shouldDownloadBundle(bundleTags []string, allowedTags []string) bool {
@@ -114,6 +142,10 @@ func parseFlags() *Flags {
 		"The special tag '"+tagUntagged+"' implicitly exists on all bundles without tags. The special tag '"+tagAll+"' "+
 		"will instruct bundown to download all bundles regardless of tags.")
 	skipPresentBundles := flag.Bool(skipPresentBundlesFlag, false, "If set, skip download of bundles the corresponding directory of which already exist under --out.")
+	tlsConfigPath := flag.String(tlsConfigFlag, "", "Path to a JSON-file with CA certificates and client certificates to use for downloads, "+
+		"in the format of the TLS field of the launcher-config. (optional)")
+	credentialsPath := flag.String(credentialsFlag, "", "Path to a JSON-file with credentials to use for downloads, "+
+		"in the format of the Credentials field of the launcher-config. (optional)")
 	flag.Parse()
 
 	if *deploymentConfig == "" {
@@ -140,6 +172,8 @@ func parseFlags() *Flags {
 		publicKeyPath:        *publicKeyPath,
 		tags:                 strings.Split(*tags, ","),
 		skipPresentBundles:   *skipPresentBundles,
+		tlsConfigPath:        *tlsConfigPath,
+		credentialsPath:      *credentialsPath,
 	}
 }
 
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+// mustHashChunks records the SHA-256 hashes of the chunks of the given size of every file in bundleFiles which is larger than
+// one chunk and not transferred compressed, so that the launcher can detect corrupt chunks while downloading them in parallel.
+func mustHashChunks(bundleFiles config.FileInfoMap, pathToHash string, chunkSize int64) {
+	log.WithFields(log.Fields{"chunkSize": chunkSize}).Info("Hashing chunks.")
+	for filePath, fileInfo := range bundleFiles {
+		if fileInfo.Compression != "" || fileInfo.Size <= chunkSize {
+			continue
+		}
+		fileInfo.ChunkSize = chunkSize
+		fileInfo.ChunkSHA256s = mustHashFileChunks(filepath.Join(pathToHash, filePath), fileInfo.ChunkCount(chunkSize), chunkSize)
+	}
+}
+
+func mustHashFileChunks(filePath string, chunkCount int, chunkSize int64) []string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Panicf("Cannot open \"%s\": %v", filePath, err)
+	}
+	defer file.Close()
+	chunkSHA256s := make([]string, 0, chunkCount)
+	for i := 0; i < chunkCount; i++ {
+		hash := sha256.New()
+		if _, err = io.CopyN(hash, file, chunkSize); err != nil && !(err == io.EOF && i == chunkCount-1) {
+			log.Panicf("Cannot read chunk %d of \"%s\": %v", i, filePath, err)
+		}
+		chunkSHA256s = append(chunkSHA256s, hex.EncodeToString(hash.Sum(nil)))
+	}
+	return chunkSHA256s
+}
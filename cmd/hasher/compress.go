@@ -0,0 +1,71 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+// mustCompressFiles writes a compressed copy of every file in bundleFiles next to it and records the compression in bundleFiles.
+// Compressed copies which are not smaller than their file are removed again.
+func mustCompressFiles(bundleFiles config.FileInfoMap, pathToHash, compression string) {
+	extension := config.CompressedFileExtension(compression)
+	for filePath := range bundleFiles {
+		if _, ok := bundleFiles[filePath+extension]; ok {
+			log.Panicf("Cannot compress \"%s\" because the bundle already contains \"%s\".", filePath, filePath+extension)
+		}
+	}
+	log.WithFields(log.Fields{"compression": compression}).Info("Compressing files.")
+	for filePath, fileInfo := range bundleFiles {
+		compressedFilePath := filepath.Join(pathToHash, filePath+extension)
+		compressedSize := mustCompressFile(filepath.Join(pathToHash, filePath), compressedFilePath, compression)
+		if compressedSize >= fileInfo.Size {
+			if err := os.Remove(compressedFilePath); err != nil {
+				log.Panicf("Cannot remove \"%s\": %v", compressedFilePath, err)
+			}
+			continue
+		}
+		fileInfo.Compression = compression
+		fileInfo.CompressedSize = compressedSize
+	}
+}
+
+func mustCompressFile(filePath, compressedFilePath, compression string) (compressedSize int64) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Panicf("Cannot open file: %v", err)
+	}
+	defer file.Close()
+	compressedFile, err := os.OpenFile(compressedFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Panicf("Cannot create file: %v", err)
+	}
+	defer compressedFile.Close()
+	var compressor io.WriteCloser
+	switch compression {
+	case config.CompressionGzip:
+		compressor, err = gzip.NewWriterLevel(compressedFile, gzip.BestCompression)
+	case config.CompressionZstd:
+		compressor, err = zstd.NewWriter(compressedFile, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	}
+	if err != nil {
+		log.Panicf("Cannot create compressor: %v", err)
+	}
+	if _, err = io.Copy(compressor, file); err != nil {
+		log.Panicf("Cannot compress \"%s\": %v", filePath, err)
+	}
+	if err = compressor.Close(); err != nil {
+		log.Panicf("Cannot compress \"%s\": %v", filePath, err)
+	}
+	compressedSize, err = compressedFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Panicf("Cannot determine size of \"%s\": %v", compressedFilePath, err)
+	}
+	return compressedSize
+}
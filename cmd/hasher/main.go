@@ -1,28 +1,59 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/setlog/trivrost/pkg/delta"
 	"github.com/setlog/trivrost/pkg/launcher/config"
 	"github.com/setlog/trivrost/pkg/launcher/hashing"
+	"github.com/setlog/trivrost/pkg/system"
 )
 
 const timeFormat = "2006-01-02 15:04:05"
 const bundlefilename = "/bundleinfo.json"
 
+type pathList []string
+
+func (paths *pathList) String() string {
+	return strings.Join(*paths, ", ")
+}
+
+func (paths *pathList) Set(value string) error {
+	*paths = append(*paths, value)
+	return nil
+}
+
 func main() {
+	var previousPaths pathList
+	flag.Var(&previousPaths, "previous", "Path to a directory with a previous version of the bundle, including its bundle info file. "+
+		"Patches from its files to the changed files are added to the bundle. Can be given multiple times.")
+	compression := flag.String("compress", "", "Compress files with this algorithm (\""+config.CompressionGzip+"\" or \""+config.CompressionZstd+"\") for transfer. "+
+		"Compressed files are written next to the original ones. Files which do not get smaller are left uncompressed.")
+	chunkSizeMiB := flag.Int("chunk-size", 0, "List hashes of chunks of this many MiB of large files, so that corrupt chunks are detected while downloading them in parallel.")
+	packSizeMiB := flag.Int("pack-size", 0, "Pack the smaller files into tar-archives of up to this many MiB, so that installing them takes few requests.")
+	hashAlgorithm := flag.String("hash-algorithm", config.DefaultHashAlgorithm, "Hash files with this algorithm (\""+config.HashAlgorithmSHA256+"\", \""+
+		config.HashAlgorithmSHA512+"\" or \""+config.HashAlgorithmBLAKE3+"\"). Launchers which predate support for it cannot install the bundle.")
 	flag.Parse()
 	if flag.NArg() != 2 {
 		fmt.Println("Hasher expects exactly two parameters.")
 		fmt.Println("The first parameter is the unique bundle name.")
 		fmt.Println("The second parameter is the path to the directory to hash.")
+		fmt.Println("Use -previous to create patches from previous versions of the bundle.")
+		fmt.Println("Use -compress to compress files for transfer.")
+		fmt.Println("Use -chunk-size to list hashes of chunks of large files.")
+		fmt.Println("Use -pack-size to pack small files into archives.")
+		fmt.Println("Use -hash-algorithm to hash files with another algorithm than " + config.DefaultHashAlgorithm + ".")
 
 		log.Info("Wrong number of arguments for hasher. Stopping.")
 
@@ -32,12 +63,24 @@ func main() {
 	uniqueBundleName := flag.Arg(0)
 	pathToHash := flag.Arg(1)
 	hashesFile := filepath.Join(pathToHash, bundlefilename)
-	mustHashDirectory(uniqueBundleName, pathToHash, hashesFile)
+	if *compression != "" && config.CompressedFileExtension(*compression) == "" {
+		log.Fatalf("Unsupported compression \"%s\".", *compression)
+	}
+	if *chunkSizeMiB < 0 {
+		log.Fatalf("Chunk size must not be negative.")
+	}
+	if *packSizeMiB < 0 {
+		log.Fatalf("Pack size must not be negative.")
+	}
+	if *hashAlgorithm == "" || !config.IsSupportedHashAlgorithm(*hashAlgorithm) {
+		log.Fatalf("Unsupported hash algorithm \"%s\".", *hashAlgorithm)
+	}
+	mustHashDirectory(uniqueBundleName, pathToHash, hashesFile, previousPaths, *hashAlgorithm, *compression, int64(*chunkSizeMiB)*1024*1024, int64(*packSizeMiB)*1024*1024)
 
 	log.Info("Finished hasher.")
 }
 
-func mustHashDirectory(uniqueBundleName, pathToHash, hashesFile string) {
+func mustHashDirectory(uniqueBundleName, pathToHash, hashesFile string, previousPaths []string, hashAlgorithm, compression string, chunkSize, packSize int64) {
 	log.WithFields(log.Fields{"uniqueBundleName": uniqueBundleName, "pathToHash": pathToHash, "hashesFile": hashesFile}).Info("Hashing directory.")
 	pathInfo, err := os.Stat(pathToHash)
 	if err != nil {
@@ -51,12 +94,120 @@ func mustHashDirectory(uniqueBundleName, pathToHash, hashesFile string) {
 		log.Panicf("Found existing \"%s\", aborting!", filepath.Join(pathToHash, bundlefilename))
 	}
 	bundleInfo := &config.BundleInfo{
-		BundleFiles:      hashing.MustHash(context.Background(), pathToHash),
+		BundleFiles:      hashing.MustHash(context.Background(), pathToHash, hashAlgorithm),
 		Timestamp:        time.Now().UTC().Format(timeFormat),
 		UniqueBundleName: uniqueBundleName,
 	}
+	if hashAlgorithm != config.DefaultHashAlgorithm {
+		bundleInfo.HashAlgorithm = hashAlgorithm // Omitted otherwise, so that launchers which predate it can read the bundle info.
+	}
+	for filePath := range bundleInfo.BundleFiles {
+		if isGeneratedPath(filepath.ToSlash(filePath)) {
+			delete(bundleInfo.BundleFiles, filePath) // Left behind by an earlier run.
+		}
+	}
+	addSymlinksAndDirectories(bundleInfo, pathToHash)
 	if len(bundleInfo.BundleFiles) == 0 {
 		log.Panicf("No files to hash at %v", pathToHash)
 	}
+	if compression != "" {
+		mustCompressFiles(bundleInfo.BundleFiles, pathToHash, compression)
+	}
+	if chunkSize > 0 {
+		mustHashChunks(bundleInfo.BundleFiles, pathToHash, chunkSize)
+	}
+	for _, previousPath := range previousPaths {
+		mustCreatePatches(bundleInfo.BundleFiles, pathToHash, previousPath, hashAlgorithm)
+	}
+	if packSize > 0 {
+		mustPackFiles(bundleInfo, pathToHash, packSize, compression)
+	}
+	bundleInfo.MustValidate()
 	config.WriteInfo(bundleInfo, hashesFile)
 }
+
+// isGeneratedPath returns true if the forward-slashed path lies in one of the directories the hasher writes patches and packs to.
+func isGeneratedPath(slashedPath string) bool {
+	return strings.HasPrefix(slashedPath, config.PatchDirectoryName+"/") || strings.HasPrefix(slashedPath, config.PackDirectoryName+"/") ||
+		slashedPath == config.PatchDirectoryName || slashedPath == config.PackDirectoryName
+}
+
+// addSymlinksAndDirectories records the symlinks and the empty directories in pathToHash. Symlinks whose targets are absolute
+// or lead outside of pathToHash are rejected once the bundle info is validated.
+func addSymlinksAndDirectories(bundleInfo *config.BundleInfo, pathToHash string) {
+	linksAndDirectories := hashing.MustListLinksAndDirectories(pathToHash)
+	for linkPath, target := range linksAndDirectories.Symlinks {
+		if !isGeneratedPath(linkPath) {
+			if bundleInfo.Symlinks == nil {
+				bundleInfo.Symlinks = make(map[string]string)
+			}
+			bundleInfo.Symlinks[linkPath] = target
+		}
+	}
+	for _, directoryPath := range linksAndDirectories.EmptyDirectories {
+		if !isGeneratedPath(directoryPath) {
+			bundleInfo.Directories = append(bundleInfo.Directories, directoryPath)
+		}
+	}
+	if len(bundleInfo.Symlinks) > 0 || len(bundleInfo.Directories) > 0 {
+		log.Infof("Recorded %d symlinks and %d empty directories.", len(bundleInfo.Symlinks), len(bundleInfo.Directories))
+	}
+}
+
+// mustCreatePatches creates patches from the files of the bundle version in previousPath to the changed files in pathToHash
+// and adds them to bundleFiles. Patches which would not be smaller than the files they create are omitted, as are all patches
+// from a version whose bundle info uses another hash algorithm, since launchers could not tell which files they apply to.
+func mustCreatePatches(bundleFiles config.FileInfoMap, pathToHash, previousPath, hashAlgorithm string) {
+	previousBundleInfo := config.ReadInfo(filepath.Join(previousPath, bundlefilename))
+	if previousHashAlgorithm := config.NormalizeHashAlgorithm(previousBundleInfo.HashAlgorithm); previousHashAlgorithm != hashAlgorithm {
+		log.Warnf("Not creating patches from \"%s\" because its files have been hashed with %s instead of %s.", previousPath, previousHashAlgorithm, hashAlgorithm)
+		return
+	}
+	previousBundleFiles := previousBundleInfo.BundleFiles
+	log.WithFields(log.Fields{"previousPath": previousPath}).Info("Creating patches.")
+	for filePath, fileInfo := range bundleFiles {
+		previousFileInfo, ok := previousBundleFiles[filepath.ToSlash(filePath)]
+		if !ok || strings.EqualFold(previousFileInfo.Hash, fileInfo.Hash) || fileInfo.PatchFrom(previousFileInfo.Hash) != nil {
+			continue
+		}
+		patchInfo := mustCreatePatch(filepath.Join(previousPath, filePath), previousFileInfo, filepath.Join(pathToHash, filePath), fileInfo,
+			filepath.Join(pathToHash, filepath.FromSlash(config.PatchFilePath(previousFileInfo.Hash, fileInfo.Hash))))
+		if patchInfo != nil {
+			if fileInfo.Patches == nil {
+				fileInfo.Patches = make(map[string]*config.FileInfo)
+			}
+			fileInfo.Patches[strings.ToLower(previousFileInfo.Hash)] = patchInfo
+		}
+	}
+}
+
+func mustCreatePatch(oldFilePath string, oldFileInfo *config.FileInfo, newFilePath string, newFileInfo *config.FileInfo, patchFilePath string) *config.FileInfo {
+	oldData, err := ioutil.ReadFile(oldFilePath)
+	if err != nil {
+		log.Panicf("Cannot read previous version of file: %v", err)
+	}
+	if !strings.EqualFold(hashOf(oldData, newFileInfo.HashAlgorithm), oldFileInfo.Hash) {
+		log.Panicf("\"%s\" does not match its bundle info file.", oldFilePath)
+	}
+	newData, err := ioutil.ReadFile(newFilePath)
+	if err != nil {
+		log.Panicf("Cannot read file: %v", err)
+	}
+	patch := &bytes.Buffer{}
+	if err = delta.Create(patch, oldData, newData); err != nil {
+		log.Panicf("Cannot create patch from \"%s\" to \"%s\": %v", oldFilePath, newFilePath, err)
+	}
+	if int64(patch.Len()) >= newFileInfo.Size {
+		log.Infof("Omitting patch from \"%s\" to \"%s\" because it is not smaller than the file.", oldFilePath, newFilePath)
+		return nil
+	}
+	system.MustPutFile(patchFilePath, patch.Bytes())
+	log.Infof("Created patch \"%s\" with %d bytes for \"%s\" with %d bytes.", patchFilePath, patch.Len(), newFilePath, newFileInfo.Size)
+	return &config.FileInfo{Hash: hashOf(patch.Bytes(), newFileInfo.HashAlgorithm), Size: int64(patch.Len())}
+}
+
+func hashOf(data []byte, hashAlgorithm string) string {
+	hash := config.NewHash(hashAlgorithm)
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
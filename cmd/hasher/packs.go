@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+// mustPackFiles writes the files of bundleInfo which are smaller than packSize, in the order of their paths, into packs of
+// at most packSize bytes each and lists the packs in bundleInfo. If compression is set, packs are compressed as a whole
+// for transfer, unless that does not make them smaller. Packs which would hold a single file are omitted.
+func mustPackFiles(bundleInfo *config.BundleInfo, pathToHash string, packSize int64, compression string) {
+	log.WithFields(log.Fields{"packSize": packSize}).Info("Packing files.")
+	filePaths := make([]string, 0, len(bundleInfo.BundleFiles))
+	for filePath, fileInfo := range bundleInfo.BundleFiles {
+		if fileInfo.Size < packSize {
+			filePaths = append(filePaths, filepath.ToSlash(filePath))
+		}
+	}
+	sort.Strings(filePaths)
+	bundleInfo.Packs = make(config.PackInfoMap)
+	var packFilePaths []string
+	var packFilesSize int64
+	for i, filePath := range filePaths {
+		packFilePaths = append(packFilePaths, filePath)
+		packFilesSize += bundleInfo.BundleFiles[filepath.FromSlash(filePath)].Size
+		if i+1 == len(filePaths) || packFilesSize+bundleInfo.BundleFiles[filepath.FromSlash(filePaths[i+1])].Size > packSize {
+			if len(packFilePaths) > 1 {
+				packPath := fmt.Sprintf("%s/pack-%d.tar", config.PackDirectoryName, len(bundleInfo.Packs))
+				bundleInfo.Packs[packPath] = mustWritePack(pathToHash, packPath, packFilePaths, compression)
+			}
+			packFilePaths, packFilesSize = nil, 0
+		}
+	}
+}
+
+func mustWritePack(pathToHash, packPath string, filePaths []string, compression string) *config.PackInfo {
+	packFilePath := filepath.Join(pathToHash, filepath.FromSlash(packPath))
+	system.MustMakeDir(filepath.Dir(packFilePath))
+	packFile, err := os.OpenFile(packFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Panicf("Cannot create file: %v", err)
+	}
+	defer packFile.Close()
+	tarWriter := tar.NewWriter(packFile)
+	for _, filePath := range filePaths {
+		mustWritePackEntry(tarWriter, filepath.Join(pathToHash, filepath.FromSlash(filePath)), filePath)
+	}
+	if err = tarWriter.Close(); err != nil {
+		log.Panicf("Cannot write pack \"%s\": %v", packFilePath, err)
+	}
+	size, err := packFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Panicf("Cannot determine size of \"%s\": %v", packFilePath, err)
+	}
+	packInfo := &config.PackInfo{Size: size, Files: filePaths}
+	if compression != "" {
+		compressedFilePath := packFilePath + config.CompressedFileExtension(compression)
+		if compressedSize := mustCompressFile(packFilePath, compressedFilePath, compression); compressedSize < size {
+			packInfo.Compression, packInfo.CompressedSize = compression, compressedSize
+		} else if err = os.Remove(compressedFilePath); err != nil {
+			log.Panicf("Cannot remove \"%s\": %v", compressedFilePath, err)
+		}
+	}
+	log.Infof("Created pack \"%s\" with %d files and %d bytes.", packFilePath, len(filePaths), size)
+	return packInfo
+}
+
+func mustWritePackEntry(tarWriter *tar.Writer, filePath, name string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Panicf("Cannot open \"%s\": %v", filePath, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		log.Panicf("Cannot stat \"%s\": %v", filePath, err)
+	}
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err = tarWriter.WriteHeader(header); err != nil {
+		log.Panicf("Cannot add \"%s\" to pack: %v", filePath, err)
+	}
+	if _, err = io.Copy(tarWriter, file); err != nil {
+		log.Panicf("Cannot add \"%s\" to pack: %v", filePath, err)
+	}
+}
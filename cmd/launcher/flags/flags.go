@@ -18,6 +18,9 @@ type LauncherFlags struct {
 	Roaming          bool
 	PrintBuildTime   bool
 	DeploymentConfig string
+	BandwidthLimit   int
+	OfflineSource    string
+	VerifyBundles    bool
 
 	AcceptInstall      bool
 	AcceptUninstall    bool
@@ -39,6 +42,9 @@ const (
 	RoamingFlag          = "roaming"
 	PrintBuildTimeFlag   = "build-time"
 	DeploymentConfigFlag = "deployment-config"
+	BandwidthLimitFlag   = "bandwidth-limit"
+	OfflineSourceFlag    = "offline-source"
+	VerifyBundlesFlag    = "verify-bundles"
 
 	AcceptInstallFlag      = "accept-install"
 	AcceptUninstallFlag    = "accept-uninstall"
@@ -68,6 +74,9 @@ func Setup(args []string) (*LauncherFlags, error) {
 	flagSet.BoolVar(&launcherFlags.PrintBuildTime, PrintBuildTimeFlag, false, "Print the output of 'date -u \"+%Y-%m-%d %H:%M:%S UTC\"' from the time the binary "+
 		"was built to standard out and exit immediately.")
 	flagSet.StringVar(&launcherFlags.DeploymentConfig, DeploymentConfigFlag, "", "Override the embedded URL of the deployment-config.")
+	flagSet.IntVar(&launcherFlags.BandwidthLimit, BandwidthLimitFlag, 0, "Limit downloads to this many KiB per second, overriding any limits in the deployment-config.")
+	flagSet.StringVar(&launcherFlags.OfflineSource, OfflineSourceFlag, "", "Take the deployment from this directory, .zip- or .tar-archive instead of downloading it.")
+	flagSet.BoolVar(&launcherFlags.VerifyBundles, VerifyBundlesFlag, false, "Hash all files of the bundles instead of only those which changed since they have last been hashed.")
 
 	flagSet.BoolVar(&launcherFlags.AcceptInstall, AcceptInstallFlag, false, fmt.Sprintf("Accept install prompt when it is dismissed. Use with -%s.", DismissGuiPromptsFlag))
 	flagSet.BoolVar(&launcherFlags.AcceptUninstall, AcceptUninstallFlag, false, fmt.Sprintf("Accept uninstall prompt when it is dismissed. Use with -%s.", DismissGuiPromptsFlag))
@@ -83,6 +92,10 @@ func Setup(args []string) (*LauncherFlags, error) {
 	}
 	launcherFlags.ExtraEnvs = parseExtraEnv(launcherFlags.extraEnvString)
 
+	if launcherFlags.BandwidthLimit < 0 {
+		return &launcherFlags, fmt.Errorf("-%s must not be negative", BandwidthLimitFlag)
+	}
+
 	if !launcherFlags.DismissGuiPrompts && launcherFlags.AcceptInstall {
 		return &launcherFlags, fmt.Errorf("-%s was set when -%s was not", AcceptInstallFlag, DismissGuiPromptsFlag)
 	}
@@ -144,6 +157,15 @@ func (launcherFlags *LauncherFlags) GetTransmittingFlags() (transmittingFlags []
 	if launcherFlags.DeploymentConfig != "" {
 		transmittingFlags = append(transmittingFlags, "-"+DeploymentConfigFlag, launcherFlags.DeploymentConfig)
 	}
+	if launcherFlags.BandwidthLimit > 0 {
+		transmittingFlags = append(transmittingFlags, "-"+BandwidthLimitFlag, strconv.Itoa(launcherFlags.BandwidthLimit))
+	}
+	if launcherFlags.OfflineSource != "" {
+		transmittingFlags = append(transmittingFlags, "-"+OfflineSourceFlag, launcherFlags.OfflineSource)
+	}
+	if launcherFlags.VerifyBundles {
+		transmittingFlags = append(transmittingFlags, "-"+VerifyBundlesFlag)
+	}
 	if launcherFlags.AcceptInstall {
 		transmittingFlags = append(transmittingFlags, "-"+AcceptInstallFlag)
 	}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/andlabs/ui"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/setlog/trivrost/pkg/stats"
 )
 
 const barUpdateInterval = time.Millisecond * 100
@@ -19,6 +21,18 @@ var ProgressFunc = func(s Stage) uint64 {
 	return 0
 }
 
+// ProgressEstimateFunc should be set to a function which samples the progress of the current download stage and
+// returns an estimate of its download rate and remaining time.
+var ProgressEstimateFunc = func() stats.ProgressEstimate {
+	return stats.ProgressEstimate{}
+}
+
+// BandwidthLimitFunc should be set to a function which reports the bandwidth limit of downloads in bytes per second
+// and whether it has recently slowed them down.
+var BandwidthLimitFunc = func() (bytesPerSecond int64, isThrottling bool) {
+	return 0, false
+}
+
 // SetStage sets up the GUI to determine the progress bar value based on the progress
 // interval of the given stage. When progressTotal is >0, you can set gui.ProgressFunc
 // to a function which reports the current progress.
@@ -27,10 +41,9 @@ func SetStage(s Stage, progressTarget uint64) {
 	ui.QueueMain(func() {
 		isStateChange := panelDownloadStatus.stage.IsWaitingStage() != s.IsWaitingStage()
 		panelDownloadStatus.stage = s
-		panelDownloadStatus.progressMovingAverage.Reset()
 		panelDownloadStatus.progressTarget = progressTarget
 		panelDownloadStatus.labelStage.SetText(s.getText())
-		barProgress, percentage := calculateProgress(panelDownloadStatus.stage, panelDownloadStatus.progressMovingAverage.Total(), panelDownloadStatus.progressTarget)
+		barProgress, percentage := calculateProgress(panelDownloadStatus.stage, 0, panelDownloadStatus.progressTarget)
 		window.SetTitle(fmt.Sprintf("[%.1f%%] %s", percentage, windowTitle))
 		panelDownloadStatus.currentProblemMessage = ""
 		panelDownloadStatus.labelStatus.SetText("")
@@ -132,12 +145,9 @@ func updateProgressLabel() {
 	defer uiShutdownMutex.Unlock()
 	if !didQuit {
 		ui.QueueMain(func() {
-			panelDownloadStatus.progressMovingAverage.TakeSample()
-			average := panelDownloadStatus.progressMovingAverage.AveragePerSecondDelta()
-
 			var message string
 			if panelDownloadStatus.stage.IsDownloadStage() {
-				message = fmt.Sprintf("Downloading at %s. ", rateString(average))
+				message = downloadStatusMessage(ProgressEstimateFunc())
 			}
 			if panelDownloadStatus.currentProblemMessage != "" {
 				message += fmt.Sprintf("(%s)", panelDownloadStatus.currentProblemMessage)
@@ -160,17 +170,50 @@ func updateWindowTitle() {
 	}
 }
 
+// downloadStatusMessage describes the download rate, the bytes remaining and the time remaining of estimate.
+func downloadStatusMessage(estimate stats.ProgressEstimate) string {
+	message := fmt.Sprintf("Downloading at %s", rateString(estimate.RatePerSecond))
+	if limit, isThrottling := BandwidthLimitFunc(); isThrottling {
+		message += fmt.Sprintf(" (limited to %s)", rateString(float64(limit)))
+	}
+	if estimate.Total > 0 {
+		message += fmt.Sprintf(", %s left", sizeString(float64(estimate.Remaining)))
+	}
+	if estimate.HasTimeRemaining {
+		message += fmt.Sprintf(", about %s remaining", durationString(estimate.TimeRemaining))
+	}
+	return message + ". "
+}
+
 func rateString(rate float64) string {
-	if rate < 1000 {
-		return fmt.Sprintf("%.0f B/s", rate)
-	} else if rate < 1024*10 {
-		return fmt.Sprintf("%.2f KiB/s", rate/1024)
-	} else if rate < 1024*100 {
-		return fmt.Sprintf("%.1f KiB/s", rate/1024)
-	} else if rate < 1024*1000 {
-		return fmt.Sprintf("%.0f KiB/s", rate/1024)
-	} else if rate < 1024*1024*10 {
-		return fmt.Sprintf("%.2f MiB/s", rate/(1024*1024))
+	return sizeString(rate) + "/s"
+}
+
+func sizeString(size float64) string {
+	if size < 1000 {
+		return fmt.Sprintf("%.0f B", size)
+	} else if size < 1024*10 {
+		return fmt.Sprintf("%.2f KiB", size/1024)
+	} else if size < 1024*100 {
+		return fmt.Sprintf("%.1f KiB", size/1024)
+	} else if size < 1024*1000 {
+		return fmt.Sprintf("%.0f KiB", size/1024)
+	} else if size < 1024*1024*10 {
+		return fmt.Sprintf("%.2f MiB", size/(1024*1024))
+	} else if size < 1024*1024*1000 {
+		return fmt.Sprintf("%.1f MiB", size/(1024*1024))
+	}
+	return fmt.Sprintf("%.2f GiB", size/(1024*1024*1024))
+}
+
+// durationString rounds d up to whole seconds below a minute and to whole minutes above, so that it does not flicker.
+func durationString(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d s", int(math.Ceil(d.Seconds())))
+	}
+	minutes := int(math.Ceil(d.Minutes()))
+	if minutes < 60 {
+		return fmt.Sprintf("%d min", minutes)
 	}
-	return fmt.Sprintf("%.1f MiB/s", rate/(1024*1024))
+	return fmt.Sprintf("%d h %d min", minutes/60, minutes%60)
 }
@@ -1,81 +1,64 @@
 package gui
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 
 	"net/http"
 
+	"github.com/setlog/trivrost/pkg/fetching"
 	"github.com/setlog/trivrost/pkg/logging"
 )
 
 // Implements fetching.DownloadProgressHandler
 type GuiDownloadProgressHandler struct {
-	progressMutex          *sync.RWMutex
-	progressAccumulator    uint64
-	ongoingProgressBuckets []uint64
-	problemUrl             string
-	log                    *logging.LogLimiter
+	*fetching.ProgressEstimatingHandler
+	problemMutex *sync.RWMutex
+	problemUrl   string
+	log          *logging.LogLimiter
 }
 
-func NewGuiDownloadProgressHandler(bucketCount int) *GuiDownloadProgressHandler {
-	return &GuiDownloadProgressHandler{progressMutex: &sync.RWMutex{}, ongoingProgressBuckets: make([]uint64, bucketCount), log: logging.NewLogLimiter(5)}
-}
-
-func (handler *GuiDownloadProgressHandler) ResetProgress() {
-	handler.progressMutex.Lock()
-	defer handler.progressMutex.Unlock()
-	handler.progressAccumulator = 0
-	for i := range handler.ongoingProgressBuckets {
-		handler.ongoingProgressBuckets[i] = 0
-	}
-}
-
-func (handler *GuiDownloadProgressHandler) GetProgress() uint64 {
-	handler.progressMutex.Lock()
-	defer handler.progressMutex.Unlock()
-	currentTotal := handler.progressAccumulator
-	for _, v := range handler.ongoingProgressBuckets {
-		currentTotal += v
-	}
-	return currentTotal
+func NewGuiDownloadProgressHandler() *GuiDownloadProgressHandler {
+	return &GuiDownloadProgressHandler{ProgressEstimatingHandler: fetching.NewProgressEstimatingHandler(), problemMutex: &sync.RWMutex{},
+		log: logging.NewLogLimiter(5)}
 }
 
 func (handler *GuiDownloadProgressHandler) HandleProgress(fromURL string, workerId int, receivedBytes uint64) {
-	handler.progressMutex.RLock()
-	defer handler.progressMutex.RUnlock()
-	handler.ongoingProgressBuckets[workerId] = receivedBytes
+	handler.ProgressEstimatingHandler.HandleProgress(fromURL, workerId, receivedBytes)
+	handler.problemMutex.RLock()
+	defer handler.problemMutex.RUnlock()
 	if fromURL == handler.problemUrl {
 		ClearProblem()
 	}
 }
 
-func (handler *GuiDownloadProgressHandler) HandleStartDownload(fromURL string, workerId int) {
-}
-
-func (handler *GuiDownloadProgressHandler) HandleFinishDownload(fromURL string, workerId int) {
-	handler.progressMutex.Lock()
-	defer handler.progressMutex.Unlock()
-	handler.progressAccumulator += handler.ongoingProgressBuckets[workerId]
-	handler.ongoingProgressBuckets[workerId] = 0
-}
-
 func (handler *GuiDownloadProgressHandler) HandleFailDownload(fromURL string, workerId int, err error) {
+	handler.ProgressEstimatingHandler.HandleFailDownload(fromURL, workerId, err)
 	handler.log.Errorf("GET %s failed: %v", fromURL, err)
-	handler.progressMutex.Lock()
-	defer handler.progressMutex.Unlock()
+	handler.problemMutex.Lock()
+	defer handler.problemMutex.Unlock()
 	handler.problemUrl = fromURL
-	NotifyProblem("Security error", false)
+	var retryLimitError *fetching.RetryLimitError
+	if errors.As(err, &retryLimitError) {
+		NotifyProblem("Download failed", false)
+	} else {
+		NotifyProblem("Security error", false)
+	}
 }
 
 func (handler *GuiDownloadProgressHandler) HandleHttpGetError(fromURL string, err error) {
+	handler.ProgressEstimatingHandler.HandleHttpGetError(fromURL, err)
 	handler.log.Warnf("GET %s could not start: %v", fromURL, err)
-	handler.progressMutex.Lock()
-	defer handler.progressMutex.Unlock()
+	handler.problemMutex.Lock()
+	defer handler.problemMutex.Unlock()
 	handler.problemUrl = fromURL
 
-	if strings.Contains(err.Error(), "x509: ") {
+	var pinningError *fetching.PinningError
+	if errors.As(err, &pinningError) {
+		NotifyProblem("Untrusted public key", false)
+	} else if strings.Contains(err.Error(), "x509: ") {
 		NotifyProblem("Certificate (X.509) problem", false)
 	} else if strings.Contains(err.Error(), "dial tcp: lookup") && strings.Contains(err.Error(), "no such host") {
 		NotifyProblem("Unable to resolve hostname", false)
@@ -85,17 +68,19 @@ func (handler *GuiDownloadProgressHandler) HandleHttpGetError(fromURL string, er
 }
 
 func (handler *GuiDownloadProgressHandler) HandleBadHttpResponse(fromURL string, code int) {
+	handler.ProgressEstimatingHandler.HandleBadHttpResponse(fromURL, code)
 	handler.log.Warnf("GET %s, error %d: %s", fromURL, code, http.StatusText(code))
-	handler.progressMutex.Lock()
-	defer handler.progressMutex.Unlock()
+	handler.problemMutex.Lock()
+	defer handler.problemMutex.Unlock()
 	handler.problemUrl = fromURL
 	NotifyProblem(fmt.Sprintf("HTTP Status %d", code), false)
 }
 
 func (handler *GuiDownloadProgressHandler) HandleReadError(fromURL string, err error, receivedByteCount int64) {
+	handler.ProgressEstimatingHandler.HandleReadError(fromURL, err, receivedByteCount)
 	handler.log.Warnf("GET %s interrupted after receiving %d bytes: %v.", fromURL, receivedByteCount, err)
-	handler.progressMutex.Lock()
-	defer handler.progressMutex.Unlock()
+	handler.problemMutex.Lock()
+	defer handler.problemMutex.Unlock()
 	handler.problemUrl = fromURL
 	NotifyProblem("Connection unstable", false)
 }
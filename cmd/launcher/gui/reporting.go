@@ -37,8 +37,8 @@ func PanicInformatively(r interface{}, launcherFlags *flags.LauncherFlags) {
 func getPanicMessage(r interface{}) string {
 	message := "Something went wrong. The program will now close."
 
-	userError, ok := r.(*misc.UserError)
-	if ok && !misc.IsNil(userError) {
+	var userError *misc.UserError
+	if err, ok := r.(error); ok && errors.As(err, &userError) && !misc.IsNil(userError) {
 		message = userError.Message()
 	}
 
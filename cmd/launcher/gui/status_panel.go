@@ -2,11 +2,8 @@ package gui
 
 import (
 	"github.com/andlabs/ui"
-	"github.com/setlog/trivrost/pkg/stats"
 )
 
-const progressDeltaSampleCount = 3
-
 type DownloadStatusPanel struct {
 	*ui.Box
 
@@ -19,9 +16,8 @@ type DownloadStatusPanel struct {
 	barTotalProgress *ui.ProgressBar
 	labelStatus      *ui.Label
 
-	// Whether these refer to amount of bytes downloaded or something else depends on the current GUI stage.
-	progressMovingAverage *stats.MovingAverage
-	progressTarget        uint64
+	// Whether this refers to amount of bytes downloaded or something else depends on the current GUI stage.
+	progressTarget uint64
 
 	currentProblemMessage string
 	stage                 Stage
@@ -29,9 +25,6 @@ type DownloadStatusPanel struct {
 
 func newDownloadStatusPanel() *DownloadStatusPanel {
 	panel := &DownloadStatusPanel{Box: ui.NewVerticalBox()}
-	panel.progressMovingAverage = stats.NewMovingAverage(progressDeltaSampleCount, labelUpdateInterval, func() uint64 {
-		return ProgressFunc(panel.stage)
-	})
 
 	panel.SetPadded(true)
 
@@ -0,0 +1,48 @@
+package launcher
+
+import (
+	"github.com/setlog/trivrost/cmd/launcher/flags"
+	"github.com/setlog/trivrost/cmd/launcher/gui"
+	"github.com/setlog/trivrost/cmd/launcher/resources"
+
+	"github.com/setlog/trivrost/pkg/launcher/bundle"
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// offlineEnvironmentVariable is set to "1" for the commands of the deployment-config when they are launched offline.
+const offlineEnvironmentVariable = "TRIVROST_OFFLINE"
+
+// enableOfflineLaunch lets the updater fall back to the last verified deployment-config and bundle info files if the
+// OfflineLaunch-field of the launcher-config allows it, asking the user first if it says so.
+func enableOfflineLaunch(updater *bundle.Updater, launcherFlags *flags.LauncherFlags) {
+	offlineLaunchConfig := resources.LauncherConfig.OfflineLaunch
+	if offlineLaunchConfig == nil {
+		return
+	}
+	updater.EnableOfflineLaunch(offlineLaunchConfig.GracePeriod(), func(err error) bool {
+		if !offlineLaunchConfig.Prompt {
+			return true
+		}
+		brandingName := resources.LauncherConfig.BrandingName
+		message := "Could not connect to the server to check for updates of " + brandingName + ".\n" +
+			"Do you want to start the installed version offline?"
+		return gui.BlockingDialog("Start "+brandingName+" offline?", message, []string{"Start offline", "Cancel"}, 0, launcherFlags.DismissGuiPrompts) == 0
+	})
+}
+
+// withOfflineEnvironment returns a copy of commands which tell the launched programs that they were launched offline.
+func withOfflineEnvironment(commands []config.Command) []config.Command {
+	log.Infof("Launching offline. Setting %s=1 for the commands.", offlineEnvironmentVariable)
+	offlineCommands := make([]config.Command, len(commands))
+	offline := "1"
+	for i, command := range commands {
+		offlineCommands[i] = command
+		offlineCommands[i].Env = make(map[string]*string)
+		for name, value := range command.Env {
+			offlineCommands[i].Env[name] = value
+		}
+		offlineCommands[i].Env[offlineEnvironmentVariable] = &offline
+	}
+	return offlineCommands
+}
@@ -0,0 +1,79 @@
+package launcher
+
+import (
+	"errors"
+	"net/url"
+	"os"
+
+	"github.com/mattn/go-ieproxy"
+	"github.com/setlog/trivrost/cmd/launcher/places"
+	"github.com/setlog/trivrost/cmd/launcher/resources"
+	"github.com/setlog/trivrost/pkg/fetching"
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
+)
+
+var (
+	proxyRouter        *fetching.ProxyRouter
+	proxyRoutesPrinted = make(map[string]bool)
+)
+
+// newProxyRouter returns a router for the proxy settings of the launcher-config, overridden by the local proxy policy
+// file if there is one.
+func newProxyRouter() *fetching.ProxyRouter {
+	proxyConfig := resources.LauncherConfig.Proxy.MergedWith(nil)
+	localProxyConfig, err := config.ReadProxyConfig(places.GetProxyPolicyFilePath())
+	if err == nil {
+		log.Infof("Applying proxy policy file \"%s\".", places.GetProxyPolicyFilePath())
+		proxyConfig = resources.LauncherConfig.Proxy.MergedWith(localProxyConfig)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		panic(misc.UserErrorf(err, "Could not read the proxy policy file \"%s\". Please contact your system administrator.", places.GetProxyPolicyFilePath()))
+	}
+	router, err := fetching.NewProxyRouter(proxyConfig)
+	if err != nil {
+		panic(misc.UserErrorf(err, "The proxy settings of %s are invalid or the proxy auto-config script could not be loaded. "+
+			"Please contact your system administrator.", resources.LauncherConfig.BrandingName))
+	}
+	proxyRouter = router
+	return router
+}
+
+func printProxySettings() {
+	envcfg := httpproxy.FromEnvironment()
+	log.Infof("Environment proxy: HTTPProxy: \"%s\"; HTTPSProxy: \"%s\".", envcfg.HTTPProxy, envcfg.HTTPSProxy)
+	conf := ieproxy.GetConf()
+	log.Infof("Automatic proxy: %v; Preconfigured URL: \"%s\".", conf.Automatic.Active, conf.Automatic.PreConfiguredURL)
+	log.Infof("Static proxy: %v, Protocols: %v, No proxy: \"%s\".", conf.Static.Active, conf.Static.Protocols, conf.Static.NoProxy)
+	printProxyRoutes([]string{resources.LauncherConfig.DeploymentConfigURL})
+}
+
+// printProxyRoutes logs the route of requests to each scheme, host and port among urls once.
+func printProxyRoutes(urls []string) {
+	for _, rawURL := range urls {
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Host == "" || proxyRoutesPrinted[u.Scheme+"://"+u.Host] {
+			continue
+		}
+		proxyRoutesPrinted[u.Scheme+"://"+u.Host] = true
+		log.Infof("Route to %s://%s: %s.", u.Scheme, u.Host, proxyRouter.Describe(rawURL))
+	}
+}
+
+func deploymentConfigURLs(deploymentConfig *config.DeploymentConfig) (urls []string) {
+	hashDataConfigs := []config.HashDataConfig{}
+	if launcherUpdateConfig := deploymentConfig.GetLauncherUpdateConfig(); launcherUpdateConfig != nil {
+		hashDataConfigs = append(hashDataConfigs, launcherUpdateConfig.HashDataConfig)
+	}
+	for _, bundleConfig := range deploymentConfig.Bundles {
+		hashDataConfigs = append(hashDataConfigs, bundleConfig.HashDataConfig)
+	}
+	for _, hashDataConfig := range hashDataConfigs {
+		urls = append(urls, hashDataConfig.BundleInfoURL, hashDataConfig.BaseURL)
+		for _, mirror := range hashDataConfig.Mirrors {
+			urls = append(urls, mirror.BundleInfoURL, mirror.BaseURL)
+		}
+	}
+	return urls
+}
@@ -2,6 +2,10 @@ package launcher
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/setlog/trivrost/pkg/launcher/config"
 
@@ -16,15 +20,28 @@ import (
 	"github.com/setlog/trivrost/pkg/misc"
 
 	"github.com/setlog/trivrost/pkg/launcher/bundle"
+	log "github.com/sirupsen/logrus"
 )
 
+// Without a user who could close the window, downloads must not retry failed requests forever.
+const headlessRetryTime = time.Minute * 5
+
+// Files whose metadata did not change are hashed again after this long, in case their content changed nonetheless.
+const fullBundleVerificationInterval = time.Hour * 24 * 7
+
 func Run(ctx context.Context, launcherFlags *flags.LauncherFlags) {
 	doHousekeeping()
 
-	updater := createUpdater(ctx, wireHandler(gui.NewGuiDownloadProgressHandler(fetching.MaxConcurrentDownloads)))
+	handler := gui.NewGuiDownloadProgressHandler()
+	updater := createUpdater(ctx, handler, launcherFlags)
+	wireProgress(handler, updater)
+	if launcherFlags.OfflineSource == "" {
+		signIn(ctx, updater, launcherFlags)
+	}
 
 	gui.SetStage(gui.StageGetDeploymentConfig, 0)
 	updater.Prepare(resources.LauncherConfig.DeploymentConfigURL)
+	printProxyRoutes(deploymentConfigURLs(updater.GetDeploymentConfig()))
 
 	if !IsInstanceInstalledInSystemMode() && !launcherFlags.SkipSelfUpdate {
 		updateLauncherToLatestVersion(updater, launcherFlags)
@@ -33,7 +50,11 @@ func Run(ctx context.Context, launcherFlags *flags.LauncherFlags) {
 
 	gui.SetStage(gui.StageLaunchApplication, 0)
 	handleUpdateOmissions(ctx, updater)
-	launch(ctx, updater.GetDeploymentConfig().Execution, launcherFlags)
+	execution := updater.GetDeploymentConfig().Execution
+	if updater.IsOffline() {
+		execution.Commands = withOfflineEnvironment(execution.Commands)
+	}
+	launch(ctx, execution, launcherFlags)
 }
 
 func doHousekeeping() {
@@ -42,31 +63,92 @@ func doHousekeeping() {
 	deleteLeftoverBinaries()
 }
 
-func wireHandler(handler *gui.GuiDownloadProgressHandler) *gui.GuiDownloadProgressHandler {
-	hashLauncherProgress, hashBundlesProgress := newProgressFaker(10), newProgressFaker(10)
+func wireProgress(handler *gui.GuiDownloadProgressHandler, updater *bundle.Updater) {
+	gui.ProgressEstimateFunc = handler.EstimateProgress
 	gui.ProgressFunc = func(s gui.Stage) uint64 {
 		if s.IsDownloadStage() {
 			return handler.GetProgress()
-		} else if s == gui.StageDetermineLocalLauncherVersion {
-			return hashLauncherProgress.getProgress()
-		} else if s == gui.StageDetermineLocalBundleVersions {
-			return hashBundlesProgress.getProgress()
+		} else if s == gui.StageDetermineLocalLauncherVersion || s == gui.StageDetermineLocalBundleVersions {
+			return updater.GetHashingProgress()
 		}
 		return 0
 	}
-	return handler
 }
 
-func createUpdater(ctx context.Context, handler *gui.GuiDownloadProgressHandler) *bundle.Updater {
+func createUpdater(ctx context.Context, handler *gui.GuiDownloadProgressHandler, launcherFlags *flags.LauncherFlags) *bundle.Updater {
 	updater := bundle.NewUpdater(ctx, handler, resources.PublicRsaKeys)
 	updater.EnableTimestampVerification(places.GetTimestampsFilePath())
+	updater.EnableResourceCache(places.GetResourceCacheFolderPath())
+	if launcherFlags.VerifyBundles {
+		updater.EnableHashCache(places.GetHashCacheFolderPath(), 0)
+	} else {
+		updater.EnableHashCache(places.GetHashCacheFolderPath(), fullBundleVerificationInterval)
+	}
+	enableOfflineLaunch(updater, launcherFlags)
+	if err := updater.SetProxyRouter(newProxyRouter()); err != nil {
+		panic(err)
+	}
+	printProxySettings()
+	if err := updater.SetTLSConfig(readTLSConfig()); err != nil {
+		panic(misc.UserErrorf(err, "The TLS settings of %s are invalid. Please contact your system administrator.", resources.LauncherConfig.BrandingName))
+	}
+	if err := updater.SetCredentials(readCredentials()); err != nil {
+		panic(misc.UserErrorf(err, "The credentials of %s are invalid. Please contact your system administrator.", resources.LauncherConfig.BrandingName))
+	}
+	if err := updater.SetPublicKeyPins(resources.LauncherConfig.PinnedPublicKeys); err != nil {
+		panic(fmt.Sprintf("Invalid PinnedPublicKeys in launcher-config: %v", err))
+	}
+	updater.SetBandwidthLimitOverride(int64(launcherFlags.BandwidthLimit) * 1024)
+	gui.BandwidthLimitFunc = updater.GetBandwidthLimit
+	if launcherFlags.DismissGuiPrompts {
+		retryPolicy := fetching.NewDefaultRetryPolicy()
+		retryPolicy.MaxElapsedTime = headlessRetryTime
+		updater.SetRetryPolicy(retryPolicy)
+	}
 	updater.SetStatusCallback(func(status bundle.UpdaterStatus, expectedProgressUnits uint64) {
-		handler.ResetProgress()
+		handler.ResetProgress(expectedProgressUnits)
 		handleStatusChange(status, expectedProgressUnits)
 	})
+	if launcherFlags.OfflineSource != "" {
+		updater.SetLocalSource(openLocalSource(launcherFlags.OfflineSource))
+	}
 	return updater
 }
 
+// openLocalSource opens the deployment at sourcePath. It stays open until the launcher exits.
+func openLocalSource(sourcePath string) *fetching.LocalSource {
+	source, err := fetching.OpenLocalSource(sourcePath, resources.LauncherConfig.DeploymentConfigURL)
+	if err != nil {
+		panic(misc.UserErrorf(err, "Could not open the deployment at \"%s\". Please check that the medium is attached and try again.", sourcePath))
+	}
+	log.Infof("Installing from \"%s\" instead of downloading.", sourcePath)
+	return source
+}
+
+// readTLSConfig returns the TLS settings of the launcher-config, extended by the local TLS policy file if there is one.
+func readTLSConfig() *config.TLSConfig {
+	localTLSConfig, err := config.ReadTLSConfig(places.GetTLSPolicyFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return resources.LauncherConfig.TLS.MergedWith(nil)
+	} else if err != nil {
+		panic(misc.UserErrorf(err, "Could not read the TLS policy file \"%s\". Please contact your system administrator.", places.GetTLSPolicyFilePath()))
+	}
+	log.Infof("Applying TLS policy file \"%s\".", places.GetTLSPolicyFilePath())
+	return resources.LauncherConfig.TLS.MergedWith(localTLSConfig)
+}
+
+// readCredentials returns the credentials of the launcher-config, extended by the local credentials file if there is one.
+func readCredentials() map[string]*config.CredentialsConfig {
+	localCredentials, err := config.ReadCredentials(places.GetCredentialsFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return config.MergeCredentials(resources.LauncherConfig.Credentials, nil)
+	} else if err != nil {
+		panic(misc.UserErrorf(err, "Could not read the credentials file \"%s\". Please contact your system administrator.", places.GetCredentialsFilePath()))
+	}
+	log.Infof("Applying credentials file \"%s\".", places.GetCredentialsFilePath())
+	return config.MergeCredentials(resources.LauncherConfig.Credentials, localCredentials)
+}
+
 func updateLauncherToLatestVersion(updater *bundle.Updater, launcherFlags *flags.LauncherFlags) {
 	updater.SetIgnoredLauncherUpdateBundleInfoSHAs(resources.LauncherConfig.IgnoreLauncherBundleInfoHashes)
 	if updater.UpdateLauncherToLatestVersion() {
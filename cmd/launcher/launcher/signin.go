@@ -0,0 +1,114 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/setlog/trivrost/cmd/launcher/flags"
+	"github.com/setlog/trivrost/cmd/launcher/gui"
+	"github.com/setlog/trivrost/cmd/launcher/places"
+	"github.com/setlog/trivrost/cmd/launcher/resources"
+
+	"github.com/setlog/trivrost/pkg/launcher/bundle"
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+	"github.com/setlog/trivrost/pkg/oauth"
+	"github.com/setlog/trivrost/pkg/system"
+	log "github.com/sirupsen/logrus"
+)
+
+// signIn makes the updater authenticate to the hosts of the OAuth-field of the launcher-config with access tokens. It
+// refreshes the stored refresh token or, if there is none or it is not valid anymore, asks the user to sign in.
+func signIn(ctx context.Context, updater *bundle.Updater, launcherFlags *flags.LauncherFlags) {
+	oauthConfig := resources.LauncherConfig.OAuth
+	if oauthConfig == nil {
+		return
+	}
+	if err := oauthConfig.Validate(); err != nil {
+		panic(fmt.Sprintf("Invalid OAuth in launcher-config: %v", err))
+	}
+	client := oauth.NewClient(oauthConfig, updater.ClientWithoutCredentials())
+	token, err := refreshStoredToken(ctx, client)
+	var urlErr *url.Error
+	if token == nil && resources.LauncherConfig.OfflineLaunch != nil && errors.As(err, &urlErr) {
+		log.Warnf("Not signing in because the authorization server cannot be reached. Downloads which require signing in will fail.")
+		return
+	}
+	if token == nil {
+		if launcherFlags.DismissGuiPrompts {
+			panic(misc.UserErrorf(nil, "You need to sign in to %s, which is not possible while GUI prompts are dismissed.", resources.LauncherConfig.BrandingName))
+		}
+		token = signInInteractively(ctx, client, oauthConfig)
+		storeRefreshToken(token.RefreshToken)
+	}
+	updater.SetAccessTokenSource(oauthConfig.Hosts, oauth.NewTokenSource(client, token, storeRefreshToken))
+}
+
+// refreshStoredToken returns a token for the stored refresh token, or nil and the error of refreshing it, if any.
+func refreshStoredToken(ctx context.Context, client *oauth.Client) (*oauth.Token, error) {
+	refreshToken, err := oauth.ReadRefreshToken(places.GetRefreshTokenFilePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Could not read refresh token: %v", err)
+		}
+		return nil, nil
+	}
+	token, err := client.Refresh(ctx, refreshToken)
+	if err != nil {
+		log.Warnf("Could not refresh access token: %v", err)
+		if oauth.IsInvalidGrant(err) {
+			storeRefreshToken("")
+		}
+		return nil, err
+	}
+	if token.RefreshToken != refreshToken {
+		storeRefreshToken(token.RefreshToken)
+	}
+	log.Info("Signed in with stored refresh token.")
+	return token, nil
+}
+
+func signInInteractively(ctx context.Context, client *oauth.Client, oauthConfig *config.OAuthConfig) *oauth.Token {
+	defer gui.HideWaitDialog()
+	var token *oauth.Token
+	var err error
+	if oauthConfig.Flow == config.OAuthFlowDevice {
+		token, err = signInWithDeviceCode(ctx, client)
+	} else {
+		token, err = client.AuthorizeInBrowser(ctx, func(authorizationURL string) error {
+			log.Infof("Opening browser for sign-in at \"%s\".", oauthConfig.AuthorizationURL)
+			gui.ShowWaitDialog("Sign in", "Please sign in with the browser window which has just opened.")
+			return system.OpenURL(authorizationURL)
+		})
+	}
+	if err != nil {
+		panic(misc.UserErrorf(err, "Could not sign in to %s. Please try again later.", resources.LauncherConfig.BrandingName))
+	}
+	log.Info("Signed in.")
+	return token
+}
+
+func signInWithDeviceCode(ctx context.Context, client *oauth.Client) (*oauth.Token, error) {
+	authorization, err := client.AuthorizeDevice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Waiting for sign-in with code %s at \"%s\".", authorization.UserCode, authorization.VerificationURI)
+	gui.ShowWaitDialog("Sign in", fmt.Sprintf("To sign in, visit\n%s\nand enter the code\n%s", authorization.VerificationURI, authorization.UserCode))
+	if authorization.VerificationURIComplete != "" {
+		if err := system.OpenURL(authorization.VerificationURIComplete); err != nil {
+			log.Warnf("Could not open browser: %v", err)
+		}
+	}
+	return client.AwaitDeviceToken(ctx, authorization)
+}
+
+// storeRefreshToken keeps the user signed in across launches. An empty refreshToken signs the user out.
+func storeRefreshToken(refreshToken string) {
+	if err := oauth.WriteRefreshToken(places.GetRefreshTokenFilePath(), refreshToken); err != nil {
+		log.Warnf("Could not store refresh token: %v", err)
+	}
+}
@@ -49,6 +49,8 @@ func deletePlainFiles() {
 		deleteStartMenuEntries()
 	}
 	deleteTimestampFile()
+	deleteResourceCache()
+	deleteHashCache()
 	deleteIcon()
 }
 
@@ -79,6 +81,22 @@ func deleteTimestampFile() {
 	system.MustRemoveFile(places.GetTimestampsFilePath())
 }
 
+func deleteResourceCache() {
+	resourceCacheFolderPath := places.GetResourceCacheFolderPath()
+	err := os.RemoveAll(resourceCacheFolderPath)
+	if err != nil {
+		log.Errorf("Could not remove folder \"%s\": %v", resourceCacheFolderPath, err)
+	}
+}
+
+func deleteHashCache() {
+	hashCacheFolderPath := places.GetHashCacheFolderPath()
+	err := os.RemoveAll(hashCacheFolderPath)
+	if err != nil {
+		log.Errorf("Could not remove folder \"%s\": %v", hashCacheFolderPath, err)
+	}
+}
+
 func deleteIcon() {
 	if runtime.GOOS == system.OsLinux {
 		system.MustRemoveFile(places.GetLauncherIconPath())
@@ -33,9 +33,6 @@ import (
 	"github.com/setlog/trivrost/cmd/launcher/gui"
 	"github.com/setlog/trivrost/cmd/launcher/launcher"
 	"github.com/setlog/trivrost/cmd/launcher/resources"
-
-	"github.com/mattn/go-ieproxy"
-	"golang.org/x/net/http/httpproxy"
 )
 
 // These are overwritten via ldflags.
@@ -71,7 +68,6 @@ func initializeEnvironment() (*flags.LauncherFlags, error) {
 		launcherFlags.LogIndexCounter, launcherFlags.LogInstanceCounter))
 	logState(argumentError, flagError, pathError, evalError)
 
-	printProxySettings()
 	setGuiStatusMessages(resources.LauncherConfig.StatusMessages)
 	return launcherFlags, misc.NewUserErrorFromErrors(argumentError, flagError, pathError, placesError)
 }
@@ -165,14 +161,6 @@ func logState(argumentError, flagError, pathError, evalError error) {
 	places.ReportResults()
 }
 
-func printProxySettings() {
-	envcfg := httpproxy.FromEnvironment()
-	log.Infof("Environment proxy: HTTPProxy: \"%s\"; HTTPSProxy: \"%s\".", envcfg.HTTPProxy, envcfg.HTTPSProxy)
-	conf := ieproxy.GetConf()
-	log.Infof("Automatic proxy: %v; Preconfigured URL: \"%s\".", conf.Automatic.Active, conf.Automatic.PreConfiguredURL)
-	log.Infof("Static proxy: %v, Protocols: %v, No proxy: \"%s\".", conf.Static.Active, conf.Static.Protocols, conf.Static.NoProxy)
-}
-
 func setGuiStatusMessages(statusMessages config.StatusMessages) {
 	setGuiStatusMessage(gui.StageAcquireLock, statusMessages.AcquireLock)
 	setGuiStatusMessage(gui.StageGetDeploymentConfig, statusMessages.GetDeploymentConfig)
@@ -51,6 +51,18 @@ func GetAppLogFolderPath() string {
 	return filepath.Join(GetAppCacheFolderPath(), "log")
 }
 
+// GetResourceCacheFolderPath returns the path of the folder with copies of the deployment-config and bundle info files,
+// which let trivrost download them only if they changed.
+func GetResourceCacheFolderPath() string {
+	return filepath.Join(GetAppCacheFolderPath(), "resources")
+}
+
+// GetHashCacheFolderPath returns the path of the folder with the hashes of the files of each bundle, which let trivrost
+// skip hashing files which did not change.
+func GetHashCacheFolderPath() string {
+	return filepath.Join(GetAppCacheFolderPath(), "hashes")
+}
+
 func GetLauncherTargetDirectoryPath() string {
 	return GetAppDataFolderPath()
 }
@@ -80,6 +92,26 @@ func GetUninstallStartMenuShortcutPath() string {
 	return getUninstallStartMenuShortcutPath()
 }
 
+// GetTLSPolicyFilePath returns the path of the optional file with TLS settings which extend those of the launcher-config.
+func GetTLSPolicyFilePath() string {
+	return filepath.Join(GetAppLocalDataFolderPath(), "tls-policy.json")
+}
+
+// GetCredentialsFilePath returns the path of the optional file with credentials which extend those of the launcher-config.
+func GetCredentialsFilePath() string {
+	return filepath.Join(GetAppLocalDataFolderPath(), "credentials.json")
+}
+
+// GetRefreshTokenFilePath returns the path of the file which keeps the user signed in with OAuth 2.0 across launches.
+func GetRefreshTokenFilePath() string {
+	return filepath.Join(GetAppLocalDataFolderPath(), "refresh-token")
+}
+
+// GetProxyPolicyFilePath returns the path of the optional file with proxy settings which override those of the launcher-config.
+func GetProxyPolicyFilePath() string {
+	return filepath.Join(GetAppLocalDataFolderPath(), "proxy-policy.json")
+}
+
 func GetTimestampsFilePath() string {
 	return filepath.Join(GetAppLocalDataFolderPath(), "timestamps.json")
 }
@@ -8,6 +8,8 @@ type ValidatorFlags struct {
 	SkipJarChek         bool
 	ActAsService        bool
 	Port                int
+	TLSConfigPath       string
+	CredentialsPath     string
 }
 
 func parseFlags() *ValidatorFlags {
@@ -16,6 +18,10 @@ func parseFlags() *ValidatorFlags {
 	flag.BoolVar(&flags.SkipJarChek, "skipjarcheck", false, "Disable checking of availability of .jar files given to java with the -jar argument.")
 	flag.BoolVar(&flags.ActAsService, "act-as-service", false, "Validate deployment-config for HTTP GET requests on :80/validate.")
 	flag.IntVar(&flags.Port, "port", 80, "Override port for --act-as-service.")
+	flag.StringVar(&flags.TLSConfigPath, "tls-config", "", "Path to a JSON-file with CA certificates and client certificates to use for requests, "+
+		"in the format of the TLS field of the launcher-config.")
+	flag.StringVar(&flags.CredentialsPath, "credentials", "", "Path to a JSON-file with credentials to use for requests, "+
+		"in the format of the Credentials field of the launcher-config.")
 	flag.Parse()
 
 	if flag.NArg() > 1 {
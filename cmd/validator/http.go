@@ -6,8 +6,33 @@ import (
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/setlog/trivrost/pkg/fetching"
+	"github.com/setlog/trivrost/pkg/launcher/config"
 )
 
+var httpClient = &http.Client{Timeout: time.Second * 30, Transport: http.DefaultTransport.(*http.Transport).Clone()}
+
+func configureTLS(tlsConfigPath string) {
+	tlsConfig, err := config.ReadTLSConfig(tlsConfigPath)
+	if err != nil {
+		fatalf("Could not read TLS settings: %v", err)
+	}
+	if err = fetching.ConfigureTLS(httpClient, tlsConfig); err != nil {
+		fatalf("Could not apply TLS settings from \"%s\": %v", tlsConfigPath, err)
+	}
+}
+
+func configureCredentials(credentialsPath string) {
+	credentials, err := config.ReadCredentials(credentialsPath)
+	if err != nil {
+		fatalf("Could not read credentials: %v", err)
+	}
+	if err = fetching.AuthorizeRequests(httpClient, credentials); err != nil {
+		fatalf("Could not apply credentials from \"%s\": %v", credentialsPath, err)
+	}
+}
+
 func getFile(fileUrlString string) ([]byte, error) {
 	fileUrl, err := url.Parse(fileUrlString)
 	if err == nil && fileUrl.Scheme == "file" {
@@ -17,9 +42,7 @@ func getFile(fileUrlString string) ([]byte, error) {
 		return ioutil.ReadFile(fileUrlString)
 	}
 
-	client := &http.Client{}
-	client.Timeout = time.Second * 30
-	resp, err := client.Get(fileUrlString)
+	resp, err := httpClient.Get(fileUrlString)
 	if err != nil {
 		return nil, err
 	}
@@ -31,10 +54,8 @@ func getFile(fileUrlString string) ([]byte, error) {
 }
 
 func getHttpHeadResult(url string) (responseCode int, err error) {
-	client := &http.Client{}
-	client.Timeout = time.Second * 30
 	var response *http.Response
-	response, err = client.Head(url)
+	response, err = httpClient.Head(url)
 	if err != nil {
 		return 0, err
 	}
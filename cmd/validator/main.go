@@ -17,6 +17,12 @@ import (
 
 func main() {
 	flags := parseFlags()
+	if flags.TLSConfigPath != "" {
+		configureTLS(flags.TLSConfigPath)
+	}
+	if flags.CredentialsPath != "" {
+		configureCredentials(flags.CredentialsPath)
+	}
 	if flags.ActAsService {
 		registerMetrics()
 		actAsService(flags)
@@ -3,13 +3,16 @@ module github.com/setlog/trivrost
 require (
 	git.sr.ht/~tslocum/preallocate v0.1.2
 	github.com/andlabs/ui v0.0.0-20200610043537-70a69d6ae31e
+	github.com/dop251/goja v0.0.0-20260917113740-793a2a65c13b
 	github.com/go-ole/go-ole v1.3.0
 	github.com/gofrs/flock v0.13.0
+	github.com/klauspost/compress v1.18.0
 	github.com/mattn/go-ieproxy v0.0.1
 	github.com/prometheus/client_golang v1.23.2
 	github.com/shirou/gopsutil/v4 v4.26.4
 	github.com/sirupsen/logrus v1.9.4
 	github.com/xeipuuv/gojsonschema v1.2.0
+	github.com/zeebo/blake3 v0.2.4
 	golang.org/x/net v0.53.0
 	golang.org/x/sys v0.43.0
 )
@@ -17,7 +20,11 @@ require (
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dlclark/regexp2/v2 v2.5.2 // indirect
 	github.com/ebitengine/purego v0.10.0 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/lufia/plan9stats v0.0.0-20211012122336-39d0f177ccd0 // indirect
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
@@ -0,0 +1,216 @@
+// Package delta creates and applies binary patches which turn one version of a file into another.
+//
+// A patch starts with a magic string, followed by a gzip stream of instructions which either copy a range of bytes
+// from the old file or insert literal bytes. Matching ranges are found in the fashion of rsync: the old file is split
+// into blocks which are looked up with a rolling checksum while scanning the new file, so that changes which shift
+// the remaining content of a file do not spoil the patch.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const magic = "TRVDLT01"
+
+const (
+	opEnd byte = iota
+	opCopy
+	opInsert
+)
+
+// blockSize is the size of the blocks of the old file which are looked up when creating a patch.
+const blockSize = 2048
+
+// maxCandidatesPerChecksum limits the work done for old files with many identical blocks, e.g. long runs of zeroes.
+const maxCandidatesPerChecksum = 8
+
+// Create writes a patch to w which turns oldData into newData when passed to Apply().
+func Create(w io.Writer, oldData, newData []byte) error {
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	zw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	pw := &patchWriter{w: bufio.NewWriter(zw)}
+	index := indexBlocks(oldData)
+	literalStart, pos := 0, 0
+	var sum rollingChecksum
+	if len(newData) >= blockSize {
+		sum.reset(newData[:blockSize])
+	}
+	for pos+blockSize <= len(newData) {
+		oldPos, matchLength := findMatch(index, oldData, newData, pos, sum.value())
+		if matchLength == 0 {
+			if pos+blockSize < len(newData) {
+				sum.roll(newData[pos], newData[pos+blockSize])
+			}
+			pos++
+			continue
+		}
+		for oldPos > 0 && pos > literalStart && oldData[oldPos-1] == newData[pos-1] {
+			oldPos, pos, matchLength = oldPos-1, pos-1, matchLength+1
+		}
+		pw.insert(newData[literalStart:pos])
+		pw.copy(oldPos, matchLength)
+		pos += matchLength
+		literalStart = pos
+		if pos+blockSize <= len(newData) {
+			sum.reset(newData[pos : pos+blockSize])
+		}
+	}
+	pw.insert(newData[literalStart:])
+	pw.end()
+	if pw.err != nil {
+		return pw.err
+	}
+	if err = pw.w.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Apply writes the result of applying patch to old into dst.
+func Apply(dst io.Writer, old io.ReaderAt, patch io.Reader) error {
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(patch, header); err != nil {
+		return fmt.Errorf("could not read patch header: %w", err)
+	}
+	if string(header) != magic {
+		return fmt.Errorf("patch has unknown format")
+	}
+	zr, err := gzip.NewReader(patch)
+	if err != nil {
+		return fmt.Errorf("could not decompress patch: %w", err)
+	}
+	defer zr.Close()
+	r := bufio.NewReader(zr)
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("could not read patch instruction: %w", err)
+		}
+		switch op {
+		case opEnd:
+			return nil
+		case opCopy:
+			offset, err := binary.ReadUvarint(r)
+			if err != nil {
+				return fmt.Errorf("could not read copy offset: %w", err)
+			}
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return fmt.Errorf("could not read copy length: %w", err)
+			}
+			n, err := io.Copy(dst, io.NewSectionReader(old, int64(offset), int64(length)))
+			if err != nil {
+				return err
+			}
+			if n != int64(length) {
+				return fmt.Errorf("patch copies bytes %d to %d, which are beyond the end of the old file", offset, offset+length)
+			}
+		case opInsert:
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return fmt.Errorf("could not read insert length: %w", err)
+			}
+			if _, err = io.CopyN(dst, r, int64(length)); err != nil {
+				return fmt.Errorf("could not insert %d bytes: %w", length, err)
+			}
+		default:
+			return fmt.Errorf("patch contains unknown instruction %d", op)
+		}
+	}
+}
+
+func indexBlocks(data []byte) map[uint32][]int {
+	index := make(map[uint32][]int)
+	var sum rollingChecksum
+	for pos := 0; pos+blockSize <= len(data); pos += blockSize {
+		sum.reset(data[pos : pos+blockSize])
+		if candidates := index[sum.value()]; len(candidates) < maxCandidatesPerChecksum {
+			index[sum.value()] = append(candidates, pos)
+		}
+	}
+	return index
+}
+
+// findMatch returns the position and length of the longest range in oldData which starts with the block at newData[pos:].
+func findMatch(index map[uint32][]int, oldData, newData []byte, pos int, checksum uint32) (oldPos, length int) {
+	for _, candidate := range index[checksum] {
+		if !bytes.Equal(oldData[candidate:candidate+blockSize], newData[pos:pos+blockSize]) {
+			continue
+		}
+		n := blockSize
+		for candidate+n < len(oldData) && pos+n < len(newData) && oldData[candidate+n] == newData[pos+n] {
+			n++
+		}
+		if n > length {
+			oldPos, length = candidate, n
+		}
+	}
+	return oldPos, length
+}
+
+// rollingChecksum is the weak checksum of rsync over a window of blockSize bytes.
+type rollingChecksum struct {
+	a, b uint32
+}
+
+func (c *rollingChecksum) reset(window []byte) {
+	c.a, c.b = 0, 0
+	for i, x := range window {
+		c.a += uint32(x)
+		c.b += uint32(len(window)-i) * uint32(x)
+	}
+}
+
+func (c *rollingChecksum) roll(out, in byte) {
+	c.a += uint32(in) - uint32(out)
+	c.b += c.a - blockSize*uint32(out)
+}
+
+func (c *rollingChecksum) value() uint32 {
+	return c.a&0xffff | c.b<<16
+}
+
+type patchWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (pw *patchWriter) copy(offset, length int) {
+	pw.op(opCopy, uint64(offset), uint64(length))
+}
+
+func (pw *patchWriter) insert(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	pw.op(opInsert, uint64(len(data)))
+	if pw.err == nil {
+		_, pw.err = pw.w.Write(data)
+	}
+}
+
+func (pw *patchWriter) end() {
+	pw.op(opEnd)
+}
+
+func (pw *patchWriter) op(op byte, args ...uint64) {
+	if pw.err != nil {
+		return
+	}
+	buf := make([]byte, 1, 1+len(args)*binary.MaxVarintLen64)
+	buf[0] = op
+	for _, arg := range args {
+		buf = binary.AppendUvarint(buf, arg)
+	}
+	_, pw.err = pw.w.Write(buf)
+}
@@ -0,0 +1,73 @@
+package delta_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/delta"
+)
+
+func randomData(rng *rand.Rand, length int) []byte {
+	data := make([]byte, length)
+	rng.Read(data)
+	return data
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestCreateAndApply(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	old := randomData(rng, 200000)
+	tests := []struct {
+		name    string
+		old     []byte
+		new     []byte
+		maxSize int // Upper bound for the size of the patch. 0 if the size does not matter.
+	}{
+		{"identical", old, old, 1000},
+		{"empty old", nil, randomData(rng, 5000), 0},
+		{"empty new", old, nil, 100},
+		{"small", []byte("Hello"), []byte("Hello, World!"), 0},
+		{"inserted", concat(old[:70000], randomData(rng, 3000), old[70000:]), old, 1000},
+		{"insertion", old, concat(old[:70000], randomData(rng, 3000), old[70000:]), 5000},
+		{"shifted and changed", old, concat(randomData(rng, 17), old[:120000], []byte("changed"), old[120007:]), 1000},
+		{"reordered", old, concat(old[150000:], old[:150000]), 1000},
+		{"unrelated", old, randomData(rng, 10000), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := &bytes.Buffer{}
+			if err := delta.Create(patch, test.old, test.new); err != nil {
+				t.Fatal(err)
+			}
+			if test.maxSize > 0 && patch.Len() > test.maxSize {
+				t.Errorf("Patch has %d bytes. Expected at most %d.", patch.Len(), test.maxSize)
+			}
+			result := &bytes.Buffer{}
+			if err := delta.Apply(result, bytes.NewReader(test.old), bytes.NewReader(patch.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(result.Bytes(), test.new) {
+				t.Errorf("Applying the patch produced %d bytes which differ from the expected %d bytes.", result.Len(), len(test.new))
+			}
+		})
+	}
+}
+
+func TestApplyRejectsPatchForOtherFile(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	old := randomData(rng, 100000)
+	patch := &bytes.Buffer{}
+	if err := delta.Create(patch, old, concat(old[50000:], old[:50000])); err != nil {
+		t.Fatal(err)
+	}
+	if err := delta.Apply(&bytes.Buffer{}, bytes.NewReader(old[:10000]), patch); err == nil {
+		t.Errorf("Apply() succeeded on an old file which is too short")
+	}
+	if err := delta.Apply(&bytes.Buffer{}, bytes.NewReader(old), bytes.NewReader([]byte("not a patch"))); err == nil {
+		t.Errorf("Apply() succeeded on garbage")
+	}
+}
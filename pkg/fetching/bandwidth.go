@@ -0,0 +1,110 @@
+package fetching
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How long a bandwidth limiter is reported to be throttling after it last delayed a download.
+const throttlingReportDuration = time.Second
+
+// bandwidthLimiter is a token bucket shared by all downloads of a Downloader. Every byte received takes a token. Tokens are
+// replenished at bytesPerSecond, and the bucket holds at most a quarter second's worth of them to keep bursts short.
+type bandwidthLimiter struct {
+	mutex          *sync.Mutex
+	bytesPerSecond int64 // No limit if <= 0.
+	tokens         float64
+	lastRefill     time.Time
+	throttledUntil time.Time
+}
+
+func newBandwidthLimiter() *bandwidthLimiter {
+	return &bandwidthLimiter{mutex: &sync.Mutex{}}
+}
+
+func (limiter *bandwidthLimiter) setLimit(bytesPerSecond int64) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.bytesPerSecond = bytesPerSecond
+	limiter.tokens = 0
+	limiter.lastRefill = time.Now()
+}
+
+func (limiter *bandwidthLimiter) limit() int64 {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return limiter.bytesPerSecond
+}
+
+// maxReadSize returns how many bytes a download should read at once so that the limiter can spread them evenly.
+func (limiter *bandwidthLimiter) maxReadSize(wanted int) int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.bytesPerSecond <= 0 {
+		return wanted
+	}
+	return intMin(wanted, int(limiter.burstSize()))
+}
+
+func (limiter *bandwidthLimiter) burstSize() float64 {
+	if limiter.bytesPerSecond < 4*1024 {
+		return 1024
+	}
+	return float64(limiter.bytesPerSecond) / 4
+}
+
+// take consumes byteCount tokens and blocks until the bucket is no longer in debt or ctx is done.
+func (limiter *bandwidthLimiter) take(ctx context.Context, byteCount int) error {
+	delay := limiter.reserve(byteCount)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (limiter *bandwidthLimiter) reserve(byteCount int) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.bytesPerSecond <= 0 {
+		return 0
+	}
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.lastRefill).Seconds() * float64(limiter.bytesPerSecond)
+	if burstSize := limiter.burstSize(); limiter.tokens > burstSize {
+		limiter.tokens = burstSize
+	}
+	limiter.lastRefill = now
+	limiter.tokens -= float64(byteCount)
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	delay := time.Duration(-limiter.tokens / float64(limiter.bytesPerSecond) * float64(time.Second))
+	limiter.throttledUntil = now.Add(delay)
+	return delay
+}
+
+func (limiter *bandwidthLimiter) isThrottling() bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return limiter.bytesPerSecond > 0 && time.Since(limiter.throttledUntil) < throttlingReportDuration
+}
+
+// SetBandwidthLimit limits the combined rate at which all downloads of the Downloader receive data to the given number of
+// bytes per second. A value <= 0 removes the limit. The new limit also applies to downloads which are already running.
+func (downloader *Downloader) SetBandwidthLimit(bytesPerSecond int64) {
+	downloader.limiter.setLimit(bytesPerSecond)
+}
+
+// BandwidthLimit returns the current limit in bytes per second set with SetBandwidthLimit() and whether downloads have
+// recently been slowed down to adhere to it.
+func (downloader *Downloader) BandwidthLimit() (bytesPerSecond int64, isThrottling bool) {
+	return downloader.limiter.limit(), downloader.limiter.isThrottling()
+}
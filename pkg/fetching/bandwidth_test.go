@@ -0,0 +1,58 @@
+package fetching
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func TestBandwidthLimitSlowsDownDownloads(t *testing.T) {
+	data := bytes.Repeat([]byte{42}, 24*1024)
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		header := http.Header{"content-length": []string{fmt.Sprintf("%d", len(data))}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+	}
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetBandwidthLimit(32 * 1024)
+	startedAt := time.Now()
+	fileMap := config.FileInfoMap{"http://example.com/a": {}, "http://example.com/b": {}}
+	if _, err := downloader.DownloadToRAM(fileMap); err != nil {
+		t.Fatal(err)
+	}
+	// 48 KiB at 32 KiB/s, starting with an empty bucket.
+	if elapsed := time.Since(startedAt); elapsed < 1400*time.Millisecond {
+		t.Errorf("Downloads finished after %v despite the bandwidth limit.", elapsed)
+	}
+	if limit, isThrottling := downloader.BandwidthLimit(); limit != 32*1024 || !isThrottling {
+		t.Errorf("Expected downloader to report throttling at 32 KiB/s. Got %d B/s, throttling: %v", limit, isThrottling)
+	}
+}
+
+func TestBandwidthLimiterWithoutLimit(t *testing.T) {
+	limiter := newBandwidthLimiter()
+	if delay := limiter.reserve(1 << 30); delay != 0 {
+		t.Errorf("Unlimited limiter delayed by %v.", delay)
+	}
+	if limiter.maxReadSize(1<<20) != 1<<20 {
+		t.Errorf("Unlimited limiter restricted read size.")
+	}
+	if limiter.isThrottling() {
+		t.Errorf("Unlimited limiter reports throttling.")
+	}
+}
+
+func TestBandwidthLimiterIsCanceledWithContext(t *testing.T) {
+	limiter := newBandwidthLimiter()
+	limiter.setLimit(1024)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.take(ctx, 1024*1024); err != context.Canceled {
+		t.Errorf("Expected context.Canceled. Got %v", err)
+	}
+}
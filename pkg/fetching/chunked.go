@@ -0,0 +1,357 @@
+package fetching
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"git.sr.ht/~tslocum/preallocate"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+const (
+	// Files of at least this size are downloaded in chunks over several connections in parallel, unless they are transferred compressed.
+	parallelDownloadThreshold = 32 * 1024 * 1024
+
+	// Size of the chunks of files whose bundle info does not specify a ChunkSize.
+	defaultChunkSize = 8 * 1024 * 1024
+
+	// Maximum number of connections over which the chunks of a single file are downloaded.
+	maxParallelChunks = 4
+
+	// A chunk whose SHA-256 hash does not match the bundle info is downloaded at most this many times.
+	maxChunkAttempts = 3
+)
+
+func chunkSizeOf(expectedFileInfo *config.FileInfo) int64 {
+	if expectedFileInfo.ChunkSize > 0 {
+		return expectedFileInfo.ChunkSize
+	}
+	return defaultChunkSize
+}
+
+func shouldDownloadInChunks(expectedFileInfo *config.FileInfo) bool {
+	if expectedFileInfo.Compression != "" || expectedFileInfo.Size <= chunkSizeOf(expectedFileInfo) {
+		return false
+	}
+	return expectedFileInfo.Size >= parallelDownloadThreshold || expectedFileInfo.ChunkSize > 0
+}
+
+// chunkedFile is a file which is written by several chunk downloads in parallel. Completed chunks are recorded in a
+// partialInfo file so that a later run of the program only needs to download the remaining ones.
+type chunkedFile struct {
+	mutex            *sync.Mutex
+	file             *os.File
+	infoFilePath     string
+	info             partialInfo
+	expectedFileInfo *config.FileInfo
+	isChunkComplete  []bool
+}
+
+func openChunkedFile(localFilePath string, expectedFileInfo *config.FileInfo, chunkSize int64) (*chunkedFile, error) {
+	cf := &chunkedFile{
+		mutex:            &sync.Mutex{},
+		infoFilePath:     PartialInfoFilePath(localFilePath),
+		info:             partialInfo{Hash: expectedFileInfo.Hash, HashAlgorithm: expectedFileInfo.HashAlgorithm, Size: expectedFileInfo.Size, ChunkSize: chunkSize},
+		expectedFileInfo: expectedFileInfo,
+		isChunkComplete:  make([]bool, expectedFileInfo.ChunkCount(chunkSize)),
+	}
+	if info := readPartialInfo(cf.infoFilePath, expectedFileInfo); info != nil && info.ChunkSize == chunkSize {
+		for _, index := range info.CompletedChunks {
+			if index >= 0 && index < len(cf.isChunkComplete) && !cf.isChunkComplete[index] {
+				cf.isChunkComplete[index] = true
+				cf.info.CompletedChunks = append(cf.info.CompletedChunks, index)
+			}
+		}
+	}
+	flags := os.O_RDWR | os.O_CREATE
+	if len(cf.info.CompletedChunks) == 0 {
+		flags |= os.O_TRUNC
+	}
+	var err error
+	cf.file, err = os.OpenFile(localFilePath, flags, 0700)
+	if err != nil {
+		return nil, system.NewFileSystemError(fmt.Sprintf("Could not open file \"%s\" for writing", localFilePath), err)
+	}
+	if err = preallocate.File(cf.file, expectedFileInfo.Size); err != nil {
+		log.Printf("Could not preallocate file \"%s\" with %d bytes: %v", localFilePath, expectedFileInfo.Size, err)
+	}
+	return cf, nil
+}
+
+// chunkRange returns the indices of the first and last byte of the chunk with the given index.
+func (cf *chunkedFile) chunkRange(index int) (firstByteIndex, lastByteIndex int64) {
+	firstByteIndex = int64(index) * cf.info.ChunkSize
+	lastByteIndex = firstByteIndex + cf.info.ChunkSize - 1
+	if lastByteIndex >= cf.info.Size {
+		lastByteIndex = cf.info.Size - 1
+	}
+	return firstByteIndex, lastByteIndex
+}
+
+// markComplete flushes the file and records that the chunk with the given index has been written.
+func (cf *chunkedFile) markComplete(index int) error {
+	cf.mutex.Lock()
+	defer cf.mutex.Unlock()
+	if err := cf.file.Sync(); err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not flush file \"%s\"", cf.file.Name()), err)
+	}
+	cf.isChunkComplete[index] = true
+	cf.info.CompletedChunks = append(cf.info.CompletedChunks, index)
+	data, err := json.Marshal(&cf.info)
+	if err != nil {
+		return err
+	}
+	return system.PutFileAtomically(cf.infoFilePath, data)
+}
+
+// keep closes the file, leaving the record of its completed chunks in place.
+func (cf *chunkedFile) keep() {
+	cf.file.Close()
+	if len(cf.info.CompletedChunks) > 0 {
+		log.Printf("Kept %d of %d chunks of \"%s\" to resume download later.", len(cf.info.CompletedChunks), len(cf.isChunkComplete), cf.file.Name())
+	}
+}
+
+// discard closes and removes the file as well as any record of its progress.
+func (cf *chunkedFile) discard() {
+	cf.file.Close()
+	for _, filePath := range []string{cf.file.Name(), cf.infoFilePath} {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Could not remove file \"%s\" after error: %v", filePath, err)
+		}
+	}
+}
+
+// complete closes the file and removes the record of its progress.
+func (cf *chunkedFile) complete() error {
+	if err := cf.file.Close(); err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not close file \"%s\"", cf.file.Name()), err)
+	}
+	if err := os.Remove(cf.infoFilePath); err != nil && !os.IsNotExist(err) {
+		return system.NewFileSystemError(fmt.Sprintf("Could not remove partial download info \"%s\"", cf.infoFilePath), err)
+	}
+	return nil
+}
+
+// updateFileInChunks is like updateFile, but downloads the file in chunks over up to maxParallelChunks connections.
+// It returns errRangesNotSupported, having removed the file, if the remote does not support range requests.
+func updateFileInChunks(dl *Download, expectedFileInfo *config.FileInfo, localFilePath string) error {
+	cf, err := openChunkedFile(localFilePath, expectedFileInfo, chunkSizeOf(expectedFileInfo))
+	if err != nil {
+		return err
+	}
+	progress := &chunkProgress{mutex: &sync.Mutex{}, handler: dl.handler, url: dl.url, workerId: dl.workerId, received: make([]uint64, len(cf.isChunkComplete))}
+	pendingChunks := make(chan int, len(cf.isChunkComplete))
+	for index, isComplete := range cf.isChunkComplete {
+		if isComplete {
+			firstByteIndex, lastByteIndex := cf.chunkRange(index)
+			progress.total += uint64(lastByteIndex - firstByteIndex + 1)
+		} else {
+			pendingChunks <- index
+		}
+	}
+	close(pendingChunks)
+	if progress.total > 0 {
+		log.Printf("Resuming download of \"%s\" into \"%s\" with %d of %d chunks present.", dl.url, localFilePath, len(cf.info.CompletedChunks), len(cf.isChunkComplete))
+	}
+	if err = downloadChunks(dl, cf, progress, pendingChunks); err != nil {
+		var downloadError DownloadError
+		if dl.ctx.Err() != nil {
+			cf.keep()
+			return dl.ctx.Err()
+		} else if errors.As(err, &downloadError) { // The remote file changed or cannot be served in chunks: what we have is worthless.
+			cf.discard()
+		} else {
+			cf.keep()
+		}
+		return err
+	}
+	hash := config.NewHash(expectedFileInfo.HashAlgorithm)
+	if _, err = misc.IOCopyWithContext(dl.ctx, hash, io.NewSectionReader(cf.file, 0, expectedFileInfo.Size)); err != nil {
+		if dl.ctx.Err() != nil {
+			cf.keep()
+			return dl.ctx.Err()
+		}
+		cf.discard()
+		return system.NewFileSystemError(fmt.Sprintf("Could not read downloaded file \"%s\"", localFilePath), err)
+	}
+	if dlFileSha := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(expectedFileInfo.Hash, dlFileSha) {
+		cf.discard()
+		return fmt.Errorf("Hash of downloaded file \"%s\" does not match expected value \"%s\" for file \"%s\". Was \"%s\"",
+			dl.url, expectedFileInfo.Hash, localFilePath, dlFileSha)
+	}
+	progress.start() // In case all chunks were already present.
+	dl.handler.HandleFinishDownload(dl.url, dl.workerId)
+	return cf.complete()
+}
+
+// downloadChunks downloads the chunks from pendingChunks in parallel and returns the first error which occurs, if any.
+// The first connection uses the concurrency slot of dl, every further one acquires its own for each chunk it downloads.
+func downloadChunks(dl *Download, cf *chunkedFile, progress *chunkProgress, pendingChunks chan int) error {
+	ctx, cancelFunc := context.WithCancel(dl.ctx)
+	defer cancelFunc()
+	workerCount := intMin(maxParallelChunks, len(pendingChunks))
+	errChan := make(chan error, workerCount)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func(needsSlot bool) {
+			defer wg.Done()
+			for {
+				index, ok, err := nextChunk(ctx, dl, pendingChunks, needsSlot)
+				if err == nil && ok {
+					err = downloadChunk(ctx, dl, cf, progress, index)
+					releaseChunkSlot(dl, needsSlot)
+				}
+				if err != nil {
+					errChan <- err
+					cancelFunc()
+					return
+				}
+				if !ok {
+					return
+				}
+			}
+		}(i > 0)
+	}
+	wg.Wait()
+	close(errChan)
+	return <-errChan // The first error is the one which caused the others by cancelling ctx.
+}
+
+// nextChunk takes the index of the next chunk to download from pendingChunks. If needsSlot is set, it first acquires a slot
+// from the concurrency controller of the Downloader managing dl, if any, which the caller has to release once the chunk has
+// been downloaded. Waiting for the slot before taking a chunk leaves the chunk to connections which can proceed right away.
+func nextChunk(ctx context.Context, dl *Download, pendingChunks chan int, needsSlot bool) (index int, ok bool, err error) {
+	if needsSlot && dl.downloader != nil {
+		if err = dl.downloader.concurrency.acquire(ctx); err != nil {
+			return 0, false, err
+		}
+	}
+	index, ok = <-pendingChunks
+	if !ok {
+		releaseChunkSlot(dl, needsSlot)
+	}
+	return index, ok, nil
+}
+
+func releaseChunkSlot(dl *Download, needsSlot bool) {
+	if needsSlot && dl.downloader != nil {
+		dl.downloader.concurrency.release()
+	}
+}
+
+func downloadChunk(ctx context.Context, dl *Download, cf *chunkedFile, progress *chunkProgress, index int) error {
+	firstByteIndex, lastByteIndex := cf.chunkRange(index)
+	expectedChunkSHA256 := cf.expectedFileInfo.ChunkSHA256(index, cf.info.ChunkSize)
+	for attempt := 1; ; attempt++ {
+		chunkDl := NewDownloadForConcurrentUse(ctx, dl.url, dl.client, &chunkProgressHandler{progress: progress, index: index, firstByteIndex: firstByteIndex}, dl.workerId)
+		chunkDl.downloader = dl.downloader
+		chunkDl.retryPolicy = dl.retryPolicy
+		chunkDl.useMirrors(dl.mirrors, dl.mirrorRelativePath)
+		chunkDl.restrictTo(firstByteIndex, lastByteIndex)
+		hash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(io.NewOffsetWriter(cf.file, firstByteIndex), hash), chunkDl); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		chunkSHA256 := hex.EncodeToString(hash.Sum(nil))
+		if expectedChunkSHA256 == "" || strings.EqualFold(expectedChunkSHA256, chunkSHA256) {
+			return cf.markComplete(index)
+		}
+		progress.set(index, 0)
+		if attempt >= maxChunkAttempts {
+			return fmt.Errorf("SHA256 of chunk %d of \"%s\" does not match expected value \"%s\" after %d attempts. Was \"%s\"",
+				index, dl.url, expectedChunkSHA256, attempt, chunkSHA256)
+		}
+		log.Printf("SHA256 of chunk %d of \"%s\" does not match expected value \"%s\". Was \"%s\". Downloading it again.", index, dl.url, expectedChunkSHA256, chunkSHA256)
+	}
+}
+
+// chunkProgress sums up the progress of the chunk downloads of a file and reports it under the worker id of the file's download.
+type chunkProgress struct {
+	mutex    *sync.Mutex
+	handler  DownloadProgressHandler
+	url      string
+	workerId int
+	received []uint64 // Bytes received per chunk.
+	total    uint64   // Bytes received for all chunks, including the ones which were already present.
+
+	// The start of the file's download is reported along with the first progress rather than when the chunk downloads
+	// begin, so that it is not reported twice if the remote turns out not to support range requests and the file has
+	// to be downloaded in one piece instead.
+	isStarted bool
+}
+
+// start reports the start of the file's download unless it has already been reported.
+func (progress *chunkProgress) start() {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.startLocked()
+}
+
+func (progress *chunkProgress) startLocked() {
+	if !progress.isStarted {
+		progress.handler.HandleStartDownload(progress.url, progress.workerId)
+		progress.isStarted = true
+	}
+}
+
+func (progress *chunkProgress) set(index int, receivedBytes uint64) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.startLocked()
+	progress.total += receivedBytes - progress.received[index]
+	progress.received[index] = receivedBytes
+	progress.handler.HandleProgress(progress.url, progress.workerId, progress.total)
+}
+
+// chunkProgressHandler forwards the events of a chunk download to the handler of the file's download.
+type chunkProgressHandler struct {
+	progress       *chunkProgress
+	index          int
+	firstByteIndex int64
+}
+
+func (handler *chunkProgressHandler) HandleStartDownload(fromURL string, workerId int) {
+}
+
+func (handler *chunkProgressHandler) HandleProgress(fromURL string, workerId int, receivedBytes uint64) {
+	handler.progress.set(handler.index, receivedBytes-uint64(handler.firstByteIndex))
+}
+
+func (handler *chunkProgressHandler) HandleFinishDownload(fromURL string, workerId int) {
+}
+
+func (handler *chunkProgressHandler) HandleFailDownload(fromURL string, workerId int, err error) {
+	if err != errRangesNotSupported && err != context.Canceled {
+		handler.progress.start()
+		handler.progress.handler.HandleFailDownload(fromURL, workerId, err)
+	}
+}
+
+func (handler *chunkProgressHandler) HandleHttpGetError(fromURL string, err error) {
+	handler.progress.handler.HandleHttpGetError(fromURL, err)
+}
+
+func (handler *chunkProgressHandler) HandleBadHttpResponse(fromURL string, code int) {
+	handler.progress.handler.HandleBadHttpResponse(fromURL, code)
+}
+
+func (handler *chunkProgressHandler) HandleReadError(fromURL string, err error, receivedByteCount int64) {
+	handler.progress.handler.HandleReadError(fromURL, err, receivedByteCount)
+}
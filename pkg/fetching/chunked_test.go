@@ -0,0 +1,220 @@
+package fetching
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+const testChunkSize, testChunkedFileSize = 1000, 4500
+
+type chunkedTestServer struct {
+	*DummyEnvironment
+	mutex           *sync.Mutex
+	requestedRanges []string
+	corruptOnce     map[string]bool // Ranges which are served corrupted the first time they are requested.
+	ignoreRange     bool
+}
+
+func newChunkedTestServer(t *testing.T) *chunkedTestServer {
+	server := &chunkedTestServer{DummyEnvironment: CreateDummyEnvironment(t, testChunkedFileSize, -1), mutex: &sync.Mutex{}, corruptOnce: make(map[string]bool)}
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		requestedRange := NewLowercaseHeaders(req.Header).Get("range")
+		server.mutex.Lock()
+		server.requestedRanges = append(server.requestedRanges, requestedRange)
+		corrupt := server.corruptOnce[requestedRange]
+		delete(server.corruptOnce, requestedRange)
+		server.mutex.Unlock()
+		if server.ignoreRange {
+			delete(req.Header, "range")
+		}
+		response, err := server.DummyEnvironment.DoForClientFunc(client, req)
+		if corrupt {
+			data, _ := ioutil.ReadAll(response.Body)
+			data[0]++
+			response.Body = ioutil.NopCloser(bytes.NewReader(data))
+		}
+		return response, err
+	}
+	return server
+}
+
+func (server *chunkedTestServer) fileInfo(withChunkHashes bool) *config.FileInfo {
+	info := &config.FileInfo{Hash: sha256Hex(server.Data), Size: testChunkedFileSize, ChunkSize: testChunkSize}
+	for offset := 0; offset < testChunkedFileSize; offset += testChunkSize {
+		end := offset + testChunkSize
+		if end > testChunkedFileSize {
+			end = testChunkedFileSize
+		}
+		sum := sha256.Sum256(server.Data[offset:end])
+		info.ChunkSHA256s = append(info.ChunkSHA256s, hex.EncodeToString(sum[:]))
+	}
+	if !withChunkHashes {
+		info.ChunkSHA256s = nil
+	}
+	return info
+}
+
+func (server *chunkedTestServer) sortedRanges() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	ranges := append([]string{}, server.requestedRanges...)
+	sort.Strings(ranges)
+	return ranges
+}
+
+func testChunkedDownload(t *testing.T, server *chunkedTestServer, dl *Download, fileInfo *config.FileInfo, prepare func(localFilePath string)) string {
+	d, err := ioutil.TempDir("", "trivrost-chunk-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(d) })
+	localFilePath := filepath.Join(d, "file")
+	if prepare != nil {
+		prepare(localFilePath)
+	}
+	if err = updateFile(dl, fileInfo, localFilePath); err != nil {
+		t.Fatal(err)
+	}
+	diskData, err := ioutil.ReadFile(localFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(diskData, server.Data) {
+		t.Fatalf("Data on disk mismatches data of download")
+	}
+	if _, err = os.Stat(PartialInfoFilePath(localFilePath)); !os.IsNotExist(err) {
+		t.Errorf("Partial download info has not been removed: %v", err)
+	}
+	return localFilePath
+}
+
+func TestUpdateFileDownloadsChunksInParallel(t *testing.T) {
+	server := newChunkedTestServer(t)
+	testChunkedDownload(t, server, NewDownload(context.Background(), "http://example.com"), server.fileInfo(false), nil)
+	expectedRanges := []string{"bytes=0-999", "bytes=1000-1999", "bytes=2000-2999", "bytes=3000-3999", "bytes=4000-4499"}
+	if fmt.Sprint(server.sortedRanges()) != fmt.Sprint(expectedRanges) {
+		t.Errorf("Expected requests for ranges %q. Got %q.", expectedRanges, server.sortedRanges())
+	}
+}
+
+func TestUpdateFileDownloadsCorruptChunkAgain(t *testing.T) {
+	server := newChunkedTestServer(t)
+	server.corruptOnce["bytes=2000-2999"] = true
+	testChunkedDownload(t, server, NewDownload(context.Background(), "http://example.com"), server.fileInfo(true), nil)
+	if len(server.sortedRanges()) != 6 {
+		t.Errorf("Expected only the corrupt chunk to be requested again. Requested ranges: %q", server.sortedRanges())
+	}
+}
+
+func TestUpdateFileResumesChunkedDownload(t *testing.T) {
+	server := newChunkedTestServer(t)
+	fileInfo := server.fileInfo(false)
+	testChunkedDownload(t, server, NewDownload(context.Background(), "http://example.com"), fileInfo, func(localFilePath string) {
+		if err := ioutil.WriteFile(localFilePath, server.Data[:2000], 0600); err != nil {
+			t.Fatal(err)
+		}
+		info := fmt.Sprintf(`{"Hash":"%s","Size":%d,"Offset":0,"ChunkSize":%d,"CompletedChunks":[1,0]}`, fileInfo.Hash, fileInfo.Size, testChunkSize)
+		if err := ioutil.WriteFile(PartialInfoFilePath(localFilePath), []byte(info), 0600); err != nil {
+			t.Fatal(err)
+		}
+	})
+	expectedRanges := []string{"bytes=2000-2999", "bytes=3000-3999", "bytes=4000-4499"}
+	if fmt.Sprint(server.sortedRanges()) != fmt.Sprint(expectedRanges) {
+		t.Errorf("Expected requests for ranges %q. Got %q.", expectedRanges, server.sortedRanges())
+	}
+}
+
+func TestUpdateFileFallsBackToOnePieceWithoutRangeSupport(t *testing.T) {
+	server := newChunkedTestServer(t)
+	server.ignoreRange = true
+	testChunkedDownload(t, server, NewDownload(context.Background(), "http://example.com"), server.fileInfo(true), nil)
+	if ranges := server.sortedRanges(); ranges[0] != "" {
+		t.Errorf("Expected a request for the whole file. Requested ranges: %q", ranges)
+	}
+}
+
+type startCountingHandler struct {
+	EmptyHandler
+	startCount int
+}
+
+func (handler *startCountingHandler) HandleStartDownload(fromURL string, workerId int) {
+	handler.startCount++
+}
+
+func TestUpdateFileReportsStartOnceWithoutRangeSupport(t *testing.T) {
+	server := newChunkedTestServer(t)
+	server.ignoreRange = true
+	handler := &startCountingHandler{}
+	testChunkedDownload(t, server, NewHandledDownload(context.Background(), "http://example.com", handler), server.fileInfo(true), nil)
+	if handler.startCount != 1 {
+		t.Errorf("Expected the start of the download to be reported once. Was reported %d times.", handler.startCount)
+	}
+}
+
+func TestUpdateFileAcquiresConcurrencySlotsForChunkConnections(t *testing.T) {
+	server := newChunkedTestServer(t)
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.concurrency.target = 2
+	if err := downloader.concurrency.acquire(context.Background()); err != nil { // The slot of the file's download.
+		t.Fatal(err)
+	}
+	connectionCount := 0
+	serve := DoForClientFunc
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		server.mutex.Lock()
+		downloader.concurrency.mutex.Lock()
+		connectionCount++
+		if connectionCount > downloader.concurrency.active {
+			t.Errorf("%d chunk connections are open with only %d concurrency slots acquired", connectionCount, downloader.concurrency.active)
+		}
+		downloader.concurrency.mutex.Unlock()
+		server.mutex.Unlock()
+		time.Sleep(time.Millisecond * 20) // Give the other chunk connections a chance to open meanwhile.
+		response, err := serve(client, req)
+		if err == nil {
+			data, _ := ioutil.ReadAll(response.Body)
+			response.Body = ioutil.NopCloser(&closingReader{Reader: bytes.NewReader(data), close: func() {
+				server.mutex.Lock()
+				connectionCount--
+				server.mutex.Unlock()
+			}})
+		}
+		return response, err
+	}
+	dl := NewDownload(context.Background(), "http://example.com")
+	dl.downloader = downloader
+	testChunkedDownload(t, server, dl, server.fileInfo(false), nil)
+	if downloader.concurrency.active != 1 {
+		t.Errorf("Expected only the slot of the file's download to remain acquired. %d are.", downloader.concurrency.active)
+	}
+}
+
+// closingReader calls close once its Reader is exhausted.
+type closingReader struct {
+	*bytes.Reader
+	close func()
+}
+
+func (reader *closingReader) Read(p []byte) (int, error) {
+	n, err := reader.Reader.Read(p)
+	if err == io.EOF && reader.close != nil {
+		reader.close()
+		reader.close = nil
+	}
+	return n, err
+}
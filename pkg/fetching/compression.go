@@ -0,0 +1,27 @@
+package fetching
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+// newDecompressingReader returns an io.ReadCloser which reads the data decompressed from r with the given algorithm.
+// Closing it does not close r.
+func newDecompressingReader(compression string, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case config.CompressionGzip:
+		return gzip.NewReader(r)
+	case config.CompressionZstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression \"%s\"", compression)
+}
@@ -0,0 +1,111 @@
+package fetching
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func compress(t *testing.T, compression string, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	var err error
+	switch compression {
+	case config.CompressionGzip:
+		w = gzip.NewWriter(buf)
+	case config.CompressionZstd:
+		w, err = zstd.NewWriter(buf)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadToDirectoryDecompresses(t *testing.T) {
+	for _, compression := range []string{config.CompressionGzip, config.CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			d, err := ioutil.TempDir("", "trivrost-compression-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(d)
+			data := bytes.Repeat([]byte("All work and no play makes Jack a dull boy. "), 1000)
+			compressedData := compress(t, compression, data)
+			var requestedPaths []string
+			DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+				requestedPaths = append(requestedPaths, req.URL.Path)
+				header := http.Header{"content-length": []string{fmt.Sprintf("%d", len(compressedData))}}
+				return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(bytes.NewReader(compressedData))}, nil
+			}
+			fileMap := config.FileInfoMap{"file.json": {Hash: sha256Hex(data), Size: int64(len(data)), Compression: compression, CompressedSize: int64(len(compressedData))}}
+			if err = NewDownloader(context.Background(), &EmptyHandler{}).DownloadToDirectory("http://example.com/bundle", fileMap, d); err != nil {
+				t.Fatal(err)
+			}
+			if len(requestedPaths) != 1 || !strings.HasSuffix(requestedPaths[0], "/file.json"+config.CompressedFileExtension(compression)) {
+				t.Errorf("Expected a single request for the compressed file. Got %q.", requestedPaths)
+			}
+			diskData, err := ioutil.ReadFile(filepath.Join(d, "file.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(diskData, data) {
+				t.Fatalf("Data on disk mismatches decompressed data")
+			}
+		})
+	}
+}
+
+func TestDownloadToDirectoryRejectsWrongDecompressedHash(t *testing.T) {
+	d, err := ioutil.TempDir("", "trivrost-compression-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	data := []byte("The compressed file is fine, but it is not the file we want.")
+	compressedData := compress(t, config.CompressionGzip, data)
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: ioutil.NopCloser(bytes.NewReader(compressedData))}, nil
+	}
+	fileMap := config.FileInfoMap{"file": {Hash: sha256Hex(compressedData), Size: int64(len(data)), Compression: config.CompressionGzip}}
+	if err = NewDownloader(context.Background(), &EmptyHandler{}).DownloadToDirectory("http://example.com/bundle", fileMap, d); err == nil {
+		t.Fatalf("Download succeeded although the SHA256 of the decompressed file mismatches")
+	}
+	if _, err = os.Stat(filepath.Join(d, "file")); !os.IsNotExist(err) {
+		t.Errorf("Rejected file has not been removed: %v", err)
+	}
+}
+
+func TestDownloadToDirectoryRejectsDecompressedDataBeyondDeclaredSize(t *testing.T) {
+	d := t.TempDir()
+	data := make([]byte, 16*1024*1024)
+	compressedData := compress(t, config.CompressionGzip, data)
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: ioutil.NopCloser(bytes.NewReader(compressedData))}, nil
+	}
+	fileMap := config.FileInfoMap{"file": {Hash: sha256Hex(data), Size: 1024, Compression: config.CompressionGzip}}
+	if err := NewDownloader(context.Background(), &EmptyHandler{}).DownloadToDirectory("http://example.com/bundle", fileMap, d); err == nil {
+		t.Fatalf("Download succeeded although the file decompresses to more than its declared size")
+	}
+	if _, err := os.Stat(filepath.Join(d, "file")); !os.IsNotExist(err) {
+		t.Errorf("Rejected file has not been removed: %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package fetching
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinConcurrentDownloads = 1
+	defaultMaxConcurrentDownloads = 8
+	initialConcurrentDownloads    = MaxConcurrentDownloads
+
+	// Throughput is compared between windows of this duration to decide whether to add another concurrent download.
+	concurrencySampleInterval = time.Second * 2
+
+	// If adding a concurrent download did not raise throughput by this factor, it is taken back and no further downloads
+	// are added for concurrencyProbeBackoff.
+	concurrencyImprovementFactor = 1.1
+	concurrencyProbeBackoff      = time.Second * 30
+
+	// Responses which take longer than this to arrive count as a sign of an overloaded remote.
+	slowResponseThreshold = time.Second * 10
+
+	// Retry-After headers asking to wait longer than this are capped to it.
+	maxRetryAfter = time.Minute * 5
+)
+
+// concurrencyController adapts the number of concurrent downloads of a Downloader: It adds downloads while throughput keeps
+// improving, takes one away on errors or slow responses and halves their number when the remote reports being overloaded.
+type concurrencyController struct {
+	mutex   *sync.Mutex
+	changed chan struct{} // Closed and replaced whenever a waiting acquire() might be able to proceed.
+
+	min, max int
+	target   int
+	active   int
+
+	pausedUntil   time.Time // Set by Retry-After headers.
+	lastDecrease  time.Time
+	noProbesUntil time.Time
+
+	windowStart           time.Time
+	windowBytes           int64
+	windowHadProblems     bool
+	previousThroughput    float64
+	lastChangeWasIncrease bool
+}
+
+func newConcurrencyController() *concurrencyController {
+	return &concurrencyController{
+		mutex:       &sync.Mutex{},
+		changed:     make(chan struct{}),
+		min:         defaultMinConcurrentDownloads,
+		max:         defaultMaxConcurrentDownloads,
+		target:      initialConcurrentDownloads,
+		windowStart: time.Now(),
+	}
+}
+
+func (controller *concurrencyController) setBounds(min, max int) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	if min <= 0 {
+		min = defaultMinConcurrentDownloads
+	}
+	if max <= 0 {
+		max = defaultMaxConcurrentDownloads
+	}
+	controller.min = intMax(1, intMin(min, maxConcurrentDownloadsLimit))
+	controller.max = intMax(controller.min, intMin(max, maxConcurrentDownloadsLimit))
+	controller.target = intMax(controller.min, intMin(controller.target, controller.max))
+	controller.notify()
+}
+
+// upperBound returns the most downloads the controller lets run at the same time.
+func (controller *concurrencyController) upperBound() int {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	return controller.max
+}
+
+// acquire blocks until another download may start or ctx is done.
+func (controller *concurrencyController) acquire(ctx context.Context) error {
+	for {
+		controller.mutex.Lock()
+		if ctx.Err() != nil {
+			controller.mutex.Unlock()
+			return ctx.Err()
+		}
+		pause := time.Until(controller.pausedUntil)
+		if pause <= 0 && controller.active < controller.target {
+			controller.active++
+			controller.mutex.Unlock()
+			return nil
+		}
+		changed := controller.changed
+		controller.mutex.Unlock()
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if pause > 0 {
+			timer = time.NewTimer(pause)
+			timeout = timer.C
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (controller *concurrencyController) release() {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	controller.active--
+	controller.notify()
+}
+
+func (controller *concurrencyController) notify() {
+	close(controller.changed)
+	controller.changed = make(chan struct{})
+}
+
+// recordBytes accounts for byteCount received bytes and reevaluates the number of concurrent downloads once per sample interval.
+func (controller *concurrencyController) recordBytes(byteCount int, now time.Time) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	controller.windowBytes += int64(byteCount)
+	elapsed := now.Sub(controller.windowStart)
+	if elapsed < concurrencySampleInterval {
+		return
+	}
+	throughput := float64(controller.windowBytes) / elapsed.Seconds()
+	if !controller.windowHadProblems {
+		controller.adjustToThroughput(throughput, now)
+	}
+	controller.previousThroughput = throughput
+	controller.windowStart, controller.windowBytes, controller.windowHadProblems = now, 0, false
+}
+
+func (controller *concurrencyController) adjustToThroughput(throughput float64, now time.Time) {
+	if controller.lastChangeWasIncrease && throughput < controller.previousThroughput*concurrencyImprovementFactor {
+		controller.lastChangeWasIncrease = false
+		controller.noProbesUntil = now.Add(concurrencyProbeBackoff)
+		controller.setTarget(controller.target-1, "throughput did not improve")
+		return
+	}
+	controller.lastChangeWasIncrease = false
+	isSaturated := controller.active >= controller.target
+	if isSaturated && controller.target < controller.max && !now.Before(controller.noProbesUntil) {
+		controller.lastChangeWasIncrease = true
+		controller.setTarget(controller.target+1, "probing for higher throughput")
+	}
+}
+
+// reportProblem takes away one concurrent download, at most once per sample interval, because of a failed or slow request.
+func (controller *concurrencyController) reportProblem(reason string, now time.Time) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	controller.windowHadProblems = true
+	controller.lastChangeWasIncrease = false
+	if now.Sub(controller.lastDecrease) >= concurrencySampleInterval {
+		controller.lastDecrease = now
+		controller.setTarget(controller.target-1, reason)
+	}
+}
+
+// reportOverload halves the number of concurrent downloads, at most once per sample interval, and pauses the start of
+// new downloads for retryAfter.
+func (controller *concurrencyController) reportOverload(retryAfter time.Duration, now time.Time) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	controller.windowHadProblems = true
+	controller.lastChangeWasIncrease = false
+	if retryAfter > 0 && now.Add(retryAfter).After(controller.pausedUntil) {
+		controller.pausedUntil = now.Add(retryAfter)
+		log.Printf("Remote is overloaded. Not starting new downloads for %v.", retryAfter)
+	}
+	if now.Sub(controller.lastDecrease) >= concurrencySampleInterval {
+		controller.lastDecrease = now
+		controller.setTarget(controller.target/2, "remote is overloaded")
+	}
+}
+
+func (controller *concurrencyController) setTarget(target int, reason string) {
+	target = intMax(controller.min, intMin(target, controller.max))
+	if target != controller.target {
+		log.Printf("Changing number of concurrent downloads from %d to %d: %s.", controller.target, target, reason)
+		controller.target = target
+		controller.notify()
+	}
+}
+
+func (controller *concurrencyController) currentTarget() int {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	return controller.target
+}
+
+// isOverloadStatusCode returns true if the remote responded with statusCode because it is too busy to serve the request.
+func isOverloadStatusCode(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter returns the duration the Retry-After header value asks clients to wait, which is either a number of seconds
+// or an HTTP date, capped at maxRetryAfter. It returns 0 if the value is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		retryAfter = date.Sub(now)
+	}
+	if retryAfter < 0 {
+		return 0
+	} else if retryAfter > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return retryAfter
+}
+
+func intMax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// SetConcurrencyBounds sets the range within which the Downloader adapts the number of concurrent downloads.
+// Bounds <= 0 select the defaults. Both bounds are clamped to [1, 16]. Worker ids passed to a DownloadProgressHandler are
+// always less than the upper bound.
+func (downloader *Downloader) SetConcurrencyBounds(min, max int) {
+	downloader.concurrency.setBounds(min, max)
+}
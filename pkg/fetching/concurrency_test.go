@@ -0,0 +1,138 @@
+package fetching
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyBounds(t *testing.T) {
+	controller := newConcurrencyController()
+	controller.setBounds(2, 100)
+	if controller.min != 2 || controller.max != maxConcurrentDownloadsLimit || controller.target != initialConcurrentDownloads {
+		t.Errorf("Unexpected bounds [%d, %d] with target %d.", controller.min, controller.max, controller.target)
+	}
+	controller.setBounds(0, 3)
+	if controller.min != defaultMinConcurrentDownloads || controller.max != 3 || controller.target != 3 {
+		t.Errorf("Unexpected bounds [%d, %d] with target %d.", controller.min, controller.max, controller.target)
+	}
+}
+
+func TestConcurrencyIncreasesWhileThroughputImproves(t *testing.T) {
+	controller := newConcurrencyController()
+	now := controller.windowStart
+	saturate(t, controller)
+	now = now.Add(concurrencySampleInterval)
+	controller.recordBytes(1000, now)
+	if controller.currentTarget() != initialConcurrentDownloads+1 {
+		t.Fatalf("Expected concurrency to increase. Got %d.", controller.currentTarget())
+	}
+	saturate(t, controller)
+	now = now.Add(concurrencySampleInterval)
+	controller.recordBytes(2000, now)
+	if controller.currentTarget() != initialConcurrentDownloads+2 {
+		t.Fatalf("Expected concurrency to increase further. Got %d.", controller.currentTarget())
+	}
+	now = now.Add(concurrencySampleInterval)
+	controller.recordBytes(2000, now)
+	if controller.currentTarget() != initialConcurrentDownloads+1 {
+		t.Fatalf("Expected concurrency to be taken back because throughput did not improve. Got %d.", controller.currentTarget())
+	}
+	now = now.Add(concurrencySampleInterval)
+	controller.recordBytes(2000, now)
+	if controller.currentTarget() != initialConcurrentDownloads+1 {
+		t.Fatalf("Expected concurrency not to be probed again right away. Got %d.", controller.currentTarget())
+	}
+}
+
+func TestConcurrencyDoesNotIncreaseWhenUnsaturated(t *testing.T) {
+	controller := newConcurrencyController()
+	controller.recordBytes(1000, controller.windowStart.Add(concurrencySampleInterval))
+	if controller.currentTarget() != initialConcurrentDownloads {
+		t.Fatalf("Expected concurrency to stay the same without enough downloads. Got %d.", controller.currentTarget())
+	}
+}
+
+func TestConcurrencyDecreasesOnProblems(t *testing.T) {
+	controller := newConcurrencyController()
+	now := time.Now()
+	controller.reportProblem("test", now)
+	controller.reportProblem("test", now)
+	if controller.currentTarget() != initialConcurrentDownloads-1 {
+		t.Fatalf("Expected concurrency to decrease once per sample interval. Got %d.", controller.currentTarget())
+	}
+	now = now.Add(concurrencySampleInterval)
+	controller.reportOverload(time.Minute, now)
+	if controller.currentTarget() != (initialConcurrentDownloads-1)/2 {
+		t.Fatalf("Expected concurrency to be halved. Got %d.", controller.currentTarget())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := controller.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected new downloads to be paused after Retry-After. Got %v.", err)
+	}
+}
+
+func TestConcurrencyAcquireWaitsForRelease(t *testing.T) {
+	controller := newConcurrencyController()
+	controller.setBounds(1, 1)
+	if err := controller.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		controller.release()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := controller.acquire(ctx); err != nil {
+		t.Fatalf("Expected download to start after release. Got %v.", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":         0,
+		"nonsense": 0,
+		"-5":       0,
+		"120":      2 * time.Minute,
+		"100000":   maxRetryAfter,
+		now.Add(time.Minute).Format(http.TimeFormat): time.Minute,
+	}
+	for value, expected := range tests {
+		if retryAfter := parseRetryAfter(value, now); retryAfter != expected {
+			t.Errorf("Expected Retry-After \"%s\" to be %v. Got %v.", value, expected, retryAfter)
+		}
+	}
+}
+
+func saturate(t *testing.T, controller *concurrencyController) {
+	for controller.active < controller.target {
+		if err := controller.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWorkerIdsStayBelowUpperBound(t *testing.T) {
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetConcurrencyBounds(1, 3)
+	urls := make([]string, 20)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://example.com/%d", i)
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	err := downloader.runDownloadWorkers(ctx, cancelFunc, urls, func(dl *Download) error {
+		if dl.workerId >= 3 {
+			return fmt.Errorf("worker id %d is not less than the upper bound of 3", dl.workerId)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,141 @@
+package fetching
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+// authTransport adds the credentials of the host of each request to it, so that credentials are never sent to
+// other hosts, neither after redirects nor to mirrors.
+type authTransport struct {
+	next        http.RoundTripper
+	credentials map[string]*hostCredentials
+}
+
+type hostCredentials struct {
+	username, password string
+	bearerToken        string
+	accessTokenSource  AccessTokenSource
+}
+
+// AccessTokenSource provides bearer tokens which may change over time, such as OAuth 2.0 access tokens.
+type AccessTokenSource interface {
+	AccessToken(ctx context.Context) (string, error)
+}
+
+// AuthorizationError is returned for requests to Host which could not be authorized because its AccessTokenSource failed.
+type AuthorizationError struct {
+	Host string
+	Err  error
+}
+
+func (err *AuthorizationError) Error() string {
+	return fmt.Sprintf("could not get access token for host \"%s\": %v", err.Host, err.Err)
+}
+
+func (err *AuthorizationError) Unwrap() error {
+	return err.Err
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	credentials, ok := t.credentials[strings.ToLower(req.URL.Hostname())]
+	if !ok || req.URL.Scheme == "file" {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context()) // RoundTrippers must not modify the request.
+	if credentials.accessTokenSource != nil {
+		token, err := credentials.accessTokenSource.AccessToken(req.Context())
+		if err != nil {
+			return nil, &AuthorizationError{Host: req.URL.Hostname(), Err: err}
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if credentials.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+credentials.bearerToken)
+	} else {
+		req.SetBasicAuth(credentials.username, credentials.password)
+	}
+	return t.next.RoundTrip(req)
+}
+
+func (t *authTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// AuthorizeRequests makes client authenticate its requests to the hosts in credentials. Bearer tokens from files or
+// environment variables are read once, right away.
+func AuthorizeRequests(client *http.Client, credentials map[string]*config.CredentialsConfig) error {
+	transport := authTransportOf(client)
+	for host, hostConfig := range credentials {
+		if hostConfig == nil {
+			return fmt.Errorf("no credentials given for host \"%s\"", host)
+		}
+		if err := hostConfig.Validate(); err != nil {
+			return fmt.Errorf("invalid credentials for host \"%s\": %w", host, err)
+		}
+		token, err := hostConfig.Token()
+		if err != nil {
+			return fmt.Errorf("invalid credentials for host \"%s\": %w", host, err)
+		}
+		if token == "" && hostConfig.Username == "" {
+			return fmt.Errorf("invalid credentials for host \"%s\": bearer token is empty", host)
+		}
+		transport.credentials[strings.ToLower(host)] = &hostCredentials{username: hostConfig.Username, password: hostConfig.Password, bearerToken: token}
+		if token != "" {
+			log.Printf("Authenticating requests to host \"%s\" with a bearer token.", host)
+		} else {
+			log.Printf("Authenticating requests to host \"%s\" as user \"%s\".", host, hostConfig.Username)
+		}
+	}
+	return nil
+}
+
+// AuthorizeRequestsWithTokens makes client authenticate its requests to hosts with the bearer tokens of source,
+// replacing other credentials for these hosts.
+func AuthorizeRequestsWithTokens(client *http.Client, hosts []string, source AccessTokenSource) {
+	transport := authTransportOf(client)
+	for _, host := range hosts {
+		transport.credentials[strings.ToLower(host)] = &hostCredentials{accessTokenSource: source}
+		log.Printf("Authenticating requests to host \"%s\" with access tokens.", host)
+	}
+}
+
+func authTransportOf(client *http.Client) *authTransport {
+	if transport, ok := client.Transport.(*authTransport); ok {
+		return transport
+	}
+	transport := &authTransport{next: client.Transport, credentials: make(map[string]*hostCredentials)}
+	if transport.next == nil {
+		transport.next = http.DefaultTransport
+	}
+	client.Transport = transport
+	return transport
+}
+
+// SetCredentials makes the Downloader authenticate its requests to the hosts in credentials.
+// It must be called before any downloads start.
+func (downloader *Downloader) SetCredentials(credentials map[string]*config.CredentialsConfig) error {
+	return AuthorizeRequests(downloader.client, credentials)
+}
+
+// SetAccessTokenSource makes the Downloader authenticate its requests to hosts with the bearer tokens of source.
+// It must be called before any downloads start.
+func (downloader *Downloader) SetAccessTokenSource(hosts []string, source AccessTokenSource) {
+	AuthorizeRequestsWithTokens(downloader.client, hosts, source)
+}
+
+// ClientWithoutCredentials returns a client which shares the connections, TLS settings and public key pins of the
+// Downloader, but does not authenticate its requests, e.g. for talking to an authorization server.
+func (downloader *Downloader) ClientWithoutCredentials() *http.Client {
+	client := *downloader.client
+	if transport, ok := client.Transport.(*authTransport); ok {
+		client.Transport = transport.next
+	}
+	return &client
+}
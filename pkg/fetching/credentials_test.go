@@ -0,0 +1,126 @@
+package fetching
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func newAuthorizationEchoServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	t.Cleanup(server.Close)
+	DoForClientFunc = DoForClient
+	return server
+}
+
+func downloadAuthorization(t *testing.T, url string, credentials map[string]*config.CredentialsConfig) string {
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetRetryPolicy(&BackoffRetryPolicy{MaxAttempts: 1})
+	if err := downloader.SetCredentials(credentials); err != nil {
+		t.Fatal(err)
+	}
+	fileData, err := downloader.DownloadToRAM(config.FileInfoMap{url: {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(fileData[url])
+}
+
+func TestCredentialsBasicAuth(t *testing.T) {
+	server := newAuthorizationEchoServer(t)
+	authorization := downloadAuthorization(t, server.URL, map[string]*config.CredentialsConfig{"127.0.0.1": {Username: "user", Password: "secret"}})
+	if authorization != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("Unexpected Authorization header: %q", authorization)
+	}
+}
+
+func TestCredentialsBearerTokenFromEnvironment(t *testing.T) {
+	server := newAuthorizationEchoServer(t)
+	os.Setenv("TRIVROST_TEST_TOKEN", " token \n")
+	defer os.Unsetenv("TRIVROST_TEST_TOKEN")
+	authorization := downloadAuthorization(t, server.URL, map[string]*config.CredentialsConfig{"127.0.0.1": {BearerTokenEnv: "TRIVROST_TEST_TOKEN"}})
+	if authorization != "Bearer token" {
+		t.Errorf("Unexpected Authorization header: %q", authorization)
+	}
+}
+
+func TestCredentialsAreNotSentToOtherHosts(t *testing.T) {
+	server := newAuthorizationEchoServer(t)
+	authorization := downloadAuthorization(t, server.URL, map[string]*config.CredentialsConfig{"example.com": {BearerToken: "token"}})
+	if authorization != "" {
+		t.Errorf("Expected no Authorization header. Got %q", authorization)
+	}
+}
+
+func TestCredentialsWorkWithTLSConfig(t *testing.T) {
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	if err := downloader.SetCredentials(map[string]*config.CredentialsConfig{"example.com": {BearerToken: "token"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := downloader.SetTLSConfig(&config.TLSConfig{}); err != nil {
+		t.Fatalf("Expected TLS to be configurable after credentials: %v", err)
+	}
+	if _, ok := downloader.client.Transport.(*authTransport); !ok {
+		t.Errorf("Expected credentials to still be applied. Got transport of type %T", downloader.client.Transport)
+	}
+}
+
+func TestInvalidCredentialsAreRejected(t *testing.T) {
+	for _, credentials := range []*config.CredentialsConfig{
+		{},
+		{Password: "secret"},
+		{Username: "user", BearerToken: "token"},
+		{BearerTokenEnv: "TRIVROST_TEST_UNSET_TOKEN"},
+		{BearerTokenFile: "/does/not/exist"},
+	} {
+		downloader := NewDownloader(context.Background(), &EmptyHandler{})
+		if err := downloader.SetCredentials(map[string]*config.CredentialsConfig{"example.com": credentials}); err == nil {
+			t.Errorf("Expected credentials %+v to be rejected.", credentials)
+		}
+	}
+}
+
+type testAccessTokenSource struct {
+	token string
+	err   error
+}
+
+func (source *testAccessTokenSource) AccessToken(ctx context.Context) (string, error) {
+	return source.token, source.err
+}
+
+func TestAccessTokenSource(t *testing.T) {
+	server := newAuthorizationEchoServer(t)
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetRetryPolicy(&BackoffRetryPolicy{MaxAttempts: 1})
+	downloader.SetAccessTokenSource([]string{"127.0.0.1"}, &testAccessTokenSource{token: "access"})
+	fileData, err := downloader.DownloadToRAM(config.FileInfoMap{server.URL: {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fileData[server.URL]) != "Bearer access" {
+		t.Errorf("Unexpected Authorization header: %q", fileData[server.URL])
+	}
+}
+
+func TestFailingAccessTokenSourceIsNotRetried(t *testing.T) {
+	server := newAuthorizationEchoServer(t)
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetAccessTokenSource([]string{"127.0.0.1"}, &testAccessTokenSource{err: fmt.Errorf("refresh token revoked")})
+	_, err := downloader.DownloadToRAM(config.FileInfoMap{server.URL: {}})
+	var authorizationError *AuthorizationError
+	if !errors.As(err, &authorizationError) {
+		t.Fatalf("Expected AuthorizationError. Got %v", err)
+	}
+	if _, err = downloader.ClientWithoutCredentials().Get(server.URL); err != nil {
+		t.Errorf("Expected client without credentials to work: %v", err)
+	}
+}
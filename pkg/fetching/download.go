@@ -2,14 +2,16 @@ package fetching
 
 import (
 	"context"
-	"crypto/rand"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/setlog/trivrost/pkg/misc"
 )
 
 // Changed only during tests.
@@ -21,6 +23,10 @@ func DoForClient(client *http.Client, req *http.Request) (*http.Response, error)
 
 type DownloadError string
 
+// errRangesNotSupported is returned by the Read() method of a Download which has been restricted to a range of bytes
+// when the remote responds with the whole resource.
+const errRangesNotSupported = DownloadError("remote does not support range requests")
+
 func (err DownloadError) Error() string {
 	return string(err)
 }
@@ -47,7 +53,8 @@ func (wc *writeCounter) Write(p []byte) (int, error) {
 // The Read() method will only return a non-nil error other than io.EOF when the
 // Content-Length of the requested resource changes during Download's attempts to
 // retrieve it or the remote signals that it cannot serve a range-request made in
-// an attempt to resume if the first GET was interrupted.
+// an attempt to resume if the first GET was interrupted, or when its RetryPolicy
+// gives up on failed requests, in which case the error is a *misc.UserError.
 //
 // See Download.handler for Download's behavior in other error scenarios.
 type Download struct {
@@ -57,7 +64,8 @@ type Download struct {
 
 	// Calls to methods of handler occur during Download.Read(). The calls are followed by these behaviors:
 	// HandleStartDownload(): Read() will start its first (ideally only) HTTP request.
-	// HandleHttpGetError() and HandleBadHttpResponse(): Read() will not return. The request will be retried after one second.
+	// HandleHttpGetError() and HandleBadHttpResponse(): Read() will not return unless the RetryPolicy gives up. Otherwise,
+	//                                               the request will be retried after the delay chosen by the RetryPolicy.
 	// HandleReadError(): If at least 1 byte has been read, Read() will return with a nil error, having accepted all data which
 	//                    has been received so far. Either way, Download will continue with a range-request immediately.
 	// HandleFailDownload(): Read() will return with a non-nil, non-io.EOF error.
@@ -67,22 +75,45 @@ type Download struct {
 	// An arbitrary integer id or value which should be contained in applicable calls on DownloadProgressHandler. Useful for concurrency.
 	workerId int
 
-	isDownloadStarted     bool
-	gotValidFirstResponse bool
-	firstByteIndex        int64
-	lastByteIndex         int64
+	// Set by callers which can do without the resource. If true, the Download fails with a DownloadError instead of retrying when
+	// the remote responds that the resource does not exist, and HandleFailDownload() is not called.
+	optional bool
+
+	// Decides how to retry failed requests. NewDefaultRetryPolicy() is used if nil.
+	retryPolicy RetryPolicy
+
+	// Set by callers which hold a copy of the resource. If not nil, the first request asks the remote to respond with
+	// 304 Not Modified if the resource is unchanged, in which case notModified is set and Read() returns io.EOF right away.
+	conditions  *resourceValidators
+	notModified bool
+	validators  resourceValidators // Sent by the remote along with the resource.
+
+	isDownloadStarted       bool
+	gotValidFirstResponse   bool
+	firstByteIndex          int64
+	lastByteIndex           int64
+	isRestricted            bool // If true, only the bytes up to and including restrictedLastByteIndex are downloaded.
+	restrictedLastByteIndex int64
 
 	client        *http.Client
 	request       *http.Request
 	cancelRequest context.CancelFunc
 	cooldownTime  time.Time
-	cooldownIndex int
+	failureCount  int // Consecutive failed requests.
+	failingSince  time.Time
 
 	response       *http.Response
 	responseReader io.Reader
 
 	// Communicate some TLS information to the downloader which is managing this download.
 	downloader *Downloader
+
+	// If the resource is served by a group of mirrors, requests go to mirror.url + mirrorRelativePath instead of url.
+	mirrors            *mirrorGroup
+	mirror             *mirrorState
+	mirrorRelativePath string
+	mirrorFailureCount int
+	mirrorsGivenUpOn   int // Mirrors which failed in a way which is not retryable since the last successful request.
 }
 
 func NewDownload(ctx context.Context, resourceUrl string) *Download {
@@ -107,10 +138,25 @@ func (dl *Download) URL() string {
 	return dl.url
 }
 
+// resumeAt makes the Download skip the first offset bytes of the resource. It must be called before the first Read().
+func (dl *Download) resumeAt(offset int64) {
+	dl.firstByteIndex = offset
+}
+
+// restrictTo makes the Download retrieve only the bytes from firstByteIndex up to and including lastByteIndex of the
+// resource. It must be called before the first Read().
+func (dl *Download) restrictTo(firstByteIndex, lastByteIndex int64) {
+	dl.firstByteIndex = firstByteIndex
+	dl.isRestricted, dl.restrictedLastByteIndex = true, lastByteIndex
+}
+
 // Read reads some data of the requested resource into p.
 // Calling Read() again after it returned a non-nil error results in undefined behaviour.
 func (dl *Download) Read(p []byte) (n int, err error) {
 	defer dl.handlePanic(&err)
+	if dl.downloader != nil {
+		p = p[:dl.downloader.limiter.maxReadSize(len(p))]
+	}
 	for n == 0 && err == nil {
 		if len(p) == 0 || dl.ctx.Err() != nil {
 			return 0, dl.ctx.Err()
@@ -121,10 +167,11 @@ func (dl *Download) Read(p []byte) (n int, err error) {
 			dl.isDownloadStarted = true
 		}
 		n, err = dl.readDownload(p)
+		dl.accountReceivedBytes(n)
 	}
 	if err == io.EOF {
 		dl.handler.HandleFinishDownload(dl.url, dl.workerId)
-	} else if err != nil {
+	} else if err != nil && !dl.optional {
 		dl.handler.HandleFailDownload(dl.url, dl.workerId, err)
 	}
 	return n, err
@@ -156,20 +203,107 @@ func (dl *Download) readDownload(p []byte) (bytesReadCount int, err error) {
 }
 
 func (dl *Download) createRequest() (*http.Request, context.CancelFunc) {
+	requestURL := dl.requestURL()
 	if !dl.gotValidFirstResponse {
-		return newRequestWithCancel(dl.ctx, dl.url)
+		if dl.isRestricted {
+			return newRangeRequestWithCancel(dl.ctx, requestURL, dl.firstByteIndex, dl.restrictedLastByteIndex)
+		}
+		if dl.firstByteIndex > 0 {
+			return newRangeRequestWithCancel(dl.ctx, requestURL, dl.firstByteIndex, -1)
+		}
+		req, cancel := newRequestWithCancel(dl.ctx, requestURL)
+		if dl.conditions != nil {
+			dl.conditions.applyTo(req)
+		}
+		return req, cancel
+	}
+	return newRangeRequestWithCancel(dl.ctx, requestURL, dl.firstByteIndex, dl.lastByteIndex)
+}
+
+func (dl *Download) useMirrors(group *mirrorGroup, relativePath string) {
+	if group != nil {
+		dl.mirrors, dl.mirror, dl.mirrorRelativePath = group, group.preferred(), relativePath
+	}
+}
+
+// requestURL returns the URL to request the resource from, which differs from dl.url if it is served by mirrors.
+func (dl *Download) requestURL() string {
+	if dl.mirror != nil {
+		return dl.mirror.url + dl.mirrorRelativePath
+	}
+	return dl.url
+}
+
+// handleRequestFailure lets the RetryPolicy decide when to retry after a request failed with err or a bad response with
+// statusCode. It panics with a *misc.UserError if the policy gives up and there is no other mirror to switch to.
+func (dl *Download) handleRequestFailure(err error, statusCode int) {
+	now := time.Now()
+	if dl.failureCount == 0 {
+		dl.failingSince = now
+	}
+	dl.failureCount++
+	failure := &RetryFailure{Err: err, StatusCode: statusCode, Attempt: dl.failureCount, Elapsed: now.Sub(dl.failingSince)}
+	policy := dl.getRetryPolicy()
+	if !policy.IsRetryable(failure) {
+		if dl.mirror != nil && dl.mirrorsGivenUpOn+1 < len(dl.mirrors.mirrors) {
+			dl.mirrorsGivenUpOn++
+			dl.switchMirror()
+			return
+		}
+		dl.cleanUp()
+		panic(newRetryLimitError(dl.url, failure, false))
+	}
+	delay, retry := policy.NextDelay(failure)
+	if !retry {
+		dl.cleanUp()
+		panic(newRetryLimitError(dl.url, failure, true))
+	}
+	dl.cooldownTime = now.Add(delay)
+	if dl.mirror == nil {
+		return
+	}
+	dl.mirrorFailureCount++
+	if dl.mirrorFailureCount >= mirrorFailureThreshold {
+		dl.switchMirror()
+	}
+}
+
+// switchMirror makes the next request go to another mirror right away.
+func (dl *Download) switchMirror() {
+	previousURL := dl.requestURL()
+	dl.mirror = dl.mirrors.failOver(dl.mirror)
+	dl.mirrorFailureCount = 0
+	dl.cooldownTime = time.Now()
+	log.Printf("Switching download of \"%s\" to mirror: \"%s\" instead of \"%s\".", dl.url, dl.requestURL(), previousURL)
+}
+
+func (dl *Download) handleRequestSuccess() {
+	if dl.mirror != nil {
+		dl.mirrorFailureCount, dl.mirrorsGivenUpOn = 0, 0
+		dl.mirrors.reportSuccess(dl.mirror)
 	}
-	return newRangeRequestWithCancel(dl.ctx, dl.url, dl.firstByteIndex, dl.lastByteIndex)
+}
+
+func (dl *Download) getRetryPolicy() RetryPolicy {
+	if dl.retryPolicy == nil {
+		dl.retryPolicy = NewDefaultRetryPolicy()
+	}
+	return dl.retryPolicy
 }
 
 func (dl *Download) sendRequest(req *http.Request) {
+	sentAt := time.Now()
 	resp, err := DoForClientFunc(dl.client, req)
 	if err != nil {
 		dl.cleanUp()
 		dl.handler.HandleHttpGetError(dl.url, err)
 		dl.response = nil
-		dl.inscribeCooldown()
+		dl.reportProblem("request failed")
+		dl.handleRequestFailure(err, 0)
 	} else {
+		if time.Since(sentAt) > slowResponseThreshold {
+			dl.reportProblem("slow response")
+		}
 		dl.response = resp
 		if dl.downloader != nil {
 			dl.downloader.downloadInitiatedSuccessfully(dl)
@@ -182,23 +316,56 @@ func (dl *Download) sendRequest(req *http.Request) {
 
 func (dl *Download) processResponse() {
 	if !dl.gotValidFirstResponse {
-		if dl.response.StatusCode == http.StatusOK {
+		if dl.response.StatusCode == http.StatusOK && dl.isRestricted {
+			dl.cleanUp()
+			panic(errRangesNotSupported)
+		} else if dl.response.StatusCode == http.StatusOK {
+			dl.handleRequestSuccess()
 			dl.acceptFirstResponseHeader(dl.response.Header)
+			if dl.firstByteIndex > 0 {
+				dl.skipResumedBytes()
+			}
+		} else if dl.response.StatusCode == http.StatusNotModified && dl.conditions != nil && dl.firstByteIndex == 0 && !dl.isRestricted {
+			dl.handleRequestSuccess()
+			dl.notModified = true
+			dl.lastByteIndex = -1
+			dl.gotValidFirstResponse = true
+		} else if dl.response.StatusCode == http.StatusPartialContent && (dl.firstByteIndex > 0 || dl.isRestricted) {
+			dl.handleRequestSuccess()
+			dl.acceptResumedResponseHeader(dl.response.Header)
+			if dl.isRestricted {
+				dl.acceptRestriction()
+			}
 		} else {
 			dl.cleanUp()
 			if dl.response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
 				panic(DownloadError("remote file changed during download"))
 			}
+			if dl.optional && isMissingResourceStatusCode(dl.response.StatusCode) {
+				panic(DownloadError(fmt.Sprintf("remote responded with HTTP %d: %s", dl.response.StatusCode, http.StatusText(dl.response.StatusCode))))
+			}
 			dl.handler.HandleBadHttpResponse(dl.url, dl.response.StatusCode)
+			if !isOverloadStatusCode(dl.response.StatusCode) && dl.response.StatusCode >= 500 {
+				dl.reportProblem(fmt.Sprintf("HTTP %d", dl.response.StatusCode))
+			}
+			dl.handleRequestFailure(nil, dl.response.StatusCode)
+			if isOverloadStatusCode(dl.response.StatusCode) {
+				dl.handleOverloadResponse(dl.response.Header)
+			}
 			dl.response = nil
-			dl.inscribeCooldown()
 		}
 	} else if dl.response.StatusCode != http.StatusPartialContent {
 		dl.cleanUp()
 		panic(DownloadError("range-request not supported by target host, or connection has been rigged"))
+	} else {
+		dl.handleRequestSuccess()
 	}
 }
 
+func isMissingResourceStatusCode(statusCode int) bool {
+	return statusCode == http.StatusNotFound || statusCode == http.StatusGone || statusCode == http.StatusForbidden
+}
+
 func (dl *Download) acceptFirstResponseHeader(header http.Header) {
 	contentLength, err := strconv.ParseInt(NewLowercaseHeaders(header).Get("content-length"), 10, 64)
 	if err != nil {
@@ -207,9 +374,37 @@ func (dl *Download) acceptFirstResponseHeader(header http.Header) {
 	} else {
 		dl.lastByteIndex = contentLength - 1
 	}
+	dl.validators = readResourceValidators(header)
 	dl.gotValidFirstResponse = true
 }
 
+func (dl *Download) acceptResumedResponseHeader(header http.Header) {
+	dl.lastByteIndex = parseTotalLengthFromContentRangeHeader(NewLowercaseHeaders(header).Get("content-range")) - 1
+	if dl.lastByteIndex < 0 {
+		log.Printf("Assuming remote file won't change: Could not get total length from content-range header from \"%s\".", dl.url)
+		dl.lastByteIndex = -1
+	}
+	dl.gotValidFirstResponse = true
+}
+
+func (dl *Download) acceptRestriction() {
+	if dl.lastByteIndex >= 0 && dl.lastByteIndex < dl.restrictedLastByteIndex {
+		dl.cleanUp()
+		panic(DownloadError("remote file is shorter than the requested range"))
+	}
+	dl.lastByteIndex = dl.restrictedLastByteIndex
+}
+
+// skipResumedBytes discards the bytes of a response to a resumed download which the remote sent despite the range request.
+func (dl *Download) skipResumedBytes() {
+	log.Printf("Remote ignored range request to resume \"%s\" at byte %d. Skipping already present bytes.", dl.url, dl.firstByteIndex)
+	if _, err := io.CopyN(ioutil.Discard, dl.response.Body, dl.firstByteIndex); err != nil {
+		dl.cleanUp()
+		dl.response = nil
+		dl.handler.HandleReadError(dl.url, err, dl.firstByteIndex)
+	}
+}
+
 func (dl *Download) readFromResponse(p []byte) (n int, err error) {
 	n, err = dl.responseReader.Read(p)
 	dl.firstByteIndex += int64(n)
@@ -221,6 +416,7 @@ func (dl *Download) readFromResponse(p []byte) (n int, err error) {
 		dl.response = nil
 		if err != io.EOF { // Network failures are temporary. Keep trying until it works.
 			dl.handler.HandleReadError(dl.url, err, dl.firstByteIndex)
+			dl.reportProblem("connection interrupted")
 			return n, nil
 		}
 		if (dl.lastByteIndex >= 0) && (dl.firstByteIndex < dl.lastByteIndex+1) {
@@ -243,6 +439,9 @@ func setError(panicObject interface{}, errPtr *error) bool {
 	if panicErr, ok := panicObject.(DownloadError); ok {
 		*errPtr = panicErr
 		return true
+	} else if userError, ok := panicObject.(*misc.UserError); ok {
+		*errPtr = userError
+		return true
 	} else if panicObject == context.Canceled {
 		*errPtr = context.Canceled
 		return true
@@ -261,7 +460,7 @@ func (dl *Download) cleanUp() {
 
 func (dl *Download) waitCooldown() {
 	now := time.Now()
-	if dl.cooldownIndex > 0 && dl.cooldownTime.After(now) {
+	if dl.failureCount > 0 && dl.cooldownTime.After(now) {
 		select {
 		case <-time.NewTimer(dl.cooldownTime.Sub(now)).C:
 		case <-dl.ctx.Done():
@@ -270,25 +469,39 @@ func (dl *Download) waitCooldown() {
 	}
 }
 
-func (dl *Download) inscribeCooldown() {
-	cooldownIntervalOptions := []time.Duration{1, 1, 2, 3, 5, 8, 13}
-	cooldownDuration := time.Second * cooldownIntervalOptions[dl.cooldownIndex]
+// accountReceivedBytes lets the Downloader managing dl, if any, adapt its concurrency to the received bytes and blocks
+// until receiving them is within its bandwidth limit.
+func (dl *Download) accountReceivedBytes(byteCount int) {
+	if dl.downloader == nil || byteCount == 0 {
+		return
+	}
+	dl.downloader.concurrency.recordBytes(byteCount, time.Now())
+	if err := dl.downloader.limiter.take(dl.ctx, byteCount); err != nil {
+		panic(err)
+	}
+}
 
-	p := make([]byte, 1)
-	_, err := rand.Read(p)
-	if err != nil {
-		log.Printf("Could not crypto/rand.Read(): %v\n", err)
-	} else {
-		rand := ((time.Duration(p[0]) - 127) * cooldownDuration) / (10 * 128) // +/- 10%
-		cooldownDuration += rand
+// reportProblem lets the Downloader managing dl, if any, reduce its concurrency.
+func (dl *Download) reportProblem(reason string) {
+	if dl.downloader != nil {
+		dl.downloader.concurrency.reportProblem(reason, time.Now())
 	}
+}
 
-	dl.cooldownTime = time.Now().Add(cooldownDuration)
-	dl.cooldownIndex = intMin(dl.cooldownIndex+1, len(cooldownIntervalOptions)-1)
+// handleOverloadResponse makes dl and the Downloader managing it, if any, back off as the remote asks in the response.
+func (dl *Download) handleOverloadResponse(header http.Header) {
+	now := time.Now()
+	retryAfter := parseRetryAfter(NewLowercaseHeaders(header).Get("retry-after"), now)
+	if dl.downloader != nil {
+		dl.downloader.concurrency.reportOverload(retryAfter, now)
+	}
+	if dl.failureCount > 0 && now.Add(retryAfter).After(dl.cooldownTime) {
+		dl.cooldownTime = now.Add(retryAfter)
+	}
 }
 
 func (dl *Download) resetCooldown() {
-	dl.cooldownIndex = 0
+	dl.failureCount = 0
 }
 
 func intMin(a, b int) int {
@@ -4,9 +4,10 @@ import (
 	"context"
 	"crypto/rsa"
 	"crypto/sha1"
-	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"log"
@@ -27,8 +28,14 @@ import (
 
 const defaultTimeout = time.Second * 30
 
+// MaxConcurrentDownloads is the number of concurrent downloads a Downloader starts with, before it adapts them to the
+// throughput within the bounds set with SetConcurrencyBounds().
 const MaxConcurrentDownloads = 5
 
+// maxConcurrentDownloadsLimit is the hard upper bound of the number of concurrent downloads of a Downloader. The maximum of
+// MinConcurrentDownloads and MaxConcurrentDownloads in the deployment-config schema refers to it.
+const maxConcurrentDownloadsLimit = 16
+
 // Downloader has helper functions for common use cases of Download, such as writing a resource to a file while downloading it,
 // downloading multiple resources in parallel and verifying the hashsum or signature of downloading resources.
 type Downloader struct {
@@ -36,10 +43,16 @@ type Downloader struct {
 	client           *http.Client
 	ctx              context.Context
 	seenFingerprints *sync.Map
+	mirrors          *mirrorRegistry
+	limiter          *bandwidthLimiter
+	concurrency      *concurrencyController
+	retryPolicy      RetryPolicy
+	resourceCache    *resourceCache // Nil unless SetResourceCacheDirectory() has been called.
 }
 
 func NewDownloader(ctx context.Context, handler DownloadProgressHandler) *Downloader {
-	return &Downloader{handler: handler, client: MakeClient(), ctx: ctx, seenFingerprints: &sync.Map{}}
+	return &Downloader{handler: handler, client: MakeClient(), ctx: ctx, seenFingerprints: &sync.Map{}, mirrors: newMirrorRegistry(),
+		limiter: newBandwidthLimiter(), concurrency: newConcurrencyController(), retryPolicy: NewDefaultRetryPolicy()}
 }
 
 func (downloader *Downloader) downloadInitiatedSuccessfully(dl *Download) {
@@ -65,6 +78,9 @@ func (downloader *Downloader) DownloadSignedResource(fromURL string, keys []*rsa
 	return data[fromURL], nil
 }
 
+// DownloadSignedResources downloads the resources at urls along with their signatures and panics if any signature is not
+// valid for one of keys. If a resource cache has been set, it keeps copies of the resources, only downloads those which
+// changed since they were cached, and verifies cached copies just like downloaded ones.
 func (downloader *Downloader) DownloadSignedResources(urls []string, keys []*rsa.PublicKey) (map[string][]byte, error) {
 	fileMapWithSignatures := make(config.FileInfoMap)
 	for _, url := range urls {
@@ -75,14 +91,28 @@ func (downloader *Downloader) DownloadSignedResources(urls []string, keys []*rsa
 			fileMapWithSignatures[url+".signature"] = &config.FileInfo{}
 		}
 	}
-	fileData, err := downloader.DownloadToRAM(fileMapWithSignatures)
+	cachedResources := downloader.resourceCache.load(fileMapWithSignatures.FilePaths())
+	fileData, downloadedResources, err := downloader.downloadToRAM(fileMapWithSignatures, cachedResources)
 	if err != nil {
 		return nil, err
 	}
 	for _, url := range urls {
-		if !strings.HasPrefix(url, "file://") && !signatures.IsSignatureValid(fileData[url], fileData[url+".signature"], keys) {
+		if strings.HasPrefix(url, "file://") || signatures.IsSignatureValid(fileData[url], fileData[url+".signature"], keys) {
+			continue
+		}
+		if cachedResources[url] == nil && cachedResources[url+".signature"] == nil {
 			log.Panicf("Invalid signature of resource %s.", url)
 		}
+		// E.g. the remote changed the resource, but not yet its signature, or the cache has been tampered with.
+		log.Printf("Invalid signature of resource %s with cached data. Downloading it again.", url)
+		downloader.resourceCache.remove(url)
+		downloader.resourceCache.remove(url + ".signature")
+		return downloader.DownloadSignedResources(urls, keys)
+	}
+	for url, resource := range downloadedResources {
+		if !strings.HasPrefix(url, "file://") {
+			downloader.resourceCache.store(resource)
+		}
 	}
 	validatedResources := make(map[string][]byte)
 	for _, url := range urls {
@@ -120,7 +150,7 @@ func (downloader *Downloader) DownloadToDirectory(baseUrl string, fileMap config
 	urlToPathMap := make(map[string]string)
 	urlToInfoMap := make(config.FileInfoMap)
 	for relativeFilePath, fileInfo := range fileMap {
-		url := misc.MustJoinURL(baseUrl, filepath.ToSlash(relativeFilePath))
+		url := misc.MustJoinURL(baseUrl, fileInfo.TransferPath(filepath.ToSlash(relativeFilePath)))
 		urlToPathMap[url] = relativeFilePath
 		urlToInfoMap[url] = fileInfo
 	}
@@ -136,26 +166,47 @@ func (downloader *Downloader) DownloadFile(url string, fileInfo *config.FileInfo
 }
 
 func (downloader *Downloader) DownloadToRAM(fileMap config.FileInfoMap) (fileData map[string][]byte, dlErr error) {
+	fileData, _, dlErr = downloader.downloadToRAM(fileMap, nil)
+	return fileData, dlErr
+}
+
+// downloadToRAM downloads the resources in fileMap, requesting those in cachedResources conditionally and taking their
+// data from there if they did not change. It returns the data of all resources and the resources it actually downloaded.
+func (downloader *Downloader) downloadToRAM(fileMap config.FileInfoMap, cachedResources map[string]*cachedResource) (
+	fileData map[string][]byte, downloadedResources map[string]*cachedResource, dlErr error) {
 	m := &sync.Mutex{}
 	fileData = make(map[string][]byte)
-	return fileData, downloader.DownloadResources(fileMap.FilePaths(), func(dl *Download) error {
+	downloadedResources = make(map[string]*cachedResource)
+	return fileData, downloadedResources, downloader.DownloadResources(fileMap.FilePaths(), func(dl *Download) error {
 		wantedFileInfo := fileMap[dl.url]
-		hash := sha256.New()
+		cached := cachedResources[dl.url]
+		if cached != nil && !cached.Validators.isEmpty() {
+			dl.conditions = &cached.Validators
+		}
+		hash := config.NewHash(wantedFileInfo.HashAlgorithm)
 		data, err := ioutil.ReadAll(io.TeeReader(dl, hash))
 		if err != nil {
 			if downloader.ctx.Err() != nil {
 				return downloader.ctx.Err()
 			}
-			return fmt.Errorf("ioutil.ReadAll failed: %v", err)
+			return fmt.Errorf("ioutil.ReadAll failed: %w", err)
+		}
+		if dl.notModified {
+			log.Printf("Resource \"%s\" has not been modified. Using cached copy.", dl.url)
+			data = cached.data
+			hash.Write(data)
 		}
 		dlFileSha := hex.EncodeToString(hash.Sum(nil))
-		if wantedFileInfo.SHA256 != "" && !strings.EqualFold(wantedFileInfo.SHA256, dlFileSha) {
-			return fmt.Errorf("SHA256 of downloaded file \"%s\" does not match expected value \"%s\". Was \"%s\"",
-				dl.url, wantedFileInfo.SHA256, dlFileSha)
+		if wantedFileInfo.Hash != "" && !strings.EqualFold(wantedFileInfo.Hash, dlFileSha) {
+			return fmt.Errorf("Hash of downloaded file \"%s\" does not match expected value \"%s\". Was \"%s\"",
+				dl.url, wantedFileInfo.Hash, dlFileSha)
 		}
 		m.Lock()
 		defer m.Unlock()
 		fileData[dl.url] = data
+		if !dl.notModified {
+			downloadedResources[dl.url] = &cachedResource{URL: dl.url, Validators: dl.validators, data: data}
+		}
 		return nil
 	})
 }
@@ -167,8 +218,8 @@ func (downloader *Downloader) DownloadResource(url string, processDownload func(
 }
 
 // DownloadResources makes a goroutined call of processDownload with a *Download ready for Read()s for every url
-// in urls, never allowing more than MaxConcurrentDownloads to be processed at the same time and returning a non-nil
-// error if and only if any of the calls to processDownload do return a non-nil error or the context is cancelled.
+// in urls, never allowing more than the Downloader's adaptive number of concurrent downloads to be processed at the same
+// time and returning a non-nil error if and only if any of the calls to processDownload do return a non-nil error or the context is cancelled.
 func (downloader *Downloader) DownloadResources(urls []string, processDownload func(dl *Download) error) error {
 	ctx, cancelFunc := context.WithCancel(downloader.ctx)
 	defer cancelFunc()
@@ -176,7 +227,8 @@ func (downloader *Downloader) DownloadResources(urls []string, processDownload f
 }
 
 func (downloader *Downloader) runDownloadWorkers(ctx context.Context, cancelFunc context.CancelFunc, urls []string, processDownload func(dl *Download) error) error {
-	availableWorkerIds := createWorkerIdChannel(MaxConcurrentDownloads)
+	workerCount := downloader.concurrency.upperBound()
+	availableWorkerIds := createWorkerIdChannel(workerCount)
 	errChan := make(chan error, 1)
 	workerErrChan := make(chan error, len(urls))
 	allWorkersDoneCond := &sync.Cond{L: &sync.Mutex{}}
@@ -185,17 +237,22 @@ func (downloader *Downloader) runDownloadWorkers(ctx context.Context, cancelFunc
 		if ctx.Err() != nil {
 			break
 		}
+		if downloader.concurrency.acquire(ctx) != nil {
+			break
+		}
 		select {
 		case <-ctx.Done():
-			break
+			downloader.concurrency.release()
 		case workerId := <-availableWorkerIds:
 			dl := NewDownloadForConcurrentUse(ctx, url, downloader.client, downloader.handler, workerId)
 			dl.downloader = downloader
+			dl.retryPolicy = downloader.retryPolicy
+			dl.useMirrors(downloader.mirrors.lookUp(url))
 			go downloadWorker(dl, availableWorkerIds, allWorkersDoneCond, workerErrChan, processDownload)
 		}
 	}
 	allWorkersDoneCond.L.Lock()
-	for len(availableWorkerIds) < MaxConcurrentDownloads {
+	for len(availableWorkerIds) < workerCount {
 		allWorkersDoneCond.Wait()
 	}
 	allWorkersDoneCond.L.Unlock()
@@ -228,6 +285,7 @@ func downloadWorker(dl *Download, workerIds chan int, allWorkersDoneCond *sync.C
 		if panicObject != nil {
 			workerErr = fmt.Errorf("worker %d panicked: %v", dl.workerId, panicObject)
 		}
+		dl.downloader.concurrency.release()
 		allWorkersDoneCond.L.Lock()
 		workerErrChan <- workerErr
 		workerIds <- dl.workerId
@@ -241,38 +299,91 @@ func downloadWorker(dl *Download, workerIds chan int, allWorkersDoneCond *sync.C
 	workerErr = processDownload(dl)
 }
 
-func updateFile(dl *Download, expectedFileInfo *config.FileInfo, localFilePath string) (returnError error) {
+// updateFile downloads the file described by expectedFileInfo to localFilePath and gives it the declared mode, if any.
+func updateFile(dl *Download, expectedFileInfo *config.FileInfo, localFilePath string) error {
+	if err := downloadFile(dl, expectedFileInfo, localFilePath); err != nil {
+		return err
+	}
+	return applyFileMode(localFilePath, expectedFileInfo)
+}
+
+func downloadFile(dl *Download, expectedFileInfo *config.FileInfo, localFilePath string) error {
 	system.MustMakeDir(filepath.Dir(localFilePath))
-	file, err := os.OpenFile(localFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0700)
+	if shouldDownloadInChunks(expectedFileInfo) {
+		err := updateFileInChunks(dl, expectedFileInfo, localFilePath)
+		if err != errRangesNotSupported {
+			return err
+		}
+		log.Printf("Downloading \"%s\" in one piece because the remote does not support range requests.", dl.url)
+	}
+	pf, err := openPartialFile(dl.ctx, localFilePath, expectedFileInfo)
 	if err != nil {
-		return system.NewFileSystemError(fmt.Sprintf("Could not open file \"%s\" for writing", localFilePath), err)
+		return err
 	}
-	defer system.CleanUpFileOperation(file, &returnError)
-	if err = preallocate.File(file, expectedFileInfo.Size); err != nil { // Important: Screws up royally on files opened with the os.O_APPEND-flag.
+	if pf.info.Offset > 0 {
+		log.Printf("Resuming download of \"%s\" into \"%s\" at byte %d of %d.", dl.url, localFilePath, pf.info.Offset, expectedFileInfo.Size)
+		dl.resumeAt(pf.info.Offset)
+	}
+	if err = preallocate.File(pf.file, expectedFileInfo.Size); err != nil { // Important: Screws up royally on files opened with the os.O_APPEND-flag.
 		log.Printf("Could not preallocate file \"%s\" with %d bytes: %v", localFilePath, expectedFileInfo.Size, err)
 	}
-	n, dlFileSha, err := ioHashingCopy(dl.ctx, file, dl)
+	transferReader := &countingReader{reader: dl}
+	var src io.Reader = transferReader
+	if expectedFileInfo.Compression != "" {
+		decompressor, err := newDecompressingReader(expectedFileInfo.Compression, transferReader)
+		if err != nil {
+			pf.discard()
+			if dl.ctx.Err() != nil {
+				return dl.ctx.Err()
+			}
+			return fmt.Errorf("Could not decompress \"%s\": %w", dl.url, err)
+		}
+		defer decompressor.Close()
+		src = decompressor
+	}
+	src = newSizeLimitedReader(src, fmt.Sprintf("downloaded file \"%s\"", dl.url), expectedFileInfo.Size-pf.info.Offset)
+	_, dlFileSha, err := ioHashingCopy(dl.ctx, pf, src, pf.hash)
 	if err != nil {
+		var downloadError DownloadError
+		if errors.As(err, &downloadError) || errors.Is(err, errTooLarge) { // The remote file changed, cannot be resumed or is corrupt: what we have is worthless.
+			pf.discard()
+		} else {
+			pf.keep()
+		}
 		return err
 	}
-	if !strings.EqualFold(expectedFileInfo.SHA256, dlFileSha) {
-		return fmt.Errorf("Sha of downloaded file \"%s\" does not match expected value \"%s\" for file \"%s\". Was \"%s\"",
-			dl.url, expectedFileInfo.SHA256, localFilePath, dlFileSha)
+	if !strings.EqualFold(expectedFileInfo.Hash, dlFileSha) {
+		pf.discard()
+		return fmt.Errorf("Hash of downloaded file \"%s\" does not match expected value \"%s\" for file \"%s\". Was \"%s\"",
+			dl.url, expectedFileInfo.Hash, localFilePath, dlFileSha)
+	}
+	if pf.info.Offset < expectedFileInfo.Size { // Needed to prevent trailing null bytes.
+		pf.discard()
+		return fmt.Errorf("Wrote less bytes than expected after preallocating file \"%s\". Written: %d; Expected: %d", localFilePath, pf.info.Offset, expectedFileInfo.Size)
 	}
-	if n < expectedFileInfo.Size { // Needed to prevent trailing null bytes.
-		return fmt.Errorf("Wrote less bytes than expected after preallocating file \"%s\". Written: %d; Expected: %d", localFilePath, n, expectedFileInfo.Size)
+	if expectedFileInfo.Compression != "" && expectedFileInfo.CompressedSize > 0 && transferReader.n != expectedFileInfo.CompressedSize {
+		pf.discard()
+		return fmt.Errorf("Size of compressed file \"%s\" does not match expected value %d. Was %d", dl.url, expectedFileInfo.CompressedSize, transferReader.n)
+	}
+	return pf.complete()
+}
+
+// applyFileMode gives the file at localFilePath the mode which fileInfo declares, if any. Files are created with mode 0700
+// until they are complete, so that a partial download can be resumed even if the declared mode does not allow writing.
+func applyFileMode(localFilePath string, fileInfo *config.FileInfo) error {
+	if mode, ok := fileInfo.FileMode(); ok {
+		return system.SetFileMode(localFilePath, mode)
 	}
 	return nil
 }
 
-func ioHashingCopy(ctx context.Context, dst io.Writer, src io.Reader) (int64, string, error) {
-	hash := sha256.New()
+func ioHashingCopy(ctx context.Context, dst io.Writer, src io.Reader, hash hash.Hash) (int64, string, error) {
 	n, err := io.Copy(dst, io.TeeReader(src, hash))
 	if err != nil {
 		if ctx.Err() != nil {
 			return n, hex.EncodeToString(hash.Sum(nil)), ctx.Err()
 		}
-		return n, hex.EncodeToString(hash.Sum(nil)), fmt.Errorf("io.Copy failed: %v", err)
+		return n, hex.EncodeToString(hash.Sum(nil)), fmt.Errorf("io.Copy failed: %w", err)
 	}
 	return n, hex.EncodeToString(hash.Sum(nil)), nil
 }
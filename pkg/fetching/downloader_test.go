@@ -10,9 +10,13 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/system"
+	"github.com/zeebo/blake3"
 )
 
 type ErrorRecordingHandler struct {
@@ -168,7 +172,7 @@ func TestUpdateFile(t *testing.T) {
 	x := sha256.Sum256(de.Data)
 	expectedSha := hex.EncodeToString(x[:])
 	dl := NewDownload(context.Background(), "http://example.com")
-	di := &config.FileInfo{SHA256: expectedSha, Size: dataSize}
+	di := &config.FileInfo{Hash: expectedSha, Size: dataSize}
 	err = updateFile(dl, di, "testfile.txt")
 	if err != nil {
 		t.Fatal(err)
@@ -182,3 +186,100 @@ func TestUpdateFile(t *testing.T) {
 		t.Fatalf("Data on disk mismatches data of download")
 	}
 }
+
+func TestUpdateFileVerifiesWithHashAlgorithmOfFileInfo(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "testfile.txt")
+	const dataSize = 2000
+	de := CreateDummyEnvironment(t, dataSize, -1)
+	DoForClientFunc = de.DoForClientFunc
+	sum := blake3.Sum256(de.Data)
+	di := &config.FileInfo{Hash: hex.EncodeToString(sum[:]), HashAlgorithm: config.HashAlgorithmBLAKE3, Size: dataSize}
+	if err := updateFile(NewDownload(context.Background(), "http://example.com"), di, filePath); err != nil {
+		t.Fatal(err)
+	}
+	di.HashAlgorithm = config.HashAlgorithmSHA256
+	if err := updateFile(NewDownload(context.Background(), "http://example.com"), di, filePath); err == nil {
+		t.Fatalf("Download succeeded although the file's BLAKE3 hash was checked as SHA-256")
+	}
+}
+
+func TestUpdateFileAppliesDeclaredMode(t *testing.T) {
+	if !system.SupportsFileModes {
+		t.Skip("File modes are not supported on this operating system.")
+	}
+	filePath := filepath.Join(t.TempDir(), "run.sh")
+	const dataSize = 2000
+	de := CreateDummyEnvironment(t, dataSize, -1)
+	DoForClientFunc = de.DoForClientFunc
+	sum := sha256.Sum256(de.Data)
+	di := &config.FileInfo{Hash: hex.EncodeToString(sum[:]), Size: dataSize, Mode: "0555"}
+	if err := updateFile(NewDownload(context.Background(), "http://example.com"), di, filePath); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filePath); err != nil || info.Mode().Perm() != 0555 {
+		t.Fatalf("Expected downloaded file to have mode 0555. Got %v (%v)", info.Mode(), err)
+	}
+}
+
+func TestUpdateFileResumesPartialDownload(t *testing.T) {
+	testUpdateFileResumesPartialDownload(t, false)
+}
+
+func TestUpdateFileResumesPartialDownloadWithoutRangeSupport(t *testing.T) {
+	testUpdateFileResumesPartialDownload(t, true)
+}
+
+func testUpdateFileResumesPartialDownload(t *testing.T, ignoreRange bool) {
+	d, err := ioutil.TempDir("", "trivrost-partial-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	const dataSize, offset = 2000, 700
+	de := CreateDummyEnvironment(t, dataSize, -1)
+	var requestedRanges []string
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		requestedRanges = append(requestedRanges, NewLowercaseHeaders(req.Header).Get("range"))
+		if ignoreRange {
+			delete(req.Header, "range")
+		}
+		return de.DoForClientFunc(client, req)
+	}
+	x := sha256.Sum256(de.Data)
+	di := &config.FileInfo{Hash: hex.EncodeToString(x[:]), Size: dataSize}
+	localFilePath := filepath.Join(d, "testfile.txt")
+	if err = ioutil.WriteFile(localFilePath, de.Data[:offset], 0600); err != nil {
+		t.Fatal(err)
+	}
+	info := fmt.Sprintf(`{"Hash":"%s","Size":%d,"Offset":%d}`, di.Hash, di.Size, offset)
+	if err = ioutil.WriteFile(PartialInfoFilePath(localFilePath), []byte(info), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = updateFile(NewDownload(context.Background(), "http://example.com"), di, localFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if len(requestedRanges) == 0 || requestedRanges[0] != fmt.Sprintf("bytes=%d-", offset) {
+		t.Errorf("Expected first request to ask for range \"bytes=%d-\". Requested ranges: %q", offset, requestedRanges)
+	}
+	diskData, err := ioutil.ReadFile(localFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(diskData, de.Data) {
+		t.Fatalf("Data on disk mismatches data of download")
+	}
+	if _, err = os.Stat(PartialInfoFilePath(localFilePath)); !os.IsNotExist(err) {
+		t.Fatalf("Partial download info has not been removed: %v", err)
+	}
+}
+
+func TestReadPartialInfoDiscardsInfoWithHashNamedSHA256(t *testing.T) {
+	infoFilePath := PartialInfoFilePath(filepath.Join(t.TempDir(), "testfile.txt"))
+	hash := strings.Repeat("ab", 32)
+	if err := ioutil.WriteFile(infoFilePath, []byte(`{"SHA256":"`+hash+`","Size":2000,"Offset":700}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if info := readPartialInfo(infoFilePath, &config.FileInfo{Hash: hash, Size: 2000}); info != nil {
+		t.Errorf("Expected partial download info in the format which predates HashAlgorithm to be discarded. Got %+v", info)
+	}
+}
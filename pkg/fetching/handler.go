@@ -3,10 +3,16 @@ package fetching
 import (
 	"net/http"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/setlog/trivrost/pkg/stats"
 	log "github.com/sirupsen/logrus"
 )
 
+// progressRateHalfLife is how quickly the estimated download rate follows changes of the actual rate.
+const progressRateHalfLife = time.Second * 5
+
 // DownloadProgressHandler is an interface which defines callbacks for typical events which
 // will or may occur during a resource download via an HTTP GET request, such as receiving bytes,
 // the connection being interrupted or a bad HTTP response code being received.
@@ -75,3 +81,96 @@ func (handler *EmptyHandler) HandleBadHttpResponse(fromURL string, code int) {
 
 func (handler *EmptyHandler) HandleReadError(fromURL string, err error, receivedByteCount int64) {
 }
+
+// ProgressEstimatingHandler sums up the bytes received by the downloads of a stage and estimates their rate and the time
+// remaining. The estimate holds steady while failed requests are retried. Frontends embed it and call its methods from
+// their own, so that they only need to present the estimate.
+type ProgressEstimatingHandler struct {
+	progressMutex          *sync.RWMutex
+	progressAccumulator    uint64
+	ongoingProgressBuckets []uint64 // Bytes received by the current download of each worker.
+	stalledURL             string   // The URL whose failed request stalled the stage, if any.
+	estimator              *stats.ProgressEstimator
+}
+
+func NewProgressEstimatingHandler() *ProgressEstimatingHandler {
+	return &ProgressEstimatingHandler{progressMutex: &sync.RWMutex{}, ongoingProgressBuckets: make([]uint64, maxConcurrentDownloadsLimit),
+		estimator: stats.NewProgressEstimator(progressRateHalfLife)}
+}
+
+// ResetProgress starts a new stage in which progressTarget bytes are expected to be downloaded.
+func (handler *ProgressEstimatingHandler) ResetProgress(progressTarget uint64) {
+	handler.progressMutex.Lock()
+	defer handler.progressMutex.Unlock()
+	handler.progressAccumulator = 0
+	for i := range handler.ongoingProgressBuckets {
+		handler.ongoingProgressBuckets[i] = 0
+	}
+	handler.stalledURL = ""
+	handler.estimator.Reset(progressTarget)
+}
+
+// GetProgress returns the bytes received in the current stage.
+func (handler *ProgressEstimatingHandler) GetProgress() uint64 {
+	handler.progressMutex.Lock()
+	defer handler.progressMutex.Unlock()
+	currentTotal := handler.progressAccumulator
+	for _, v := range handler.ongoingProgressBuckets {
+		currentTotal += v
+	}
+	return currentTotal
+}
+
+// EstimateProgress samples the progress of the current stage and returns the estimate of its download rate, the bytes
+// remaining and the time it will take to download them.
+func (handler *ProgressEstimatingHandler) EstimateProgress() stats.ProgressEstimate {
+	return handler.estimator.Sample(handler.GetProgress(), time.Now())
+}
+
+func (handler *ProgressEstimatingHandler) HandleProgress(fromURL string, workerId int, receivedBytes uint64) {
+	handler.progressMutex.RLock()
+	handler.ongoingProgressBuckets[workerId] = receivedBytes // Only the worker itself writes to its bucket.
+	isStalledByURL := fromURL == handler.stalledURL
+	handler.progressMutex.RUnlock()
+	if isStalledByURL {
+		handler.progressMutex.Lock()
+		defer handler.progressMutex.Unlock()
+		if fromURL == handler.stalledURL { // Unless another request stalled the stage meanwhile.
+			handler.stalledURL = ""
+			handler.estimator.SetStalled(false)
+		}
+	}
+}
+
+func (handler *ProgressEstimatingHandler) HandleStartDownload(fromURL string, workerId int) {
+}
+
+func (handler *ProgressEstimatingHandler) HandleFinishDownload(fromURL string, workerId int) {
+	handler.progressMutex.Lock()
+	defer handler.progressMutex.Unlock()
+	handler.progressAccumulator += handler.ongoingProgressBuckets[workerId]
+	handler.ongoingProgressBuckets[workerId] = 0
+}
+
+func (handler *ProgressEstimatingHandler) HandleFailDownload(fromURL string, workerId int, err error) {
+}
+
+func (handler *ProgressEstimatingHandler) HandleHttpGetError(fromURL string, err error) {
+	handler.setStalledBy(fromURL)
+}
+
+func (handler *ProgressEstimatingHandler) HandleBadHttpResponse(fromURL string, code int) {
+	handler.setStalledBy(fromURL)
+}
+
+func (handler *ProgressEstimatingHandler) HandleReadError(fromURL string, err error, receivedByteCount int64) {
+	handler.setStalledBy(fromURL)
+}
+
+// setStalledBy marks the stage as stalled until the request for fromURL, which is being retried, makes progress again.
+func (handler *ProgressEstimatingHandler) setStalledBy(fromURL string) {
+	handler.progressMutex.Lock()
+	defer handler.progressMutex.Unlock()
+	handler.stalledURL = fromURL
+	handler.estimator.SetStalled(true)
+}
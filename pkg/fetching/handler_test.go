@@ -0,0 +1,44 @@
+package fetching
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestProgressEstimatingHandlerSumsUpProgress(t *testing.T) {
+	handler := NewProgressEstimatingHandler()
+	handler.ResetProgress(1000)
+	handler.HandleProgress("http://example.com/a", 0, 100)
+	handler.HandleProgress("http://example.com/b", 1, 200)
+	handler.HandleFinishDownload("http://example.com/a", 0)
+	handler.HandleProgress("http://example.com/c", 0, 50)
+	if progress := handler.GetProgress(); progress != 350 {
+		t.Errorf("Expected progress of 350 bytes. Got %d", progress)
+	}
+	if estimate := handler.EstimateProgress(); estimate.Done != 350 || estimate.Remaining != 650 {
+		t.Errorf("Expected 350 bytes done and 650 remaining. Got %+v", estimate)
+	}
+	handler.ResetProgress(500)
+	if progress := handler.GetProgress(); progress != 0 {
+		t.Errorf("Expected no progress after reset. Got %d", progress)
+	}
+}
+
+func TestProgressEstimatingHandlerStallsUntilFailedRequestProgresses(t *testing.T) {
+	handler := NewProgressEstimatingHandler()
+	handler.ResetProgress(1000)
+	handler.HandleReadError("http://example.com/a", fmt.Errorf("connection reset"), 100)
+	handler.HandleProgress("http://example.com/b", 1, 200)
+	if handler.stalledURL != "http://example.com/a" {
+		t.Errorf("Expected progress of another download to leave the stage stalled. Stalled by %q", handler.stalledURL)
+	}
+	handler.HandleProgress("http://example.com/a", 0, 150)
+	if handler.stalledURL != "" {
+		t.Errorf("Expected progress of the failed download to end the stall. Stalled by %q", handler.stalledURL)
+	}
+	handler.HandleBadHttpResponse("http://example.com/a", 503)
+	handler.ResetProgress(1000)
+	if handler.stalledURL != "" {
+		t.Errorf("Expected a new stage not to be stalled. Stalled by %q", handler.stalledURL)
+	}
+}
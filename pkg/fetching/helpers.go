@@ -34,17 +34,20 @@ func MakeClient() *http.Client {
 }
 
 func GetProxyLoggingFunc() func(req *http.Request) (*url.URL, error) {
-	proxyFunc := ieproxy.GetProxyFunc()
+	return newProxyLoggingFunc(ieproxy.GetProxyFunc())
+}
+
+func newProxyLoggingFunc(proxyFunc func(req *http.Request) (*url.URL, error)) func(req *http.Request) (*url.URL, error) {
 	log := logging.NewLogLimiter(5)
 	return func(req *http.Request) (*url.URL, error) {
 		proxyURL, err := proxyFunc(req)
 		if err != nil {
-			log.Warnf("Getting proxy for URL %v failed: %v", req.URL, err)
+			log.Warnf("Getting proxy for URL %v failed: %v", req.URL.Redacted(), err)
 		} else {
 			if proxyURL == nil {
-				log.Infof("GET %v (direct).", req.URL)
+				log.Infof("GET %v (direct).", req.URL.Redacted())
 			} else {
-				log.Infof("GET %v (proxy: %v).", req.URL, proxyURL)
+				log.Infof("GET %v (proxy: %v).", req.URL.Redacted(), proxyURL.Redacted())
 			}
 		}
 		return proxyURL, err
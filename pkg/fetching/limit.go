@@ -0,0 +1,69 @@
+package fetching
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errTooLarge is wrapped by the errors of sizeLimitedReader and sizeLimitedWriter. Mirrors are not trusted, so a transfer
+// which yields more bytes than the bundle info declares is treated as corrupt as soon as it does, rather than once it has
+// filled the disk and its hash is checked.
+var errTooLarge = errors.New("exceeds its declared size")
+
+// sizeLimitedReader reads from reader and fails with errTooLarge once it would yield more than limit bytes in total.
+type sizeLimitedReader struct {
+	reader io.Reader
+	what   string
+	limit  int64
+	n      int64
+}
+
+func newSizeLimitedReader(reader io.Reader, what string, limit int64) *sizeLimitedReader {
+	return &sizeLimitedReader{reader: reader, what: what, limit: limit}
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	if r.n > r.limit {
+		return 0, tooLargeError(r.what, r.limit)
+	}
+	if int64(len(p)) > r.limit-r.n+1 {
+		p = p[:r.limit-r.n+1] // Read one byte beyond the limit at most, which suffices to notice that it is exceeded.
+	}
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	if r.n > r.limit {
+		return n - int(r.n-r.limit), tooLargeError(r.what, r.limit)
+	}
+	return n, err
+}
+
+// sizeLimitedWriter writes to writer and fails with errTooLarge once more than limit bytes are written in total.
+type sizeLimitedWriter struct {
+	writer io.Writer
+	what   string
+	limit  int64
+	n      int64
+}
+
+func newSizeLimitedWriter(writer io.Writer, what string, limit int64) *sizeLimitedWriter {
+	return &sizeLimitedWriter{writer: writer, what: what, limit: limit}
+}
+
+func (w *sizeLimitedWriter) Write(p []byte) (int, error) {
+	if remaining := w.limit - w.n; int64(len(p)) > remaining {
+		n, err := w.writer.Write(p[:remaining])
+		w.n += int64(n)
+		if err == nil {
+			err = tooLargeError(w.what, w.limit)
+		}
+		return n, err
+	}
+	n, err := w.writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func tooLargeError(what string, limit int64) error {
+	return fmt.Errorf("%s %w of %d bytes", what, errTooLarge, limit)
+}
@@ -0,0 +1,31 @@
+package fetching
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSizeLimitedReaderFailsBeyondLimit(t *testing.T) {
+	data, err := io.ReadAll(newSizeLimitedReader(strings.NewReader("abc"), "file", 3))
+	if err != nil || string(data) != "abc" {
+		t.Errorf("Expected to read all of \"abc\" within its limit. Got %q, %v", data, err)
+	}
+	data, err = io.ReadAll(newSizeLimitedReader(strings.NewReader("abcd"), "file", 3))
+	if !errors.Is(err, errTooLarge) || string(data) != "abc" {
+		t.Errorf("Expected errTooLarge after \"abc\". Got %q, %v", data, err)
+	}
+}
+
+func TestSizeLimitedWriterFailsBeyondLimit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newSizeLimitedWriter(buf, "file", 3)
+	if _, err := w.Write([]byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := w.Write([]byte("cd")); !errors.Is(err, errTooLarge) || n != 1 || buf.String() != "abc" {
+		t.Errorf("Expected errTooLarge after writing \"abc\". Got %d, %v, %q", n, err, buf.String())
+	}
+}
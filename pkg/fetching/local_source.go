@@ -0,0 +1,235 @@
+package fetching
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// LocalSource serves the resources of a deployment from a directory or an archive instead of their URLs, e.g. from
+// removable media at sites without internet access. Resources keep their URLs, so they are verified against their
+// signatures and hashes just like downloaded ones.
+//
+// The resource at a URL which starts with the base URL of the source is looked up at the path which follows the base
+// URL. Other resources are looked up at "<host>/<path>" of their URL, which is the layout of a mirrored website.
+type LocalSource struct {
+	baseURL       *url.URL
+	files         fs.FS
+	closer        io.Closer
+	fileTransport http.RoundTripper // Serves resources with the "file"-scheme as usual.
+}
+
+// OpenLocalSource opens the directory, ".zip"-archive or uncompressed ".tar"-archive at sourcePath, which contains the
+// deployment with the deployment-config at deploymentConfigURL at its root.
+func OpenLocalSource(sourcePath, deploymentConfigURL string) (*LocalSource, error) {
+	baseURL, err := url.Parse(deploymentConfigURL)
+	if err != nil {
+		return nil, err
+	}
+	baseURL.Path = path.Dir(baseURL.Path)
+	source := &LocalSource{baseURL: baseURL, fileTransport: http.NewFileTransport(http.Dir("/"))}
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	lowerCasePath := strings.ToLower(sourcePath)
+	if info.IsDir() {
+		source.files = os.DirFS(sourcePath)
+	} else if strings.HasSuffix(lowerCasePath, ".zip") {
+		zipReader, err := zip.OpenReader(sourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not open zip-archive \"%s\": %w", sourcePath, err)
+		}
+		source.files, source.closer = zipReader, zipReader
+	} else if strings.HasSuffix(lowerCasePath, ".tar") {
+		tarFS, err := openTarFS(sourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not open tar-archive \"%s\": %w", sourcePath, err)
+		}
+		source.files, source.closer = tarFS, tarFS.file
+	} else {
+		return nil, fmt.Errorf("\"%s\" is neither a directory, nor a \".zip\"- or \".tar\"-archive", sourcePath)
+	}
+	log.Printf("Serving resources under \"%s\" from \"%s\".", source.baseURL.Redacted(), sourcePath)
+	return source, nil
+}
+
+// Close closes the archive of the source, if any.
+func (source *LocalSource) Close() error {
+	if source.closer != nil {
+		return source.closer.Close()
+	}
+	return nil
+}
+
+// filePath returns the path of the resource at u within the source.
+func (source *LocalSource) filePath(u *url.URL) string {
+	basePath := strings.TrimSuffix(source.baseURL.Path, "/") + "/"
+	if u.Scheme == source.baseURL.Scheme && u.Host == source.baseURL.Host && strings.HasPrefix(u.Path, basePath) {
+		return path.Clean(strings.TrimPrefix(u.Path, basePath))
+	}
+	return path.Clean(u.Host + "/" + strings.TrimPrefix(u.Path, "/"))
+}
+
+// RoundTrip serves GET requests for the resources of the source, including requests for a single range of bytes.
+func (source *LocalSource) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "file" {
+		return source.fileTransport.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if req.Method != http.MethodGet {
+		return newLocalSourceResponse(req, http.StatusMethodNotAllowed, nil), nil
+	}
+	filePath := source.filePath(req.URL)
+	if !fs.ValidPath(filePath) {
+		return newLocalSourceResponse(req, http.StatusNotFound, nil), nil
+	}
+	file, err := source.files.Open(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return newLocalSourceResponse(req, http.StatusNotFound, nil), nil
+		}
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		return newLocalSourceResponse(req, http.StatusNotFound, nil), nil
+	}
+	size := info.Size()
+	rangeHeader := NewLowercaseHeaders(req.Header).Get("range")
+	if rangeHeader == "" {
+		resp := newLocalSourceResponse(req, http.StatusOK, file)
+		resp.ContentLength = size
+		resp.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+		return resp, nil
+	}
+	first, last, err := ParseRange(rangeHeader, size-1)
+	if err != nil || first > last || first >= size {
+		file.Close()
+		resp := newLocalSourceResponse(req, http.StatusRequestedRangeNotSatisfiable, nil)
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return resp, nil
+	}
+	if last >= size {
+		last = size - 1
+	}
+	if err = skip(file, first); err != nil {
+		file.Close()
+		return nil, err
+	}
+	resp := newLocalSourceResponse(req, http.StatusPartialContent, &limitedReadCloser{Reader: io.LimitReader(file, last-first+1), Closer: file})
+	resp.ContentLength = last - first + 1
+	resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, size))
+	return resp, nil
+}
+
+func newLocalSourceResponse(req *http.Request, statusCode int, body io.ReadCloser) *http.Response {
+	if body == nil {
+		body = ioutil.NopCloser(strings.NewReader(""))
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       body,
+		Request:    req,
+	}
+}
+
+// skip advances file by n bytes. Files in compressed archives cannot seek, so their bytes are read and discarded.
+func skip(file fs.File, n int64) error {
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, file, n)
+	return err
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// tarFS is a read-only fs.FS of the regular files in an uncompressed tar-archive, which it reads directly from the
+// archive file instead of extracting them.
+type tarFS struct {
+	file    *os.File
+	entries map[string]*tarEntry
+}
+
+type tarEntry struct {
+	offset int64
+	header *tar.Header
+}
+
+type tarFile struct {
+	*io.SectionReader
+	info fs.FileInfo
+}
+
+func (file *tarFile) Stat() (fs.FileInfo, error) { return file.info, nil }
+func (file *tarFile) Close() error               { return nil }
+
+// openTarFS indexes the tar-archive at filePath. The reader of the archive seeks over the contents of the files.
+func openTarFS(filePath string) (*tarFS, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	tarFS := &tarFS{file: file, entries: make(map[string]*tarEntry)}
+	tarReader := tar.NewReader(file)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return tarFS, nil
+		} else if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		tarFS.entries[path.Clean(strings.TrimPrefix(header.Name, "./"))] = &tarEntry{offset: offset, header: header}
+	}
+}
+
+func (tarFS *tarFS) Open(name string) (fs.File, error) {
+	entry, ok := tarFS.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &tarFile{SectionReader: io.NewSectionReader(tarFS.file, entry.offset, entry.header.Size), info: entry.header.FileInfo()}, nil
+}
+
+// SetLocalSource makes the Downloader take all resources with the "http"- and "https"-schemes from source instead of
+// downloading them. It must be called after all other settings of connections, which it makes irrelevant.
+func (downloader *Downloader) SetLocalSource(source *LocalSource) {
+	downloader.client = &http.Client{Transport: source}
+}
@@ -0,0 +1,167 @@
+package fetching
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/rsa"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var localSourceFiles = map[string]string{
+	"deployment-config.json":   "config",
+	"bundles/app/bundleinfo":   "info",
+	"other.example.com/a/b.js": "other",
+}
+
+func writeLocalSourceDirectory(t *testing.T) string {
+	directoryPath := t.TempDir()
+	for name, data := range localSourceFiles {
+		filePath := filepath.Join(directoryPath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directoryPath
+}
+
+func writeLocalSourceZip(t *testing.T) string {
+	filePath := filepath.Join(t.TempDir(), "deploy.zip")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zipWriter := zip.NewWriter(file)
+	for name, data := range localSourceFiles {
+		entryWriter, err := zipWriter.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entryWriter.Write([]byte(data))
+	}
+	if err = zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func writeLocalSourceTar(t *testing.T) string {
+	filePath := filepath.Join(t.TempDir(), "deploy.tar")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tarWriter := tar.NewWriter(file)
+	for name, data := range localSourceFiles {
+		if err = tarWriter.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tarWriter.Write([]byte(data))
+	}
+	if err = tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func getFromLocalSource(t *testing.T, source *LocalSource, url string, rangeHeader string) (int, string) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	resp, err := (&http.Client{Transport: source}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestLocalSource(t *testing.T) {
+	for kind, write := range map[string]func(t *testing.T) string{
+		"directory": writeLocalSourceDirectory, "zip": writeLocalSourceZip, "tar": writeLocalSourceTar,
+	} {
+		t.Run(kind, func(t *testing.T) {
+			source, err := OpenLocalSource(write(t), "https://example.com/deploy/deployment-config.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer source.Close()
+			tests := []struct {
+				url, rangeHeader string
+				status           int
+				data             string
+			}{
+				{"https://example.com/deploy/deployment-config.json", "", http.StatusOK, "config"},
+				{"https://example.com/deploy/bundles/app/bundleinfo", "", http.StatusOK, "info"},
+				{"https://other.example.com/a/b.js", "", http.StatusOK, "other"},
+				{"https://example.com/deploy/deployment-config.json", "bytes=2-", http.StatusPartialContent, "nfig"},
+				{"https://example.com/deploy/deployment-config.json", "bytes=1-2", http.StatusPartialContent, "on"},
+				{"https://example.com/deploy/deployment-config.json", "bytes=6-", http.StatusRequestedRangeNotSatisfiable, ""},
+				{"https://example.com/deploy/missing.json", "", http.StatusNotFound, ""},
+				{"https://example.com/deploy/bundles", "", http.StatusNotFound, ""},
+				{"https://example.com/deploy/../../etc/passwd", "", http.StatusNotFound, ""},
+			}
+			for _, test := range tests {
+				status, data := getFromLocalSource(t, source, test.url, test.rangeHeader)
+				if status != test.status || data != test.data {
+					t.Errorf("GET %s with range %q: expected %d %q. Got %d %q", test.url, test.rangeHeader, test.status, test.data, status, data)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenLocalSourceRejectsOtherFiles(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "deploy.tar.gz")
+	if err := ioutil.WriteFile(filePath, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenLocalSource(filePath, "https://example.com/deployment-config.json"); err == nil {
+		t.Errorf("Expected error for unsupported archive.")
+	}
+}
+
+func TestDownloadSignedResourcesFromLocalSource(t *testing.T) {
+	server := newSignedResourceServer(t, map[string]string{"/deploy/deployment-config.json": "config"})
+	directoryPath := t.TempDir()
+	for name, data := range server.resources {
+		if err := ioutil.WriteFile(filepath.Join(directoryPath, filepath.Base(name)), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.Close()
+	source, err := OpenLocalSource(directoryPath, "https://example.com/deploy/deployment-config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetRetryPolicy(&BackoffRetryPolicy{MaxAttempts: 1})
+	downloader.SetLocalSource(source)
+	url := "https://example.com/deploy/deployment-config.json"
+	data, err := downloader.DownloadSignedResources([]string{url}, []*rsa.PublicKey{server.publicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[url]) != "config" {
+		t.Errorf("Expected data \"config\". Got %q", data[url])
+	}
+}
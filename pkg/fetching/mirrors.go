@@ -0,0 +1,149 @@
+package fetching
+
+import (
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// After this many consecutive failed requests to a mirror, a Download switches to the next mirror and the failing
+// mirror is considered unhealthy until a request to it succeeds again.
+const mirrorFailureThreshold = 3
+
+// Mirror is a URL under which the same resources are served as under the other Mirrors it is added with.
+type Mirror struct {
+	URL    string
+	Weight int // If any of a group of Mirrors has a positive weight, their order of preference is randomized by weight.
+}
+
+type mirrorState struct {
+	url       string
+	unhealthy bool
+}
+
+// mirrorGroup tracks the health of a group of mirrors over the lifetime of a Downloader.
+type mirrorGroup struct {
+	mutex   *sync.Mutex
+	mirrors []*mirrorState // In order of preference.
+}
+
+type mirrorRegistry struct {
+	mutex  *sync.RWMutex
+	groups []*mirrorGroup
+}
+
+func newMirrorRegistry() *mirrorRegistry {
+	return &mirrorRegistry{mutex: &sync.RWMutex{}}
+}
+
+// AddMirrors makes downloads of resources under the URL of any of the given mirrors use the URL of the most preferable
+// healthy mirror instead and switch to another mirror when it fails repeatedly. Resources are expected to be
+// identical on all mirrors, so callers must verify them no matter which mirror they were downloaded from.
+func (downloader *Downloader) AddMirrors(mirrors []Mirror) {
+	if len(mirrors) < 2 {
+		return
+	}
+	group := &mirrorGroup{mutex: &sync.Mutex{}}
+	for _, mirror := range orderMirrorsByPreference(mirrors) {
+		group.mirrors = append(group.mirrors, &mirrorState{url: strings.TrimRight(mirror.URL, "/")})
+	}
+	downloader.mirrors.mutex.Lock()
+	defer downloader.mirrors.mutex.Unlock()
+	downloader.mirrors.groups = append(downloader.mirrors.groups, group)
+}
+
+func orderMirrorsByPreference(mirrors []Mirror) []Mirror {
+	var weighted, unweighted []Mirror
+	totalWeight := 0
+	for _, mirror := range mirrors {
+		if mirror.Weight > 0 {
+			weighted = append(weighted, mirror)
+			totalWeight += mirror.Weight
+		} else {
+			unweighted = append(unweighted, mirror)
+		}
+	}
+	ordered := make([]Mirror, 0, len(mirrors))
+	for len(weighted) > 0 {
+		pick := rand.Intn(totalWeight)
+		for i, mirror := range weighted {
+			if pick < mirror.Weight {
+				ordered = append(ordered, mirror)
+				totalWeight -= mirror.Weight
+				weighted = append(weighted[:i], weighted[i+1:]...)
+				break
+			}
+			pick -= mirror.Weight
+		}
+	}
+	return append(ordered, unweighted...)
+}
+
+// lookUp returns the group of mirrors which serves the resource at url and the path of the resource relative to the mirrors.
+// If several groups match, the one with the longest matching URL wins.
+func (registry *mirrorRegistry) lookUp(url string) (group *mirrorGroup, relativePath string) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	longestMatch := -1
+	for _, candidate := range registry.groups {
+		for _, mirror := range candidate.mirrors {
+			if len(mirror.url) > longestMatch && isUnderMirror(url, mirror.url) {
+				group, relativePath, longestMatch = candidate, url[len(mirror.url):], len(mirror.url)
+			}
+		}
+	}
+	return group, relativePath
+}
+
+// isUnderMirror returns true if url is mirrorURL itself, a resource within it or a resource derived from it, like a ".signature" file.
+func isUnderMirror(url, mirrorURL string) bool {
+	if !strings.HasPrefix(url, mirrorURL) {
+		return false
+	}
+	remainder := url[len(mirrorURL):]
+	return remainder == "" || strings.HasPrefix(remainder, "/") || strings.HasPrefix(remainder, ".")
+}
+
+// preferred returns the most preferable healthy mirror, or the most preferable one if none is healthy.
+func (group *mirrorGroup) preferred() *mirrorState {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	for _, mirror := range group.mirrors {
+		if !mirror.unhealthy {
+			return mirror
+		}
+	}
+	return group.mirrors[0]
+}
+
+// failOver marks current as unhealthy and returns the mirror to try next: the most preferable healthy one or,
+// if none is healthy, the one after current.
+func (group *mirrorGroup) failOver(current *mirrorState) *mirrorState {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if !current.unhealthy {
+		log.Printf("Considering mirror \"%s\" unhealthy after %d failed requests.", current.url, mirrorFailureThreshold)
+		current.unhealthy = true
+	}
+	for _, mirror := range group.mirrors {
+		if !mirror.unhealthy {
+			return mirror
+		}
+	}
+	for i, mirror := range group.mirrors {
+		if mirror == current {
+			return group.mirrors[(i+1)%len(group.mirrors)]
+		}
+	}
+	return group.mirrors[0]
+}
+
+func (group *mirrorGroup) reportSuccess(mirror *mirrorState) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if mirror.unhealthy {
+		log.Printf("Mirror \"%s\" is healthy again.", mirror.url)
+		mirror.unhealthy = false
+	}
+}
@@ -0,0 +1,89 @@
+package fetching
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func TestMirrorLookUp(t *testing.T) {
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.AddMirrors([]Mirror{{URL: "https://a.example.com/bundle"}, {URL: "https://b.example.com/mirror/bundle/"}})
+	downloader.AddMirrors([]Mirror{{URL: "https://a.example.com/bundle/bundleinfo.json"}, {URL: "https://b.example.com/info.json"}})
+	tests := []struct {
+		url          string
+		expectedURL  string
+		relativePath string
+	}{
+		{"https://a.example.com/bundle/dir/file", "https://a.example.com/bundle", "/dir/file"},
+		{"https://b.example.com/mirror/bundle/file", "https://a.example.com/bundle", "/file"},
+		{"https://a.example.com/bundle/bundleinfo.json.signature", "https://a.example.com/bundle/bundleinfo.json", ".signature"},
+		{"https://a.example.com/bundle2/file", "", ""},
+	}
+	for _, test := range tests {
+		group, relativePath := downloader.mirrors.lookUp(test.url)
+		if test.expectedURL == "" {
+			if group != nil {
+				t.Errorf("Expected no mirrors for \"%s\".", test.url)
+			}
+			continue
+		}
+		if group == nil || group.mirrors[0].url != test.expectedURL || relativePath != test.relativePath {
+			t.Errorf("Expected \"%s\" to be \"%s\" under \"%s\". Got \"%s\" under %v.", test.url, test.relativePath, test.expectedURL, relativePath, group)
+		}
+	}
+}
+
+func TestOrderMirrorsByPreferenceKeepsOrderWithoutWeights(t *testing.T) {
+	mirrors := orderMirrorsByPreference([]Mirror{{URL: "a"}, {URL: "b"}, {URL: "c"}})
+	if mirrors[0].URL != "a" || mirrors[1].URL != "b" || mirrors[2].URL != "c" {
+		t.Errorf("Unweighted mirrors have been reordered: %v", mirrors)
+	}
+	mirrors = orderMirrorsByPreference([]Mirror{{URL: "a"}, {URL: "b", Weight: 1}, {URL: "c", Weight: 1}})
+	if mirrors[2].URL != "a" {
+		t.Errorf("Unweighted mirror should be least preferable: %v", mirrors)
+	}
+}
+
+func TestDownloadFailsOverToMirror(t *testing.T) {
+	data := []byte("Served by the mirror.")
+	var requestedHosts []string
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		requestedHosts = append(requestedHosts, req.URL.Host)
+		if req.URL.Host == "primary.example.com" {
+			return nil, fmt.Errorf("host unreachable")
+		}
+		if req.URL.Path != "/mirror/file" {
+			return &http.Response{StatusCode: http.StatusNotFound, Header: make(http.Header), Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+		}
+		header := http.Header{"content-length": []string{fmt.Sprintf("%d", len(data))}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+	}
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.AddMirrors([]Mirror{{URL: "https://primary.example.com/bundle"}, {URL: "https://secondary.example.com/mirror"}})
+	fileData, err := downloader.DownloadToRAM(config.FileInfoMap{"https://primary.example.com/bundle/file": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fileData["https://primary.example.com/bundle/file"], data) {
+		t.Fatalf("Got unexpected data: %q", fileData["https://primary.example.com/bundle/file"])
+	}
+	if strings.Join(requestedHosts, ",") != "primary.example.com,primary.example.com,primary.example.com,secondary.example.com" {
+		t.Errorf("Unexpected sequence of requested hosts: %v", requestedHosts)
+	}
+
+	// The unhealthy primary is skipped by subsequent downloads.
+	requestedHosts = nil
+	if _, err = downloader.DownloadToRAM(config.FileInfoMap{"https://primary.example.com/bundle/file": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(requestedHosts, ",") != "secondary.example.com" {
+		t.Errorf("Unexpected sequence of requested hosts: %v", requestedHosts)
+	}
+}
@@ -0,0 +1,158 @@
+package pac
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	log "github.com/sirupsen/logrus"
+)
+
+// Replaceable in tests.
+var (
+	lookupHost     = net.LookupHost
+	localIPAddress = findLocalIPAddress
+	now            = time.Now
+)
+
+var weekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// globalFunctions returns the functions which browsers offer to proxy auto-config scripts, except for dateRange().
+func globalFunctions(runtime *goja.Runtime) map[string]interface{} {
+	return map[string]interface{}{
+		"isPlainHostName": func(host string) bool {
+			return !strings.Contains(host, ".")
+		},
+		"dnsDomainIs": func(host, domain string) bool {
+			return strings.HasSuffix(strings.ToLower(host), strings.ToLower(domain))
+		},
+		"localHostOrDomainIs": func(host, hostDomain string) bool {
+			host, hostDomain = strings.ToLower(host), strings.ToLower(hostDomain)
+			return host == hostDomain || (!strings.Contains(host, ".") && strings.HasPrefix(hostDomain, host+"."))
+		},
+		"isResolvable": func(host string) bool {
+			return resolveIPv4(host) != nil
+		},
+		"dnsResolve": func(host string) interface{} {
+			if ip := resolveIPv4(host); ip != nil {
+				return ip.String()
+			}
+			return nil
+		},
+		"isInNet": func(host, pattern, mask string) bool {
+			ip := resolveIPv4(host)
+			patternIP, maskIP := net.ParseIP(pattern).To4(), net.ParseIP(mask).To4()
+			return ip != nil && patternIP != nil && maskIP != nil && ip.Mask(net.IPMask(maskIP)).Equal(patternIP.Mask(net.IPMask(maskIP)))
+		},
+		"myIpAddress": func() string {
+			return localIPAddress()
+		},
+		"dnsDomainLevels": func(host string) int {
+			return strings.Count(host, ".")
+		},
+		"shExpMatch": func(str, shellExpression string) bool {
+			return shellExpressionToRegexp(shellExpression).MatchString(str)
+		},
+		"weekdayRange": func(call goja.FunctionCall) goja.Value {
+			return runtime.ToValue(weekdayRange(runtime, call.Arguments))
+		},
+		"timeRange": func(call goja.FunctionCall) goja.Value {
+			return runtime.ToValue(timeRange(runtime, call.Arguments))
+		},
+		"alert": func(message string) {
+			log.Infof("Proxy auto-config: %s", message)
+		},
+	}
+}
+
+func resolveIPv4(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.To4()
+	}
+	addresses, err := lookupHost(host)
+	if err != nil {
+		return nil
+	}
+	for _, address := range addresses {
+		if ip := net.ParseIP(address).To4(); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// findLocalIPAddress returns the address of the interface which routes to the internet. Dialing UDP sends no packets.
+func findLocalIPAddress() string {
+	connection, err := net.Dial("udp4", "198.51.100.1:53")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer connection.Close()
+	return connection.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// shellExpressionToRegexp converts a shell expression with the wildcards "*" and "?" into an anchored regular expression.
+func shellExpressionToRegexp(expression string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(expression)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.MustCompile("^" + quoted + "$")
+}
+
+// splitTimeZone removes a trailing "GMT" argument and returns the current time in the time zone it selects.
+func splitTimeZone(args []goja.Value) ([]goja.Value, time.Time) {
+	if len(args) > 0 && args[len(args)-1].String() == "GMT" {
+		return args[:len(args)-1], now().UTC()
+	}
+	return args, now()
+}
+
+func weekdayRange(runtime *goja.Runtime, args []goja.Value) bool {
+	args, t := splitTimeZone(args)
+	if len(args) == 0 || len(args) > 2 {
+		panic(runtime.NewTypeError("weekdayRange() expects one or two weekdays"))
+	}
+	indexes := make([]int, len(args))
+	for i, arg := range args {
+		indexes[i] = -1
+		for j, weekday := range weekdays {
+			if arg.String() == weekday {
+				indexes[i] = j
+			}
+		}
+		if indexes[i] < 0 {
+			panic(runtime.NewTypeError("invalid weekday \"%s\"", arg.String()))
+		}
+	}
+	today := int(t.Weekday())
+	if len(indexes) == 1 {
+		return today == indexes[0]
+	}
+	return isInCyclicRange(today, indexes[0], indexes[1])
+}
+
+func timeRange(runtime *goja.Runtime, args []goja.Value) bool {
+	args, t := splitTimeZone(args)
+	if len(args) == 0 || len(args) > 2 {
+		panic(runtime.NewTypeError("timeRange() is only supported with one or two hours"))
+	}
+	if len(args) == 1 {
+		return t.Hour() == int(args[0].ToInteger())
+	}
+	// The end hour is exclusive, e.g. timeRange(8, 17) is true from 8:00:00 to 16:59:59.
+	start, end := int(args[0].ToInteger()), int(args[1].ToInteger())
+	if start == end {
+		return t.Hour() == start
+	}
+	return isInCyclicRange(t.Hour(), start, end-1)
+}
+
+// isInCyclicRange returns true if first <= n <= last, where the range may wrap around, e.g. from Friday to Monday.
+func isInCyclicRange(n, first, last int) bool {
+	if first <= last {
+		return first <= n && n <= last
+	}
+	return n >= first || n <= last
+}
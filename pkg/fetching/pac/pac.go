@@ -0,0 +1,79 @@
+// Package pac evaluates proxy auto-config (PAC) scripts with the JavaScript engine goja and offers them the functions
+// which browsers do, except for dateRange().
+package pac
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Bounds the time a single evaluation may take, so that a broken script cannot hang downloads. Replaceable in tests.
+var maxEvaluationTime = time.Second * 5
+
+var errTimeout = errors.New("script did not finish in time")
+
+// Script is a parsed PAC script. It is safe for concurrent use.
+type Script struct {
+	runtime         *goja.Runtime
+	findProxyForURL goja.Callable
+	mutex           sync.Mutex
+}
+
+// Parse parses source and runs its top-level statements.
+func Parse(source string) (*Script, error) {
+	program, err := goja.Compile("PAC script", source, false)
+	if err != nil {
+		return nil, err
+	}
+	script := &Script{runtime: goja.New()}
+	for name, f := range globalFunctions(script.runtime) {
+		if err = script.runtime.Set(name, f); err != nil {
+			return nil, err
+		}
+	}
+	err = script.run(func() error {
+		_, err := script.runtime.RunProgram(program)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if script.findProxyForURL, ok = goja.AssertFunction(script.runtime.Get("FindProxyForURL")); !ok {
+		return nil, fmt.Errorf("script does not define function FindProxyForURL")
+	}
+	return script, nil
+}
+
+// FindProxyForURL calls the function of the same name of the script and returns its result, e.g. "PROXY proxy:8080; DIRECT".
+func (script *Script) FindProxyForURL(url, host string) (result string, err error) {
+	err = script.run(func() error {
+		v, err := script.findProxyForURL(goja.Undefined(), script.runtime.ToValue(url), script.runtime.ToValue(host))
+		if err == nil {
+			result = v.String()
+		}
+		return err
+	})
+	return result, err
+}
+
+// run calls f, interrupting the script if it runs for longer than maxEvaluationTime.
+func (script *Script) run(f func() error) error {
+	script.mutex.Lock()
+	defer script.mutex.Unlock()
+	interrupted := make(chan struct{})
+	timer := time.AfterFunc(maxEvaluationTime, func() {
+		script.runtime.Interrupt(errTimeout)
+		close(interrupted)
+	})
+	err := f()
+	if !timer.Stop() {
+		<-interrupted // Clear the interrupt only once it has been set, so that it cannot hit the next evaluation.
+		script.runtime.ClearInterrupt()
+	}
+	return err
+}
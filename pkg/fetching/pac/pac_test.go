@@ -0,0 +1,145 @@
+package pac
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, source string) *Script {
+	script, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Could not parse script: %v", err)
+	}
+	return script
+}
+
+func findProxy(t *testing.T, script *Script, host string) string {
+	result, err := script.FindProxyForURL("https://"+host+"/", host)
+	if err != nil {
+		t.Fatalf("FindProxyForURL(%s) failed: %v", host, err)
+	}
+	return result
+}
+
+func TestFindProxyForURL(t *testing.T) {
+	script := mustParse(t, `
+		// Hosts which are reached through the SOCKS proxy.
+		var socksHosts = ["*.imgur.com", "imgur.com"];
+
+		function isSocksHost(host) {
+			for (var i = 0; i < socksHosts.length; i++) {
+				if (shExpMatch(host, socksHosts[i])) {
+					return true;
+				}
+			}
+			return false;
+		}
+
+		function FindProxyForURL(url, host) {
+			host = host.toLowerCase();
+			if (isPlainHostName(host) || dnsDomainIs(host, ".corp.example.com") || localHostOrDomainIs(host, "intranet.example.com"))
+				return "DIRECT";
+			if (isSocksHost(host)) return 'SOCKS5 socks.example.com:1080';
+			var port = url.substring(0, 6) == "https:" ? 8443 : 8080;
+			return "PROXY proxy.example.com:" + port + "; DIRECT";
+		}`)
+	for host, expected := range map[string]string{
+		"intranet":                  "DIRECT",
+		"build.CORP.example.com":    "DIRECT",
+		"i.imgur.com":               "SOCKS5 socks.example.com:1080",
+		"deployment.example.com":    "PROXY proxy.example.com:8443; DIRECT",
+		"intranet.example.com.evil": "PROXY proxy.example.com:8443; DIRECT",
+	} {
+		if result := findProxy(t, script, host); result != expected {
+			t.Errorf("Expected %q for host %s. Got %q", expected, host, result)
+		}
+	}
+}
+
+func TestNetworkFunctions(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { lookupHost = f }(lookupHost)
+	lookupHost = func(host string) ([]string, error) {
+		if host == "build.corp" {
+			return []string{"fe80::1", "10.1.2.3"}, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+	defer func(f func() string) { localIPAddress = f }(localIPAddress)
+	localIPAddress = func() string { return "192.168.1.20" }
+	script := mustParse(t, `function FindProxyForURL(url, host) {
+		if (!isResolvable(host)) return "PROXY unresolvable:80";
+		if (isInNet(myIpAddress(), "192.168.1.0", "255.255.255.0") && isInNet(host, "10.0.0.0", "255.0.0.0"))
+			return "PROXY " + dnsResolve(host) + ":" + dnsDomainLevels(host);
+		return "DIRECT";
+	}`)
+	if result := findProxy(t, script, "build.corp"); result != "PROXY 10.1.2.3:1" {
+		t.Errorf("Unexpected result %q", result)
+	}
+	if result := findProxy(t, script, "example.com"); result != "PROXY unresolvable:80" {
+		t.Errorf("Unexpected result %q", result)
+	}
+}
+
+func TestTimeFunctions(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2024, 3, 16, 22, 30, 0, 0, time.UTC) } // A Saturday.
+	script := mustParse(t, `function FindProxyForURL(url, host) {
+		return [weekdayRange("SAT"), weekdayRange("FRI", "MON", "GMT"), weekdayRange("MON", "FRI"), timeRange(22, 2, "GMT"), timeRange(8, 17)].join(";");
+	}`)
+	if result := findProxy(t, script, "example.com"); result != "true;true;false;true;false" {
+		t.Errorf("Unexpected result %q", result)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for source, expectedError := range map[string]string{
+		`function FindProxyForURL(url, host) { return "DIRECT"`:           "Line 1:",
+		"function FindProxyForURL(url, host) {\n return DIRECT DIRECT; }": "Line 2:",
+		`function findProxyForURL(url, host) { return "DIRECT"; }`:        "does not define function FindProxyForURL",
+		`undefinedFunction();`: "undefinedFunction is not defined",
+	} {
+		if _, err := Parse(source); err == nil || !strings.Contains(err.Error(), expectedError) {
+			t.Errorf("Expected error containing %q for script %q. Got %v", expectedError, source, err)
+		}
+	}
+}
+
+func TestLanguageFeatures(t *testing.T) {
+	script := mustParse(t, `function FindProxyForURL(url, host) {
+		switch (host.split(".").pop()) {
+		case "internal":
+			return "DIRECT";
+		default:
+			return /^(www\.)?example\.(com|org)$/.test(host) ? "PROXY proxy.example.com:8080" : "SOCKS socks.example.com:1080";
+		}
+	}`)
+	for host, expected := range map[string]string{
+		"build.internal":  "DIRECT",
+		"www.example.org": "PROXY proxy.example.com:8080",
+		"example.net":     "SOCKS socks.example.com:1080",
+	} {
+		if result := findProxy(t, script, host); result != expected {
+			t.Errorf("Expected %q for host %s. Got %q", expected, host, result)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	defer func(d time.Duration) { maxEvaluationTime = d }(maxEvaluationTime)
+	maxEvaluationTime = time.Millisecond * 100
+	for _, body := range []string{`while (true) {}`, `return host();`, `return dateRange("JAN", "MAR");`, `return weekdayRange("SOMEDAY");`} {
+		script := mustParse(t, `function FindProxyForURL(url, host) { `+body+` }`)
+		if _, err := script.FindProxyForURL("https://example.com/", "example.com"); err == nil {
+			t.Errorf("Expected script with body %q to fail.", body)
+		}
+	}
+	script := mustParse(t, `var calls = 0; function FindProxyForURL(url, host) { if (calls++ == 0) while (true) {} return "DIRECT"; }`)
+	if _, err := script.FindProxyForURL("https://example.com/", "example.com"); err == nil {
+		t.Errorf("Expected endless loop to be interrupted.")
+	}
+	if result := findProxy(t, script, "example.com"); result != "DIRECT" {
+		t.Errorf("Expected evaluation after interrupted one to succeed. Got %q", result)
+	}
+}
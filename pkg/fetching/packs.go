@@ -0,0 +1,132 @@
+package fetching
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+func (downloader *Downloader) MustExtractPacksToDirectory(baseUrl string, packs config.PackInfoMap, fileMap config.FileInfoMap, localDirPath string) (remainingFileMap config.FileInfoMap) {
+	err := os.MkdirAll(localDirPath, 0700)
+	if err != nil {
+		panic(system.NewFileSystemError(fmt.Sprintf("Could not create directory \"%s\"", localDirPath), err))
+	}
+	remainingFileMap, err = downloader.ExtractPacksToDirectory(baseUrl, packs, fileMap, localDirPath)
+	if err != nil {
+		panic(err)
+	}
+	return remainingFileMap
+}
+
+// ExtractPacksToDirectory downloads packs and extracts the files of fileMap which they hold into localDirPath while
+// downloading them, verifying each file against fileMap. It returns the part of fileMap which has not been extracted,
+// e.g. because a pack is missing or broken, which can then be downloaded file by file.
+func (downloader *Downloader) ExtractPacksToDirectory(baseUrl string, packs config.PackInfoMap, fileMap config.FileInfoMap, localDirPath string) (config.FileInfoMap, error) {
+	remainingFileMap := make(config.FileInfoMap)
+	remainingFileMap.Join(fileMap)
+	urlToPackMap := make(map[string]*config.PackInfo)
+	urls := make([]string, 0, len(packs))
+	for packPath, packInfo := range packs {
+		url := misc.MustJoinURL(baseUrl, packInfo.TransferPath(packPath))
+		urlToPackMap[url] = packInfo
+		urls = append(urls, url)
+	}
+
+	m := &sync.Mutex{}
+	err := downloader.DownloadResources(urls, func(dl *Download) error {
+		dl.optional = true // The files of a missing pack can still be downloaded one by one.
+		extractedFilePaths, err := extractPack(dl, urlToPackMap[dl.url], fileMap, localDirPath)
+		m.Lock()
+		for _, filePath := range extractedFilePaths {
+			delete(remainingFileMap, filePath)
+		}
+		m.Unlock()
+		if err != nil {
+			if dl.ctx.Err() != nil {
+				return dl.ctx.Err()
+			}
+			log.Printf("Could not extract pack \"%s\": %v. Downloading the %d files it has not yielded one by one instead.",
+				dl.url, err, len(urlToPackMap[dl.url].Files)-len(extractedFilePaths))
+		}
+		return nil
+	})
+	return remainingFileMap, err
+}
+
+// extractPack writes the entries of the pack downloaded by dl which are wanted by fileMap to localDirPath and returns
+// the paths of those which match fileMap. Entries which do not match are removed again, but do not end extraction.
+func extractPack(dl *Download, packInfo *config.PackInfo, fileMap config.FileInfoMap, localDirPath string) (extractedFilePaths []string, err error) {
+	defer dl.Close()
+	transferReader := &countingReader{reader: dl}
+	var src io.Reader = transferReader
+	if packInfo.Compression != "" {
+		decompressor, err := newDecompressingReader(packInfo.Compression, transferReader)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress: %w", err)
+		}
+		defer decompressor.Close()
+		src = decompressor
+	}
+	tarReader := tar.NewReader(newSizeLimitedReader(src, "pack", packInfo.Size))
+	isExtracted := make(map[string]bool)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			_, err = io.Copy(io.Discard, transferReader) // Let the download finish, e.g. by reading the padding of the archive.
+			return extractedFilePaths, err
+		} else if err != nil {
+			return extractedFilePaths, err
+		}
+		relativeFilePath := filepath.FromSlash(header.Name)
+		fileInfo, ok := fileMap[relativeFilePath]
+		if header.Typeflag != tar.TypeReg || !ok || fileInfo.Hash == "" || isExtracted[relativeFilePath] {
+			continue // Another entry of the same file must not overwrite the one which has already been verified.
+		}
+		localFilePath := filepath.Join(localDirPath, relativeFilePath)
+		if system.FileExists(PartialInfoFilePath(localFilePath)) { // Do not give up on a full download in progress.
+			continue
+		}
+		sha, size, err := extractPackedFile(dl, tarReader, localFilePath, fileInfo.HashAlgorithm)
+		if err != nil {
+			return extractedFilePaths, err
+		}
+		if err = checkHashAndSize("packed file", header.Name, fileInfo, sha, size); err != nil {
+			log.Printf("%v. Downloading it on its own instead.", err)
+			if removeErr := os.Remove(localFilePath); removeErr != nil && !os.IsNotExist(removeErr) {
+				log.Printf("Could not remove file \"%s\" after error: %v", localFilePath, removeErr)
+			}
+			continue
+		}
+		if err = applyFileMode(localFilePath, fileInfo); err != nil {
+			return extractedFilePaths, err
+		}
+		isExtracted[relativeFilePath] = true
+		extractedFilePaths = append(extractedFilePaths, relativeFilePath)
+	}
+}
+
+func extractPackedFile(dl *Download, src io.Reader, localFilePath, hashAlgorithm string) (sha string, size int64, err error) {
+	system.MustMakeDir(filepath.Dir(localFilePath))
+	localFile, err := os.OpenFile(localFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0700)
+	if err != nil {
+		return "", 0, system.NewFileSystemError(fmt.Sprintf("Could not open file \"%s\" for writing", localFilePath), err)
+	}
+	size, sha, err = ioHashingCopy(dl.ctx, localFile, src, config.NewHash(hashAlgorithm))
+	if closeErr := localFile.Close(); err == nil && closeErr != nil {
+		err = system.NewFileSystemError(fmt.Sprintf("Could not close file \"%s\"", localFilePath), closeErr)
+	}
+	if err != nil {
+		if removeErr := os.Remove(localFilePath); removeErr != nil && !os.IsNotExist(removeErr) {
+			log.Printf("Could not remove file \"%s\" after error: %v", localFilePath, removeErr)
+		}
+	}
+	return sha, size, err
+}
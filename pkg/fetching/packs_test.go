@@ -0,0 +1,123 @@
+package fetching
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func createPack(t *testing.T, files map[string][]byte, names ...string) []byte {
+	buf := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buf)
+	for _, name := range names {
+		if err := tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(files[name]))}); err != nil {
+			t.Fatal(err)
+		}
+		tarWriter.Write(files[name])
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func extractPacksToDirectory(t *testing.T, packData []byte, packInfo *config.PackInfo, fileMap config.FileInfoMap) (remainingFileMap config.FileInfoMap, localDirPath string) {
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		if packData == nil || req.URL.Path != "/bundle/"+packInfo.TransferPath(".packs/pack-0.tar") {
+			return &http.Response{StatusCode: http.StatusNotFound, Header: make(http.Header), Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+		}
+		header := http.Header{"content-length": []string{fmt.Sprintf("%d", len(packData))}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(bytes.NewReader(packData))}, nil
+	}
+	localDirPath = t.TempDir()
+	remainingFileMap, err := NewDownloader(context.Background(), &EmptyHandler{}).ExtractPacksToDirectory("http://example.com/bundle",
+		config.PackInfoMap{".packs/pack-0.tar": packInfo}, fileMap, localDirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return remainingFileMap, localDirPath
+}
+
+func TestExtractPacksToDirectoryVerifiesEachFile(t *testing.T) {
+	files := map[string][]byte{"a.txt": []byte("A"), "sub/b.txt": []byte("B"), "sub/c.txt": []byte("C"), "unchanged.txt": []byte("U")}
+	fileMap := config.FileInfoMap{
+		"a.txt":                         {Hash: sha256Hex(files["a.txt"]), Size: 1},
+		filepath.FromSlash("sub/b.txt"): {Hash: sha256Hex(files["sub/b.txt"]), Size: 1},
+		filepath.FromSlash("sub/c.txt"): {Hash: sha256Hex([]byte("X")), Size: 1},
+		"deleted.txt":                   {Hash: ""},
+	}
+	packData := createPack(t, files, "a.txt", "sub/b.txt", "sub/c.txt", "unchanged.txt")
+	remainingFileMap, localDirPath := extractPacksToDirectory(t, packData,
+		&config.PackInfo{Size: int64(len(packData)), Files: []string{"a.txt", "sub/b.txt", "sub/c.txt", "unchanged.txt"}}, fileMap)
+
+	if len(remainingFileMap) != 2 || remainingFileMap[filepath.FromSlash("sub/c.txt")] == nil || remainingFileMap["deleted.txt"] == nil {
+		t.Errorf("Expected the mismatching and the deleted file to remain. Got %v", remainingFileMap)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if data, err := ioutil.ReadFile(filepath.Join(localDirPath, filepath.FromSlash(name))); err != nil || !bytes.Equal(data, files[name]) {
+			t.Errorf("Expected \"%s\" to be extracted. Got %q, %v", name, data, err)
+		}
+	}
+	for _, name := range []string{"sub/c.txt", "unchanged.txt"} {
+		if _, err := ioutil.ReadFile(filepath.Join(localDirPath, filepath.FromSlash(name))); err == nil {
+			t.Errorf("Expected \"%s\" not to be extracted.", name)
+		}
+	}
+}
+
+func TestExtractPacksToDirectoryFallsBackOnMissingPack(t *testing.T) {
+	fileMap := config.FileInfoMap{"a.txt": {Hash: sha256Hex([]byte("A")), Size: 1}}
+	remainingFileMap, _ := extractPacksToDirectory(t, nil, &config.PackInfo{Size: 1024, Files: []string{"a.txt"}}, fileMap)
+	if len(remainingFileMap) != 1 {
+		t.Errorf("Expected all files to remain. Got %v", remainingFileMap)
+	}
+}
+
+func TestExtractPacksToDirectoryStopsBeyondDeclaredPackSize(t *testing.T) {
+	files := map[string][]byte{"a.txt": []byte("A"), "large.bin": make([]byte, 16*1024*1024)}
+	fileMap := config.FileInfoMap{
+		"a.txt":     {Hash: sha256Hex(files["a.txt"]), Size: 1},
+		"large.bin": {Hash: sha256Hex(files["large.bin"]), Size: int64(len(files["large.bin"]))},
+	}
+	packData := compress(t, config.CompressionGzip, createPack(t, files, "a.txt", "large.bin"))
+	remainingFileMap, localDirPath := extractPacksToDirectory(t, packData,
+		&config.PackInfo{Size: 4096, Compression: config.CompressionGzip, CompressedSize: int64(len(packData)), Files: []string{"a.txt", "large.bin"}}, fileMap)
+
+	if len(remainingFileMap) != 1 || remainingFileMap["large.bin"] == nil {
+		t.Errorf("Expected only the file beyond the declared pack size to remain. Got %v", remainingFileMap)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(localDirPath, "large.bin")); err == nil {
+		t.Errorf("Expected \"large.bin\" not to be extracted.")
+	}
+}
+
+func TestExtractPacksToDirectoryKeepsFirstOfDuplicateEntries(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buf)
+	for _, data := range [][]byte{[]byte("A"), []byte("X")} {
+		if err := tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "a.txt", Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		tarWriter.Write(data)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	packData := buf.Bytes()
+	fileMap := config.FileInfoMap{"a.txt": {Hash: sha256Hex([]byte("A")), Size: 1}}
+	remainingFileMap, localDirPath := extractPacksToDirectory(t, packData, &config.PackInfo{Size: int64(len(packData)), Files: []string{"a.txt"}}, fileMap)
+
+	if len(remainingFileMap) != 0 {
+		t.Errorf("Expected no file to remain. Got %v", remainingFileMap)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(localDirPath, "a.txt")); err != nil || string(data) != "A" {
+		t.Errorf("Expected the first entry of \"a.txt\" to be kept. Got %q, %v", data, err)
+	}
+}
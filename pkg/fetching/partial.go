@@ -0,0 +1,209 @@
+package fetching
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+const partialInfoFileSuffix = ".partial"
+
+// Write the progress of a partial file to disk every so many bytes, so that not much is lost if the process is killed.
+const partialCheckpointInterval = 4 * 1024 * 1024
+
+// partialInfo describes a file which has been downloaded in part. It is stored next to the file so that the download can
+// be resumed with a range request by a later run of the program, provided the file is still expected to have the same content.
+type partialInfo struct {
+	Hash          string `json:"Hash"`                    // The hash of the complete file. Infos which name it "SHA256" predate HashAlgorithm and are discarded.
+	HashAlgorithm string `json:"HashAlgorithm,omitempty"` // See config.BundleInfo.HashAlgorithm.
+	Size          int64  `json:"Size"`
+	Offset        int64  `json:"Offset"` // Amount of bytes at the beginning of the file which have been written and flushed to disk.
+
+	// Set instead of Offset for files downloaded in chunks. Lists the indices of the chunks which have been written and flushed to disk.
+	ChunkSize       int64 `json:"ChunkSize,omitempty"`
+	CompletedChunks []int `json:"CompletedChunks,omitempty"`
+}
+
+// partialFile is an io.Writer which writes a downloaded file and periodically records its progress in a partialInfo file.
+type partialFile struct {
+	file            *os.File
+	infoFilePath    string
+	info            partialInfo
+	hash            hash.Hash // Hash over the first info.Offset bytes of the file.
+	sinceCheckpoint int64
+	resumable       bool // Files which are transferred compressed cannot be resumed, as the decompressor's state would be lost.
+}
+
+// PartialInfoFilePath returns the path of the file which records the progress of a partial download to the file at localFilePath.
+func PartialInfoFilePath(localFilePath string) string {
+	return filepath.Join(filepath.Dir(localFilePath), "~"+filepath.Base(localFilePath)+partialInfoFileSuffix)
+}
+
+// IsPartialInfoFile returns true if the file at filePath has been created with a path returned by PartialInfoFilePath().
+func IsPartialInfoFile(filePath string) bool {
+	fileName := filepath.Base(filePath)
+	return strings.HasPrefix(fileName, "~") && strings.HasSuffix(fileName, partialInfoFileSuffix)
+}
+
+// RemovePartialDownload removes the file at localFilePath along with information on its partial download, if present.
+func RemovePartialDownload(localFilePath string) {
+	system.MustRemoveFile(PartialInfoFilePath(localFilePath))
+	system.MustRemoveFile(localFilePath)
+}
+
+// openPartialFile opens the file at localFilePath for writing. If a previous run has left behind a partial download of the
+// file with the expected content, the data already present is hashed again and the file is positioned at its end.
+// Otherwise, the file is truncated.
+func openPartialFile(ctx context.Context, localFilePath string, expectedFileInfo *config.FileInfo) (*partialFile, error) {
+	pf := &partialFile{
+		infoFilePath: PartialInfoFilePath(localFilePath),
+		info:         partialInfo{Hash: expectedFileInfo.Hash, HashAlgorithm: expectedFileInfo.HashAlgorithm, Size: expectedFileInfo.Size},
+		hash:         config.NewHash(expectedFileInfo.HashAlgorithm),
+		resumable:    expectedFileInfo.Compression == "",
+	}
+	var offset int64
+	if pf.resumable {
+		offset = readPartialOffset(pf.infoFilePath, expectedFileInfo)
+	} else {
+		system.MustRemoveFile(pf.infoFilePath)
+	}
+	flags := os.O_RDWR | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	var err error
+	pf.file, err = os.OpenFile(localFilePath, flags, 0700)
+	if err != nil {
+		return nil, system.NewFileSystemError(fmt.Sprintf("Could not open file \"%s\" for writing", localFilePath), err)
+	}
+	if offset > 0 {
+		n, err := misc.IOCopyWithContext(ctx, pf.hash, io.LimitReader(pf.file, offset))
+		if err == nil && n == offset {
+			pf.info.Offset = offset
+		} else {
+			if ctx.Err() != nil {
+				pf.file.Close()
+				return nil, ctx.Err()
+			}
+			log.Printf("Could not resume download of \"%s\": reading the first %d bytes failed after %d bytes: %v. Starting over.", localFilePath, offset, n, err)
+			pf.hash.Reset()
+			if err = pf.file.Truncate(0); err != nil {
+				pf.file.Close()
+				return nil, system.NewFileSystemError(fmt.Sprintf("Could not truncate file \"%s\"", localFilePath), err)
+			}
+		}
+		if _, err = pf.file.Seek(pf.info.Offset, io.SeekStart); err != nil {
+			pf.file.Close()
+			return nil, system.NewFileSystemError(fmt.Sprintf("Could not seek in file \"%s\"", localFilePath), err)
+		}
+	}
+	return pf, nil
+}
+
+func readPartialOffset(infoFilePath string, expectedFileInfo *config.FileInfo) int64 {
+	info := readPartialInfo(infoFilePath, expectedFileInfo)
+	if info == nil {
+		return 0
+	}
+	if info.ChunkSize != 0 {
+		log.Printf("Discarding partial download info \"%s\" because the file was downloaded in chunks.", infoFilePath)
+		return 0
+	}
+	if info.Offset < 0 || info.Offset >= info.Size {
+		log.Printf("Discarding partial download info \"%s\" because its offset is invalid.", infoFilePath)
+		return 0
+	}
+	return info.Offset
+}
+
+// readPartialInfo returns the partial download info stored at infoFilePath, or nil if there is none for a file as described by expectedFileInfo.
+func readPartialInfo(infoFilePath string, expectedFileInfo *config.FileInfo) *partialInfo {
+	data, err := ioutil.ReadFile(infoFilePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Could not read partial download info \"%s\": %v", infoFilePath, err)
+		}
+		return nil
+	}
+	info := &partialInfo{}
+	if err = json.Unmarshal(data, info); err != nil {
+		log.Printf("Could not parse partial download info \"%s\": %v", infoFilePath, err)
+		return nil
+	}
+	if !strings.EqualFold(info.Hash, expectedFileInfo.Hash) || info.Size != expectedFileInfo.Size ||
+		config.NormalizeHashAlgorithm(info.HashAlgorithm) != config.NormalizeHashAlgorithm(expectedFileInfo.HashAlgorithm) {
+		log.Printf("Discarding partial download info \"%s\" because the expected file changed.", infoFilePath)
+		return nil
+	}
+	return info
+}
+
+func (pf *partialFile) Write(p []byte) (int, error) {
+	n, err := pf.file.Write(p)
+	pf.info.Offset += int64(n)
+	pf.sinceCheckpoint += int64(n)
+	if err == nil && pf.resumable && pf.sinceCheckpoint >= partialCheckpointInterval {
+		err = pf.checkpoint()
+	}
+	return n, err
+}
+
+func (pf *partialFile) checkpoint() error {
+	pf.sinceCheckpoint = 0
+	if err := pf.file.Sync(); err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not flush file \"%s\"", pf.file.Name()), err)
+	}
+	data, err := json.Marshal(&pf.info)
+	if err != nil {
+		return err
+	}
+	return system.PutFileAtomically(pf.infoFilePath, data)
+}
+
+// keep closes the file and records its progress so that a later call of openPartialFile() can resume the download.
+func (pf *partialFile) keep() {
+	if pf.info.Offset == 0 || !pf.resumable {
+		pf.discard()
+		return
+	}
+	err := pf.checkpoint()
+	pf.file.Close()
+	if err != nil {
+		log.Printf("Could not keep partial download \"%s\": %v", pf.file.Name(), err)
+		pf.discard()
+		return
+	}
+	log.Printf("Kept %d of %d bytes of \"%s\" to resume download later.", pf.info.Offset, pf.info.Size, pf.file.Name())
+}
+
+// discard closes and removes the file as well as any record of its progress.
+func (pf *partialFile) discard() {
+	pf.file.Close()
+	for _, filePath := range []string{pf.file.Name(), pf.infoFilePath} {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Could not remove file \"%s\" after error: %v", filePath, err)
+		}
+	}
+}
+
+// complete closes the file and removes the record of its progress.
+func (pf *partialFile) complete() error {
+	if err := pf.file.Close(); err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not close file \"%s\"", pf.file.Name()), err)
+	}
+	if err := os.Remove(pf.infoFilePath); err != nil && !os.IsNotExist(err) {
+		return system.NewFileSystemError(fmt.Sprintf("Could not remove partial download info \"%s\"", pf.infoFilePath), err)
+	}
+	return nil
+}
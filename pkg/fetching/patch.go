@@ -0,0 +1,159 @@
+package fetching
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/setlog/trivrost/pkg/delta"
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+func (downloader *Downloader) MustPatchOrDownloadToDirectory(baseUrl string, fileMap config.FileInfoMap, localDirPath, presentDirPath string) {
+	err := os.MkdirAll(localDirPath, 0700)
+	if err != nil {
+		panic(system.NewFileSystemError(fmt.Sprintf("Could not create directory \"%s\"", localDirPath), err))
+	}
+	err = downloader.PatchOrDownloadToDirectory(baseUrl, fileMap, localDirPath, presentDirPath)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// PatchOrDownloadToDirectory is like DownloadToDirectory, but creates files for which fileMap lists a patch by downloading
+// the patch and applying it to the file with the same relative path under presentDirPath. Files which cannot be created this
+// way, for whatever reason, are downloaded in full.
+func (downloader *Downloader) PatchOrDownloadToDirectory(baseUrl string, fileMap config.FileInfoMap, localDirPath, presentDirPath string) error {
+	fileMap = fileMap.OmitEntriesWithMissingSha()
+	downloadMap := make(config.FileInfoMap)
+	urlToPathMap := make(map[string]string)
+	urlToInfoMap := make(config.FileInfoMap)
+	for relativeFilePath, fileInfo := range fileMap {
+		fromHash, patchInfo := choosePatch(fileInfo)
+		localFilePath := filepath.Join(localDirPath, relativeFilePath)
+		if patchInfo == nil || system.FileExists(PartialInfoFilePath(localFilePath)) { // Do not give up on a full download in progress.
+			downloadMap[relativeFilePath] = fileInfo
+			continue
+		}
+		url := misc.MustJoinURL(baseUrl, config.PatchFilePath(fromHash, fileInfo.Hash))
+		urlToPathMap[url] = relativeFilePath
+		urlToInfoMap[url] = patchInfo
+	}
+
+	m := &sync.Mutex{}
+	err := downloader.DownloadResources(stringStringMapKeys(urlToPathMap), func(dl *Download) error {
+		relativeFilePath := urlToPathMap[dl.url]
+		dl.optional = true // There is no point in waiting for a patch when we can download the whole file instead.
+		err := patchFile(dl, urlToInfoMap[dl.url], filepath.Join(presentDirPath, relativeFilePath), fileMap[relativeFilePath], filepath.Join(localDirPath, relativeFilePath))
+		if err != nil {
+			if dl.ctx.Err() != nil {
+				return dl.ctx.Err()
+			}
+			log.Printf("Could not patch \"%s\": %v. Downloading the whole file instead.", relativeFilePath, err)
+			m.Lock()
+			defer m.Unlock()
+			downloadMap[relativeFilePath] = fileMap[relativeFilePath]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return downloader.DownloadToDirectory(baseUrl, downloadMap, localDirPath)
+}
+
+func choosePatch(fileInfo *config.FileInfo) (fromHash string, patchInfo *config.FileInfo) {
+	for fromHash, patchInfo = range fileInfo.Patches {
+		if patchInfo != nil && patchInfo.Size < fileInfo.DownloadSize() {
+			return fromHash, patchInfo
+		}
+	}
+	return "", nil
+}
+
+// patchFile applies the patch downloaded by dl to the file at presentFilePath and writes the result to localFilePath.
+func patchFile(dl *Download, patchInfo *config.FileInfo, presentFilePath string, expectedFileInfo *config.FileInfo, localFilePath string) error {
+	presentFile, err := os.Open(presentFilePath)
+	if err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not open file \"%s\" to patch", presentFilePath), err)
+	}
+	defer presentFile.Close()
+	system.MustMakeDir(filepath.Dir(localFilePath))
+	localFile, err := os.OpenFile(localFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0700)
+	if err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not open file \"%s\" for writing", localFilePath), err)
+	}
+	patchHash, fileHash := config.NewHash(patchInfo.HashAlgorithm), config.NewHash(expectedFileInfo.HashAlgorithm)
+	// Limit both sides, so that a mirror cannot fill the disk with a small patch which expands without end.
+	patchReader := &countingReader{reader: io.TeeReader(newSizeLimitedReader(dl, "patch", patchInfo.Size), patchHash)}
+	fileWriter := &countingWriter{writer: newSizeLimitedWriter(io.MultiWriter(localFile, fileHash), "patched file", expectedFileInfo.Size)}
+	err = delta.Apply(fileWriter, presentFile, patchReader)
+	if err == nil {
+		_, err = io.Copy(io.Discard, patchReader) // Patches carry no trailing data, but it must be part of the hash.
+	}
+	if closeErr := localFile.Close(); err == nil && closeErr != nil {
+		err = system.NewFileSystemError(fmt.Sprintf("Could not close file \"%s\"", localFilePath), closeErr)
+	}
+	if err == nil {
+		err = checkHashAndSize("patch", dl.url, patchInfo, hex.EncodeToString(patchHash.Sum(nil)), patchReader.n)
+	}
+	if err == nil {
+		err = checkHashAndSize("patched file", localFilePath, expectedFileInfo, hex.EncodeToString(fileHash.Sum(nil)), fileWriter.n)
+	}
+	if err == nil {
+		err = applyFileMode(localFilePath, expectedFileInfo)
+	}
+	if err != nil {
+		if removeErr := os.Remove(localFilePath); removeErr != nil && !os.IsNotExist(removeErr) {
+			log.Printf("Could not remove file \"%s\" after error: %v", localFilePath, removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func checkHashAndSize(what, where string, expectedFileInfo *config.FileInfo, sha string, size int64) error {
+	if !strings.EqualFold(expectedFileInfo.Hash, sha) {
+		return fmt.Errorf("Hash of %s \"%s\" does not match expected value \"%s\". Was \"%s\"", what, where, expectedFileInfo.Hash, sha)
+	}
+	if expectedFileInfo.Size != size {
+		return fmt.Errorf("Size of %s \"%s\" does not match expected value %d. Was %d", what, where, expectedFileInfo.Size, size)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from reader. Once reader returned an error, it keeps returning that error
+// without reading again, as Download does not allow for that.
+type countingReader struct {
+	reader io.Reader
+	n      int64
+	err    error
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	r.err = err
+	return n, err
+}
+
+type countingWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
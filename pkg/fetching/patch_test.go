@@ -0,0 +1,104 @@
+package fetching
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/delta"
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func testPatchOrDownloadToDirectory(t *testing.T, patchData []byte, servePatch bool, expectPatched bool) {
+	d, err := ioutil.TempDir("", "trivrost-patch-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	oldData := arbitraryData(50000)
+	newData := append(append(append([]byte{}, oldData[:20000]...), arbitraryData(10000)...), oldData[20000:]...)
+	de := CreateDummyEnvironment(t, len(newData), -1)
+	de.Data = newData
+	if patchData == nil {
+		buf := &bytes.Buffer{}
+		if err = delta.Create(buf, oldData, newData); err != nil {
+			t.Fatal(err)
+		}
+		patchData = buf.Bytes()
+	}
+	presentDirPath, localDirPath := filepath.Join(d, "present"), filepath.Join(d, "local")
+	if err = os.MkdirAll(filepath.Join(presentDirPath, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(presentDirPath, "sub", "file"), oldData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	patchPath := config.PatchFilePath(sha256Hex(oldData), sha256Hex(newData))
+	var fullDownloads int
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, patchPath) {
+			if !servePatch {
+				return &http.Response{StatusCode: http.StatusNotFound, Header: make(http.Header), Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+			}
+			header := http.Header{"content-length": []string{fmt.Sprintf("%d", len(patchData))}}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(bytes.NewReader(patchData))}, nil
+		}
+		fullDownloads++
+		return de.DoForClientFunc(client, req)
+	}
+	fileMap := config.FileInfoMap{filepath.Join("sub", "file"): {
+		Hash:    sha256Hex(newData),
+		Size:    int64(len(newData)),
+		Patches: map[string]*config.FileInfo{sha256Hex(oldData): {Hash: sha256Hex(patchData), Size: int64(len(patchData))}},
+	}}
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	if err = downloader.PatchOrDownloadToDirectory("http://example.com/bundle", fileMap, localDirPath, presentDirPath); err != nil {
+		t.Fatal(err)
+	}
+	diskData, err := ioutil.ReadFile(filepath.Join(localDirPath, "sub", "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(diskData, newData) {
+		t.Fatalf("Data on disk mismatches wanted data")
+	}
+	if expectPatched && fullDownloads != 0 {
+		t.Errorf("File has been downloaded in full although it could have been patched")
+	} else if !expectPatched && fullDownloads == 0 {
+		t.Errorf("File has not been downloaded in full although the patch is unusable")
+	}
+}
+
+func TestPatchOrDownloadToDirectoryAppliesPatch(t *testing.T) {
+	testPatchOrDownloadToDirectory(t, nil, true, true)
+}
+
+func TestPatchOrDownloadToDirectoryFallsBackOnMissingPatch(t *testing.T) {
+	testPatchOrDownloadToDirectory(t, nil, false, false)
+}
+
+func TestPatchOrDownloadToDirectoryFallsBackOnBrokenPatch(t *testing.T) {
+	testPatchOrDownloadToDirectory(t, []byte("TRVDLT01 but otherwise garbage"), true, false)
+}
+
+func TestPatchOrDownloadToDirectoryFallsBackOnExpandingPatch(t *testing.T) {
+	oldData := arbitraryData(50000)
+	buf := &bytes.Buffer{}
+	if err := delta.Create(buf, oldData, append(oldData, make([]byte, 4*1024*1024)...)); err != nil {
+		t.Fatal(err)
+	}
+	testPatchOrDownloadToDirectory(t, buf.Bytes(), true, false)
+}
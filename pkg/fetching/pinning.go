@@ -0,0 +1,80 @@
+package fetching
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// PinningError is the error of requests to a host with pinned public keys which did not present any of them.
+type PinningError struct {
+	Host string
+}
+
+func (err *PinningError) Error() string {
+	return fmt.Sprintf("the certificate chain of \"%s\" contains none of the public keys pinned for the host", err.Host)
+}
+
+// SetPublicKeyPins makes the Downloader only accept TLS connections to the hosts in pins if one of the certificates of the
+// verified chain has a public key whose SHA-256 hash of its DER-encoded SubjectPublicKeyInfo is listed for the host in base64.
+// Listing several hashes per host allows for backup keys and rotation. Connections to other hosts are not affected.
+// Hosts must be given as host names without port.
+func (downloader *Downloader) SetPublicKeyPins(pins map[string][]string) error {
+	parsedPins := make(map[string][][]byte)
+	for host, hashes := range pins {
+		if net.ParseIP(host) != nil { // TLS connections to IP addresses carry no server name to look up pins by.
+			return fmt.Errorf("cannot pin public keys of IP address \"%s\": only host names are supported", host)
+		}
+		if len(hashes) == 0 {
+			return fmt.Errorf("no public key pins given for host \"%s\"", host)
+		}
+		for _, hash := range hashes {
+			decodedHash, err := base64.StdEncoding.DecodeString(hash)
+			if err != nil || len(decodedHash) != sha256.Size {
+				return fmt.Errorf("public key pin \"%s\" for host \"%s\" is not a base64-encoded SHA-256 hash", hash, host)
+			}
+			parsedPins[strings.ToLower(host)] = append(parsedPins[strings.ToLower(host)], decodedHash)
+		}
+	}
+	transports, err := transports(downloader.client)
+	if err != nil {
+		return fmt.Errorf("cannot pin public keys: %w", err)
+	}
+	for _, transport := range transports {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPublicKeyPins(parsedPins, state)
+		}
+	}
+	return nil
+}
+
+func verifyPublicKeyPins(pins map[string][][]byte, state tls.ConnectionState) error {
+	host := strings.ToLower(state.ServerName)
+	hostPins, isPinned := pins[host]
+	if !isPinned {
+		return nil
+	}
+	chains := state.VerifiedChains
+	if len(chains) == 0 && len(state.PeerCertificates) > 0 { // Without verification, only the key of the leaf is proven to be the server's.
+		chains = [][]*x509.Certificate{state.PeerCertificates[:1]}
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range hostPins {
+				if bytes.Equal(spkiHash[:], pin) {
+					return nil
+				}
+			}
+		}
+	}
+	return &PinningError{Host: host}
+}
@@ -0,0 +1,73 @@
+package fetching
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+)
+
+func newPinningTestDownloader(t *testing.T, pins map[string][]string) (*Downloader, string) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pinned"))
+	}))
+	t.Cleanup(server.Close)
+	DoForClientFunc = DoForClient
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.ServerName = "example.com" // The test certificate is valid for this name.
+	downloader.client.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	if err := downloader.SetPublicKeyPins(pins); err != nil {
+		t.Fatal(err)
+	}
+	return downloader, server.URL
+}
+
+func serverPin(t *testing.T, downloader *Downloader, url string) string {
+	resp, err := downloader.client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	hash := sha256.Sum256(resp.TLS.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestPublicKeyPinsAcceptMatchingKey(t *testing.T) {
+	downloader, url := newPinningTestDownloader(t, nil)
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	if err := downloader.SetPublicKeyPins(map[string][]string{"example.com": {otherPin, serverPin(t, downloader, url)}}); err != nil {
+		t.Fatal(err)
+	}
+	downloader.client.CloseIdleConnections()
+	if _, err := downloader.DownloadToRAM(config.FileInfoMap{url: {}}); err != nil {
+		t.Fatalf("Expected download with a matching backup pin to succeed. Got %v", err)
+	}
+}
+
+func TestPublicKeyPinsRejectOtherKeys(t *testing.T) {
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	downloader, url := newPinningTestDownloader(t, map[string][]string{"example.com": {otherPin}})
+	_, err := downloader.DownloadToRAM(config.FileInfoMap{url: {}})
+	var userError *misc.UserError
+	var pinningError *PinningError
+	if !errors.As(err, &userError) || !errors.As(err, &pinningError) {
+		t.Fatalf("Expected a *misc.UserError caused by a *PinningError. Got %v", err)
+	}
+}
+
+func TestSetPublicKeyPinsRejectsInvalidPins(t *testing.T) {
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	for _, pins := range []map[string][]string{{"example.com": {}}, {"example.com": {"bm90IGEgaGFzaA=="}}, {"127.0.0.1": {otherPin}}} {
+		if err := downloader.SetPublicKeyPins(pins); err == nil {
+			t.Errorf("Expected pins %v to be rejected.", pins)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package fetching
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-ieproxy"
+	"github.com/setlog/trivrost/pkg/fetching/pac"
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyRouter decides through which proxy, if any, requests are sent, as configured by a ProxyConfig.
+type ProxyRouter struct {
+	mode        string
+	route       func(u *url.URL) (*url.URL, error)
+	credentials map[string]*config.ProxyCredentialsConfig
+}
+
+// NewProxyRouter returns a ProxyRouter for proxyConfig, which may be nil to use the proxy settings of the system.
+// For ProxyModePAC, it fetches and parses the PAC script right away.
+func NewProxyRouter(proxyConfig *config.ProxyConfig) (*ProxyRouter, error) {
+	proxyConfig = proxyConfig.MergedWith(nil)
+	if err := proxyConfig.Validate(); err != nil {
+		return nil, err
+	}
+	router := &ProxyRouter{mode: proxyConfig.Mode, credentials: proxyConfig.Credentials}
+	switch proxyConfig.Mode {
+	case config.ProxyModeSystem:
+		systemProxyFunc := ieproxy.GetProxyFunc()
+		router.route = func(u *url.URL) (*url.URL, error) { return systemProxyFunc(&http.Request{URL: u}) }
+	case config.ProxyModeDirect:
+		router.route = func(u *url.URL) (*url.URL, error) { return nil, nil }
+	case config.ProxyModeManual:
+		router.route = (&httpproxy.Config{HTTPProxy: proxyConfig.URL, HTTPSProxy: proxyConfig.URL,
+			NoProxy: strings.Join(proxyConfig.NoProxy, ",")}).ProxyFunc()
+	case config.ProxyModePAC:
+		script, err := loadPACScript(proxyConfig.PACURL)
+		if err != nil {
+			return nil, err
+		}
+		router.route = newPACRouteFunc(script)
+	}
+	return router, nil
+}
+
+// Proxy returns the URL of the proxy to send req through, or nil to connect directly. It suits http.Transport.Proxy.
+func (router *ProxyRouter) Proxy(req *http.Request) (*url.URL, error) {
+	if req.URL.Scheme == "file" {
+		return nil, nil
+	}
+	proxyURL, err := router.route(req.URL)
+	if err != nil || proxyURL == nil || proxyURL.User != nil {
+		return proxyURL, err
+	}
+	if credentials, ok := router.credentials[strings.ToLower(proxyURL.Hostname())]; ok {
+		proxyURL = &url.URL{Scheme: proxyURL.Scheme, Host: proxyURL.Host, User: url.UserPassword(credentials.Username, credentials.Password)}
+	}
+	return proxyURL, nil
+}
+
+// Describe returns a description of the route of requests to rawURL which does not reveal proxy credentials.
+func (router *ProxyRouter) Describe(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Sprintf("invalid URL: %v", err)
+	}
+	proxyURL, err := router.Proxy(&http.Request{URL: u})
+	if err != nil {
+		return fmt.Sprintf("%s proxy failed: %v", router.mode, err)
+	} else if proxyURL == nil {
+		return fmt.Sprintf("direct (%s)", router.mode)
+	}
+	return fmt.Sprintf("proxy %s (%s)", proxyURL.Redacted(), router.mode)
+}
+
+func loadPACScript(pacURL string) (*pac.Script, error) {
+	var source []byte
+	var err error
+	if u, parseErr := url.Parse(pacURL); parseErr == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		source, err = downloadPACScript(pacURL)
+	} else if parseErr == nil && u.Scheme == "file" {
+		source, err = ioutil.ReadFile(u.Path)
+	} else {
+		source, err = ioutil.ReadFile(pacURL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load proxy auto-config script \"%s\": %w", pacURL, err)
+	}
+	script, err := pac.Parse(string(source))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse proxy auto-config script \"%s\": %w", pacURL, err)
+	}
+	return script, nil
+}
+
+func downloadPACScript(pacURL string) ([]byte, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	client := &http.Client{Timeout: defaultTimeout, Transport: transport}
+	resp, err := client.Get(pacURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with status %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// newPACRouteFunc returns a function which evaluates script once for each scheme, host and port. Like browsers do, it
+// passes no path and query to the script, so that it cannot leak them.
+func newPACRouteFunc(script *pac.Script) func(u *url.URL) (*url.URL, error) {
+	routes := &sync.Map{}
+	return func(u *url.URL) (*url.URL, error) {
+		strippedURL := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}).String()
+		if route, ok := routes.Load(strippedURL); ok {
+			return route.(*url.URL), nil
+		}
+		result, err := script.FindProxyForURL(strippedURL, u.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("proxy auto-config script failed for \"%s\": %w", strippedURL, err)
+		}
+		route, err := parsePACResult(result)
+		if err != nil {
+			return nil, err
+		}
+		routes.Store(strippedURL, route)
+		return route, nil
+	}
+}
+
+// parsePACResult returns the first route of the result of FindProxyForURL() which is supported. Go can only send a
+// request along one route, so the others are no fallback. SOCKS is taken to be SOCKS5, as SOCKS4 is not supported.
+func parsePACResult(result string) (*url.URL, error) {
+	if strings.TrimSpace(result) == "" || result == "undefined" {
+		return nil, nil
+	}
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		scheme := ""
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			return nil, nil
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		}
+		if scheme != "" && len(fields) == 2 {
+			return &url.URL{Scheme: scheme, Host: fields[1]}, nil
+		}
+	}
+	return nil, fmt.Errorf("proxy auto-config script returned no supported route: \"%s\"", result)
+}
+
+// isProxyAuthenticationError returns true if err tells that a proxy refused the credentials or the lack thereof.
+// Go reports responses to CONNECT requests other than 200 as errors carrying the status text, and SOCKS5 failures as
+// errors carrying the failed method.
+func isProxyAuthenticationError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), http.StatusText(http.StatusProxyAuthRequired)) ||
+		strings.Contains(err.Error(), "username/password authentication failed"))
+}
+
+// SetProxyRouter makes the Downloader send its requests along the routes of router. It must be called before any
+// downloads start.
+func (downloader *Downloader) SetProxyRouter(router *ProxyRouter) error {
+	transports, err := transports(downloader.client)
+	if err != nil {
+		return fmt.Errorf("cannot set proxy: %w", err)
+	}
+	for _, transport := range transports {
+		transport.Proxy = newProxyLoggingFunc(router.Proxy)
+	}
+	return nil
+}
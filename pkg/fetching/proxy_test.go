@@ -0,0 +1,131 @@
+package fetching
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+)
+
+// newTestProxy returns a proxy which answers requests for any URL itself, with the requested URL, if they carry the
+// given basic authentication.
+func newTestProxy(t *testing.T, username, password string) *httptest.Server {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username != "" && r.Header.Get("Proxy-Authorization") != "Basic "+basicAuth(username, password) {
+			w.Header().Set("Proxy-Authenticate", "Basic realm=\"test\"")
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.Write([]byte("via proxy: " + r.URL.String()))
+	}))
+	t.Cleanup(proxy.Close)
+	DoForClientFunc = DoForClient
+	return proxy
+}
+
+func basicAuth(username, password string) string {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.SetBasicAuth(username, password)
+	return strings.TrimPrefix(req.Header.Get("Authorization"), "Basic ")
+}
+
+func downloadThroughRouter(t *testing.T, proxyConfig *config.ProxyConfig, fileURL string) (string, error) {
+	router, err := NewProxyRouter(proxyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetRetryPolicy(&BackoffRetryPolicy{MaxAttempts: 1})
+	if err = downloader.SetProxyRouter(router); err != nil {
+		t.Fatal(err)
+	}
+	fileData, err := downloader.DownloadToRAM(config.FileInfoMap{fileURL: {}})
+	return string(fileData[fileURL]), err
+}
+
+func TestManualProxyWithCredentials(t *testing.T) {
+	proxy := newTestProxy(t, "user", "secret")
+	data, err := downloadThroughRouter(t, &config.ProxyConfig{Mode: config.ProxyModeManual, URL: proxy.URL,
+		Credentials: map[string]*config.ProxyCredentialsConfig{"127.0.0.1": {Username: "user", Password: "secret"}}},
+		"http://deployment.example.com/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "via proxy: http://deployment.example.com/file" {
+		t.Errorf("Unexpected data %q", data)
+	}
+}
+
+func TestProxyAuthenticationRequiredIsNotRetried(t *testing.T) {
+	proxy := newTestProxy(t, "user", "secret")
+	_, err := downloadThroughRouter(t, &config.ProxyConfig{Mode: config.ProxyModeManual, URL: proxy.URL}, "http://deployment.example.com/file")
+	var userError *misc.UserError
+	if !errors.As(err, &userError) || !strings.Contains(userError.Error(), "proxy server requires authentication") {
+		t.Fatalf("Expected error about proxy authentication. Got %v", err)
+	}
+	if !isProxyAuthenticationError(fmt.Errorf("proxyconnect tcp: %s", http.StatusText(http.StatusProxyAuthRequired))) {
+		t.Errorf("Expected failed CONNECT to be recognized.")
+	}
+}
+
+func TestManualProxyNoProxy(t *testing.T) {
+	router, err := NewProxyRouter(&config.ProxyConfig{Mode: config.ProxyModeManual, URL: "socks5://socks.example.com:1080",
+		NoProxy: []string{".corp.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rawURL, expected := range map[string]string{
+		"https://build.corp.example.com/file": "",
+		"https://deployment.example.com/file": "socks5://socks.example.com:1080",
+	} {
+		u, _ := url.Parse(rawURL)
+		proxyURL, err := router.Proxy(&http.Request{URL: u})
+		if err != nil || (proxyURL == nil && expected != "") || (proxyURL != nil && proxyURL.String() != expected) {
+			t.Errorf("Expected proxy %q for %s. Got %v, %v", expected, rawURL, proxyURL, err)
+		}
+	}
+}
+
+func TestPACProxy(t *testing.T) {
+	proxy := newTestProxy(t, "", "")
+	pacServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `function FindProxyForURL(url, host) {
+			if (dnsDomainIs(host, "example.com")) return "SOCKS4 unsupported:1080; PROXY %s";
+			return "DIRECT";
+		}`, strings.TrimPrefix(proxy.URL, "http://"))
+	}))
+	defer pacServer.Close()
+	data, err := downloadThroughRouter(t, &config.ProxyConfig{Mode: config.ProxyModePAC, PACURL: pacServer.URL + "/proxy.pac"},
+		"http://deployment.example.com/file?secret=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "via proxy: http://deployment.example.com/file?secret=1" {
+		t.Errorf("Unexpected data %q", data)
+	}
+}
+
+func TestParsePACResult(t *testing.T) {
+	for result, expected := range map[string]string{
+		"DIRECT":                            "",
+		"":                                  "",
+		"PROXY proxy:8080; DIRECT":          "http://proxy:8080",
+		"HTTPS proxy:443":                   "https://proxy:443",
+		" SOCKS4 old:1080;SOCKS socks:1080": "socks5://socks:1080",
+	} {
+		proxyURL, err := parsePACResult(result)
+		if err != nil || (proxyURL == nil && expected != "") || (proxyURL != nil && proxyURL.String() != expected) {
+			t.Errorf("Expected %q for result %q. Got %v, %v", expected, result, proxyURL, err)
+		}
+	}
+	if _, err := parsePACResult("SOCKS4 old:1080"); err == nil {
+		t.Errorf("Expected error for result without supported route.")
+	}
+}
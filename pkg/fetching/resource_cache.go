@@ -0,0 +1,191 @@
+package fetching
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/setlog/trivrost/pkg/signatures"
+)
+
+// resourceValidators are the values of the ETag and Last-Modified headers of a response, which let a later request for
+// the same resource ask the remote to respond with 304 Not Modified if it is unchanged.
+type resourceValidators struct {
+	ETag         string `json:"ETag,omitempty"`
+	LastModified string `json:"LastModified,omitempty"`
+}
+
+func readResourceValidators(header http.Header) resourceValidators {
+	headers := NewLowercaseHeaders(header)
+	return resourceValidators{ETag: headers.Get("etag"), LastModified: headers.Get("last-modified")}
+}
+
+func (validators resourceValidators) isEmpty() bool {
+	return validators.ETag == "" && validators.LastModified == ""
+}
+
+// applyTo makes req conditional on the resource having changed.
+func (validators resourceValidators) applyTo(req *http.Request) {
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+}
+
+// cachedResource is a copy of a resource which has been verified when it was downloaded.
+type cachedResource struct {
+	URL        string             `json:"URL"`
+	SHA256     string             `json:"SHA256"` // Of data, so that a torn write of the cache is noticed.
+	Validators resourceValidators `json:"Validators"`
+	data       []byte
+}
+
+// resourceCache keeps copies of signed resources on disk, so that they can be requested conditionally and used while
+// offline. Its copies are no more trustworthy than the files of the user, so they must be verified again when they are used.
+type resourceCache struct {
+	directoryPath string
+}
+
+// entryFilePath returns the path of the file with the data of the entry for url. Its metadata is kept in a file with
+// the extension ".json" next to it.
+func (cache *resourceCache) entryFilePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(cache.directoryPath, hex.EncodeToString(sum[:]))
+}
+
+// load returns the intact cached copies of those of urls which the cache holds.
+func (cache *resourceCache) load(urls []string) map[string]*cachedResource {
+	resources := make(map[string]*cachedResource)
+	if cache == nil {
+		return resources
+	}
+	for _, url := range urls {
+		if resource := cache.loadEntry(url); resource != nil {
+			resources[url] = resource
+		}
+	}
+	return resources
+}
+
+func (cache *resourceCache) loadEntry(url string) *cachedResource {
+	filePath := cache.entryFilePath(url)
+	metadata, err := ioutil.ReadFile(filePath + ".json")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Could not read cached copy of \"%s\": %v", url, err)
+		}
+		return nil
+	}
+	resource := &cachedResource{}
+	if err = json.Unmarshal(metadata, resource); err != nil || resource.URL != url {
+		log.Printf("Ignoring invalid cache entry \"%s\" for \"%s\".", filePath, url)
+		return nil
+	}
+	resource.data, err = ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Printf("Could not read cached copy of \"%s\": %v", url, err)
+		return nil
+	}
+	sum := sha256.Sum256(resource.data)
+	if !strings.EqualFold(resource.SHA256, hex.EncodeToString(sum[:])) {
+		log.Printf("Ignoring cached copy of \"%s\" because it has been altered.", url)
+		return nil
+	}
+	return resource
+}
+
+// store replaces the cached copy of resource.URL with resource. Failing to do so is not fatal, as the resource can
+// always be downloaded again.
+func (cache *resourceCache) store(resource *cachedResource) {
+	if cache == nil {
+		return
+	}
+	if err := os.MkdirAll(cache.directoryPath, 0700); err != nil {
+		log.Printf("Could not create resource cache folder \"%s\": %v", cache.directoryPath, err)
+		return
+	}
+	filePath := cache.entryFilePath(resource.URL)
+	sum := sha256.Sum256(resource.data)
+	resource.SHA256 = hex.EncodeToString(sum[:])
+	metadata, err := json.Marshal(resource)
+	if err != nil {
+		panic(err)
+	}
+	if err = ioutil.WriteFile(filePath, resource.data, 0600); err == nil {
+		err = ioutil.WriteFile(filePath+".json", metadata, 0600)
+	}
+	if err != nil {
+		log.Printf("Could not cache copy of \"%s\": %v", resource.URL, err)
+		cache.remove(resource.URL)
+	}
+}
+
+// remove removes the cached copy of url, if any.
+func (cache *resourceCache) remove(url string) {
+	if cache == nil {
+		return
+	}
+	filePath := cache.entryFilePath(url)
+	for _, path := range []string{filePath + ".json", filePath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Could not remove cached copy \"%s\" of \"%s\": %v", path, url, err)
+		}
+	}
+}
+
+// SetResourceCacheDirectory makes the Downloader keep copies of the signed resources it downloads in the directory at
+// directoryPath and request them conditionally, reusing a copy whenever the remote responds with 304 Not Modified.
+// The signatures of copies are verified again whenever they are used. An empty path disables the cache.
+// See also LoadCachedSignedResources().
+func (downloader *Downloader) SetResourceCacheDirectory(directoryPath string) {
+	if directoryPath == "" {
+		downloader.resourceCache = nil
+	} else {
+		downloader.resourceCache = &resourceCache{directoryPath: directoryPath}
+	}
+}
+
+// LoadCachedSignedResources returns the copies of the resources at urls which the resource cache holds, without
+// downloading anything. It returns an error if a copy is missing or its signature is not valid for one of keys.
+// Resources with the "file://"-scheme are read from their files.
+func (downloader *Downloader) LoadCachedSignedResources(urls []string, keys []*rsa.PublicKey) (map[string][]byte, error) {
+	if downloader.resourceCache == nil {
+		return nil, fmt.Errorf("resource cache is not enabled")
+	}
+	resources := make(map[string][]byte)
+	fileURLs := []string{}
+	for _, url := range urls {
+		if strings.HasPrefix(url, "file://") {
+			fileURLs = append(fileURLs, url)
+			continue
+		}
+		cachedResources := downloader.resourceCache.load([]string{url, url + ".signature"})
+		if cachedResources[url] == nil || cachedResources[url+".signature"] == nil {
+			return nil, fmt.Errorf("no cached copy of \"%s\" and its signature", url)
+		}
+		if !signatures.IsSignatureValid(cachedResources[url].data, cachedResources[url+".signature"].data, keys) {
+			return nil, fmt.Errorf("invalid signature of cached copy of \"%s\"", url)
+		}
+		resources[url] = cachedResources[url].data
+	}
+	if len(fileURLs) > 0 {
+		fileData, err := downloader.DownloadSignedResources(fileURLs, keys)
+		if err != nil {
+			return nil, err
+		}
+		for url, data := range fileData {
+			resources[url] = data
+		}
+	}
+	return resources, nil
+}
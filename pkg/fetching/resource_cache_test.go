@@ -0,0 +1,169 @@
+package fetching
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signedResourceServer serves resources along with their signatures and counts the responses of each status.
+type signedResourceServer struct {
+	*httptest.Server
+	publicKey *rsa.PublicKey
+	resources map[string][]byte
+	mutex     sync.Mutex
+	responses map[int]int
+}
+
+func newSignedResourceServer(t *testing.T, resources map[string]string) *signedResourceServer {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &signedResourceServer{publicKey: &privateKey.PublicKey, resources: make(map[string][]byte), responses: make(map[int]int)}
+	for path, data := range resources {
+		hashed := sha256.Sum256([]byte(data))
+		signature, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA256, hashed[:], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.resources[path] = []byte(data)
+		server.resources[path+".signature"] = []byte(base64.StdEncoding.EncodeToString(signature))
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := server.resources[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		sum := sha256.Sum256(data)
+		recorder := httptest.NewRecorder()
+		recorder.Header().Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(sum[:8])+`"`)
+		http.ServeContent(recorder, r, r.URL.Path, time.Unix(1500000000, 0), bytes.NewReader(data))
+		server.mutex.Lock()
+		server.responses[recorder.Code]++
+		server.mutex.Unlock()
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(recorder.Body.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	DoForClientFunc = DoForClient
+	return server
+}
+
+func (server *signedResourceServer) countResponses(statusCode int) int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	count := server.responses[statusCode]
+	server.responses[statusCode] = 0
+	return count
+}
+
+func downloadSignedResourcesWithCache(t *testing.T, server *signedResourceServer, cacheDirectoryPath string, urls ...string) map[string][]byte {
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetRetryPolicy(&BackoffRetryPolicy{MaxAttempts: 1})
+	downloader.SetResourceCacheDirectory(cacheDirectoryPath)
+	data, err := downloader.DownloadSignedResources(urls, []*rsa.PublicKey{server.publicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDownloadSignedResourcesReusesUnmodifiedCachedCopies(t *testing.T) {
+	server := newSignedResourceServer(t, map[string]string{"/a.json": "A", "/b.json": "B"})
+	cacheDirectoryPath := t.TempDir()
+	urls := []string{server.URL + "/a.json", server.URL + "/b.json"}
+	downloadSignedResourcesWithCache(t, server, cacheDirectoryPath, urls...)
+	if count := server.countResponses(http.StatusOK); count != 4 {
+		t.Fatalf("Expected 4 full responses on first download. Got %d", count)
+	}
+	data := downloadSignedResourcesWithCache(t, server, cacheDirectoryPath, urls...)
+	if string(data[urls[0]]) != "A" || string(data[urls[1]]) != "B" {
+		t.Errorf("Unexpected data from cache: %q", data)
+	}
+	if count := server.countResponses(http.StatusNotModified); count != 4 {
+		t.Errorf("Expected 4 responses with status 304. Got %d", count)
+	}
+	if count := server.countResponses(http.StatusOK); count != 0 {
+		t.Errorf("Expected no full responses. Got %d", count)
+	}
+}
+
+func TestDownloadSignedResourcesDownloadsAgainIfCachedCopyIsInvalid(t *testing.T) {
+	server := newSignedResourceServer(t, map[string]string{"/a.json": "A"})
+	cacheDirectoryPath := t.TempDir()
+	url := server.URL + "/a.json"
+	downloadSignedResourcesWithCache(t, server, cacheDirectoryPath, url)
+	cache := &resourceCache{directoryPath: cacheDirectoryPath}
+	tampered := cache.load([]string{url})[url]
+	tampered.data = []byte("evil")
+	cache.store(tampered)
+	server.countResponses(http.StatusOK)
+
+	data := downloadSignedResourcesWithCache(t, server, cacheDirectoryPath, url)
+	if string(data[url]) != "A" {
+		t.Errorf("Expected downloaded data \"A\". Got %q", data[url])
+	}
+	if count := server.countResponses(http.StatusOK); count != 2 {
+		t.Errorf("Expected resource and signature to be downloaded again. Got %d full responses", count)
+	}
+	if cached := cache.load([]string{url})[url]; cached == nil || string(cached.data) != "A" {
+		t.Errorf("Expected cache to hold the downloaded data again. Got %+v", cached)
+	}
+}
+
+func TestResourceCacheIgnoresAlteredData(t *testing.T) {
+	cache := &resourceCache{directoryPath: t.TempDir()}
+	cache.store(&cachedResource{URL: "https://example.com/a", Validators: resourceValidators{ETag: `"1"`}, data: []byte("A")})
+	if cache.load([]string{"https://example.com/a"})["https://example.com/a"] == nil {
+		t.Fatalf("Expected stored resource to load.")
+	}
+	if err := ioutil.WriteFile(cache.entryFilePath("https://example.com/a"), []byte("B"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if resource := cache.load([]string{"https://example.com/a"})["https://example.com/a"]; resource != nil {
+		t.Errorf("Expected altered resource to be ignored. Got %+v", resource)
+	}
+}
+
+func TestLoadCachedSignedResources(t *testing.T) {
+	server := newSignedResourceServer(t, map[string]string{"/a.json": "A", "/b.json": "B"})
+	cacheDirectoryPath := t.TempDir()
+	urls := []string{server.URL + "/a.json", server.URL + "/b.json"}
+	downloadSignedResourcesWithCache(t, server, cacheDirectoryPath, urls[0])
+	server.Close()
+
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetResourceCacheDirectory(cacheDirectoryPath)
+	data, err := downloader.LoadCachedSignedResources(urls[:1], []*rsa.PublicKey{server.publicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[urls[0]]) != "A" {
+		t.Errorf("Expected cached data \"A\". Got %q", data[urls[0]])
+	}
+	if _, err = downloader.LoadCachedSignedResources(urls, []*rsa.PublicKey{server.publicKey}); err == nil {
+		t.Errorf("Expected error for resource which has never been downloaded.")
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = downloader.LoadCachedSignedResources(urls[:1], []*rsa.PublicKey{&otherKey.PublicKey}); err == nil {
+		t.Errorf("Expected error for cached copy with signature of other key.")
+	}
+}
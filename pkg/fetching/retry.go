@@ -0,0 +1,199 @@
+package fetching
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/misc"
+)
+
+// RetryPolicy decides whether and when a Download retries its resource after a failed attempt to retrieve it.
+// Attempts fail when a request receives no response or a bad one. Interrupted responses are always resumed right away.
+type RetryPolicy interface {
+	// IsRetryable returns true if the failure may go away on its own. Downloads give up on other failures, unless they
+	// can switch to a mirror which has not failed the same way yet.
+	IsRetryable(failure *RetryFailure) bool
+
+	// NextDelay is called after retryable failures. It returns how long to wait before the next attempt, or false if
+	// the download should give up.
+	NextDelay(failure *RetryFailure) (delay time.Duration, retry bool)
+}
+
+// RetryFailure describes a failed attempt of a Download.
+type RetryFailure struct {
+	Err        error         // The error of a request which did not receive a response. Nil for bad responses.
+	StatusCode int           // The HTTP status code of a bad response. 0 if there was no response.
+	Attempt    int           // The number of consecutive failed attempts, including this one.
+	Elapsed    time.Duration // The time since the first of the consecutive failed attempts.
+}
+
+func (failure *RetryFailure) String() string {
+	if failure.StatusCode != 0 {
+		return fmt.Sprintf("HTTP %d: %s", failure.StatusCode, http.StatusText(failure.StatusCode))
+	}
+	return fmt.Sprint(failure.Err)
+}
+
+// BackoffRetryPolicy retries retryable failures with increasing delays until it runs out of attempts or time.
+// Embed it and override IsRetryable to change which failures are retried.
+type BackoffRetryPolicy struct {
+	MaxAttempts    int             // Give up after this many consecutive failed attempts. Unlimited if <= 0.
+	MaxElapsedTime time.Duration   // Give up once attempts have been failing for this long. Unlimited if <= 0.
+	Delays         []time.Duration // The delays before the first, second, ... retry. The last one is repeated. Randomized by +/- 10%.
+}
+
+var defaultRetryDelays = []time.Duration{1 * time.Second, 1 * time.Second, 2 * time.Second, 3 * time.Second, 5 * time.Second, 8 * time.Second, 13 * time.Second}
+
+// NewDefaultRetryPolicy returns the RetryPolicy of new Downloaders, which retries retryable failures forever.
+func NewDefaultRetryPolicy() *BackoffRetryPolicy {
+	return &BackoffRetryPolicy{Delays: defaultRetryDelays}
+}
+
+func (policy *BackoffRetryPolicy) IsRetryable(failure *RetryFailure) bool {
+	return IsRetryableFailure(failure)
+}
+
+func (policy *BackoffRetryPolicy) NextDelay(failure *RetryFailure) (time.Duration, bool) {
+	if policy.MaxAttempts > 0 && failure.Attempt >= policy.MaxAttempts {
+		return 0, false
+	}
+	if policy.MaxElapsedTime > 0 && failure.Elapsed >= policy.MaxElapsedTime {
+		return 0, false
+	}
+	if len(policy.Delays) == 0 {
+		return 0, true
+	}
+	return jitter(policy.Delays[intMin(failure.Attempt, len(policy.Delays))-1]), true
+}
+
+// jitter randomizes duration by +/- 10%, so that many clients which failed at the same time do not retry at the same time.
+func jitter(duration time.Duration) time.Duration {
+	p := make([]byte, 1)
+	_, err := rand.Read(p)
+	if err != nil {
+		log.Printf("Could not crypto/rand.Read(): %v\n", err)
+		return duration
+	}
+	return duration + ((time.Duration(p[0])-127)*duration)/(10*128)
+}
+
+// IsRetryableFailure classifies failures as temporary, like connection problems, timeouts and server errors, or as
+// permanent, like missing resources, certificates which cannot be verified, violations of public key pins, failures
+// to get an access token and proxies refusing authentication.
+func IsRetryableFailure(failure *RetryFailure) bool {
+	if failure.StatusCode != 0 {
+		return isRetryableStatusCode(failure.StatusCode)
+	}
+	var pinningError *PinningError
+	var authorizationError *AuthorizationError
+	return !isCertificateError(failure.Err) && !errors.As(failure.Err, &pinningError) && !errors.As(failure.Err, &authorizationError) &&
+		!isProxyAuthenticationError(failure.Err)
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return statusCode >= 500
+}
+
+func isCertificateError(err error) bool {
+	var verificationError *tls.CertificateVerificationError
+	var unknownAuthorityError x509.UnknownAuthorityError
+	var hostnameError x509.HostnameError
+	var certificateInvalidError x509.CertificateInvalidError
+	return errors.As(err, &verificationError) || errors.As(err, &unknownAuthorityError) ||
+		errors.As(err, &hostnameError) || errors.As(err, &certificateInvalidError)
+}
+
+// RetryLimitError is the cause of the *misc.UserError which a Download fails with when it gives up on its resource.
+type RetryLimitError struct {
+	URL     string
+	Failure RetryFailure
+}
+
+func (err *RetryLimitError) Error() string {
+	return fmt.Sprintf("gave up downloading \"%s\" after %d failed attempts in %v: %s",
+		err.URL, err.Failure.Attempt, err.Failure.Elapsed.Round(time.Second), err.Failure.String())
+}
+
+// Unwrap returns the error of the last failed request, or nil if it received a bad response.
+func (err *RetryLimitError) Unwrap() error {
+	return err.Failure.Err
+}
+
+func newRetryLimitError(url string, failure *RetryFailure, isRetryable bool) error {
+	cause := &RetryLimitError{URL: url, Failure: *failure}
+	if isRetryable {
+		return misc.UserErrorf(cause, "Could not download \"%s\" after trying %d times. Please check your internet connection and try again later.",
+			url, failure.Attempt)
+	}
+	if failure.StatusCode == http.StatusProxyAuthRequired || isProxyAuthenticationError(failure.Err) {
+		return misc.UserErrorf(cause, "Could not download \"%s\" because the proxy server requires authentication. Please ask your system administrator to configure proxy credentials.", url)
+	}
+	if failure.StatusCode == http.StatusUnauthorized || failure.StatusCode == http.StatusForbidden {
+		return misc.UserErrorf(cause, "Could not download \"%s\" because the server denied access (status %d). Please check your credentials or contact your system administrator.",
+			url, failure.StatusCode)
+	}
+	if failure.StatusCode != 0 {
+		return misc.UserErrorf(cause, "Could not download \"%s\" because the server responded with status %d (%s). Please contact the provider of the application.",
+			url, failure.StatusCode, http.StatusText(failure.StatusCode))
+	}
+	var pinningError *PinningError
+	if errors.As(failure.Err, &pinningError) {
+		return misc.UserErrorf(cause, "Could not download \"%s\" because the server \"%s\" did not identify itself with a trusted key. "+
+			"The connection may have been intercepted. Please contact your system administrator.", url, pinningError.Host)
+	}
+	var authorizationError *AuthorizationError
+	if errors.As(failure.Err, &authorizationError) {
+		return misc.UserErrorf(cause, "Could not download \"%s\" because signing in to \"%s\" has failed or expired. Please restart the application to sign in again.",
+			url, authorizationError.Host)
+	}
+	if isCertificateError(failure.Err) {
+		return misc.UserErrorf(cause, "Could not download \"%s\" because the certificate of the server could not be verified. Please contact your system administrator.", url)
+	}
+	return misc.UserErrorf(cause, "Could not download \"%s\". Please contact the provider of the application.", url)
+}
+
+// IsTemporaryFailure returns true if err is, or wraps, the error of a download which gave up on failures which may go
+// away on their own, like connection problems and server errors.
+func IsTemporaryFailure(err error) bool {
+	var retryLimitError *RetryLimitError
+	return errors.As(err, &retryLimitError) && IsRetryableFailure(&retryLimitError.Failure)
+}
+
+// timeLimitedRetryPolicy gives up like the embedded RetryPolicy, but at the latest once failures have persisted for maxElapsedTime.
+type timeLimitedRetryPolicy struct {
+	RetryPolicy
+	maxElapsedTime time.Duration
+}
+
+// LimitRetryTime returns a RetryPolicy which gives up like policy, but at the latest once failures have persisted for maxElapsedTime.
+func LimitRetryTime(policy RetryPolicy, maxElapsedTime time.Duration) RetryPolicy {
+	return &timeLimitedRetryPolicy{RetryPolicy: policy, maxElapsedTime: maxElapsedTime}
+}
+
+func (policy *timeLimitedRetryPolicy) NextDelay(failure *RetryFailure) (time.Duration, bool) {
+	if failure.Elapsed >= policy.maxElapsedTime {
+		return 0, false
+	}
+	delay, retry := policy.RetryPolicy.NextDelay(failure)
+	if retry && failure.Elapsed+delay > policy.maxElapsedTime {
+		delay = policy.maxElapsedTime - failure.Elapsed
+	}
+	return delay, retry
+}
+
+// SetRetryPolicy sets the RetryPolicy of all downloads of the Downloader which start afterwards.
+func (downloader *Downloader) SetRetryPolicy(policy RetryPolicy) {
+	downloader.retryPolicy = policy
+}
@@ -0,0 +1,116 @@
+package fetching
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+)
+
+func TestIsRetryableFailure(t *testing.T) {
+	tests := []struct {
+		failure     RetryFailure
+		isRetryable bool
+	}{
+		{RetryFailure{Err: fmt.Errorf("connection refused")}, true},
+		{RetryFailure{Err: fmt.Errorf("Get: %w", x509.UnknownAuthorityError{})}, false},
+		{RetryFailure{StatusCode: http.StatusServiceUnavailable}, true},
+		{RetryFailure{StatusCode: http.StatusTooManyRequests}, true},
+		{RetryFailure{StatusCode: http.StatusInternalServerError}, true},
+		{RetryFailure{StatusCode: http.StatusNotImplemented}, false},
+		{RetryFailure{StatusCode: http.StatusNotFound}, false},
+		{RetryFailure{StatusCode: http.StatusForbidden}, false},
+	}
+	for _, test := range tests {
+		if IsRetryableFailure(&test.failure) != test.isRetryable {
+			t.Errorf("Expected failure \"%s\" to be retryable: %v.", test.failure.String(), test.isRetryable)
+		}
+	}
+}
+
+func TestBackoffRetryPolicyLimits(t *testing.T) {
+	policy := &BackoffRetryPolicy{MaxAttempts: 3, MaxElapsedTime: time.Minute, Delays: []time.Duration{time.Second, time.Second * 10}}
+	if delay, retry := policy.NextDelay(&RetryFailure{Attempt: 1}); !retry || delay < 900*time.Millisecond || delay > 1100*time.Millisecond {
+		t.Errorf("Expected retry after about 1s. Got %v, %v.", delay, retry)
+	}
+	if delay, retry := policy.NextDelay(&RetryFailure{Attempt: 2}); !retry || delay < 9*time.Second || delay > 11*time.Second {
+		t.Errorf("Expected retry after about 10s. Got %v, %v.", delay, retry)
+	}
+	if _, retry := policy.NextDelay(&RetryFailure{Attempt: 3}); retry {
+		t.Errorf("Expected policy to give up after 3 attempts.")
+	}
+	if _, retry := policy.NextDelay(&RetryFailure{Attempt: 1, Elapsed: time.Minute}); retry {
+		t.Errorf("Expected policy to give up after a minute.")
+	}
+}
+
+func TestDownloadGivesUpWithUserError(t *testing.T) {
+	requestCount := 0
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		requestCount++
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: make(http.Header), Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+	}
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetRetryPolicy(&BackoffRetryPolicy{MaxAttempts: 2})
+	_, err := downloader.DownloadToRAM(config.FileInfoMap{"http://example.com/file": {}})
+	var userError *misc.UserError
+	var retryLimitError *RetryLimitError
+	if !errors.As(err, &userError) || !errors.As(err, &retryLimitError) {
+		t.Fatalf("Expected a *misc.UserError caused by a *RetryLimitError. Got %v", err)
+	}
+	if requestCount != 2 {
+		t.Errorf("Expected 2 requests. Got %d.", requestCount)
+	}
+}
+
+func TestDownloadDoesNotRetryMissingResource(t *testing.T) {
+	var requestedHosts []string
+	DoForClientFunc = func(client *http.Client, req *http.Request) (*http.Response, error) {
+		requestedHosts = append(requestedHosts, req.URL.Host)
+		return &http.Response{StatusCode: http.StatusNotFound, Header: make(http.Header), Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+	}
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.AddMirrors([]Mirror{{URL: "https://primary.example.com"}, {URL: "https://secondary.example.com"}})
+	_, err := downloader.DownloadToRAM(config.FileInfoMap{"https://primary.example.com/file": {}})
+	var userError *misc.UserError
+	if !errors.As(err, &userError) || !strings.Contains(userError.Message(), "404") {
+		t.Fatalf("Expected a *misc.UserError mentioning the status code. Got %v", err)
+	}
+	if strings.Join(requestedHosts, ",") != "primary.example.com,secondary.example.com" {
+		t.Errorf("Expected every mirror to be asked once. Requested hosts: %v", requestedHosts)
+	}
+}
+
+func TestLimitRetryTime(t *testing.T) {
+	policy := LimitRetryTime(&BackoffRetryPolicy{Delays: []time.Duration{10 * time.Second}}, 15*time.Second)
+	if delay, retry := policy.NextDelay(&RetryFailure{Attempt: 1}); !retry || delay < 9*time.Second || delay > 11*time.Second {
+		t.Errorf("Expected the delay of the limited policy. Got %v, %v", delay, retry)
+	}
+	if delay, retry := policy.NextDelay(&RetryFailure{Attempt: 2, Elapsed: 10 * time.Second}); !retry || delay != 5*time.Second {
+		t.Errorf("Expected delay to be cut to the remaining 5s. Got %v, %v", delay, retry)
+	}
+	if _, retry := policy.NextDelay(&RetryFailure{Attempt: 3, Elapsed: 15 * time.Second}); retry {
+		t.Errorf("Expected policy to give up after 15s.")
+	}
+}
+
+func TestIsTemporaryFailure(t *testing.T) {
+	if !IsTemporaryFailure(fmt.Errorf("wrapped: %w", newRetryLimitError("https://example.com/", &RetryFailure{Err: fmt.Errorf("connection refused")}, true))) {
+		t.Errorf("Expected connection failure to be temporary.")
+	}
+	if IsTemporaryFailure(newRetryLimitError("https://example.com/", &RetryFailure{StatusCode: http.StatusNotFound}, false)) {
+		t.Errorf("Expected missing resource not to be temporary.")
+	}
+	if IsTemporaryFailure(fmt.Errorf("connection refused")) {
+		t.Errorf("Expected errors other than RetryLimitErrors not to be temporary.")
+	}
+}
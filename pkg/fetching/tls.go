@@ -0,0 +1,134 @@
+package fetching
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+// hostTransport sends requests to hosts with a client certificate through a transport of their own, so that
+// each client certificate is only ever presented to the host it is meant for.
+type hostTransport struct {
+	defaultTransport *http.Transport
+	hostTransports   map[string]*http.Transport
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := t.hostTransports[strings.ToLower(req.URL.Hostname())]; ok {
+		return transport.RoundTrip(req)
+	}
+	return t.defaultTransport.RoundTrip(req)
+}
+
+func (t *hostTransport) CloseIdleConnections() {
+	t.defaultTransport.CloseIdleConnections()
+	for _, transport := range t.hostTransports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// ConfigureTLS makes client trust the certificate authorities of tlsConfig in addition to those of the system and present
+// the client certificates of tlsConfig to their hosts. The transport of client must be an *http.Transport.
+func ConfigureTLS(client *http.Client, tlsConfig *config.TLSConfig) error {
+	transportSlot := &client.Transport
+	if authTransport, ok := client.Transport.(*authTransport); ok {
+		transportSlot = &authTransport.next
+	}
+	transport, ok := (*transportSlot).(*http.Transport)
+	if !ok {
+		return fmt.Errorf("cannot configure TLS of transport of type %T", *transportSlot)
+	}
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	if len(tlsConfig.CACertificates) > 0 {
+		rootCAs, err := loadCertificatePool(tlsConfig.CACertificates)
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig.RootCAs = rootCAs
+	}
+	routingTransport := &hostTransport{defaultTransport: transport, hostTransports: make(map[string]*http.Transport)}
+	for host, clientCertificate := range tlsConfig.ClientCertificates {
+		certificate, err := loadClientCertificate(clientCertificate)
+		if err != nil {
+			return fmt.Errorf("could not load client certificate for host \"%s\": %w", host, err)
+		}
+		hostTransport := transport.Clone()
+		hostTransport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+		routingTransport.hostTransports[strings.ToLower(host)] = hostTransport
+	}
+	*transportSlot = routingTransport
+	return nil
+}
+
+func loadCertificatePool(caCertificates []string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		log.Printf("Could not load certificate authorities of the system: %v. Trusting only the configured ones.", err)
+		pool = x509.NewCertPool()
+	}
+	for _, caCertificate := range caCertificates {
+		data, err := config.LoadPEM(caCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("could not load CA certificate: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no CA certificates found in %s", describePEM(caCertificate))
+		}
+	}
+	return pool, nil
+}
+
+func loadClientCertificate(clientCertificate *config.ClientCertificateConfig) (tls.Certificate, error) {
+	if clientCertificate == nil {
+		return tls.Certificate{}, fmt.Errorf("no certificate given")
+	}
+	certificateData, err := config.LoadPEM(clientCertificate.Certificate)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyData, err := config.LoadPEM(clientCertificate.Key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certificateData, keyData)
+}
+
+func describePEM(pemOrPath string) string {
+	if config.IsPEM(pemOrPath) {
+		return "PEM-data"
+	}
+	return fmt.Sprintf("\"%s\"", pemOrPath)
+}
+
+// transports returns the transports of client, which are more than one if ConfigureTLS() set up client certificates.
+func transports(client *http.Client) ([]*http.Transport, error) {
+	roundTripper := client.Transport
+	if authTransport, ok := roundTripper.(*authTransport); ok {
+		roundTripper = authTransport.next
+	}
+	switch transport := roundTripper.(type) {
+	case *http.Transport:
+		return []*http.Transport{transport}, nil
+	case *hostTransport:
+		all := []*http.Transport{transport.defaultTransport}
+		for _, hostTransport := range transport.hostTransports {
+			all = append(all, hostTransport)
+		}
+		return all, nil
+	}
+	return nil, fmt.Errorf("unsupported transport of type %T", roundTripper)
+}
+
+// SetTLSConfig makes the Downloader trust the certificate authorities of tlsConfig in addition to those of the system and
+// present the client certificates of tlsConfig to their hosts. It must be called before any downloads start.
+func (downloader *Downloader) SetTLSConfig(tlsConfig *config.TLSConfig) error {
+	return ConfigureTLS(downloader.client, tlsConfig)
+}
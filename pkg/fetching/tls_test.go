@@ -0,0 +1,116 @@
+package fetching
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func newMutualTLSTestServer(t *testing.T, clientCertificate *x509.Certificate) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mutual"))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCertificate)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	DoForClientFunc = DoForClient
+	return server
+}
+
+func createClientCertificate(t *testing.T) (certificate *x509.Certificate, certificatePEM, keyPEM string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "trivrost test client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestTLSConfigWithCAAndClientCertificate(t *testing.T) {
+	clientCertificate, certificatePEM, keyPEM := createClientCertificate(t)
+	server := newMutualTLSTestServer(t, clientCertificate)
+	serverCAPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetRetryPolicy(&BackoffRetryPolicy{MaxAttempts: 1})
+	err := downloader.SetTLSConfig(&config.TLSConfig{
+		CACertificates:     []string{serverCAPEM},
+		ClientCertificates: map[string]*config.ClientCertificateConfig{"127.0.0.1": {Certificate: certificatePEM, Key: keyPEM}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileData, err := downloader.DownloadToRAM(config.FileInfoMap{server.URL: {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fileData[server.URL]) != "mutual" {
+		t.Errorf("Got unexpected data: %q", fileData[server.URL])
+	}
+}
+
+func TestTLSConfigPresentsClientCertificateOnlyToItsHost(t *testing.T) {
+	clientCertificate, certificatePEM, keyPEM := createClientCertificate(t)
+	server := newMutualTLSTestServer(t, clientCertificate)
+	serverCAPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	downloader.SetRetryPolicy(&BackoffRetryPolicy{MaxAttempts: 1})
+	err := downloader.SetTLSConfig(&config.TLSConfig{
+		CACertificates:     []string{serverCAPEM},
+		ClientCertificates: map[string]*config.ClientCertificateConfig{"example.com": {Certificate: certificatePEM, Key: keyPEM}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = downloader.DownloadToRAM(config.FileInfoMap{server.URL: {}}); err == nil {
+		t.Fatalf("Expected server to reject connection without client certificate.")
+	}
+}
+
+func TestTLSConfigRejectsInvalidCertificates(t *testing.T) {
+	downloader := NewDownloader(context.Background(), &EmptyHandler{})
+	if err := downloader.SetTLSConfig(&config.TLSConfig{CACertificates: []string{"-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n"}}); err == nil {
+		t.Errorf("Expected invalid CA certificate to be rejected.")
+	}
+	clientCertificates := map[string]*config.ClientCertificateConfig{"example.com": {Certificate: "/does/not/exist.pem", Key: "/does/not/exist.key"}}
+	if err := downloader.SetTLSConfig(&config.TLSConfig{ClientCertificates: clientCertificates}); err == nil {
+		t.Errorf("Expected missing client certificate to be rejected.")
+	}
+}
@@ -2,6 +2,7 @@ package bundle
 
 import (
 	"path/filepath"
+	"strings"
 
 	"github.com/setlog/trivrost/pkg/system"
 )
@@ -19,9 +20,13 @@ func (u *Updater) wantBundleWithName(localDirectory string) bool {
 	return false
 }
 
+func (u *Updater) isStagingDirectoryOfWantedBundle(directoryName string) bool {
+	return isStagingDirectoryName(directoryName) && u.wantBundleWithName(strings.TrimSuffix(strings.TrimPrefix(directoryName, "~"), stagingDirectorySuffix))
+}
+
 func (u *Updater) HasChangesToSystemBundles(considerMandatoryChangesOnly bool) bool {
 	for _, bundleUpdateInfo := range u.bundleUpdateInfos {
-		if (!considerMandatoryChangesOnly || bundleUpdateInfo.IsUpdateMandatory) && bundleUpdateInfo.IsSystemBundle && bundleUpdateInfo.WantedState.HasChanges() {
+		if (!considerMandatoryChangesOnly || bundleUpdateInfo.IsUpdateMandatory) && bundleUpdateInfo.IsSystemBundle && bundleUpdateInfo.HasChanges() {
 			return true
 		}
 	}
@@ -30,7 +35,7 @@ func (u *Updater) HasChangesToSystemBundles(considerMandatoryChangesOnly bool) b
 
 func (u *Updater) HasChangesToUserBundles() bool {
 	for _, bundleUpdateInfo := range u.bundleUpdateInfos {
-		if !bundleUpdateInfo.IsSystemBundle && bundleUpdateInfo.WantedState.HasChanges() {
+		if !bundleUpdateInfo.IsSystemBundle && bundleUpdateInfo.HasChanges() {
 			return true
 		}
 	}
@@ -40,7 +45,7 @@ func (u *Updater) HasChangesToUserBundles() bool {
 func countUpdatesBytes(bundleUpdateConfigs []*BundleUpdateInfo) uint64 {
 	var total uint64
 	for _, bundleUpdateConfig := range bundleUpdateConfigs {
-		total += bundleUpdateConfig.WantedState.UpdateByteCount()
+		total += bundleUpdateConfig.Packs.TransferByteCount(bundleUpdateConfig.WantedState)
 	}
 	return total
 }
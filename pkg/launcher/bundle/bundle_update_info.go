@@ -8,23 +8,56 @@ import (
 // BundleUpdateInfo contains information on what files need updating on the user's machine for the bundle specified by the embedded BundleConfig.
 type BundleUpdateInfo struct {
 	config.BundleConfig
-	IsSystemBundle bool
-	PresentState   config.FileInfoMap
-	RemoteState    config.FileInfoMap
-	WantedState    config.FileInfoMap
+	IsSystemBundle       bool
+	PresentState         config.FileInfoMap
+	PresentHashAlgorithm string // The algorithm PresentState has been hashed with.
+	RemoteState          config.FileInfoMap
+	WantedState          config.FileInfoMap
+	ModeChanges          config.FileInfoMap // Files which need no update, but another mode. See config.MakeModeDiffFileInfoMap().
+	Packs                config.PackInfoMap // The packs of the remote bundle, which can be used to obtain many files of WantedState at once.
+
+	// Symlinks and directories use forward-slashed paths, as in config.BundleInfo.
+	PresentSymlinks    map[string]string
+	PresentDirectories []string // All directories in the bundle, not only empty ones.
+	RemoteSymlinks     map[string]string
+	RemoteDirectories  []string
+	SymlinkChanges     map[string]string // Symlinks to create or point elsewhere. Symlinks to remove map to "". See config.MakeDiffSymlinkMap().
+	MissingDirectories []string          // Directories of RemoteDirectories which do not exist.
+}
+
+// HasChanges returns true if files of the bundle need to be updated, removed or given another mode, or if symlinks or
+// directories are missing or outdated.
+func (bui *BundleUpdateInfo) HasChanges() bool {
+	return bui.WantedState.HasChanges() || bui.ModeChanges.HasChanges() || len(bui.SymlinkChanges) > 0 || len(bui.MissingDirectories) > 0
 }
 
 func (bui *BundleUpdateInfo) LogChanges() {
 	for filePath, wantedFileInfo := range bui.WantedState {
 		presentFileInfo, ok := bui.PresentState[filePath]
 		if ok {
-			if wantedFileInfo.SHA256 == "" {
-				log.Infof("\"%s\": Delete: %s", filePath, presentFileInfo.SHA256)
+			if wantedFileInfo.Hash == "" {
+				log.Infof("\"%s\": Delete: %s", filePath, presentFileInfo.Hash)
 			} else {
-				log.Infof("\"%s\": %s -> %s", filePath, presentFileInfo.SHA256, wantedFileInfo.SHA256)
+				log.Infof("\"%s\": %s -> %s", filePath, presentFileInfo.Hash, wantedFileInfo.Hash)
 			}
 		} else {
-			log.Infof("\"%s\": Create: %s", filePath, wantedFileInfo.SHA256)
+			log.Infof("\"%s\": Create: %s", filePath, wantedFileInfo.Hash)
+		}
+	}
+	for filePath, wantedFileInfo := range bui.ModeChanges {
+		log.Infof("\"%s\": Mode: %s -> %s", filePath, bui.PresentState[filePath].Mode, wantedFileInfo.Mode)
+	}
+	for linkPath, target := range bui.SymlinkChanges {
+		presentTarget, ok := bui.PresentSymlinks[linkPath]
+		if !ok {
+			log.Infof("\"%s\": Create symlink: %s", linkPath, target)
+		} else if target == "" {
+			log.Infof("\"%s\": Delete symlink: %s", linkPath, presentTarget)
+		} else {
+			log.Infof("\"%s\": Symlink: %s -> %s", linkPath, presentTarget, target)
 		}
 	}
+	for _, directoryPath := range bui.MissingDirectories {
+		log.Infof("\"%s\": Create directory", directoryPath)
+	}
 }
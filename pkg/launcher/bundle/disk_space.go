@@ -0,0 +1,84 @@
+package bundle
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+const (
+	diskBlockSize    = 4096             // Files occupy whole blocks on most file systems, so small files need more space than their size.
+	diskSpaceReserve = 16 * 1024 * 1024 // For journals, logs and whatever else needs to be written while an update is installed.
+)
+
+var getFreeDiskSpace = system.GetFreeDiskSpace
+
+// requiredDiskSpace returns the number of bytes which need to be free to stage the files of fileMap in stagingDirectory.
+// Staged files are preallocated at their full size and the files they replace are only removed once all of them have been
+// staged, so every file counts with its full size, minus what a previous run has already staged. Each file also needs a
+// block for the information which allows resuming its download. stagingDirectory may be empty if nothing has been staged.
+func requiredDiskSpace(fileMap config.FileInfoMap, stagingDirectory string) uint64 {
+	var total uint64
+	for filePath, fileInfo := range fileMap {
+		if fileInfo.Hash == "" {
+			continue
+		}
+		required := roundUpToDiskBlocks(fileInfo.Size) + diskBlockSize
+		if stagingDirectory != "" {
+			if info, err := os.Stat(filepath.Join(stagingDirectory, filePath)); err == nil && !info.IsDir() {
+				if staged := roundUpToDiskBlocks(info.Size()); staged < required {
+					required -= staged
+				} else {
+					required = 0
+				}
+			}
+		}
+		total += required
+	}
+	return total
+}
+
+func roundUpToDiskBlocks(size int64) uint64 {
+	if size <= 0 {
+		return 0
+	}
+	return (uint64(size) + diskBlockSize - 1) / diskBlockSize * diskBlockSize
+}
+
+// mustHaveFreeDiskSpace panics with a *misc.UserError if the volume which contains folderPath has less than requiredBytes
+// and a reserve available. If the free space cannot be determined, it logs a warning and lets the update go ahead.
+func mustHaveFreeDiskSpace(folderPath string, requiredBytes uint64) {
+	if requiredBytes == 0 {
+		return
+	}
+	requiredBytes += diskSpaceReserve
+	freeBytes, err := getFreeDiskSpace(folderPath)
+	if err != nil {
+		log.Warnf("Could not determine free disk space at \"%s\": %v", folderPath, err)
+		return
+	}
+	log.Infof("Need %s of %s free disk space at \"%s\".", formatByteCount(requiredBytes), formatByteCount(freeBytes), folderPath)
+	if freeBytes < requiredBytes {
+		panic(misc.UserErrorf(nil, "There is not enough free disk space to install the update. %s are needed at \"%s\", but only %s are available. "+
+			"Please free up at least %s and try again.", formatByteCount(requiredBytes), folderPath, formatByteCount(freeBytes), formatByteCount(requiredBytes-freeBytes)))
+	}
+}
+
+func formatByteCount(byteCount uint64) string {
+	const unit = 1024
+	if byteCount < unit {
+		return fmt.Sprintf("%d bytes", byteCount)
+	}
+	value, prefixIndex := float64(byteCount)/unit, 0
+	for value >= unit && prefixIndex < 4 {
+		value /= unit
+		prefixIndex++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[prefixIndex])
+}
@@ -0,0 +1,50 @@
+package bundle
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+)
+
+func TestRequiredDiskSpaceCountsBlocksAndStagedFiles(t *testing.T) {
+	stagingDirectory := t.TempDir()
+	fileMap := config.FileInfoMap{
+		"small.txt":   {Hash: "aa", Size: 1},
+		"large.bin":   {Hash: "bb", Size: diskBlockSize*3 + 1},
+		"staged.bin":  {Hash: "cc", Size: diskBlockSize * 2},
+		"deleted.txt": {Hash: ""},
+	}
+	writeTestFile(t, filepath.Join(stagingDirectory, "staged.bin"), strings.Repeat("x", diskBlockSize*2))
+
+	if required := requiredDiskSpace(fileMap, ""); required != diskBlockSize*(2+5+3) {
+		t.Errorf("Expected %d bytes without staged files. Got %d", diskBlockSize*(2+5+3), required)
+	}
+	if required := requiredDiskSpace(fileMap, stagingDirectory); required != diskBlockSize*(2+5+1) {
+		t.Errorf("Expected %d bytes with one file staged. Got %d", diskBlockSize*(2+5+1), required)
+	}
+}
+
+func TestMustHaveFreeDiskSpacePanicsWithUserError(t *testing.T) {
+	defer func(original func(string) (uint64, error)) { getFreeDiskSpace = original }(getFreeDiskSpace)
+	getFreeDiskSpace = func(path string) (uint64, error) { return diskSpaceReserve + 1024, nil }
+	mustHaveFreeDiskSpace("/bundles", 1024)
+
+	defer func() {
+		var userError *misc.UserError
+		err, _ := recover().(error)
+		if !errors.As(err, &userError) || !strings.Contains(userError.Message(), "/bundles") || !strings.Contains(userError.Message(), "2.0 KiB") {
+			t.Errorf("Expected a user error naming the folder and the missing space. Got %v", err)
+		}
+	}()
+	mustHaveFreeDiskSpace("/bundles", 3*1024)
+}
+
+func TestMustHaveFreeDiskSpaceIgnoresUnknownFreeSpace(t *testing.T) {
+	defer func(original func(string) (uint64, error)) { getFreeDiskSpace = original }(getFreeDiskSpace)
+	getFreeDiskSpace = func(path string) (uint64, error) { return 0, errors.New("not supported") }
+	mustHaveFreeDiskSpace("/bundles", 1024)
+}
@@ -0,0 +1,355 @@
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/setlog/trivrost/pkg/fetching"
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/launcher/hashing"
+	"github.com/setlog/trivrost/pkg/misc"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+const (
+	journalFileSuffix      = ".journal.json"
+	stagingDirectorySuffix = ".staging"
+)
+
+// updateJournal records the swap of staged bundle files into a bundle directory and the changes to its symlinks. It is
+// written to disk before the first file or symlink of the bundle is touched and removed after the last one has been changed,
+// so that a swap which has been interrupted by a crash, power loss or the user killing the launcher can be finished or
+// rolled back on the next start.
+type updateJournal struct {
+	BundleDirectory  string                     `json:"BundleDirectory"`
+	StagingDirectory string                     `json:"StagingDirectory"`
+	BackupDirectory  string                     `json:"BackupDirectory"`
+	Files            map[string]*journalEntry   `json:"Files"`    // Keys are file paths relative to each of the three directories.
+	Symlinks         map[string]*journalSymlink `json:"Symlinks"` // Keys are link paths relative to the bundle directory.
+}
+
+type journalEntry struct {
+	Remove  bool `json:"Remove"`  // The update removes the file instead of creating or replacing it.
+	Existed bool `json:"Existed"` // A file existed at the path before the update began; it is moved to the backup directory.
+}
+
+type journalSymlink struct {
+	Target         string `json:"Target"`         // Empty if the update removes the symlink.
+	PreviousTarget string `json:"PreviousTarget"` // Empty if there was no symlink at the path before the update began.
+}
+
+func journalFilePath(bundleDirectory string) string {
+	return filepath.Join(filepath.Dir(bundleDirectory), "~"+filepath.Base(bundleDirectory)+journalFileSuffix)
+}
+
+func newUpdateJournal(wantedState config.FileInfoMap, symlinkChanges map[string]string, bundleDirectory, stagingDirectory string) *updateJournal {
+	journal := &updateJournal{
+		BundleDirectory:  bundleDirectory,
+		StagingDirectory: stagingDirectory,
+		BackupDirectory:  filepath.Join(filepath.Dir(bundleDirectory), "~"+filepath.Base(bundleDirectory)+".bak."+misc.MustGetRandomHexString(8)),
+		Files:            make(map[string]*journalEntry),
+		Symlinks:         make(map[string]*journalSymlink),
+	}
+	for linkPath, target := range symlinkChanges {
+		previousTarget, _ := readSymlink(filepath.Join(bundleDirectory, filepath.FromSlash(linkPath)))
+		journal.Symlinks[filepath.FromSlash(linkPath)] = &journalSymlink{Target: filepath.FromSlash(target), PreviousTarget: previousTarget}
+	}
+	for filePath, fileInfo := range wantedState {
+		existed := !journal.isBehindChangedSymlink(filePath) && pathExists(filepath.Join(bundleDirectory, filePath))
+		journal.Files[filePath] = &journalEntry{Remove: fileInfo.Hash == "", Existed: existed}
+	}
+	return journal
+}
+
+// isBehindChangedSymlink tells whether filePath or one of the directories leading to it is a symlink which the update removes
+// or points elsewhere, so that whatever is found at filePath now does not need to be backed up.
+func (journal *updateJournal) isBehindChangedSymlink(filePath string) bool {
+	for path := filePath; path != "." && path != string(filepath.Separator); path = filepath.Dir(path) {
+		if _, ok := journal.Symlinks[path]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// installBundleUpdate extracts the files described by wantedState from those of packs which are worth it, downloads or
+// patches the others into a staging directory next to bundleDirectory and, once all of them have been verified, stages copies
+// of the files of modeChanges with their new modes and swaps all of them and symlinkChanges into bundleDirectory under the
+// protection of an updateJournal. If the download fails, the staging directory is kept so that a later run can resume where
+// this one left off.
+func (u *Updater) installBundleUpdate(baseURL string, wantedState, modeChanges config.FileInfoMap, symlinkChanges map[string]string,
+	packs config.PackInfoMap, bundleDirectory string) {
+	stagingDirectory := stagingDirectoryPath(bundleDirectory)
+	remainingState := u.mustPrepareStagingDirectory(wantedState, stagingDirectory)
+	if len(remainingState) < len(wantedState) {
+		log.Infof("Resuming update of \"%s\": %d of %d files have already been staged.", bundleDirectory, len(wantedState)-len(remainingState), len(wantedState))
+	}
+	if chosenPacks := packs.Choose(remainingState); len(chosenPacks) > 0 {
+		log.Infof("Extracting files of \"%s\" from %d packs.", bundleDirectory, len(chosenPacks))
+		remainingState = u.downloader.MustExtractPacksToDirectory(baseURL, chosenPacks, remainingState, stagingDirectory)
+	}
+	u.downloader.MustPatchOrDownloadToDirectory(baseURL, remainingState, stagingDirectory, bundleDirectory)
+	mustCheckStagedFilesAreComplete(wantedState, stagingDirectory)
+	mustStageModeChanges(modeChanges, bundleDirectory, stagingDirectory)
+
+	stagedState := make(config.FileInfoMap)
+	stagedState.Join(wantedState)
+	stagedState.Join(modeChanges)
+	journal := newUpdateJournal(stagedState, symlinkChanges, bundleDirectory, stagingDirectory)
+	journal.mustCommit(journalFilePath(bundleDirectory))
+}
+
+func stagingDirectoryPath(bundleDirectory string) string {
+	return filepath.Join(filepath.Dir(bundleDirectory), "~"+filepath.Base(bundleDirectory)+stagingDirectorySuffix)
+}
+
+func isStagingDirectoryName(name string) bool {
+	return strings.HasPrefix(name, "~") && strings.HasSuffix(name, stagingDirectorySuffix)
+}
+
+// mustPrepareStagingDirectory removes files which are not wanted anymore from a staging directory left behind by a previous run
+// and returns the part of wantedState which still needs to be downloaded. Partially downloaded files are left to the downloader.
+func (u *Updater) mustPrepareStagingDirectory(wantedState config.FileInfoMap, stagingDirectory string) config.FileInfoMap {
+	remainingState := make(config.FileInfoMap)
+	remainingState.Join(wantedState)
+	if !system.FolderExists(stagingDirectory) {
+		return remainingState
+	}
+	err := filepath.Walk(stagingDirectory, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || fetching.IsPartialInfoFile(filePath) {
+			return err
+		}
+		relativeFilePath, err := filepath.Rel(stagingDirectory, filePath)
+		if err != nil {
+			return err
+		}
+		wantedFileInfo, ok := wantedState[relativeFilePath]
+		if ok && wantedFileInfo.Hash != "" {
+			if system.FileExists(fetching.PartialInfoFilePath(filePath)) {
+				return nil
+			}
+			hash, size, err := hashing.CalculateHash(u.ctx, filePath, wantedFileInfo.HashAlgorithm)
+			if err != nil && u.ctx.Err() != nil {
+				return u.ctx.Err()
+			}
+			if err == nil && size == wantedFileInfo.Size && strings.EqualFold(hash, wantedFileInfo.Hash) {
+				delete(remainingState, relativeFilePath)
+				return nil
+			}
+		}
+		fetching.RemovePartialDownload(filePath)
+		return nil
+	})
+	if err != nil {
+		panic(system.NewFileSystemError(fmt.Sprintf("Could not prepare staging directory \"%s\"", stagingDirectory), err))
+	}
+	return remainingState
+}
+
+// mustCheckStagedFilesAreComplete makes sure that every file of wantedState has been staged at its full size before the swap.
+// It does not hash them again: the downloader checks the hash of every file it stages and mustPrepareStagingDirectory those
+// of files which have been staged by an earlier run.
+func mustCheckStagedFilesAreComplete(wantedState config.FileInfoMap, stagingDirectory string) {
+	for filePath, fileInfo := range wantedState {
+		if fileInfo.Hash == "" {
+			continue
+		}
+		stagedFilePath := filepath.Join(stagingDirectory, filePath)
+		info, err := os.Stat(stagedFilePath)
+		if err != nil {
+			panic(system.NewFileSystemError(fmt.Sprintf("Could not check staged file \"%s\"", stagedFilePath), err))
+		}
+		if info.Size() != fileInfo.Size {
+			panic(fmt.Sprintf("Staged file \"%s\" has size %d when %d was expected", stagedFilePath, info.Size(), fileInfo.Size))
+		}
+	}
+}
+
+// mustStageModeChanges copies the files of modeChanges from bundleDirectory into the staging directory and gives the copies
+// their new modes, so that the swap installs them with those modes like the files which have been downloaded.
+func mustStageModeChanges(modeChanges config.FileInfoMap, bundleDirectory, stagingDirectory string) {
+	for filePath, fileInfo := range modeChanges {
+		stagedFilePath := filepath.Join(stagingDirectory, filePath)
+		system.MustCopyAll(filepath.Join(bundleDirectory, filePath), stagedFilePath)
+		if mode, ok := fileInfo.FileMode(); ok {
+			if err := system.SetFileMode(stagedFilePath, mode); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+func (journal *updateJournal) mustCommit(journalFilePath string) {
+	data, err := json.Marshal(journal)
+	if err != nil {
+		panic(err)
+	}
+	system.MustPutFileAtomically(journalFilePath, data)
+	log.Infof("Swapping %d staged files from \"%s\" and %d symlinks into \"%s\".", len(journal.Files), journal.StagingDirectory,
+		len(journal.Symlinks), journal.BundleDirectory)
+	if err = journal.apply(); err != nil {
+		log.Errorf("Could not swap staged files into \"%s\": %v. Rolling back.", journal.BundleDirectory, err)
+		if rollBackErr := journal.rollBack(); rollBackErr != nil {
+			log.Errorf("Could not roll back update of \"%s\": %v. The next launch will try again.", journal.BundleDirectory, rollBackErr)
+			panic(rollBackErr)
+		}
+		journal.finish(journalFilePath)
+		panic(err)
+	}
+	journal.finish(journalFilePath)
+}
+
+// apply moves the files to be replaced or removed into the backup directory and the staged files into the bundle directory.
+// Changed symlinks are removed before that, so that no file is moved through one of them, and created again after it.
+// It can be called repeatedly to finish a swap which has been interrupted.
+func (journal *updateJournal) apply() error {
+	for linkPath, symlink := range journal.Symlinks {
+		if err := removeSymlink(filepath.Join(journal.BundleDirectory, linkPath), symlink.Target); err != nil {
+			return err
+		}
+	}
+	for filePath, entry := range journal.Files {
+		livePath := filepath.Join(journal.BundleDirectory, filePath)
+		backupPath := filepath.Join(journal.BackupDirectory, filePath)
+		stagedPath := filepath.Join(journal.StagingDirectory, filePath)
+		if entry.Existed && !pathExists(backupPath) {
+			if err := moveFile(livePath, backupPath); err != nil {
+				return err
+			}
+		}
+		if !entry.Remove {
+			if pathExists(stagedPath) {
+				if err := os.RemoveAll(livePath); err != nil {
+					return system.NewFileSystemError(fmt.Sprintf("Could not remove \"%s\" to make room for staged file", livePath), err)
+				}
+				if err := moveFile(stagedPath, livePath); err != nil {
+					return err
+				}
+			} else if !pathExists(livePath) {
+				return fmt.Errorf("staged file \"%s\" is missing", stagedPath)
+			}
+		}
+	}
+	for linkPath, symlink := range journal.Symlinks {
+		if symlink.Target != "" {
+			if err := createSymlink(filepath.Join(journal.BundleDirectory, linkPath), symlink.Target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rollBack restores the files which have been moved into the backup directory, removes files which did not exist before and
+// recreates the symlinks which existed before. Changed symlinks are removed first, so that no file is removed through one.
+func (journal *updateJournal) rollBack() error {
+	for linkPath := range journal.Symlinks {
+		if err := removeSymlink(filepath.Join(journal.BundleDirectory, linkPath), ""); err != nil {
+			return err
+		}
+	}
+	for filePath, entry := range journal.Files {
+		livePath := filepath.Join(journal.BundleDirectory, filePath)
+		backupPath := filepath.Join(journal.BackupDirectory, filePath)
+		if pathExists(backupPath) {
+			if err := os.RemoveAll(livePath); err != nil {
+				return system.NewFileSystemError(fmt.Sprintf("Could not remove \"%s\" to restore its backup", livePath), err)
+			}
+			if err := moveFile(backupPath, livePath); err != nil {
+				return err
+			}
+		} else if !entry.Existed {
+			if err := os.RemoveAll(livePath); err != nil {
+				return system.NewFileSystemError(fmt.Sprintf("Could not remove \"%s\"", livePath), err)
+			}
+		}
+	}
+	for linkPath, symlink := range journal.Symlinks {
+		if symlink.PreviousTarget != "" {
+			if err := createSymlink(filepath.Join(journal.BundleDirectory, linkPath), symlink.PreviousTarget); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (journal *updateJournal) finish(journalFilePath string) {
+	system.MustRemoveFile(journalFilePath)
+	system.TryRemoveDirectory(journal.BackupDirectory)
+	system.TryRemoveDirectory(journal.StagingDirectory)
+	if system.FolderExists(journal.BundleDirectory) {
+		system.MustRecursivelyRemoveEmptyFolders(journal.BundleDirectory)
+	}
+}
+
+// recoverInterruptedBundleUpdates finishes every swap of staged files into a bundle directory which has been interrupted
+// during a previous run of the launcher. If a swap cannot be finished, it is rolled back instead.
+func (u *Updater) recoverInterruptedBundleUpdates() {
+	journalFilePaths, err := filepath.Glob(filepath.Join(u.userBundlesFolderPath, "~*"+journalFileSuffix))
+	if err != nil {
+		panic(err)
+	}
+	for _, journalFilePath := range journalFilePaths {
+		journal, err := readUpdateJournal(journalFilePath)
+		if err != nil {
+			log.Warnf("Discarding unreadable update journal \"%s\": %v", journalFilePath, err)
+			system.MustRemoveFile(journalFilePath)
+			continue
+		}
+		log.Infof("Finishing interrupted update of \"%s\".", journal.BundleDirectory)
+		if err = journal.apply(); err != nil {
+			log.Warnf("Could not finish interrupted update of \"%s\": %v. Rolling back.", journal.BundleDirectory, err)
+			if err = journal.rollBack(); err != nil {
+				panic(err)
+			}
+		}
+		journal.finish(journalFilePath)
+	}
+}
+
+func readUpdateJournal(journalFilePath string) (*updateJournal, error) {
+	data, err := ioutil.ReadFile(journalFilePath)
+	if err != nil {
+		return nil, err
+	}
+	journal := &updateJournal{}
+	if err = json.Unmarshal(data, journal); err != nil {
+		return nil, err
+	}
+	if journal.BundleDirectory == "" || journal.StagingDirectory == "" || journal.BackupDirectory == "" {
+		return nil, fmt.Errorf("journal is incomplete")
+	}
+	for filePath := range journal.Files {
+		if filepath.IsAbs(filePath) || strings.HasPrefix(filepath.Clean(filePath), "..") {
+			return nil, fmt.Errorf("journal contains invalid file path \"%s\"", filePath)
+		}
+	}
+	for linkPath := range journal.Symlinks {
+		if filepath.IsAbs(linkPath) || strings.HasPrefix(filepath.Clean(linkPath), "..") {
+			return nil, fmt.Errorf("journal contains invalid symlink path \"%s\"", linkPath)
+		}
+	}
+	return journal, nil
+}
+
+func moveFile(fromPath, toPath string) error {
+	if err := os.MkdirAll(filepath.Dir(toPath), 0700); err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not create directory for \"%s\"", toPath), err)
+	}
+	if err := os.Rename(fromPath, toPath); err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not move \"%s\" to \"%s\"", fromPath, toPath), err)
+	}
+	return nil
+}
+
+func pathExists(filePath string) bool {
+	_, err := os.Lstat(filePath)
+	return err == nil
+}
@@ -0,0 +1,218 @@
+package bundle
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/fetching"
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+func setUpInterruptedSwap(t *testing.T) (journal *updateJournal, journalPath string) {
+	root, err := ioutil.TempDir("", "trivrost-journal-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	bundleDirectory := filepath.Join(root, "bundle")
+	stagingDirectory := stagingDirectoryPath(bundleDirectory)
+	writeTestFile(t, filepath.Join(bundleDirectory, "changed"), "old")
+	writeTestFile(t, filepath.Join(bundleDirectory, "removed"), "old")
+	writeTestFile(t, filepath.Join(bundleDirectory, "unchanged"), "old")
+	writeTestFile(t, filepath.Join(stagingDirectory, "changed"), "new")
+	writeTestFile(t, filepath.Join(stagingDirectory, "sub", "created"), "new")
+	wantedState := config.FileInfoMap{
+		"changed":                       {Hash: "a", Size: 3},
+		"removed":                       {Hash: "", Size: 3},
+		filepath.Join("sub", "created"): {Hash: "b", Size: 3},
+	}
+	journal = newUpdateJournal(wantedState, nil, bundleDirectory, stagingDirectory)
+	journalPath = journalFilePath(bundleDirectory)
+	if err = ioutil.WriteFile(journalPath, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate the launcher being killed after having moved the first file into the backup directory.
+	if err = moveFile(filepath.Join(bundleDirectory, "changed"), filepath.Join(journal.BackupDirectory, "changed")); err != nil {
+		t.Fatal(err)
+	}
+	return journal, journalPath
+}
+
+func TestJournalApplyFinishesInterruptedSwap(t *testing.T) {
+	journal, journalPath := setUpInterruptedSwap(t)
+	if err := journal.apply(); err != nil {
+		t.Fatal(err)
+	}
+	journal.finish(journalPath)
+	expectFileContent(t, filepath.Join(journal.BundleDirectory, "changed"), "new")
+	expectFileContent(t, filepath.Join(journal.BundleDirectory, "sub", "created"), "new")
+	expectFileContent(t, filepath.Join(journal.BundleDirectory, "unchanged"), "old")
+	expectMissing(t, filepath.Join(journal.BundleDirectory, "removed"))
+	expectMissing(t, journal.BackupDirectory)
+	expectMissing(t, journal.StagingDirectory)
+	expectMissing(t, journalPath)
+}
+
+func TestJournalRollBackRestoresPreviousState(t *testing.T) {
+	journal, journalPath := setUpInterruptedSwap(t)
+	if err := journal.rollBack(); err != nil {
+		t.Fatal(err)
+	}
+	journal.finish(journalPath)
+	expectFileContent(t, filepath.Join(journal.BundleDirectory, "changed"), "old")
+	expectFileContent(t, filepath.Join(journal.BundleDirectory, "removed"), "old")
+	expectFileContent(t, filepath.Join(journal.BundleDirectory, "unchanged"), "old")
+	expectMissing(t, filepath.Join(journal.BundleDirectory, "sub"))
+	expectMissing(t, journalPath)
+}
+
+func TestJournalApplyFailsWhenStagedFileIsMissing(t *testing.T) {
+	journal, _ := setUpInterruptedSwap(t)
+	if err := os.Remove(filepath.Join(journal.StagingDirectory, "changed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.apply(); err == nil {
+		t.Fatalf("apply() succeeded despite a missing staged file")
+	}
+}
+
+func TestJournalCommitFailingMidInstallKeepsSymlinks(t *testing.T) {
+	root := t.TempDir()
+	bundleDirectory := filepath.Join(root, "bundle")
+	stagingDirectory := stagingDirectoryPath(bundleDirectory)
+	writeTestFile(t, filepath.Join(bundleDirectory, "old", "changed"), "old")
+	writeTestFile(t, filepath.Join(stagingDirectory, "created"), "new")
+	if err := os.Symlink("old", filepath.Join(bundleDirectory, "current")); err != nil {
+		t.Skipf("Cannot create symbolic links: %v", err)
+	}
+	if err := os.Symlink("old", filepath.Join(bundleDirectory, "removed")); err != nil {
+		t.Fatal(err)
+	}
+	wantedState := config.FileInfoMap{
+		"created":                       {Hash: "a", Size: 3},
+		filepath.Join("old", "changed"): {Hash: "b", Size: 3}, // Not staged, so that the swap fails.
+	}
+	symlinkChanges := map[string]string{"current": "new", "removed": "", "added": "old"}
+	journal := newUpdateJournal(wantedState, symlinkChanges, bundleDirectory, stagingDirectory)
+	journalPath := journalFilePath(bundleDirectory)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected mustCommit() to panic when a staged file is missing")
+			}
+		}()
+		journal.mustCommit(journalPath)
+	}()
+	for linkPath, expectedTarget := range map[string]string{"current": "old", "removed": "old"} {
+		if target, err := os.Readlink(filepath.Join(bundleDirectory, linkPath)); err != nil || target != expectedTarget {
+			t.Errorf("Expected symlink \"%s\" to point to \"%s\" after the rollback. Got \"%s\", %v.", linkPath, expectedTarget, target, err)
+		}
+	}
+	expectMissing(t, filepath.Join(bundleDirectory, "added"))
+	expectMissing(t, filepath.Join(bundleDirectory, "created"))
+	expectFileContent(t, filepath.Join(bundleDirectory, "old", "changed"), "old")
+	expectMissing(t, journalPath)
+}
+
+func TestJournalApplySwapsSymlinks(t *testing.T) {
+	root := t.TempDir()
+	bundleDirectory := filepath.Join(root, "bundle")
+	stagingDirectory := stagingDirectoryPath(bundleDirectory)
+	writeTestFile(t, filepath.Join(bundleDirectory, "real", "file"), "old")
+	writeTestFile(t, filepath.Join(stagingDirectory, "lib", "file"), "new")
+	if err := os.Symlink("real", filepath.Join(bundleDirectory, "lib")); err != nil {
+		t.Skipf("Cannot create symbolic links: %v", err)
+	}
+	wantedState := config.FileInfoMap{filepath.Join("lib", "file"): {Hash: "a", Size: 3}}
+	journal := newUpdateJournal(wantedState, map[string]string{"lib": "", "current": "lib/file"}, bundleDirectory, stagingDirectory)
+	journal.mustCommit(journalFilePath(bundleDirectory))
+	expectFileContent(t, filepath.Join(bundleDirectory, "real", "file"), "old") // Not written through the removed symlink.
+	expectFileContent(t, filepath.Join(bundleDirectory, "lib", "file"), "new")
+	expectFileContent(t, filepath.Join(bundleDirectory, "current"), "new")
+}
+
+func TestJournalSwapsInStagedModeChanges(t *testing.T) {
+	if !system.SupportsFileModes {
+		t.Skip("File modes are not supported on this platform")
+	}
+	root := t.TempDir()
+	bundleDirectory := filepath.Join(root, "bundle")
+	stagingDirectory := stagingDirectoryPath(bundleDirectory)
+	writeTestFile(t, filepath.Join(bundleDirectory, "run"), "old")
+	if err := os.Chmod(filepath.Join(bundleDirectory, "run"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modeChanges := config.FileInfoMap{"run": {Hash: "a", Size: 3, Mode: "0755"}}
+	mustStageModeChanges(modeChanges, bundleDirectory, stagingDirectory)
+	expectFileMode(t, filepath.Join(bundleDirectory, "run"), 0644) // Unchanged until the swap.
+	expectFileMode(t, filepath.Join(stagingDirectory, "run"), 0755)
+	newUpdateJournal(modeChanges, nil, bundleDirectory, stagingDirectory).mustCommit(journalFilePath(bundleDirectory))
+	expectFileContent(t, filepath.Join(bundleDirectory, "run"), "old")
+	expectFileMode(t, filepath.Join(bundleDirectory, "run"), 0755)
+}
+
+func TestPrepareStagingDirectoryKeepsUsableFiles(t *testing.T) {
+	stagingDirectory, err := ioutil.TempDir("", "trivrost-staging-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stagingDirectory)
+	writeTestFile(t, filepath.Join(stagingDirectory, "complete"), "new")
+	writeTestFile(t, filepath.Join(stagingDirectory, "outdated"), "old")
+	writeTestFile(t, filepath.Join(stagingDirectory, "partial"), "ne")
+	writeTestFile(t, fetching.PartialInfoFilePath(filepath.Join(stagingDirectory, "partial")), "{}")
+	writeTestFile(t, filepath.Join(stagingDirectory, "unwanted"), "new")
+	newSha := sha256.Sum256([]byte("new"))
+	newFileInfo := &config.FileInfo{Hash: hex.EncodeToString(newSha[:]), Size: 3}
+	wantedState := config.FileInfoMap{"complete": newFileInfo, "outdated": newFileInfo, "partial": newFileInfo, "missing": newFileInfo}
+	u := &Updater{ctx: context.Background()}
+	remainingState := u.mustPrepareStagingDirectory(wantedState, stagingDirectory)
+	if len(remainingState) != 3 || remainingState["complete"] != nil {
+		t.Errorf("Expected all files but \"complete\" to remain to be downloaded. Got %v.", remainingState)
+	}
+	expectFileContent(t, filepath.Join(stagingDirectory, "complete"), "new")
+	expectFileContent(t, filepath.Join(stagingDirectory, "partial"), "ne")
+	expectMissing(t, filepath.Join(stagingDirectory, "outdated"))
+	expectMissing(t, filepath.Join(stagingDirectory, "unwanted"))
+}
+
+func writeTestFile(t *testing.T, filePath, content string) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectFileContent(t *testing.T, filePath, expectedContent string) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Could not read \"%s\": %v", filePath, err)
+	}
+	if string(data) != expectedContent {
+		t.Errorf("\"%s\" contains \"%s\". Expected \"%s\".", filePath, string(data), expectedContent)
+	}
+}
+
+func expectFileMode(t *testing.T, filePath string, expectedMode os.FileMode) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Could not stat \"%s\": %v", filePath, err)
+	}
+	if info.Mode().Perm() != expectedMode {
+		t.Errorf("\"%s\" has mode %04o. Expected %04o.", filePath, info.Mode().Perm(), expectedMode)
+	}
+}
+
+func expectMissing(t *testing.T, filePath string) {
+	if _, err := os.Lstat(filePath); !os.IsNotExist(err) {
+		t.Errorf("\"%s\" should not exist, but Lstat() returned %v", filePath, err)
+	}
+}
@@ -0,0 +1,116 @@
+package bundle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+// mustRemoveChangedSymlinks removes the symlinks under localDirPath which symlinkChanges remove or point elsewhere. This has
+// to happen before files are swapped in, so that none of them are written through a symlink which is about to go away.
+func mustRemoveChangedSymlinks(symlinkChanges map[string]string, localDirPath string) {
+	for linkPath := range symlinkChanges {
+		if err := removeSymlink(filepath.Join(localDirPath, filepath.FromSlash(linkPath)), ""); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// mustCreateSymlinksAndDirectories creates the symlinks which symlinkChanges add or point elsewhere and the given directories
+// under localDirPath, once files have been swapped in. An empty directory in place of a symlink is replaced by it.
+func mustCreateSymlinksAndDirectories(symlinkChanges map[string]string, directories []string, localDirPath string) {
+	mustCreateDirectories(directories, localDirPath)
+	for linkPath, target := range symlinkChanges {
+		if target == "" {
+			continue
+		}
+		if err := createSymlink(filepath.Join(localDirPath, filepath.FromSlash(linkPath)), filepath.FromSlash(target)); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func mustCreateDirectories(directories []string, localDirPath string) {
+	for _, directoryPath := range directories {
+		system.MustMakeDir(filepath.Join(localDirPath, filepath.FromSlash(directoryPath)))
+	}
+}
+
+// removeSymlink removes the symlink at livePath unless it points to keptTarget. Anything else at livePath is left alone.
+func removeSymlink(livePath, keptTarget string) error {
+	if target, ok := readSymlink(livePath); ok && (keptTarget == "" || target != keptTarget) {
+		if err := os.Remove(livePath); err != nil {
+			return system.NewFileSystemError(fmt.Sprintf("Could not remove symlink \"%s\"", livePath), err)
+		}
+	}
+	return nil
+}
+
+// createSymlink creates a symlink at livePath which points to target, unless there already is one.
+func createSymlink(livePath, target string) error {
+	if currentTarget, ok := readSymlink(livePath); ok && currentTarget == target {
+		return nil
+	}
+	if err := makeRoomForSymlink(livePath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(livePath), 0700); err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not create directory for symlink \"%s\"", livePath), err)
+	}
+	if err := os.Symlink(target, livePath); err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not create symbolic link \"%s\" pointing to \"%s\"", livePath, target), err)
+	}
+	return nil
+}
+
+func readSymlink(livePath string) (target string, ok bool) {
+	info, err := os.Lstat(livePath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return "", false
+	}
+	target, err = os.Readlink(livePath)
+	return target, err == nil
+}
+
+func makeRoomForSymlink(livePath string) error {
+	info, err := os.Lstat(livePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil && info.Mode()&os.ModeSymlink == 0 {
+		if !info.IsDir() {
+			err = fmt.Errorf("a file is in the way")
+		} else if entries, readErr := ioutil.ReadDir(livePath); readErr != nil || len(entries) > 0 {
+			err = fmt.Errorf("a directory which is not empty is in the way")
+		}
+	}
+	if err == nil {
+		err = os.Remove(livePath)
+	}
+	if err != nil {
+		return system.NewFileSystemError(fmt.Sprintf("Could not make room for symlink \"%s\"", livePath), err)
+	}
+	return nil
+}
+
+// stripFirstPathElements returns the symlinks and directories of a launcher bundle info relative to the folder which their
+// first path element names, like config.FileInfoMap.StripFirstPathElement() does for its files.
+func stripFirstPathElements(bundleInfo *config.BundleInfo) (symlinks map[string]string, directories []string) {
+	symlinks = make(map[string]string, len(bundleInfo.Symlinks))
+	for linkPath, target := range bundleInfo.Symlinks {
+		if elements := strings.SplitN(linkPath, "/", 2); len(elements) == 2 {
+			symlinks[elements[1]] = target
+		}
+	}
+	for _, directoryPath := range bundleInfo.Directories {
+		if elements := strings.SplitN(directoryPath, "/", 2); len(elements) == 2 {
+			directories = append(directories, elements[1])
+		}
+	}
+	return symlinks, directories
+}
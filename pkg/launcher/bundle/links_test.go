@@ -0,0 +1,47 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkChangesSurroundFileSwap(t *testing.T) {
+	bundleDirectory := t.TempDir()
+	writeTestFile(t, filepath.Join(bundleDirectory, "lib", "real", "libjvm.so"), "new")
+	writeTestFile(t, filepath.Join(bundleDirectory, "old", "libjvm.so"), "old")
+	if err := os.Symlink("real", filepath.Join(bundleDirectory, "lib", "current")); err != nil {
+		t.Skipf("Cannot create symbolic links: %v", err)
+	}
+	if err := os.Symlink("old", filepath.Join(bundleDirectory, "removed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(bundleDirectory, "bin"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	symlinkChanges := map[string]string{"lib/current": "real/libjvm.so", "removed": "", "bin": "lib/real"}
+
+	mustRemoveChangedSymlinks(symlinkChanges, bundleDirectory)
+	expectMissing(t, filepath.Join(bundleDirectory, "lib", "current"))
+	expectMissing(t, filepath.Join(bundleDirectory, "removed"))
+	expectFileContent(t, filepath.Join(bundleDirectory, "old", "libjvm.so"), "old")
+
+	mustCreateSymlinksAndDirectories(symlinkChanges, []string{"logs/today"}, bundleDirectory)
+	expectFileContent(t, filepath.Join(bundleDirectory, "lib", "current"), "new")
+	expectFileContent(t, filepath.Join(bundleDirectory, "bin", "libjvm.so"), "new") // The empty directory has been replaced.
+	if info, err := os.Stat(filepath.Join(bundleDirectory, "logs", "today")); err != nil || !info.IsDir() {
+		t.Errorf("Expected directory \"logs/today\" to be created. Got %v", err)
+	}
+}
+
+func TestMustCreateSymlinksAndDirectoriesDoesNotReplaceFiles(t *testing.T) {
+	bundleDirectory := t.TempDir()
+	writeTestFile(t, filepath.Join(bundleDirectory, "bin"), "file")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic when a file is in the way of a symlink")
+		}
+		expectFileContent(t, filepath.Join(bundleDirectory, "bin"), "file")
+	}()
+	mustCreateSymlinksAndDirectories(map[string]string{"bin": "lib"}, nil, bundleDirectory)
+}
@@ -0,0 +1,88 @@
+package bundle
+
+import (
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/fetching"
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+	"github.com/setlog/trivrost/pkg/system"
+	log "github.com/sirupsen/logrus"
+)
+
+type offlineLaunch struct {
+	gracePeriod time.Duration
+	confirm     func(err error) bool
+}
+
+// EnableOfflineLaunch makes Prepare() fall back to the last deployment-config which has been downloaded and verified if
+// downloading it fails for gracePeriod for reasons which may be temporary, like the network being unreachable. The
+// updater then verifies the bundles against the last verified bundle info files instead of updating them, and fails if
+// they are incomplete or a bundle requires being online. confirm is called with the error of the download before falling
+// back. If it returns false, Prepare() panics with the error. Requires EnableResourceCache() to have been called.
+func (u *Updater) EnableOfflineLaunch(gracePeriod time.Duration, confirm func(err error) bool) {
+	u.offlineLaunch = &offlineLaunch{gracePeriod: gracePeriod, confirm: confirm}
+}
+
+// IsOffline returns true if Prepare() fell back to the last verified deployment-config, in which case nothing will be updated.
+func (u *Updater) IsOffline() bool {
+	return u.isOffline
+}
+
+func (u *Updater) downloadDeploymentConfig(deploymentConfigURL string) ([]byte, error) {
+	if u.offlineLaunch == nil {
+		return u.downloader.DownloadSignedResource(deploymentConfigURL, u.publicKeys)
+	}
+	retryPolicy := u.retryPolicy
+	if retryPolicy == nil {
+		retryPolicy = fetching.NewDefaultRetryPolicy()
+	}
+	u.downloader.SetRetryPolicy(fetching.LimitRetryTime(retryPolicy, u.offlineLaunch.gracePeriod))
+	defer u.downloader.SetRetryPolicy(u.retryPolicy)
+	return u.downloader.DownloadSignedResource(deploymentConfigURL, u.publicKeys)
+}
+
+// mustLoadDeploymentConfigForOfflineLaunch returns the last verified deployment-config if offline launch is enabled,
+// downloadErr is temporary and launching offline is allowed and confirmed. Otherwise, it panics with downloadErr.
+func (u *Updater) mustLoadDeploymentConfigForOfflineLaunch(deploymentConfigURL string, downloadErr error) []byte {
+	if u.offlineLaunch == nil || !fetching.IsTemporaryFailure(downloadErr) || u.ctx.Err() != nil {
+		panic(downloadErr)
+	}
+	log.Warnf("Could not download deployment-config: %v", downloadErr)
+	data, err := u.downloader.LoadCachedSignedResources([]string{deploymentConfigURL}, u.publicKeys)
+	if err != nil {
+		log.Warnf("Cannot launch offline: %v", err)
+		panic(downloadErr)
+	}
+	deploymentConfig := config.ParseDeploymentConfig(strings.NewReader(string(data[deploymentConfigURL])), runtime.GOOS, system.GetOSArch())
+	for _, bundleConfig := range deploymentConfig.Bundles {
+		if bundleConfig.RequiresOnline {
+			panic(misc.UserErrorf(downloadErr, "Could not download \"%s\". The application cannot be started offline because bundle \"%s\" "+
+				"requires a connection. Please check your internet connection and try again later.", deploymentConfigURL, bundleConfig.LocalDirectory))
+		}
+	}
+	if !u.offlineLaunch.confirm(downloadErr) {
+		panic(downloadErr)
+	}
+	log.Warnf("Launching offline with the last verified deployment-config.")
+	u.isOffline = true
+	return data[deploymentConfigURL]
+}
+
+// mustVerifyBundlesForOfflineLaunch panics unless all bundles are present as described by the last verified bundle info files.
+func (u *Updater) mustVerifyBundlesForOfflineLaunch() {
+	for _, bundleUpdateInfo := range u.bundleUpdateInfos {
+		if bundleUpdateInfo.WantedState.HasChanges() {
+			log.Warnf("Bundle \"%s\" differs from its last verified bundle info file:", bundleUpdateInfo.LocalDirectory)
+			bundleUpdateInfo.LogChanges()
+			panic(newIncompleteOfflineInstallationError(nil))
+		}
+	}
+}
+
+func newIncompleteOfflineInstallationError(cause error) error {
+	return misc.UserErrorf(cause, "The application cannot be started offline because it has not been installed completely. "+
+		"Please check your internet connection and try again later.")
+}
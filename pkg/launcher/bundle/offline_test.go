@@ -0,0 +1,100 @@
+package bundle
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/fetching"
+	"github.com/setlog/trivrost/pkg/misc"
+)
+
+// serveSignedDeploymentConfig serves deploymentConfig and its signature until the returned server is closed.
+func serveSignedDeploymentConfig(t *testing.T, deploymentConfig string) (*httptest.Server, *rsa.PublicKey) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256([]byte(deploymentConfig))
+	signature, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA256, hashed[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/deployment-config.json", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(deploymentConfig)) })
+	mux.HandleFunc("/deployment-config.json.signature", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(base64.StdEncoding.EncodeToString(signature)))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	fetching.DoForClientFunc = fetching.DoForClient
+	return server, &privateKey.PublicKey
+}
+
+func newOfflineTestUpdater(publicKey *rsa.PublicKey, cacheFolderPath string, confirm func(err error) bool) *Updater {
+	updater := NewUpdater(context.Background(), &fetching.EmptyHandler{}, []*rsa.PublicKey{publicKey})
+	updater.EnableResourceCache(cacheFolderPath)
+	updater.EnableOfflineLaunch(100*time.Millisecond, confirm)
+	return updater
+}
+
+func prepareOffline(t *testing.T, deploymentConfig string, confirm func(err error) bool) (updater *Updater, panicObject interface{}) {
+	server, publicKey := serveSignedDeploymentConfig(t, deploymentConfig)
+	cacheFolderPath := t.TempDir()
+	newOfflineTestUpdater(publicKey, cacheFolderPath, confirm).Prepare(server.URL + "/deployment-config.json")
+	server.Close()
+
+	updater = newOfflineTestUpdater(publicKey, cacheFolderPath, confirm)
+	defer func() { panicObject = recover() }()
+	updater.Prepare(server.URL + "/deployment-config.json")
+	return updater, nil
+}
+
+func TestPrepareFallsBackToLastVerifiedDeploymentConfig(t *testing.T) {
+	var confirmedErr error
+	updater, panicObject := prepareOffline(t, `{"Bundles": [{"BundleInfoURL": "https://example.com/bundleinfo.json", "LocalDirectory": "app"}]}`,
+		func(err error) bool {
+			confirmedErr = err
+			return true
+		})
+	if panicObject != nil {
+		t.Fatalf("Expected offline launch. Got panic: %v", panicObject)
+	}
+	if !updater.IsOffline() || len(updater.GetDeploymentConfig().Bundles) != 1 {
+		t.Errorf("Expected updater to be offline with the cached deployment-config. Got %v, %+v", updater.IsOffline(), updater.GetDeploymentConfig())
+	}
+	if !fetching.IsTemporaryFailure(confirmedErr) {
+		t.Errorf("Expected confirmation to be asked with the download error. Got %v", confirmedErr)
+	}
+}
+
+func TestPrepareDoesNotLaunchOfflineWithoutConfirmation(t *testing.T) {
+	_, panicObject := prepareOffline(t, `{}`, func(err error) bool { return false })
+	if err, ok := panicObject.(error); !ok || !fetching.IsTemporaryFailure(err) {
+		t.Errorf("Expected panic with download error. Got %v", panicObject)
+	}
+}
+
+func TestPrepareDoesNotLaunchOfflineIfBundleRequiresOnline(t *testing.T) {
+	confirmed := false
+	_, panicObject := prepareOffline(t, `{"Bundles": [{"BundleInfoURL": "https://example.com/bundleinfo.json", "LocalDirectory": "app", "RequiresOnline": true}]}`,
+		func(err error) bool {
+			confirmed = true
+			return true
+		})
+	var userError *misc.UserError
+	if err, ok := panicObject.(error); !ok || !errors.As(err, &userError) {
+		t.Errorf("Expected panic with user error. Got %v", panicObject)
+	}
+	if confirmed {
+		t.Errorf("Expected user not to be asked to launch offline.")
+	}
+}
@@ -21,33 +21,73 @@ import (
 
 func (u *Updater) DetermineBundleRequirements(userBundlesFolderPath, systemBundlesFolderPath string) {
 	u.userBundlesFolderPath, u.systemBundlesFolderPath = userBundlesFolderPath, systemBundlesFolderPath
+	u.recoverInterruptedBundleUpdates()
 	u.determineLocalBundleVersions()
 	u.removeUnknownBundles()
 	u.determineBundleChanges()
+	if u.isOffline {
+		u.mustVerifyBundlesForOfflineLaunch()
+	}
 }
 
 func (u *Updater) determineLocalBundleVersions() {
-	u.announceStatus(DetermineLocalBundleVersions, 200)
+	var totalSize uint64
+	for _, bundleConfig := range u.deploymentConfig.Bundles {
+		totalSize += hashing.MustGetSize(filepath.Join(u.bundleFolderPath(bundleConfig), bundleConfig.LocalDirectory))
+	}
+	u.resetHashingProgress()
+	u.announceStatus(DetermineLocalBundleVersions, totalSize)
 	for _, bundleConfig := range u.deploymentConfig.Bundles {
-		var bundleUpdateInfo *BundleUpdateInfo
+		bundleUpdateInfo := u.makeBundleUpdateConfigFromBundle(bundleConfig, u.bundleFolderPath(bundleConfig))
 		if u.haveSystemBundleWithName(bundleConfig.LocalDirectory) {
-			bundleUpdateInfo = u.makeBundleUpdateConfigFromBundle(bundleConfig, u.systemBundlesFolderPath)
 			bundleUpdateInfo.IsSystemBundle = true
 			log.Debugf("Identified bundle \"%s\" as system bundle.", bundleConfig.LocalDirectory)
 		} else {
-			bundleUpdateInfo = u.makeBundleUpdateConfigFromBundle(bundleConfig, u.userBundlesFolderPath)
 			log.Debugf("Identified bundle \"%s\" as user bundle.", bundleConfig.LocalDirectory)
 		}
 		u.bundleUpdateInfos = append(u.bundleUpdateInfos, bundleUpdateInfo)
 	}
 }
 
+// bundleFolderPath returns the path of the folder which contains the bundle with given config.
+func (u *Updater) bundleFolderPath(bundleConfig config.BundleConfig) string {
+	if u.haveSystemBundleWithName(bundleConfig.LocalDirectory) {
+		return u.systemBundlesFolderPath
+	}
+	return u.userBundlesFolderPath
+}
+
 func (u *Updater) makeBundleUpdateConfigFromBundle(bundleConfig config.BundleConfig, bundleFolderPath string) *BundleUpdateInfo {
-	bundleUpdateConfig := BundleUpdateInfo{BundleConfig: bundleConfig}
+	bundleUpdateConfig := BundleUpdateInfo{BundleConfig: bundleConfig, PresentHashAlgorithm: u.guessHashAlgorithm(bundleConfig)}
+	bundleUpdateConfig.PresentState = u.hashBundle(bundleConfig, bundleFolderPath, bundleUpdateConfig.PresentHashAlgorithm)
+	linksAndDirectories := hashing.MustListLinksAndDirectories(filepath.Join(bundleFolderPath, bundleConfig.LocalDirectory))
+	bundleUpdateConfig.PresentSymlinks, bundleUpdateConfig.PresentDirectories = linksAndDirectories.Symlinks, linksAndDirectories.Directories
+	return &bundleUpdateConfig
+}
+
+// guessHashAlgorithm returns the algorithm which the bundle info of the bundle with given config most likely uses, before
+// it has been retrieved: the one the bundle has been hashed with before if the hash cache is enabled, else the default.
+func (u *Updater) guessHashAlgorithm(bundleConfig config.BundleConfig) string {
+	if u.hashCacheFolderPath == "" {
+		return config.DefaultHashAlgorithm
+	}
+	return hashing.CachedHashAlgorithm(u.hashCacheFilePath(bundleConfig))
+}
+
+func (u *Updater) hashCacheFilePath(bundleConfig config.BundleConfig) string {
+	return filepath.Join(u.hashCacheFolderPath, bundleConfig.LocalDirectory+".json")
+}
+
+func (u *Updater) hashBundle(bundleConfig config.BundleConfig, bundleFolderPath, hashAlgorithm string) (presentState config.FileInfoMap) {
 	startedAt := time.Now()
-	bundleUpdateConfig.PresentState = hashing.MustHash(u.ctx, filepath.Join(bundleFolderPath, bundleConfig.LocalDirectory))
+	bundleDirectory := filepath.Join(bundleFolderPath, bundleConfig.LocalDirectory)
+	if u.hashCacheFolderPath != "" {
+		presentState = hashing.MustHashWithCache(u.ctx, bundleDirectory, u.hashCacheFilePath(bundleConfig), hashAlgorithm, u.fullVerificationInterval, u.addHashingProgress)
+	} else {
+		presentState = hashing.MustHashWithProgress(u.ctx, bundleDirectory, hashAlgorithm, u.addHashingProgress)
+	}
 	log.Infof("Hashing directory of bundle \"%s\" took %v.", bundleConfig.LocalDirectory, time.Since(startedAt))
-	return &bundleUpdateConfig
+	return presentState
 }
 
 func (u *Updater) removeUnknownBundles() {
@@ -56,7 +96,7 @@ func (u *Updater) removeUnknownBundles() {
 		panic(err)
 	}
 	for _, fileInfo := range fileInfos {
-		if fileInfo.IsDir() && !u.wantBundleWithName(fileInfo.Name()) {
+		if fileInfo.IsDir() && !u.wantBundleWithName(fileInfo.Name()) && !u.isStagingDirectoryOfWantedBundle(fileInfo.Name()) {
 			removePath := filepath.Join(u.userBundlesFolderPath, fileInfo.Name())
 			log.Infof("Remove unknown bundle folder \"%s\".", removePath)
 			err = os.RemoveAll(removePath)
@@ -79,13 +119,45 @@ func (u *Updater) determineBundleChanges() {
 		panic(err)
 	}
 	for _, bundleUpdateInfo := range u.bundleUpdateInfos {
+		u.rehashBundleIfNeeded(bundleUpdateInfo, bundleInfos[bundleUpdateInfo.BundleInfoURL].HashAlgorithm)
 		bundleUpdateInfo.RemoteState = bundleInfos[bundleUpdateInfo.BundleInfoURL].GetFileHashes()
 		bundleUpdateInfo.WantedState = config.MakeDiffFileInfoMap(bundleUpdateInfo.PresentState, bundleUpdateInfo.RemoteState)
+		bundleUpdateInfo.ModeChanges = config.MakeModeDiffFileInfoMap(bundleUpdateInfo.PresentState, bundleUpdateInfo.RemoteState)
+		bundleUpdateInfo.Packs = bundleInfos[bundleUpdateInfo.BundleInfoURL].Packs
+		bundleUpdateInfo.RemoteSymlinks = bundleInfos[bundleUpdateInfo.BundleInfoURL].Symlinks
+		bundleUpdateInfo.RemoteDirectories = bundleInfos[bundleUpdateInfo.BundleInfoURL].Directories
+		bundleUpdateInfo.SymlinkChanges = config.MakeDiffSymlinkMap(bundleUpdateInfo.PresentSymlinks, bundleUpdateInfo.RemoteSymlinks)
+		bundleUpdateInfo.MissingDirectories = config.MakeDiffDirectoryList(bundleUpdateInfo.PresentDirectories, bundleUpdateInfo.RemoteDirectories)
+	}
+}
+
+// rehashBundleIfNeeded hashes the bundle described by bundleUpdateInfo again if its bundle info uses another hash
+// algorithm than its present state has been hashed with, as hashes of different algorithms cannot be compared.
+func (u *Updater) rehashBundleIfNeeded(bundleUpdateInfo *BundleUpdateInfo, hashAlgorithm string) {
+	hashAlgorithm = config.NormalizeHashAlgorithm(hashAlgorithm)
+	if hashAlgorithm == bundleUpdateInfo.PresentHashAlgorithm {
+		return
 	}
+	log.Infof("Hashing bundle \"%s\" again with %s instead of %s, as its bundle info does.",
+		bundleUpdateInfo.LocalDirectory, hashAlgorithm, bundleUpdateInfo.PresentHashAlgorithm)
+	bundleFolderPath := u.bundleFolderPath(bundleUpdateInfo.BundleConfig)
+	u.resetHashingProgress()
+	u.announceStatus(DetermineLocalBundleVersions, hashing.MustGetSize(filepath.Join(bundleFolderPath, bundleUpdateInfo.LocalDirectory)))
+	bundleUpdateInfo.PresentState = u.hashBundle(bundleUpdateInfo.BundleConfig, bundleFolderPath, hashAlgorithm)
+	bundleUpdateInfo.PresentHashAlgorithm = hashAlgorithm
+	u.announceStatus(RetrieveRemoteBundleVersions, 0)
 }
 
 func (u *Updater) retrieveBundleInfos(urls []string) (bundleInfos map[string]*config.BundleInfo, err error) {
-	bundleInfosData, err := u.downloader.DownloadSignedResources(urls, u.publicKeys)
+	var bundleInfosData map[string][]byte
+	if u.isOffline {
+		bundleInfosData, err = u.downloader.LoadCachedSignedResources(urls, u.publicKeys)
+		if err != nil {
+			return nil, newIncompleteOfflineInstallationError(err)
+		}
+	} else {
+		bundleInfosData, err = u.downloader.DownloadSignedResources(urls, u.publicKeys)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -116,20 +188,42 @@ func (u *Updater) InstallBundleUpdates() {
 
 func (u *Updater) installBundleUpdates() {
 	u.announceStatus(DownloadBundleFiles, countUpdatesBytes(u.bundleUpdateInfos))
+	mustHaveFreeDiskSpace(u.userBundlesFolderPath, u.requiredDiskSpaceForBundleUpdates())
 	for _, bundleUpdateConfig := range u.bundleUpdateInfos {
 		if bundleUpdateConfig.IsSystemBundle {
-			if bundleUpdateConfig.WantedState.HasChanges() {
+			if bundleUpdateConfig.HasChanges() {
 				log.Warnf("Cannot update bundle \"%s\" because it is a system bundle. The following changes will not be applied:", bundleUpdateConfig.LocalDirectory)
 				bundleUpdateConfig.LogChanges()
 			}
 		} else {
 			log.Infof("Downloading %d files for bundle \"%s\".", bundleUpdateConfig.WantedState.UpdateFileCount(), bundleUpdateConfig.LocalDirectory)
 			bundleDirectory := filepath.Join(u.userBundlesFolderPath, bundleUpdateConfig.LocalDirectory)
-			deleteChangedFiles(bundleUpdateConfig.WantedState, bundleDirectory)
-			u.downloader.MustDownloadToDirectory(bundleUpdateConfig.BaseURL, bundleUpdateConfig.WantedState, bundleDirectory)
-			system.MustRecursivelyRemoveEmptyFolders(bundleDirectory)
+			u.applyBandwidthLimit(bundleUpdateConfig.BandwidthLimit)
+			if bundleUpdateConfig.ModeChanges.HasChanges() {
+				log.Infof("Changing the mode of %d files of bundle \"%s\".", len(bundleUpdateConfig.ModeChanges), bundleUpdateConfig.LocalDirectory)
+			}
+			u.installBundleUpdate(bundleUpdateConfig.BaseURL, bundleUpdateConfig.WantedState, bundleUpdateConfig.ModeChanges,
+				bundleUpdateConfig.SymlinkChanges, bundleUpdateConfig.Packs, bundleDirectory)
+			// All directories are created again, as swapping files in removes empty ones.
+			mustCreateDirectories(bundleUpdateConfig.RemoteDirectories, bundleDirectory)
 		}
 	}
+	u.applyBandwidthLimit(0)
+}
+
+// requiredDiskSpaceForBundleUpdates returns the number of bytes needed to stage the updates of all user bundles, including
+// copies of the files which only change their mode, which share the volume of the user bundles folder with their staging
+// directories.
+func (u *Updater) requiredDiskSpaceForBundleUpdates() uint64 {
+	var total uint64
+	for _, bundleUpdateConfig := range u.bundleUpdateInfos {
+		if !bundleUpdateConfig.IsSystemBundle {
+			bundleDirectory := filepath.Join(u.userBundlesFolderPath, bundleUpdateConfig.LocalDirectory)
+			total += requiredDiskSpace(bundleUpdateConfig.WantedState, stagingDirectoryPath(bundleDirectory))
+			total += requiredDiskSpace(bundleUpdateConfig.ModeChanges, "")
+		}
+	}
+	return total
 }
 
 func applyBundleUpdate(fileMap config.FileInfoMap, fromPath, toPath string) {
@@ -141,6 +235,17 @@ func applyBundleUpdate(fileMap config.FileInfoMap, fromPath, toPath string) {
 	log.Infof("Applying bundle update to folder \"%s\" took %v.", toPath, time.Since(startedAt))
 }
 
+// mustApplyFileModes gives the files under localDirPath the modes which fileMap declares for them.
+func mustApplyFileModes(fileMap config.FileInfoMap, localDirPath string) {
+	for filePath, fileInfo := range fileMap {
+		if mode, ok := fileInfo.FileMode(); ok {
+			if err := system.SetFileMode(filepath.Join(localDirPath, filePath), mode); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
 func deleteChangedFiles(fileMap config.FileInfoMap, localDirPath string) {
 	log.Infof("If existing, removing %d files from previous bundle and adding/upating %d files in \"%s\".", len(fileMap), uint64(len(fileMap))-fileMap.DeleteFileCount(), localDirPath)
 	for filePath := range fileMap {
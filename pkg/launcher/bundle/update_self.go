@@ -19,6 +19,10 @@ func (u *Updater) UpdateLauncherToLatestVersion() (madeChanges bool) {
 	if u.deploymentConfig.GetLauncherUpdateConfig() == nil {
 		return false
 	}
+	if u.isOffline {
+		log.Infof("Not checking for update of launcher while offline.")
+		return false
+	}
 	programPath := system.GetProgramPath()
 	log.Infof("Checking for update of launcher at \"%s\".", programPath)
 	return u.updateProgram(programPath)
@@ -26,8 +30,9 @@ func (u *Updater) UpdateLauncherToLatestVersion() (madeChanges bool) {
 
 func (u *Updater) updateProgram(programPath string) (madeChanges bool) {
 	log.Infof("Calculating local hashes.")
-	u.announceStatus(DetermineLocalLauncherVersion, 20)
-	presentState := hashing.MustHash(u.ctx, programPath)
+	u.resetHashingProgress()
+	u.announceStatus(DetermineLocalLauncherVersion, hashing.MustGetSize(programPath))
+	presentState := hashing.MustHashWithProgress(u.ctx, programPath, config.DefaultHashAlgorithm, u.addHashingProgress)
 
 	log.Infof("Checking against latest version.")
 	u.announceStatus(RetrieveRemoteLauncherVersion, 0)
@@ -38,41 +43,69 @@ func (u *Updater) updateProgram(programPath string) (madeChanges bool) {
 		return false
 	}
 
+	if hashAlgorithm := config.NormalizeHashAlgorithm(bundleInfo.HashAlgorithm); hashAlgorithm != config.DefaultHashAlgorithm {
+		log.Infof("Calculating local hashes again with %s, as the bundle info of the launcher does.", hashAlgorithm)
+		u.resetHashingProgress()
+		u.announceStatus(DetermineLocalLauncherVersion, hashing.MustGetSize(programPath))
+		presentState = hashing.MustHashWithProgress(u.ctx, programPath, hashAlgorithm, u.addHashingProgress)
+	}
+
 	remoteState := bundleInfo.GetFileHashes().ForOS()
-	wantedState := config.MakeDiffFileInfoMap(presentState.Prepend(remoteState.FirstPathElement(filepath.Separator), filepath.Separator), remoteState)
+	presentState = presentState.Prepend(remoteState.FirstPathElement(filepath.Separator), filepath.Separator)
+	wantedState := config.MakeDiffFileInfoMap(presentState, remoteState)
+	if modeChanges := config.MakeModeDiffFileInfoMap(presentState, remoteState); modeChanges.HasChanges() {
+		log.Infof("Changing the mode of %d files of the launcher at %q.", len(modeChanges), programPath)
+		mustApplyFileModes(modeChanges.StripFirstPathElement(filepath.Separator), programPath)
+	}
+
+	remoteSymlinks, remoteDirectories := stripFirstPathElements(bundleInfo)
+	symlinkChanges := config.MakeDiffSymlinkMap(hashing.MustListLinksAndDirectories(programPath).Symlinks, remoteSymlinks)
+	if len(symlinkChanges) > 0 {
+		log.Infof("Changing %d symlinks of the launcher at %q.", len(symlinkChanges), programPath)
+	}
 
 	if wantedState.HasChanges() {
 		log.WithFields(log.Fields{"updateConfig": fmt.Sprintf("%+v", updateConfig)}).
 			Infof("Launcher at %q is outdated. Updating from state %+v to %+v.", programPath, presentState, wantedState)
 		if system.IsDir(programPath) {
-			u.updateApplicationFolder(updateConfig, wantedState, programPath)
+			u.updateApplicationFolder(updateConfig, wantedState, symlinkChanges, programPath)
 		} else {
 			u.updateApplicationBinary(updateConfig, wantedState, programPath)
 		}
-		return true
+	} else if system.IsDir(programPath) {
+		mustRemoveChangedSymlinks(symlinkChanges, programPath)
 	}
-	return false
+	if system.IsDir(programPath) {
+		mustCreateSymlinksAndDirectories(symlinkChanges, remoteDirectories, programPath)
+	}
+	return wantedState.HasChanges()
 }
 
-func (u *Updater) updateApplicationFolder(updateConfig *config.LauncherUpdateConfig, wantedState config.FileInfoMap, programPath string) {
-	u.announceStatus(DownloadLauncherFiles, wantedState.UpdateByteCount())
+// updateApplicationFolder downloads the changed files of the launcher and moves them into programPath. Changed symlinks are
+// only removed once the download has succeeded, so that a failed update leaves them alone.
+func (u *Updater) updateApplicationFolder(updateConfig *config.LauncherUpdateConfig, wantedState config.FileInfoMap,
+	symlinkChanges map[string]string, programPath string) {
+	u.announceStatus(DownloadLauncherFiles, wantedState.DownloadByteCount())
+	mustHaveFreeDiskSpace(filepath.Dir(programPath), requiredDiskSpace(wantedState, ""))
 	tempPath := u.downloader.MustDownloadToTempDirectory(updateConfig.BaseURL, wantedState, programPath)
 	defer system.TryRemoveDirectory(tempPath)
+	mustRemoveChangedSymlinks(symlinkChanges, programPath)
 	firstPathElement := wantedState.FirstPathElement(filepath.Separator)
 	applyBundleUpdate(wantedState.StripFirstPathElement(filepath.Separator), filepath.Join(tempPath, firstPathElement), programPath)
 }
 
 func (u *Updater) updateApplicationBinary(updateConfig *config.LauncherUpdateConfig, wantedState config.FileInfoMap, programPath string) {
 	binaryName, newFileInfo := wantedState.MustGetOnly()
-	u.swapBinary(programPath, misc.MustJoinURL(updateConfig.BaseURL, binaryName), newFileInfo)
+	u.swapBinary(programPath, misc.MustJoinURL(updateConfig.BaseURL, newFileInfo.TransferPath(binaryName)), newFileInfo)
 }
 
 func (u *Updater) swapBinary(localBinaryPath string, remoteURL string, newFileInfo *config.FileInfo) {
-	u.announceStatus(DownloadLauncherFiles, uint64(newFileInfo.Size))
+	u.announceStatus(DownloadLauncherFiles, uint64(newFileInfo.DownloadSize()))
 
 	randomHex := misc.MustGetRandomHexString(8)
 	oldBinaryNewPath := filepath.Join(filepath.Dir(localBinaryPath), "~"+filepath.Base(localBinaryPath)+".old."+randomHex)
-	newBinaryTempPath := filepath.Join(filepath.Dir(localBinaryPath), "~"+filepath.Base(localBinaryPath)+".new."+randomHex)
+	newBinaryTempPath := filepath.Join(filepath.Dir(localBinaryPath), "~"+filepath.Base(localBinaryPath)+".new") // Stable, so that an interrupted download can be resumed.
+	mustHaveFreeDiskSpace(filepath.Dir(localBinaryPath), requiredDiskSpace(config.FileInfoMap{filepath.Base(newBinaryTempPath): newFileInfo}, filepath.Dir(localBinaryPath)))
 	err := u.downloader.DownloadFile(remoteURL, newFileInfo, newBinaryTempPath)
 	if err != nil {
 		panic(err)
@@ -3,8 +3,11 @@ package bundle
 import (
 	"context"
 	"crypto/rsa"
+	"net/http"
 	"runtime"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/setlog/trivrost/pkg/fetching"
 	"github.com/setlog/trivrost/pkg/launcher/config"
@@ -37,7 +40,17 @@ type Updater struct {
 
 	timestampFilePath string
 
-	statusCallback func(UpdaterStatus, uint64)
+	hashCacheFolderPath      string        // Empty unless EnableHashCache() has been called.
+	fullVerificationInterval time.Duration // As set by EnableHashCache().
+
+	bandwidthLimitOverride int64 // In bytes per second. Takes precedence over the limits in the deployment-config if > 0.
+
+	retryPolicy   fetching.RetryPolicy // As set by SetRetryPolicy(). Nil for the default.
+	offlineLaunch *offlineLaunch       // Nil unless EnableOfflineLaunch() has been called.
+	isOffline     bool                 // True if Prepare() fell back to the last verified deployment-config.
+
+	statusCallback  func(UpdaterStatus, uint64)
+	hashedByteCount uint64 // Accessed atomically. See GetHashingProgress().
 
 	ctx context.Context
 }
@@ -47,7 +60,9 @@ func NewUpdater(ctx context.Context, dlHandler fetching.DownloadProgressHandler,
 }
 
 func NewUpdaterWithDeploymentConfig(ctx context.Context, deploymentConfig *config.DeploymentConfig, dlHandler fetching.DownloadProgressHandler, publicKeys []*rsa.PublicKey) *Updater {
-	return &Updater{ctx: ctx, downloader: fetching.NewDownloader(ctx, dlHandler), publicKeys: publicKeys, deploymentConfig: deploymentConfig}
+	u := &Updater{ctx: ctx, downloader: fetching.NewDownloader(ctx, dlHandler), publicKeys: publicKeys, deploymentConfig: deploymentConfig}
+	u.configureDownloader()
+	return u
 }
 
 func (u *Updater) EnableTimestampVerification(filePath string) {
@@ -58,10 +73,108 @@ func (u *Updater) DisableTimestampVerification() {
 	u.timestampFilePath = ""
 }
 
+// EnableResourceCache makes the updater keep copies of the deployment-config and bundle info files in the folder at
+// folderPath and download them again only if they changed. Copies are verified against their signatures like downloads.
+func (u *Updater) EnableResourceCache(folderPath string) {
+	u.downloader.SetResourceCacheDirectory(folderPath)
+}
+
+// EnableHashCache makes the updater keep the hashes of the files of each bundle in the folder at folderPath, so that only
+// files whose size, modification time or file ID changed are hashed when determining the local bundle versions. All files
+// are hashed again once fullVerificationInterval has passed since they have last been, or always if it is <= 0.
+func (u *Updater) EnableHashCache(folderPath string, fullVerificationInterval time.Duration) {
+	u.hashCacheFolderPath, u.fullVerificationInterval = folderPath, fullVerificationInterval
+}
+
+// SetBandwidthLimitOverride limits downloads to the given number of bytes per second regardless of the limits
+// configured in the deployment-config. A value <= 0 restores the limits of the deployment-config.
+func (u *Updater) SetBandwidthLimitOverride(bytesPerSecond int64) {
+	u.bandwidthLimitOverride = bytesPerSecond
+	u.applyBandwidthLimit(0)
+}
+
+// GetBandwidthLimit returns the bandwidth limit in bytes per second which currently applies to downloads and whether
+// downloads have recently been slowed down to adhere to it.
+func (u *Updater) GetBandwidthLimit() (bytesPerSecond int64, isThrottling bool) {
+	return u.downloader.BandwidthLimit()
+}
+
+// applyBandwidthLimit limits the downloader to the override limit, if set, or else to bundleLimitKiB, if not 0, or else
+// to the global limit of the deployment-config.
+func (u *Updater) applyBandwidthLimit(bundleLimitKiB int) {
+	bytesPerSecond := u.bandwidthLimitOverride
+	if bytesPerSecond <= 0 {
+		limitKiB := bundleLimitKiB
+		if limitKiB == 0 && u.deploymentConfig != nil {
+			limitKiB = u.deploymentConfig.BandwidthLimit
+		}
+		bytesPerSecond = int64(limitKiB) * 1024
+	}
+	u.downloader.SetBandwidthLimit(bytesPerSecond)
+}
+
+// SetTLSConfig adds the certificate authorities and client certificates of tlsConfig to downloads.
+func (u *Updater) SetTLSConfig(tlsConfig *config.TLSConfig) error {
+	return u.downloader.SetTLSConfig(tlsConfig)
+}
+
+// SetProxyRouter makes downloads connect along the routes of router.
+func (u *Updater) SetProxyRouter(router *fetching.ProxyRouter) error {
+	return u.downloader.SetProxyRouter(router)
+}
+
+// SetCredentials makes downloads authenticate to the hosts in credentials.
+func (u *Updater) SetCredentials(credentials map[string]*config.CredentialsConfig) error {
+	return u.downloader.SetCredentials(credentials)
+}
+
+// SetAccessTokenSource makes downloads from hosts authenticate with the bearer tokens of source.
+func (u *Updater) SetAccessTokenSource(hosts []string, source fetching.AccessTokenSource) {
+	u.downloader.SetAccessTokenSource(hosts, source)
+}
+
+// ClientWithoutCredentials returns a client with the TLS settings and public key pins of downloads, but without credentials.
+func (u *Updater) ClientWithoutCredentials() *http.Client {
+	return u.downloader.ClientWithoutCredentials()
+}
+
+// SetPublicKeyPins makes downloads from the hosts in pins fail unless they present one of the pinned public keys.
+// See fetching.Downloader.SetPublicKeyPins().
+func (u *Updater) SetPublicKeyPins(pins map[string][]string) error {
+	return u.downloader.SetPublicKeyPins(pins)
+}
+
+// SetLocalSource makes downloads take all resources from source instead. It must be called after all other settings
+// of downloads. See fetching.Downloader.SetLocalSource().
+func (u *Updater) SetLocalSource(source *fetching.LocalSource) {
+	u.downloader.SetLocalSource(source)
+}
+
+// SetRetryPolicy sets how downloads retry failed requests. By default, they retry temporary failures forever.
+func (u *Updater) SetRetryPolicy(policy fetching.RetryPolicy) {
+	u.retryPolicy = policy
+	u.downloader.SetRetryPolicy(policy)
+}
+
 func (u *Updater) SetStatusCallback(statusCallback func(UpdaterStatus, uint64)) {
 	u.statusCallback = statusCallback
 }
 
+// GetHashingProgress returns the number of bytes which have been hashed, or whose hashes have been looked up, since the
+// current DetermineLocalLauncherVersion or DetermineLocalBundleVersions status has been announced. Its progress target
+// is the total number of bytes to hash.
+func (u *Updater) GetHashingProgress() uint64 {
+	return atomic.LoadUint64(&u.hashedByteCount)
+}
+
+func (u *Updater) resetHashingProgress() {
+	atomic.StoreUint64(&u.hashedByteCount, 0)
+}
+
+func (u *Updater) addHashingProgress(byteCount uint64) {
+	atomic.AddUint64(&u.hashedByteCount, byteCount)
+}
+
 func (u *Updater) announceStatus(status UpdaterStatus, progressTarget uint64) {
 	if u.statusCallback != nil {
 		u.statusCallback(status, progressTarget)
@@ -70,9 +183,9 @@ func (u *Updater) announceStatus(status UpdaterStatus, progressTarget uint64) {
 
 func (u *Updater) Prepare(deploymentConfigURL string) {
 	log.Infof("Downloading deployment config from \"%s\".", deploymentConfigURL)
-	data, err := u.downloader.DownloadSignedResource(deploymentConfigURL, u.publicKeys)
+	data, err := u.downloadDeploymentConfig(deploymentConfigURL)
 	if err != nil {
-		panic(err)
+		data = u.mustLoadDeploymentConfigForOfflineLaunch(deploymentConfigURL, err)
 	}
 
 	deploymentConfig := config.ParseDeploymentConfig(strings.NewReader(string(data)), runtime.GOOS, system.GetOSArch())
@@ -80,6 +193,39 @@ func (u *Updater) Prepare(deploymentConfigURL string) {
 		timestamps.VerifyDeploymentConfigTimestamp(deploymentConfig.Timestamp, u.timestampFilePath)
 	}
 	u.deploymentConfig = deploymentConfig
+	u.configureDownloader()
+}
+
+// configureDownloader applies the download settings of the deployment-config to the downloader.
+func (u *Updater) configureDownloader() {
+	u.addMirrors()
+	u.applyBandwidthLimit(0)
+	u.downloader.SetConcurrencyBounds(u.deploymentConfig.MinConcurrentDownloads, u.deploymentConfig.MaxConcurrentDownloads)
+}
+
+// addMirrors makes the downloader fail over to the mirrors of the bundle info files and bundles in the deployment-config.
+// Whichever mirror they come from, bundle info files are verified against their signature and bundle files against their bundle info.
+func (u *Updater) addMirrors() {
+	hashDataConfigs := []config.HashDataConfig{}
+	if launcherUpdateConfig := u.deploymentConfig.GetLauncherUpdateConfig(); launcherUpdateConfig != nil {
+		hashDataConfigs = append(hashDataConfigs, launcherUpdateConfig.HashDataConfig)
+	}
+	for _, bundleConfig := range u.deploymentConfig.Bundles {
+		hashDataConfigs = append(hashDataConfigs, bundleConfig.HashDataConfig)
+	}
+	for _, hashDataConfig := range hashDataConfigs {
+		if len(hashDataConfig.Mirrors) == 0 {
+			continue
+		}
+		bundleInfoMirrors := []fetching.Mirror{{URL: hashDataConfig.BundleInfoURL, Weight: hashDataConfig.Weight}}
+		baseMirrors := []fetching.Mirror{{URL: hashDataConfig.BaseURL, Weight: hashDataConfig.Weight}}
+		for _, mirror := range hashDataConfig.Mirrors {
+			bundleInfoMirrors = append(bundleInfoMirrors, fetching.Mirror{URL: mirror.BundleInfoURL, Weight: mirror.Weight})
+			baseMirrors = append(baseMirrors, fetching.Mirror{URL: mirror.BaseURL, Weight: mirror.Weight})
+		}
+		u.downloader.AddMirrors(bundleInfoMirrors)
+		u.downloader.AddMirrors(baseMirrors)
+	}
 }
 
 func (u *Updater) GetDeploymentConfig() *config.DeploymentConfig {
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/setlog/trivrost/pkg/misc"
@@ -15,23 +17,172 @@ import (
 type BundleInfo struct {
 	Timestamp        string `json:"Timestamp"`
 	UniqueBundleName string `json:"UniqueBundleName"`
+	HashAlgorithm    string `json:"HashAlgorithm,omitempty"` // The algorithm of the hashes of BundleFiles and their patches. DefaultHashAlgorithm if empty.
 
-	BundleFiles FileInfoMap `json:"BundleFiles"` // Within BundleInfo, keys are filepaths with forward slashes.
+	BundleFiles FileInfoMap       `json:"BundleFiles"` // Within BundleInfo, keys are filepaths with forward slashes.
+	Packs       PackInfoMap       `json:"Packs,omitempty"`
+	Symlinks    map[string]string `json:"Symlinks,omitempty"`    // Keys are paths of symbolic links, values their targets relative to the link's directory.
+	Directories []string          `json:"Directories,omitempty"` // Paths of directories which have to exist even though no file is located in them.
 }
 
 type FileInfoMap map[string]*FileInfo
 
 type FileInfo struct {
-	SHA256 string `json:"SHA256"`
-	Size   int64  `json:"Size"`
+	Hash           string               `json:"-"` // Encoded as "SHA256" or "Hash"; see fileInfoJSON.
+	HashAlgorithm  string               `json:"-"` // Set when reading a BundleInfo, by its GetFileHashes() and by hashing, so that the file can be verified on its own.
+	Size           int64                `json:"Size"`
+	Mode           string               `json:"Mode,omitempty"`           // If set, the octal permission bits of the file, e.g. "0755". See FileMode().
+	Compression    string               `json:"Compression,omitempty"`    // If set, the file is transferred compressed with this algorithm. Hash and Size describe the decompressed file.
+	CompressedSize int64                `json:"CompressedSize,omitempty"` // The size of the compressed file, if Compression is set.
+	Patches        map[string]*FileInfo `json:"Patches,omitempty"`        // Keys are the hashes of files which the patch described by the value turns into this file.
+	ChunkSize      int64                `json:"ChunkSize,omitempty"`      // If set, ChunkSHA256s lists the SHA-256 hashes of consecutive chunks of the file of this size.
+	ChunkSHA256s   []string             `json:"ChunkSHA256s,omitempty"`   // The last chunk may be shorter than ChunkSize.
+}
+
+// fileInfoJSON is the JSON encoding of FileInfo. Bundle infos which predate BundleInfo.HashAlgorithm name the hash
+// "SHA256", which SHA-256 hashes keep, so that older launchers can still read them. Hashes of other algorithms are named
+// "Hash" instead. Both names are read for all algorithms.
+type fileInfoJSON struct {
+	SHA256 *string `json:"SHA256,omitempty"`
+	Hash   *string `json:"Hash,omitempty"`
+	*fileInfoFields
+}
+
+type fileInfoFields FileInfo // Has the fields of FileInfo, but not its methods, so that encoding it does not recurse.
+
+func (info FileInfo) MarshalJSON() ([]byte, error) {
+	encoded := fileInfoJSON{fileInfoFields: (*fileInfoFields)(&info)}
+	if NormalizeHashAlgorithm(info.HashAlgorithm) == HashAlgorithmSHA256 {
+		encoded.SHA256 = &info.Hash
+	} else {
+		encoded.Hash = &info.Hash
+	}
+	return json.Marshal(encoded)
+}
+
+func (info *FileInfo) UnmarshalJSON(data []byte) error {
+	encoded := fileInfoJSON{fileInfoFields: (*fileInfoFields)(info)}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	if encoded.Hash != nil {
+		info.Hash = *encoded.Hash
+	} else if encoded.SHA256 != nil {
+		info.Hash = *encoded.SHA256
+	}
+	return nil
+}
+
+// MarshalJSON encodes a BundleInfo with the hashes of its files and patches named for its HashAlgorithm.
+func (info BundleInfo) MarshalJSON() ([]byte, error) {
+	type bundleInfoFields BundleInfo
+	info.BundleFiles = info.filesWithHashAlgorithm()
+	return json.Marshal(bundleInfoFields(info))
+}
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var compressedFileExtensions = map[string]string{
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+}
+
+// CompressedFileExtension returns the extension which is appended to the path of files compressed with the given algorithm,
+// or "" if the algorithm is not supported.
+func CompressedFileExtension(compression string) string {
+	return compressedFileExtensions[compression]
+}
+
+// TransferPath returns the path under which the file at filePath, as described by info, is found on the remote.
+func (info *FileInfo) TransferPath(filePath string) string {
+	return filePath + CompressedFileExtension(info.Compression)
+}
+
+// DownloadSize returns the amount of bytes which have to be downloaded to obtain the file described by info.
+func (info *FileInfo) DownloadSize() int64 {
+	if info.Compression != "" {
+		return info.CompressedSize
+	}
+	return info.Size
+}
+
+// ChunkCount returns the number of chunks of the given size the file described by info consists of.
+func (info *FileInfo) ChunkCount(chunkSize int64) int {
+	return int((info.Size + chunkSize - 1) / chunkSize)
+}
+
+// ChunkSHA256 returns the SHA-256 hash of the chunk of the file described by info with the given index and size,
+// or "" if info does not list hashes for chunks of that size.
+func (info *FileInfo) ChunkSHA256(index int, chunkSize int64) string {
+	if info.ChunkSize != chunkSize || index >= len(info.ChunkSHA256s) {
+		return ""
+	}
+	return info.ChunkSHA256s[index]
+}
+
+// FormatFileMode returns the permission bits of mode in the format of FileInfo.Mode.
+func FormatFileMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// FileMode returns the permission bits which info declares the file to have, or false if it does not declare any.
+func (info *FileInfo) FileMode() (mode os.FileMode, ok bool) {
+	if info.Mode == "" {
+		return 0, false
+	}
+	perm, err := strconv.ParseUint(info.Mode, 8, 32)
+	if err != nil || perm > uint64(os.ModePerm) {
+		return 0, false
+	}
+	return os.FileMode(perm), true
+}
+
+// PatchDirectoryName is the name of the directory next to the files of a bundle which contains the patches between its versions.
+const PatchDirectoryName = ".patches"
+
+// PatchFilePath returns the forward-slashed path, relative to the bundle's base URL, of the patch from
+// the file with hash fromHash to the file with hash toHash.
+func PatchFilePath(fromHash, toHash string) string {
+	return PatchDirectoryName + "/" + strings.ToLower(fromHash) + "-" + strings.ToLower(toHash) + ".patch"
+}
+
+// PatchFrom returns information on the patch which turns the file with hash fromHash into the
+// file described by info, or nil if there is no such patch.
+func (info *FileInfo) PatchFrom(fromHash string) *FileInfo {
+	for patchFromHash, patchInfo := range info.Patches {
+		if strings.EqualFold(patchFromHash, fromHash) {
+			return patchInfo
+		}
+	}
+	return nil
 }
 
 // GetFileHashes returns a FileInfoMap for the info's BundleFiles using filepath.Separator in the place of forward slashes.
+// The FileInfos and their patches carry the info's normalized HashAlgorithm.
 func (info *BundleInfo) GetFileHashes() FileInfoMap {
+	return info.filesWithHashAlgorithm().ForOS()
+}
+
+// filesWithHashAlgorithm returns a copy of the info's BundleFiles whose FileInfos and patches carry the info's normalized
+// HashAlgorithm, leaving the BundleFiles untouched.
+func (info *BundleInfo) filesWithHashAlgorithm() FileInfoMap {
+	hashAlgorithm := NormalizeHashAlgorithm(info.HashAlgorithm)
 	fm := NewFileInfoMap()
 	for filePath, fileInfo := range info.BundleFiles {
 		genericFileInfo := *fileInfo
-		fm[filepath.FromSlash(filePath)] = &genericFileInfo
+		genericFileInfo.HashAlgorithm = hashAlgorithm
+		if len(fileInfo.Patches) > 0 {
+			genericFileInfo.Patches = make(map[string]*FileInfo, len(fileInfo.Patches))
+			for fromHash, patchInfo := range fileInfo.Patches {
+				genericPatchInfo := *patchInfo
+				genericPatchInfo.HashAlgorithm = hashAlgorithm
+				genericFileInfo.Patches[fromHash] = &genericPatchInfo
+			}
+		}
+		fm[filePath] = &genericFileInfo
 	}
 	return fm
 }
@@ -50,7 +201,7 @@ func (bundleFiles FileInfoMap) ForOS() FileInfoMap {
 func (bundleFiles FileInfoMap) OmitEntriesWithMissingSha() FileInfoMap {
 	newBundleFiles := make(FileInfoMap)
 	for filePath, fileInfo := range bundleFiles {
-		if fileInfo.SHA256 != "" {
+		if fileInfo.Hash != "" {
 			osFileInfo := *fileInfo
 			newBundleFiles[filepath.ToSlash(filePath)] = &osFileInfo
 		}
@@ -142,32 +293,105 @@ func ReadInfoFromByteSlice(data []byte) *BundleInfo {
 	if err != nil {
 		panic(err)
 	}
-	validateBundleInfoPaths(info.BundleFiles)
+	info.MustValidate()
 	return &info
 }
 
+// MustValidate panics if info is not a valid bundle info.
+func (info *BundleInfo) MustValidate() {
+	validateBundleInfoHashAlgorithm(info.HashAlgorithm)
+	validateBundleInfoPaths(info.BundleFiles)
+	validateBundleInfoPatches(info.BundleFiles, info.HashAlgorithm)
+	validateBundleInfoCompression(info.BundleFiles)
+	validateBundleInfoModes(info.BundleFiles)
+	validateBundleInfoChunks(info.BundleFiles)
+	validateBundleInfoPacks(info.Packs, info.BundleFiles)
+	validateBundleInfoSymlinks(info.Symlinks)
+	validateBundleInfoDirectories(info.Directories)
+	validateBundleInfoEntryConflicts(info.BundleFiles, info.Symlinks, info.Directories)
+}
+
+func validateBundleInfoHashAlgorithm(hashAlgorithm string) {
+	if !IsSupportedHashAlgorithm(hashAlgorithm) {
+		panic(fmt.Sprintf("Bundle info uses unsupported hash algorithm %q", hashAlgorithm))
+	}
+}
+
 func validateBundleInfoPaths(bundleFiles FileInfoMap) {
 	for filePath := range bundleFiles {
-		if filePath == "" {
-			panic("Bundle info contains an empty file path")
+		validateBundleInfoPath(filePath)
+	}
+}
+
+func validateBundleInfoPath(filePath string) {
+	if filePath == "" {
+		panic("Bundle info contains an empty file path")
+	}
+	if strings.HasPrefix(filePath, "/") {
+		panic(fmt.Sprintf("Bundle info file path %q must not be absolute", filePath))
+	}
+	if strings.Contains(filePath, `\`) {
+		panic(fmt.Sprintf("Bundle info file path %q must use forward slashes", filePath))
+	}
+
+	cleanPath := path.Clean(filePath)
+	if cleanPath == "." || cleanPath == ".." || strings.HasPrefix(cleanPath, "../") {
+		panic(fmt.Sprintf("Bundle info file path %q escapes the bundle directory", filePath))
+	}
+	if cleanPath != filePath {
+		panic(fmt.Sprintf("Bundle info file path %q must be clean and normalized; use %q instead", filePath, cleanPath))
+	}
+}
+
+func validateBundleInfoPatches(bundleFiles FileInfoMap, hashAlgorithm string) {
+	for filePath, fileInfo := range bundleFiles {
+		for fromHash, patchInfo := range fileInfo.Patches {
+			if !isHash(fromHash, hashAlgorithm) || patchInfo == nil || !isHash(patchInfo.Hash, hashAlgorithm) || len(patchInfo.Patches) != 0 {
+				panic(fmt.Sprintf("Bundle info file %q lists invalid patch %q", filePath, fromHash))
+			}
 		}
-		if strings.HasPrefix(filePath, "/") {
-			panic(fmt.Sprintf("Bundle info file path %q must not be absolute", filePath))
+	}
+}
+
+func validateBundleInfoCompression(bundleFiles FileInfoMap) {
+	for filePath, fileInfo := range bundleFiles {
+		if fileInfo.Compression != "" && CompressedFileExtension(fileInfo.Compression) == "" {
+			panic(fmt.Sprintf("Bundle info file %q uses unsupported compression %q", filePath, fileInfo.Compression))
 		}
-		if strings.Contains(filePath, `\`) {
-			panic(fmt.Sprintf("Bundle info file path %q must use forward slashes", filePath))
+	}
+}
+
+func validateBundleInfoModes(bundleFiles FileInfoMap) {
+	for filePath, fileInfo := range bundleFiles {
+		if fileInfo.Mode == "" {
+			continue
+		}
+		if mode, ok := fileInfo.FileMode(); !ok || mode&0400 == 0 {
+			panic(fmt.Sprintf("Bundle info file %q declares invalid mode %q; it must consist of octal permission bits which let the owner read the file", filePath, fileInfo.Mode))
 		}
+	}
+}
 
-		cleanPath := path.Clean(filePath)
-		if cleanPath == "." || cleanPath == ".." || strings.HasPrefix(cleanPath, "../") {
-			panic(fmt.Sprintf("Bundle info file path %q escapes the bundle directory", filePath))
+func validateBundleInfoChunks(bundleFiles FileInfoMap) {
+	for filePath, fileInfo := range bundleFiles {
+		if fileInfo.ChunkSize == 0 && len(fileInfo.ChunkSHA256s) == 0 {
+			continue
+		}
+		if fileInfo.ChunkSize <= 0 || len(fileInfo.ChunkSHA256s) != fileInfo.ChunkCount(fileInfo.ChunkSize) {
+			panic(fmt.Sprintf("Bundle info file %q lists %d chunk hashes for chunks of %d bytes", filePath, len(fileInfo.ChunkSHA256s), fileInfo.ChunkSize))
 		}
-		if cleanPath != filePath {
-			panic(fmt.Sprintf("Bundle info file path %q must be clean and normalized; use %q instead", filePath, cleanPath))
+		for _, chunkSHA256 := range fileInfo.ChunkSHA256s {
+			if !isSHA256(chunkSHA256) {
+				panic(fmt.Sprintf("Bundle info file %q lists invalid chunk hash %q", filePath, chunkSHA256))
+			}
 		}
 	}
 }
 
+func isSHA256(s string) bool {
+	return isHash(s, HashAlgorithmSHA256)
+}
+
 func WriteInfo(info *BundleInfo, filePath string) {
 	data, err := json.Marshal(info)
 	if err != nil {
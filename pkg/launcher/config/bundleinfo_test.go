@@ -1,6 +1,7 @@
 package config_test
 
 import (
+	"encoding/json"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -31,7 +32,7 @@ func TestReadBundleInfoPreservesForwardSlashPaths(t *testing.T) {
 func TestGetFileHashesReturnsOSPaths(t *testing.T) {
 	info := &config.BundleInfo{
 		BundleFiles: config.FileInfoMap{
-			"app/bin/run": {SHA256: "abc", Size: 1},
+			"app/bin/run": {Hash: "abc", Size: 1},
 		},
 	}
 
@@ -74,3 +75,167 @@ func TestReadBundleInfoRejectsUnsafePaths(t *testing.T) {
 		})
 	}
 }
+
+func TestReadBundleInfoRejectsUnsupportedCompression(t *testing.T) {
+	reader := strings.NewReader(`{
+		"Timestamp": "2019-02-07 14:53:17",
+		"UniqueBundleName": "bundle",
+		"BundleFiles": {
+			"top.txt": { "SHA256": "abc", "Size": 2, "Compression": "rar", "CompressedSize": 1 }
+		}
+	}`)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for unsupported compression")
+		}
+	}()
+
+	config.ReadInfoFromReader(reader)
+}
+
+func TestTransferPathAppendsCompressedFileExtension(t *testing.T) {
+	if path := (&config.FileInfo{Compression: config.CompressionZstd}).TransferPath("app/lib.so"); path != "app/lib.so.zst" {
+		t.Fatalf("expected \"app/lib.so.zst\", got %q", path)
+	}
+	if path := (&config.FileInfo{}).TransferPath("app/lib.so"); path != "app/lib.so" {
+		t.Fatalf("expected \"app/lib.so\", got %q", path)
+	}
+}
+
+func TestReadBundleInfoRejectsIncompleteChunkHashes(t *testing.T) {
+	reader := strings.NewReader(`{
+		"Timestamp": "2019-02-07 14:53:17",
+		"UniqueBundleName": "bundle",
+		"BundleFiles": {
+			"top.txt": { "SHA256": "abc", "Size": 5, "ChunkSize": 2,
+				"ChunkSHA256s": [ "0000000000000000000000000000000000000000000000000000000000000000", "0000000000000000000000000000000000000000000000000000000000000000" ] }
+		}
+	}`)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for missing chunk hash")
+		}
+	}()
+
+	config.ReadInfoFromReader(reader)
+}
+
+func TestReadBundleInfoRejectsUnsupportedHashAlgorithm(t *testing.T) {
+	reader := strings.NewReader(`{
+		"Timestamp": "2019-02-07 14:53:17",
+		"UniqueBundleName": "bundle",
+		"HashAlgorithm": "MD5",
+		"BundleFiles": {
+			"top.txt": { "SHA256": "abc", "Size": 2 }
+		}
+	}`)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for unsupported hash algorithm")
+		}
+	}()
+
+	config.ReadInfoFromReader(reader)
+}
+
+func TestReadBundleInfoValidatesPatchesWithHashAlgorithm(t *testing.T) {
+	sha256Hex, sha512Hex := strings.Repeat("ab", 32), strings.Repeat("ab", 64)
+	bundleInfoWithPatch := func(hashAlgorithm, patchHash string) *strings.Reader {
+		return strings.NewReader(`{
+			"Timestamp": "2019-02-07 14:53:17",
+			"UniqueBundleName": "bundle",
+			"HashAlgorithm": "` + hashAlgorithm + `",
+			"BundleFiles": {
+				"top.txt": { "SHA256": "` + patchHash + `", "Size": 2, "Patches": { "` + patchHash + `": { "SHA256": "` + patchHash + `", "Size": 1 } } }
+			}
+		}`)
+	}
+
+	info := config.ReadInfoFromReader(bundleInfoWithPatch(config.HashAlgorithmSHA512, sha512Hex))
+	fileInfo := info.GetFileHashes()["top.txt"]
+	if fileInfo.HashAlgorithm != config.HashAlgorithmSHA512 || fileInfo.PatchFrom(sha512Hex).HashAlgorithm != config.HashAlgorithmSHA512 {
+		t.Fatalf("expected file and patch to carry the hash algorithm of the bundle info")
+	}
+	if info.BundleFiles["top.txt"].PatchFrom(sha512Hex).HashAlgorithm != "" {
+		t.Fatalf("expected GetFileHashes() to leave the patches of the bundle info untouched")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for patch with SHA-256 hashes in SHA-512 bundle info")
+		}
+	}()
+	config.ReadInfoFromReader(bundleInfoWithPatch(config.HashAlgorithmSHA512, sha256Hex))
+}
+
+func TestBundleInfoNamesHashesForHashAlgorithm(t *testing.T) {
+	sha256Hex, blake3Hex := strings.Repeat("ab", 32), strings.Repeat("cd", 32)
+	for hashAlgorithm, expectedKey := range map[string]string{"": `"SHA256":`, config.HashAlgorithmBLAKE3: `"Hash":`} {
+		hash := sha256Hex
+		if hashAlgorithm != "" {
+			hash = blake3Hex
+		}
+		info := &config.BundleInfo{Timestamp: "2019-02-07 14:53:17", UniqueBundleName: "bundle", HashAlgorithm: hashAlgorithm, BundleFiles: config.FileInfoMap{
+			"top.txt": {Hash: hash, Size: 2, Patches: map[string]*config.FileInfo{hash: {Hash: hash, Size: 1}}},
+		}}
+		data, err := json.Marshal(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(data), expectedKey) != 2 || strings.Count(string(data), `"SHA256":`)+strings.Count(string(data), `"Hash":`) != 2 {
+			t.Errorf("Expected the hashes of the file and its patch to be named %s with hash algorithm %q. Got %s", expectedKey, hashAlgorithm, data)
+		}
+		fileInfo := config.ReadInfoFromByteSlice(data).BundleFiles["top.txt"]
+		if fileInfo.Hash != hash || fileInfo.PatchFrom(hash) == nil || fileInfo.PatchFrom(hash).Hash != hash {
+			t.Errorf("Expected the hashes of the file and its patch to be read back with hash algorithm %q. Got %s", hashAlgorithm, data)
+		}
+	}
+}
+
+func TestReadBundleInfoReadsSHA256NameForAllHashAlgorithms(t *testing.T) {
+	blake3Hex := strings.Repeat("cd", 32)
+	info := config.ReadInfoFromReader(strings.NewReader(`{
+		"Timestamp": "2019-02-07 14:53:17",
+		"UniqueBundleName": "bundle",
+		"HashAlgorithm": "BLAKE3",
+		"BundleFiles": { "top.txt": { "SHA256": "` + blake3Hex + `", "Size": 2 } }
+	}`))
+	if hash := info.BundleFiles["top.txt"].Hash; hash != blake3Hex {
+		t.Errorf("Expected hash %s. Got %q", blake3Hex, hash)
+	}
+}
+
+func TestReadBundleInfoRejectsInvalidModes(t *testing.T) {
+	for _, mode := range []string{"rwxr-xr-x", "1755", "0055"} {
+		func() {
+			reader := strings.NewReader(`{
+				"Timestamp": "2019-02-07 14:53:17",
+				"UniqueBundleName": "bundle",
+				"BundleFiles": {
+					"top.txt": { "SHA256": "abc", "Size": 2, "Mode": "` + mode + `" }
+				}
+			}`)
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for mode %q", mode)
+				}
+			}()
+			config.ReadInfoFromReader(reader)
+		}()
+	}
+}
+
+func TestFileModeParsesOctalPermissions(t *testing.T) {
+	if mode, ok := (&config.FileInfo{Mode: "0755"}).FileMode(); !ok || mode != 0755 {
+		t.Fatalf("expected mode 0755, got %o", mode)
+	}
+	if _, ok := (&config.FileInfo{}).FileMode(); ok {
+		t.Fatalf("expected no mode for empty Mode")
+	}
+	if mode := config.FormatFileMode(0644); mode != "0644" {
+		t.Fatalf("expected \"0644\", got %q", mode)
+	}
+}
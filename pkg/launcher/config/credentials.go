@@ -0,0 +1,87 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CredentialsConfig authenticates requests to a host with either basic authentication or a bearer token.
+type CredentialsConfig struct {
+	Username string `json:"Username,omitempty"`
+	Password string `json:"Password,omitempty"`
+
+	BearerToken     string `json:"BearerToken,omitempty"`
+	BearerTokenFile string `json:"BearerTokenFile,omitempty"` // Path of a file containing the bearer token.
+	BearerTokenEnv  string `json:"BearerTokenEnv,omitempty"`  // Name of an environment variable containing the bearer token.
+}
+
+// Token returns the bearer token of the credentials, reading it from a file or the environment if configured so.
+// It returns an empty string if the credentials do not use a bearer token.
+func (credentials *CredentialsConfig) Token() (string, error) {
+	if credentials.BearerTokenFile != "" {
+		data, err := ioutil.ReadFile(credentials.BearerTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read bearer token: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if credentials.BearerTokenEnv != "" {
+		token, ok := os.LookupEnv(credentials.BearerTokenEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s with bearer token is not set", credentials.BearerTokenEnv)
+		}
+		return strings.TrimSpace(token), nil
+	}
+	return credentials.BearerToken, nil
+}
+
+// Validate returns an error unless the credentials use exactly one way of authentication.
+func (credentials *CredentialsConfig) Validate() error {
+	methodCount := 0
+	for _, isUsed := range []bool{credentials.Username != "", credentials.BearerToken != "", credentials.BearerTokenFile != "", credentials.BearerTokenEnv != ""} {
+		if isUsed {
+			methodCount++
+		}
+	}
+	if methodCount != 1 {
+		return fmt.Errorf("exactly one of Username, BearerToken, BearerTokenFile and BearerTokenEnv must be set")
+	}
+	if credentials.Password != "" && credentials.Username == "" {
+		return fmt.Errorf("Password is set without Username")
+	}
+	return nil
+}
+
+// ReadCredentials reads a map of host names to credentials from the JSON-file at filePath. Relative paths of token files
+// are resolved relative to the directory of the file.
+func ReadCredentials(filePath string) (map[string]*CredentialsConfig, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make(map[string]*CredentialsConfig)
+	if err = json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("could not parse \"%s\": %w", filePath, err)
+	}
+	for _, hostCredentials := range credentials {
+		if hostCredentials != nil && hostCredentials.BearerTokenFile != "" && !filepath.IsAbs(hostCredentials.BearerTokenFile) {
+			hostCredentials.BearerTokenFile = filepath.Join(filepath.Dir(filePath), hostCredentials.BearerTokenFile)
+		}
+	}
+	return credentials, nil
+}
+
+// MergeCredentials returns the credentials of both maps, preferring those of override for the same host. Host names are lower-cased.
+func MergeCredentials(credentials, override map[string]*CredentialsConfig) map[string]*CredentialsConfig {
+	merged := make(map[string]*CredentialsConfig)
+	for _, m := range []map[string]*CredentialsConfig{credentials, override} {
+		for host, hostCredentials := range m {
+			merged[strings.ToLower(host)] = hostCredentials
+		}
+	}
+	return merged
+}
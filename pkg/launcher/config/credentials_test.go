@@ -0,0 +1,45 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func TestReadCredentialsResolvesRelativeTokenFiles(t *testing.T) {
+	d, err := ioutil.TempDir("", "trivrost-credentials-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	content := `{"a.example.com": {"BearerTokenFile": "token.txt"}, "b.example.com": {"Username": "user", "Password": "secret"}}`
+	filePath := filepath.Join(d, "credentials.json")
+	if err = ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(d, "token.txt"), []byte("token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := config.ReadCredentials(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := credentials["a.example.com"].Token(); err != nil || token != "token" {
+		t.Errorf("Expected token \"token\". Got %q, %v", token, err)
+	}
+	if err = credentials["b.example.com"].Validate(); err != nil {
+		t.Errorf("Expected valid basic auth credentials: %v", err)
+	}
+}
+
+func TestMergeCredentials(t *testing.T) {
+	compiledIn := map[string]*config.CredentialsConfig{"a.example.com": {BearerToken: "a"}, "b.example.com": {BearerToken: "b"}}
+	local := map[string]*config.CredentialsConfig{"B.example.com": {BearerToken: "local"}}
+	merged := config.MergeCredentials(compiledIn, local)
+	if len(merged) != 2 || merged["a.example.com"].BearerToken != "a" || merged["b.example.com"].BearerToken != "local" {
+		t.Errorf("Expected local credentials to override compiled-in ones. Got %+v", merged)
+	}
+}
@@ -15,12 +15,26 @@ type DeploymentConfig struct {
 	LauncherUpdate []LauncherUpdateConfig `json:"LauncherUpdate,omitempty"`
 	Bundles        []BundleConfig         `json:"Bundles,omitempty"`
 	Execution      ExecutionConfig        `json:"Execution,omitempty"`
+	BandwidthLimit int                    `json:"BandwidthLimit,omitempty"` // In KiB per second. No limit if 0.
+
+	// Bounds of the number of concurrent downloads, which adapts to throughput and errors. Defaults are used if 0.
+	MinConcurrentDownloads int `json:"MinConcurrentDownloads,omitempty"`
+	MaxConcurrentDownloads int `json:"MaxConcurrentDownloads,omitempty"`
 }
 
 type HashDataConfig struct {
-	BundleInfoURL     string `json:"BundleInfoURL"`
-	BaseURL           string `json:"BaseURL,omitempty"`
-	IsUpdateMandatory bool   `json:"IsUpdateMandatory,omitempty"`
+	BundleInfoURL     string         `json:"BundleInfoURL"`
+	BaseURL           string         `json:"BaseURL,omitempty"`
+	IsUpdateMandatory bool           `json:"IsUpdateMandatory,omitempty"`
+	Mirrors           []MirrorConfig `json:"Mirrors,omitempty"`
+	Weight            int            `json:"Weight,omitempty"` // Weight of BundleInfoURL and BaseURL relative to Mirrors.
+}
+
+// MirrorConfig describes URLs which serve the same bundle info file and bundle files as the HashDataConfig containing it.
+type MirrorConfig struct {
+	BundleInfoURL string `json:"BundleInfoURL"`
+	BaseURL       string `json:"BaseURL,omitempty"`
+	Weight        int    `json:"Weight,omitempty"`
 }
 
 type LauncherUpdateConfig struct {
@@ -33,6 +47,8 @@ type BundleConfig struct {
 	LocalDirectory  string   `json:"LocalDirectory"`
 	TargetPlatforms []string `json:"TargetPlatforms,omitempty"`
 	Tags            []string `json:"Tags,omitempty"`
+	BandwidthLimit  int      `json:"BandwidthLimit,omitempty"` // Overrides DeploymentConfig.BandwidthLimit for this bundle if not 0.
+	RequiresOnline  bool     `json:"RequiresOnline,omitempty"` // Forbids launching offline while the bundle is wanted.
 }
 
 type ExecutionConfig struct {
@@ -90,6 +106,16 @@ func configureLauncherUpdate(launcher *LauncherUpdateConfig) {
 		log.Debugf("BaseURL for launcher for platforms %v was empty. Deriving it from BundleInfoURL \"%s\": \"%s\".",
 			launcher.TargetPlatforms, launcher.BundleInfoURL, launcher.BaseURL)
 	}
+	configureMirrors(launcher.Mirrors)
+}
+
+func configureMirrors(mirrors []MirrorConfig) {
+	for i := range mirrors {
+		if mirrors[i].BaseURL == "" {
+			mirrors[i].BaseURL = misc.MustStripLastURLPathElement(mirrors[i].BundleInfoURL)
+			log.Debugf("BaseURL for mirror was empty. Deriving it from BundleInfoURL \"%s\": \"%s\".", mirrors[i].BundleInfoURL, mirrors[i].BaseURL)
+		}
+	}
 }
 
 func configureBundles(bundles []BundleConfig) {
@@ -105,6 +131,7 @@ func configureBundle(bundle *BundleConfig) {
 		log.Debugf("BaseURL for bundle \"%s\" was empty. Deriving it from BundleInfoURL \"%s\": \"%s\".",
 			bundle.LocalDirectory, bundle.BundleInfoURL, bundle.BaseURL)
 	}
+	configureMirrors(bundle.Mirrors)
 
 	initialLocalDirectoryValue := bundle.LocalDirectory
 	if strings.HasPrefix(bundle.LocalDirectory, `/`) {
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	"github.com/zeebo/blake3"
+)
+
+// Hash algorithms which a bundle info can declare for the hashes of its files.
+const (
+	HashAlgorithmSHA256 = "SHA-256"
+	HashAlgorithmSHA512 = "SHA-512"
+	HashAlgorithmBLAKE3 = "BLAKE3"
+)
+
+// DefaultHashAlgorithm is the hash algorithm of bundle infos which do not declare one.
+const DefaultHashAlgorithm = HashAlgorithmSHA256
+
+var hashConstructors = map[string]func() hash.Hash{
+	HashAlgorithmSHA256: sha256.New,
+	HashAlgorithmSHA512: sha512.New,
+	HashAlgorithmBLAKE3: func() hash.Hash { return blake3.New() },
+}
+
+// IsSupportedHashAlgorithm returns true if algorithm is "" or one of the HashAlgorithm constants.
+func IsSupportedHashAlgorithm(algorithm string) bool {
+	_, ok := hashConstructors[NormalizeHashAlgorithm(algorithm)]
+	return ok
+}
+
+// NormalizeHashAlgorithm returns DefaultHashAlgorithm if algorithm is "" and algorithm otherwise.
+func NormalizeHashAlgorithm(algorithm string) string {
+	if algorithm == "" {
+		return DefaultHashAlgorithm
+	}
+	return algorithm
+}
+
+// NewHash returns a new hash.Hash computing the given algorithm, which is DefaultHashAlgorithm if "".
+// It panics if the algorithm is not supported.
+func NewHash(algorithm string) hash.Hash {
+	newHash, ok := hashConstructors[NormalizeHashAlgorithm(algorithm)]
+	if !ok {
+		panic(fmt.Sprintf("Unsupported hash algorithm %q", algorithm))
+	}
+	return newHash()
+}
+
+func isHash(s string, algorithm string) bool {
+	decoded, err := hex.DecodeString(s)
+	return err == nil && len(decoded) == NewHash(algorithm).Size()
+}
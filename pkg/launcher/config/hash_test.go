@@ -0,0 +1,54 @@
+package config_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+// Taken from test_vectors.json of the BLAKE3 reference implementation. The input of each case consists of the bytes
+// 0, 1, ..., 250, 0, 1, ... up to the given length.
+var blake3TestVectors = []struct {
+	inputLen int
+	hash     string
+}{
+	{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+	{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+	{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+	{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+	{8192, "aae792484c8efe4f19e2ca7d371d8c467ffb10748d8a5a1ae579948f718a2a63"},
+}
+
+func testInput(length int) []byte {
+	input := make([]byte, length)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+	return input
+}
+
+func TestNewHashComputesBLAKE3(t *testing.T) {
+	for _, vector := range blake3TestVectors {
+		h := config.NewHash(config.HashAlgorithmBLAKE3)
+		h.Write(testInput(vector.inputLen))
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != vector.hash {
+			t.Errorf("Expected %s for input of length %d. Got %s", vector.hash, vector.inputLen, sum)
+		}
+	}
+}
+
+// Run with "go test -bench NewHash ./pkg/launcher/config" to compare the throughput of the hash algorithms.
+func BenchmarkNewHash(b *testing.B) {
+	input := testInput(1024 * 1024)
+	for _, algorithm := range []string{config.HashAlgorithmSHA256, config.HashAlgorithmSHA512, config.HashAlgorithmBLAKE3} {
+		b.Run(algorithm, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				h := config.NewHash(algorithm)
+				h.Write(input)
+				h.Sum(nil)
+			}
+		})
+	}
+}
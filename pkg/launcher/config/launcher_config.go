@@ -17,6 +17,24 @@ type LauncherConfig struct {
 	BinaryName                     string         `json:"BinaryName"`
 	StatusMessages                 StatusMessages `json:"StatusMessages"`
 	IgnoreLauncherBundleInfoHashes []string       `json:"IgnoreLauncherBundleInfoHashes"`
+
+	// Maps host names to base64-encoded SHA-256 hashes of public keys, one of which must be in the certificate chain of the host.
+	PinnedPublicKeys map[string][]string `json:"PinnedPublicKeys,omitempty"`
+
+	// Certificate authorities and client certificates for downloads. Can be extended by a local TLS policy file.
+	TLS *TLSConfig `json:"TLS,omitempty"`
+
+	// Maps host names to credentials for requests to them. Can be extended by a local credentials file.
+	Credentials map[string]*CredentialsConfig `json:"Credentials,omitempty"`
+
+	// Signs the user in with OAuth 2.0 before downloads from hosts which require it.
+	OAuth *OAuthConfig `json:"OAuth,omitempty"`
+
+	// Selects the proxy for downloads. Can be overridden by a local proxy policy file.
+	Proxy *ProxyConfig `json:"Proxy,omitempty"`
+
+	// Allows launching the installed bundles when the deployment-config cannot be downloaded.
+	OfflineLaunch *OfflineLaunchConfig `json:"OfflineLaunch,omitempty"`
 }
 
 type StatusMessages struct {
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Symbolic links and required directories are listed apart from BundleFiles, so that launchers which do not know about
+// them keep working with the files they do know. All their paths use forward slashes and are relative to the bundle
+// directory, as are the targets of symbolic links relative to the directory which contains the link.
+
+// maxSymlinkDepth is the amount of symbolic links which may be followed to resolve a path, as on Linux.
+const maxSymlinkDepth = 40
+
+func validateBundleInfoSymlinks(symlinks map[string]string) {
+	for linkPath, target := range symlinks {
+		validateBundleInfoPath(linkPath)
+		if target == "" {
+			panic(fmt.Sprintf("Bundle info symlink %q has an empty target", linkPath))
+		}
+		if strings.HasPrefix(target, "/") || strings.Contains(target, ":") {
+			panic(fmt.Sprintf("Bundle info symlink %q must have a relative target, not %q", linkPath, target))
+		}
+		if strings.Contains(target, `\`) {
+			panic(fmt.Sprintf("Bundle info symlink %q must use forward slashes in its target %q", linkPath, target))
+		}
+		if _, ok := resolveBundlePath(symlinks, path.Dir(linkPath), target, 0); !ok {
+			panic(fmt.Sprintf("Bundle info symlink %q with target %q points outside of the bundle directory or through too many symlinks", linkPath, target))
+		}
+	}
+}
+
+func validateBundleInfoDirectories(directories []string) {
+	for _, directoryPath := range directories {
+		validateBundleInfoPath(directoryPath)
+	}
+}
+
+// validateBundleInfoEntryConflicts makes sure that no path is listed as more than one kind of entry and that nothing is
+// located beneath a symbolic link, where it would end up wherever the link points.
+func validateBundleInfoEntryConflicts(bundleFiles FileInfoMap, symlinks map[string]string, directories []string) {
+	kinds := make(map[string]string, len(bundleFiles)+len(symlinks)+len(directories))
+	addEntry := func(entryPath, kind string) {
+		if otherKind, ok := kinds[entryPath]; ok {
+			panic(fmt.Sprintf("Bundle info lists %q as both %s and %s", entryPath, otherKind, kind))
+		}
+		kinds[entryPath] = kind
+	}
+	for filePath := range bundleFiles {
+		addEntry(filePath, "file")
+	}
+	for linkPath := range symlinks {
+		addEntry(linkPath, "symlink")
+	}
+	for _, directoryPath := range directories {
+		addEntry(directoryPath, "directory")
+	}
+	for entryPath := range kinds {
+		for parent := path.Dir(entryPath); parent != "."; parent = path.Dir(parent) {
+			if _, isSymlink := symlinks[parent]; isSymlink {
+				panic(fmt.Sprintf("Bundle info lists %q beneath symlink %q", entryPath, parent))
+			}
+		}
+	}
+}
+
+// resolveBundlePath returns the path which relativePath, relative to the directory dirPath within the bundle, refers to
+// after following the given symlinks. It returns false if the path leaves the bundle directory at any point or if more
+// than maxSymlinkDepth symlinks have to be followed. dirPath itself must not lead through symlinks.
+func resolveBundlePath(symlinks map[string]string, dirPath, relativePath string, depth int) (string, bool) {
+	if depth > maxSymlinkDepth {
+		return "", false
+	}
+	var resolved []string
+	if dirPath != "." {
+		resolved = strings.Split(dirPath, "/")
+	}
+	for _, element := range strings.Split(relativePath, "/") {
+		switch element {
+		case "", ".":
+		case "..":
+			if len(resolved) == 0 {
+				return "", false
+			}
+			resolved = resolved[:len(resolved)-1]
+		default:
+			resolved = append(resolved, element)
+			if target, isSymlink := symlinks[strings.Join(resolved, "/")]; isSymlink {
+				targetPath, ok := resolveBundlePath(symlinks, joinPathElements(resolved[:len(resolved)-1]), target, depth+1)
+				if !ok {
+					return "", false
+				}
+				resolved = nil
+				if targetPath != "." {
+					resolved = strings.Split(targetPath, "/")
+				}
+			}
+		}
+	}
+	return joinPathElements(resolved), true
+}
+
+func joinPathElements(elements []string) string {
+	if len(elements) == 0 {
+		return "."
+	}
+	return strings.Join(elements, "/")
+}
+
+// MakeDiffSymlinkMap returns the symlinks of want which are missing from have or point elsewhere in have. Symlinks of have
+// which are missing from want are mapped to "", meaning that they are to be removed.
+func MakeDiffSymlinkMap(have, want map[string]string) map[string]string {
+	diff := make(map[string]string)
+	for linkPath, target := range want {
+		if have[linkPath] != target {
+			diff[linkPath] = target
+		}
+	}
+	for linkPath := range have {
+		if _, ok := want[linkPath]; !ok {
+			diff[linkPath] = ""
+		}
+	}
+	return diff
+}
+
+// MakeDiffDirectoryList returns the sorted directories of want which are missing from have.
+func MakeDiffDirectoryList(have, want []string) []string {
+	present := make(map[string]bool, len(have))
+	for _, directoryPath := range have {
+		present[directoryPath] = true
+	}
+	var missing []string
+	for _, directoryPath := range want {
+		if !present[directoryPath] {
+			missing = append(missing, directoryPath)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
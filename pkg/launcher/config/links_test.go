@@ -0,0 +1,78 @@
+package config_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func bundleInfoWithLinks(symlinks map[string]string, directories ...string) *config.BundleInfo {
+	return &config.BundleInfo{
+		BundleFiles: config.FileInfoMap{"lib/real/libjvm.so": {Hash: "abc", Size: 1}},
+		Symlinks:    symlinks,
+		Directories: directories,
+	}
+}
+
+func TestValidateAcceptsSymlinksWithinBundle(t *testing.T) {
+	bundleInfoWithLinks(map[string]string{
+		"lib/libjvm.so": "real/libjvm.so",
+		"bin":           "lib",
+		"lib/up":        "../bin/real", // Resolves through "bin" to "lib/real".
+		"dangling":      "missing",
+	}, "logs", "lib/empty").MustValidate()
+}
+
+func TestValidateRejectsInvalidSymlinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		symlinks map[string]string
+	}{
+		{name: "emptyTarget", symlinks: map[string]string{"link": ""}},
+		{name: "absoluteTarget", symlinks: map[string]string{"link": "/etc/passwd"}},
+		{name: "driveTarget", symlinks: map[string]string{"link": "C:/Windows"}},
+		{name: "backslashTarget", symlinks: map[string]string{"link": `lib\real`}},
+		{name: "parentTarget", symlinks: map[string]string{"lib/link": "../.."}},
+		{name: "escapeThroughSymlink", symlinks: map[string]string{"lib/root": "..", "lib/link": "root/.."}},
+		{name: "loop", symlinks: map[string]string{"a": "b", "b": "a"}},
+		{name: "unsafePath", symlinks: map[string]string{"../link": "lib"}},
+		{name: "sameAsFile", symlinks: map[string]string{"lib/real/libjvm.so": "../other"}},
+		{name: "fileBeneathSymlink", symlinks: map[string]string{"lib/real": "../other"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for symlinks %v", test.symlinks)
+				}
+			}()
+			bundleInfoWithLinks(test.symlinks).MustValidate()
+		})
+	}
+}
+
+func TestValidateRejectsDirectoryInPlaceOfFile(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for directory with the path of a file")
+		}
+	}()
+	bundleInfoWithLinks(nil, "lib/real/libjvm.so").MustValidate()
+}
+
+func TestMakeDiffSymlinkMap(t *testing.T) {
+	have := map[string]string{"same": "a", "changed": "a", "removed": "a"}
+	want := map[string]string{"same": "a", "changed": "b", "created": "c"}
+	expected := map[string]string{"changed": "b", "created": "c", "removed": ""}
+	if diff := config.MakeDiffSymlinkMap(have, want); !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("expected %v, got %v", expected, diff)
+	}
+}
+
+func TestMakeDiffDirectoryListReturnsMissingDirectories(t *testing.T) {
+	missing := config.MakeDiffDirectoryList([]string{"logs", "lib", "unwanted"}, []string{"lib/empty", "logs", "cache"})
+	if expected := []string{"cache", "lib/empty"}; !reflect.DeepEqual(missing, expected) {
+		t.Fatalf("expected %v, got %v", expected, missing)
+	}
+}
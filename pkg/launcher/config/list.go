@@ -26,35 +26,66 @@ func (fm FileInfoMap) MustGetOnly() (key string, fileInfo *FileInfo) {
 }
 
 // Returns a FileInfoMap which describes the changes from have to want.
-// In the returned FileInfoMap, a key which maps to a *FileInfo with its SHA256-field being non-empty indicates
+// In the returned FileInfoMap, a key which maps to a *FileInfo with its Hash-field being non-empty indicates
 // that the file described by the key-string requires an update or is new. A key which maps to a *FileInfo
-// with an empty SHA256-field indicates that the file described by the key-string should be removed.
+// with an empty Hash-field indicates that the file described by the key-string should be removed.
+// Hashes of different algorithms never match, so have should be hashed with the algorithm of want.
+// Of the patches listed in want, only the one from the present version of a file is retained.
 func MakeDiffFileInfoMap(have FileInfoMap, want FileInfoMap) FileInfoMap {
 	fm := make(FileInfoMap)
 	for presentKey, presentFileInfo := range have {
 		if _, ok := want[presentKey]; !ok {
-			log.Debugf("Have %s but want no hash for \"%s\". Delete.", presentFileInfo.SHA256[:8], presentKey)
+			log.Debugf("Have %s but want no hash for \"%s\". Delete.", presentFileInfo.Hash[:8], presentKey)
 			newFileInfo := *presentFileInfo
-			newFileInfo.SHA256 = ""
+			newFileInfo.Hash = ""
 			fm[presentKey] = &newFileInfo
 		}
 	}
 	for wantedKey, wantedFileInfo := range want {
 		if presentFileInfo, ok := have[wantedKey]; ok {
-			if wantedFileInfo.SHA256 != presentFileInfo.SHA256 {
-				log.Debugf("Have %s but want %s for \"%s\". Update.", presentFileInfo.SHA256[:8], wantedFileInfo.SHA256[:8], wantedKey)
+			sameHashAlgorithm := NormalizeHashAlgorithm(wantedFileInfo.HashAlgorithm) == NormalizeHashAlgorithm(presentFileInfo.HashAlgorithm)
+			if wantedFileInfo.Hash != presentFileInfo.Hash || !sameHashAlgorithm {
+				log.Debugf("Have %s but want %s for \"%s\". Update.", presentFileInfo.Hash[:8], wantedFileInfo.Hash[:8], wantedKey)
 				newFileInfo := *wantedFileInfo
+				newFileInfo.Patches = nil
+				if patchInfo := wantedFileInfo.PatchFrom(presentFileInfo.Hash); patchInfo != nil && sameHashAlgorithm {
+					newFileInfo.Patches = map[string]*FileInfo{presentFileInfo.Hash: patchInfo}
+				}
 				fm[wantedKey] = &newFileInfo
 			}
 		} else {
-			log.Debugf("Have no hash but want %s for \"%s\". Update.", wantedFileInfo.SHA256[:8], wantedKey)
+			log.Debugf("Have no hash but want %s for \"%s\". Update.", wantedFileInfo.Hash[:8], wantedKey)
 			newFileInfo := *wantedFileInfo
+			newFileInfo.Patches = nil
 			fm[wantedKey] = &newFileInfo
 		}
 	}
 	return fm
 }
 
+// MakeModeDiffFileInfoMap returns the entries of want for files which need no update according to MakeDiffFileInfoMap, but
+// whose mode, as declared by want, differs from the one in have. Files without a mode in either map are left alone.
+func MakeModeDiffFileInfoMap(have FileInfoMap, want FileInfoMap) FileInfoMap {
+	fm := make(FileInfoMap)
+	for wantedKey, wantedFileInfo := range want {
+		presentFileInfo, ok := have[wantedKey]
+		if !ok || wantedFileInfo.Hash != presentFileInfo.Hash ||
+			NormalizeHashAlgorithm(wantedFileInfo.HashAlgorithm) != NormalizeHashAlgorithm(presentFileInfo.HashAlgorithm) {
+			continue
+		}
+		wantedMode, wantsMode := wantedFileInfo.FileMode()
+		presentMode, hasMode := presentFileInfo.FileMode()
+		if !wantsMode || !hasMode || wantedMode == presentMode {
+			continue
+		}
+		log.Debugf("Have mode %s but want %s for \"%s\". Change mode.", presentFileInfo.Mode, wantedFileInfo.Mode, wantedKey)
+		newFileInfo := *wantedFileInfo
+		newFileInfo.Patches = nil
+		fm[wantedKey] = &newFileInfo
+	}
+	return fm
+}
+
 func (fm FileInfoMap) HasChanges() bool {
 	return len(fm) > 0
 }
@@ -66,7 +97,7 @@ func (fm FileInfoMap) HasUpdates() bool {
 func (fm FileInfoMap) DeleteFileCount() uint64 {
 	var total uint64
 	for _, v := range fm {
-		if v.SHA256 == "" {
+		if v.Hash == "" {
 			total++
 		}
 	}
@@ -76,7 +107,7 @@ func (fm FileInfoMap) DeleteFileCount() uint64 {
 func (fm FileInfoMap) UpdateFileCount() uint64 {
 	var total uint64
 	for _, v := range fm {
-		if v.SHA256 != "" {
+		if v.Hash != "" {
 			total++
 		}
 	}
@@ -86,9 +117,37 @@ func (fm FileInfoMap) UpdateFileCount() uint64 {
 func (fm FileInfoMap) UpdateByteCount() uint64 {
 	var total uint64
 	for _, v := range fm {
-		if v.SHA256 != "" {
+		if v.Hash != "" {
 			total += uint64(v.Size)
 		}
 	}
 	return total
 }
+
+// DownloadByteCount is like UpdateByteCount, but counts the compressed size of files which are transferred compressed.
+func (fm FileInfoMap) DownloadByteCount() uint64 {
+	var total uint64
+	for _, v := range fm {
+		if v.Hash != "" {
+			total += uint64(v.DownloadSize())
+		}
+	}
+	return total
+}
+
+// TransferByteCount is like DownloadByteCount, but counts the size of the patch instead for files which can be patched.
+func (fm FileInfoMap) TransferByteCount() uint64 {
+	var total uint64
+	for _, v := range fm {
+		if v.Hash != "" {
+			size := v.DownloadSize()
+			for _, patchInfo := range v.Patches {
+				if patchInfo.Size < size {
+					size = patchInfo.Size
+				}
+			}
+			total += uint64(size)
+		}
+	}
+	return total
+}
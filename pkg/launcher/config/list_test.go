@@ -26,13 +26,13 @@ func TestUnmarshal(t *testing.T) {
 	if err != nil {
 		t.Errorf("Unmarshal failed: %v", err)
 	}
-	if !strings.EqualFold(fm["foo"].SHA256, "9E079B502D173FE926B04E87715F4534C34F23EDF8E91FBBB2510BC666FB6C76") {
+	if !strings.EqualFold(fm["foo"].Hash, "9E079B502D173FE926B04E87715F4534C34F23EDF8E91FBBB2510BC666FB6C76") {
 		t.Error("Missing 9E079B502D173FE926B04E87715F4534C34F23EDF8E91FBBB2510BC666FB6C76")
 	}
-	if !strings.EqualFold(fm["bar"].SHA256, "3CD33E6295AEEB0622990B149A78A648200DE73C5CC0BF573F57CFDC0A6F0074") {
+	if !strings.EqualFold(fm["bar"].Hash, "3CD33E6295AEEB0622990B149A78A648200DE73C5CC0BF573F57CFDC0A6F0074") {
 		t.Error("Missing 3CD33E6295AEEB0622990B149A78A648200DE73C5CC0BF573F57CFDC0A6F0074")
 	}
-	if !strings.EqualFold(fm["bee"].SHA256, "56175A1FF29A145F58FFEC4ACC361D69728FEAEB35447750B271A8B55F4FFF50") {
+	if !strings.EqualFold(fm["bee"].Hash, "56175A1FF29A145F58FFEC4ACC361D69728FEAEB35447750B271A8B55F4FFF50") {
 		t.Error("Missing 56175A1FF29A145F58FFEC4ACC361D69728FEAEB35447750B271A8B55F4FFF50")
 	}
 	if len(fm) != 3 {
@@ -56,20 +56,90 @@ func TestMakeUpdateMap(t *testing.T) {
 	um := config.MakeDiffFileInfoMap(fm1, fm2)
 	upFoo, upBee, upBaz := um["foo"], um["bee"], um["baz"]
 	upBar, hasBar := um["bar"]
-	if upFoo.SHA256 == "" {
+	if upFoo.Hash == "" {
 		t.Error("Deleting outdated foo")
 	}
-	if upBee.SHA256 != "" {
+	if upBee.Hash != "" {
 		t.Error("Updating removed bee")
 	}
-	if upBaz.SHA256 == "" {
+	if upBaz.Hash == "" {
 		t.Error("Deleting new baz")
 	}
 	if hasBar {
-		if upBar.SHA256 == "" {
+		if upBar.Hash == "" {
 			t.Error("Incorrectly deleting unchanged bar")
 		} else {
 			t.Error("Redundantly updating unchanged bar")
 		}
 	}
 }
+
+const testJsonWithPatches string = `{
+	"foo": { "SHA256": "84C95435C2EC37380F38E453B05782B9D177D745C4BB7B43005D0337025DA857", "Size": 1000, "Patches": {
+		"9E079B502D173FE926B04E87715F4534C34F23EDF8E91FBBB2510BC666FB6C76": { "SHA256": "3CD33E6295AEEB0622990B149A78A648200DE73C5CC0BF573F57CFDC0A6F0074", "Size": 10 },
+		"56175A1FF29A145F58FFEC4ACC361D69728FEAEB35447750B271A8B55F4FFF50": { "SHA256": "CE2213DACD9C4A703AC3A960D006F06468AAE2EF9E2D09EE87872F55A0CBECE6", "Size": 20 }
+	} },
+	"baz": { "SHA256": "CE2213DACD9C4A703AC3A960D006F06468AAE2EF9E2D09EE87872F55A0CBECE6", "Size": 500, "Patches": {
+		"9E079B502D173FE926B04E87715F4534C34F23EDF8E91FBBB2510BC666FB6C76": { "SHA256": "3CD33E6295AEEB0622990B149A78A648200DE73C5CC0BF573F57CFDC0A6F0074", "Size": 10 }
+	} }
+}`
+
+func TestMakeUpdateMapRetainsPatchFromPresentFile(t *testing.T) {
+	fm1 := make(config.FileInfoMap)
+	fm2 := make(config.FileInfoMap)
+	if err := json.Unmarshal([]byte(testJson1), &fm1); err != nil {
+		t.Fatalf("Could not unmarshal json: %v", err)
+	}
+	if err := json.Unmarshal([]byte(testJsonWithPatches), &fm2); err != nil {
+		t.Fatalf("Could not unmarshal json: %v", err)
+	}
+	um := config.MakeDiffFileInfoMap(fm1, fm2)
+	if len(um["foo"].Patches) != 1 || um["foo"].PatchFrom(fm1["foo"].Hash) == nil {
+		t.Errorf("Expected foo to retain exactly the patch from its present version. Got %v.", um["foo"].Patches)
+	}
+	if len(um["baz"].Patches) != 0 {
+		t.Errorf("Expected new file baz to have no patches. Got %v.", um["baz"].Patches)
+	}
+	if um.TransferByteCount() != 510 {
+		t.Errorf("Expected to transfer 510 bytes. Got %d.", um.TransferByteCount())
+	}
+}
+
+func TestMakeUpdateMapUpdatesFilesHashedWithOtherAlgorithm(t *testing.T) {
+	have := config.FileInfoMap{"foo": {Hash: "9e079b502d173fe9", HashAlgorithm: config.HashAlgorithmSHA256, Size: 1000}}
+	want := config.FileInfoMap{"foo": {Hash: "9e079b502d173fe9", HashAlgorithm: config.HashAlgorithmBLAKE3, Size: 1000, Patches: map[string]*config.FileInfo{
+		"9e079b502d173fe9": {Hash: "3cd33e6295aeeb06", Size: 10},
+	}}}
+	um := config.MakeDiffFileInfoMap(have, want)
+	if um["foo"] == nil || um["foo"].Hash == "" {
+		t.Fatalf("Expected foo to be updated as its hashes cannot be compared. Got %v.", um)
+	}
+	if len(um["foo"].Patches) != 0 {
+		t.Errorf("Expected no patch to be applied across hash algorithms. Got %v.", um["foo"].Patches)
+	}
+	if um := config.MakeDiffFileInfoMap(config.FileInfoMap{"foo": {Hash: "9e079b502d173fe9"}}, have); len(um) != 0 {
+		t.Errorf("Expected an empty hash algorithm to mean %s. Got %v.", config.DefaultHashAlgorithm, um)
+	}
+}
+
+func TestMakeModeDiffFileInfoMap(t *testing.T) {
+	have := config.FileInfoMap{
+		"run":     {Hash: "aaaaaaaaaaaaaaaa", Mode: "0644"},
+		"lib":     {Hash: "bbbbbbbbbbbbbbbb", Mode: "0644"},
+		"changed": {Hash: "cccccccccccccccc", Mode: "0644"},
+		"unknown": {Hash: "dddddddddddddddd"},
+	}
+	want := config.FileInfoMap{
+		"run":     {Hash: "aaaaaaaaaaaaaaaa", Mode: "755"},
+		"lib":     {Hash: "bbbbbbbbbbbbbbbb", Mode: "0644"},
+		"changed": {Hash: "eeeeeeeeeeeeeeee", Mode: "0755"},
+		"unknown": {Hash: "dddddddddddddddd", Mode: "0755"},
+	}
+	um := config.MakeModeDiffFileInfoMap(have, want)
+	if len(um) != 1 || um["run"] == nil || um["run"].Mode != "755" {
+		t.Errorf("Expected only run to change its mode. Got %v.", um)
+	}
+	if dm := config.MakeDiffFileInfoMap(have, want); len(dm) != 1 || dm["changed"] == nil {
+		t.Errorf("Expected only changed to be updated. Got %v.", dm)
+	}
+}
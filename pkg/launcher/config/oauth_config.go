@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+const (
+	OAuthFlowDevice = "device" // OAuth 2.0 Device Authorization Grant (RFC 8628).
+	OAuthFlowPKCE   = "pkce"   // OAuth 2.0 Authorization Code Grant with PKCE (RFC 7636) and a loopback redirect (RFC 8252).
+)
+
+// OAuthConfig describes how to sign the user in with OAuth 2.0 before downloading from the hosts which require it.
+type OAuthConfig struct {
+	Flow                   string   `json:"Flow"` // OAuthFlowDevice or OAuthFlowPKCE.
+	ClientID               string   `json:"ClientID"`
+	DeviceAuthorizationURL string   `json:"DeviceAuthorizationURL,omitempty"` // Required for OAuthFlowDevice.
+	AuthorizationURL       string   `json:"AuthorizationURL,omitempty"`       // Required for OAuthFlowPKCE.
+	TokenURL               string   `json:"TokenURL"`
+	Scopes                 []string `json:"Scopes,omitempty"`
+	Hosts                  []string `json:"Hosts"` // Hosts whose requests carry the access token.
+}
+
+// Validate returns an error if the config lacks a field its flow requires or contains an invalid URL.
+func (oauthConfig *OAuthConfig) Validate() error {
+	if oauthConfig.ClientID == "" {
+		return fmt.Errorf("ClientID is not set")
+	}
+	if len(oauthConfig.Hosts) == 0 {
+		return fmt.Errorf("Hosts is empty")
+	}
+	requiredURLs := map[string]string{"TokenURL": oauthConfig.TokenURL}
+	switch oauthConfig.Flow {
+	case OAuthFlowDevice:
+		requiredURLs["DeviceAuthorizationURL"] = oauthConfig.DeviceAuthorizationURL
+	case OAuthFlowPKCE:
+		requiredURLs["AuthorizationURL"] = oauthConfig.AuthorizationURL
+	default:
+		return fmt.Errorf("Flow must be \"%s\" or \"%s\", not \"%s\"", OAuthFlowDevice, OAuthFlowPKCE, oauthConfig.Flow)
+	}
+	for name, urlString := range requiredURLs {
+		if u, err := url.Parse(urlString); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("%s \"%s\" is not an absolute HTTP(S) URL", name, urlString)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import "time"
+
+const defaultOfflineGracePeriod = 30 * time.Second
+
+// OfflineLaunchConfig allows launching the installed bundles when the deployment-config cannot be downloaded, using the
+// last deployment-config and bundle info files which have been downloaded and verified.
+type OfflineLaunchConfig struct {
+	// How long to keep trying to download the deployment-config before launching offline. 30 seconds if 0.
+	GracePeriodSeconds int `json:"GracePeriodSeconds,omitempty"`
+
+	// If true, the user is asked whether to launch offline. Otherwise, the launcher does so without asking.
+	Prompt bool `json:"Prompt,omitempty"`
+}
+
+// GracePeriod returns how long to keep trying to download the deployment-config before launching offline.
+func (offlineLaunchConfig *OfflineLaunchConfig) GracePeriod() time.Duration {
+	if offlineLaunchConfig.GracePeriodSeconds <= 0 {
+		return defaultOfflineGracePeriod
+	}
+	return time.Duration(offlineLaunchConfig.GracePeriodSeconds) * time.Second
+}
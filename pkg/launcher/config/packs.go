@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// PackDirectoryName is the name of the directory next to the files of a bundle which contains its packs.
+const PackDirectoryName = ".packs"
+
+// PackInfoMap describes the packs of a bundle. Keys are the forward-slashed paths of the packs relative to the bundle's base URL.
+type PackInfoMap map[string]*PackInfo
+
+// PackInfo describes a pack, an uncompressed tar-archive which holds many files of a bundle, so that they can be
+// transferred with a single request. Its entries are named like the files in BundleFiles.
+type PackInfo struct {
+	Size           int64    `json:"Size"`
+	Compression    string   `json:"Compression,omitempty"`    // If set, the pack is transferred compressed with this algorithm as a whole.
+	CompressedSize int64    `json:"CompressedSize,omitempty"` // The size of the compressed pack, if Compression is set.
+	Files          []string `json:"Files"`                    // The keys of BundleFiles which the pack holds.
+}
+
+// TransferPath returns the path under which the pack at packPath, as described by info, is found on the remote.
+func (info *PackInfo) TransferPath(packPath string) string {
+	return packPath + CompressedFileExtension(info.Compression)
+}
+
+// DownloadSize returns the amount of bytes which have to be downloaded to obtain the pack described by info.
+func (info *PackInfo) DownloadSize() int64 {
+	if info.Compression != "" {
+		return info.CompressedSize
+	}
+	return info.Size
+}
+
+// Choose returns the packs which are worth downloading to obtain the files with a SHA256 in wantedState, whose keys
+// use filepath.Separator. That is the case for packs of which at least half of the bytes belong to wanted files.
+// Other wanted files, e.g. the few files which change in an incremental update, are better downloaded one by one.
+func (packs PackInfoMap) Choose(wantedState FileInfoMap) PackInfoMap {
+	chosenPacks := make(PackInfoMap)
+	for packPath, packInfo := range packs {
+		var wantedBytes int64
+		for _, filePath := range packInfo.Files {
+			if fileInfo, ok := wantedState[filepath.FromSlash(filePath)]; ok && fileInfo.Hash != "" {
+				wantedBytes += fileInfo.Size
+			}
+		}
+		if wantedBytes > 0 && wantedBytes*2 >= packInfo.Size {
+			chosenPacks[packPath] = packInfo
+		}
+	}
+	return chosenPacks
+}
+
+// UnpackedFiles returns the entries of fileMap, whose keys use filepath.Separator, which none of the packs hold.
+func (packs PackInfoMap) UnpackedFiles(fileMap FileInfoMap) FileInfoMap {
+	unpackedFiles := make(FileInfoMap)
+	unpackedFiles.Join(fileMap)
+	for _, packInfo := range packs {
+		for _, filePath := range packInfo.Files {
+			delete(unpackedFiles, filepath.FromSlash(filePath))
+		}
+	}
+	return unpackedFiles
+}
+
+// TransferByteCount is like FileInfoMap.TransferByteCount, but counts the packs which are chosen to obtain the files
+// of wantedState instead of the files they hold.
+func (packs PackInfoMap) TransferByteCount(wantedState FileInfoMap) uint64 {
+	chosenPacks := packs.Choose(wantedState)
+	total := chosenPacks.UnpackedFiles(wantedState).TransferByteCount()
+	for _, packInfo := range chosenPacks {
+		total += uint64(packInfo.DownloadSize())
+	}
+	return total
+}
+
+func validateBundleInfoPacks(packs PackInfoMap, bundleFiles FileInfoMap) {
+	packedFiles := make(map[string]string)
+	for packPath, packInfo := range packs {
+		validateBundleInfoPath(packPath)
+		if packInfo == nil || packInfo.Size <= 0 || (packInfo.Compression != "" && CompressedFileExtension(packInfo.Compression) == "") {
+			panic(fmt.Sprintf("Bundle info lists invalid pack %q", packPath))
+		}
+		for _, filePath := range packInfo.Files {
+			if _, ok := bundleFiles[filePath]; !ok {
+				panic(fmt.Sprintf("Bundle info pack %q holds file %q which is not part of the bundle", packPath, filePath))
+			}
+			if otherPackPath, ok := packedFiles[filePath]; ok {
+				panic(fmt.Sprintf("Bundle info file %q is held by both pack %q and pack %q", filePath, otherPackPath, packPath))
+			}
+			packedFiles[filePath] = packPath
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package config_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func TestChoosePacksSkipsPacksOfFewWantedFiles(t *testing.T) {
+	packs := config.PackInfoMap{
+		".packs/pack-0.tar": {Size: 1000, Files: []string{"a/1", "a/2"}},
+		".packs/pack-1.tar": {Size: 1000, Files: []string{"b/1", "b/2"}},
+	}
+	wantedState := config.FileInfoMap{
+		filepath.FromSlash("a/1"): {Hash: "1", Size: 600},
+		filepath.FromSlash("b/1"): {Hash: "2", Size: 100},
+		filepath.FromSlash("b/2"): {Hash: ""}, // Deleted files are not wanted from any pack.
+		"c":                       {Hash: "3", Size: 50},
+	}
+	chosenPacks := packs.Choose(wantedState)
+	if len(chosenPacks) != 1 || chosenPacks[".packs/pack-0.tar"] == nil {
+		t.Fatalf("expected only pack-0 to be chosen, got %v", chosenPacks)
+	}
+	if unpackedFiles := chosenPacks.UnpackedFiles(wantedState); len(unpackedFiles) != 3 || unpackedFiles[filepath.FromSlash("a/1")] != nil {
+		t.Errorf("expected all files but a/1 to remain unpacked, got %v", unpackedFiles)
+	}
+	if count := packs.TransferByteCount(wantedState); count != 1000+100+50 {
+		t.Errorf("expected 1150 bytes to transfer, got %d", count)
+	}
+}
+
+func TestReadBundleInfoRejectsInvalidPacks(t *testing.T) {
+	tests := map[string]string{
+		"unknown file":   `{ ".packs/pack-0.tar": { "Size": 10, "Files": [ "missing.txt" ] } }`,
+		"file twice":     `{ ".packs/pack-0.tar": { "Size": 10, "Files": [ "top.txt" ] }, ".packs/pack-1.tar": { "Size": 10, "Files": [ "top.txt" ] } }`,
+		"unsafe path":    `{ "../pack.tar": { "Size": 10, "Files": [ "top.txt" ] } }`,
+		"no size":        `{ ".packs/pack-0.tar": { "Files": [ "top.txt" ] } }`,
+		"bad compressor": `{ ".packs/pack-0.tar": { "Size": 10, "Compression": "rar", "Files": [ "top.txt" ] } }`,
+	}
+	for name, packs := range tests {
+		t.Run(name, func(t *testing.T) {
+			reader := strings.NewReader(`{
+				"Timestamp": "2019-02-07 14:53:17",
+				"UniqueBundleName": "bundle",
+				"BundleFiles": { "top.txt": { "SHA256": "abc", "Size": 2 } },
+				"Packs": ` + packs + `
+			}`)
+
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for invalid packs")
+				}
+			}()
+
+			config.ReadInfoFromReader(reader)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ProxyModeSystem = "system" // Use the proxy settings of the operating system or the environment. The default.
+	ProxyModeDirect = "direct" // Connect to all hosts directly.
+	ProxyModeManual = "manual" // Connect through the proxy at URL, except to the hosts in NoProxy.
+	ProxyModePAC    = "pac"    // Evaluate the proxy auto-config script at PACURL for each host.
+)
+
+// ProxyConfig selects how downloads connect to hosts.
+type ProxyConfig struct {
+	Mode string `json:"Mode,omitempty"`
+
+	// URL of the proxy of ProxyModeManual: "http://host:port", "https://host:port" or "socks5://host:port".
+	URL string `json:"URL,omitempty"`
+
+	// Hosts which ProxyModeManual connects to directly, in the format of the NO_PROXY environment variable: host names,
+	// domains with a leading dot, IP addresses and CIDR ranges, each optionally with a port.
+	NoProxy []string `json:"NoProxy,omitempty"`
+
+	// URL or path of the proxy auto-config script of ProxyModePAC. It is fetched without proxy.
+	PACURL string `json:"PACURL,omitempty"`
+
+	// Maps host names of proxies to the credentials to authenticate to them with. Only basic authentication is supported.
+	Credentials map[string]*ProxyCredentialsConfig `json:"Credentials,omitempty"`
+}
+
+type ProxyCredentialsConfig struct {
+	Username string `json:"Username"`
+	Password string `json:"Password"`
+}
+
+// ReadProxyConfig reads a ProxyConfig from the JSON-file at filePath. A relative path in PACURL is resolved
+// relative to the directory of the file.
+func ReadProxyConfig(filePath string) (*ProxyConfig, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	proxyConfig := &ProxyConfig{}
+	if err = json.Unmarshal(data, proxyConfig); err != nil {
+		return nil, fmt.Errorf("could not parse \"%s\": %w", filePath, err)
+	}
+	if proxyConfig.PACURL != "" && !strings.Contains(proxyConfig.PACURL, "://") && !filepath.IsAbs(proxyConfig.PACURL) {
+		proxyConfig.PACURL = filepath.Join(filepath.Dir(filePath), proxyConfig.PACURL)
+	}
+	return proxyConfig, nil
+}
+
+// MergedWith returns a ProxyConfig with the route of override if it sets a Mode, or else with the route of proxyConfig,
+// and with the credentials of both, preferring those of override for the same host. Either may be nil.
+func (proxyConfig *ProxyConfig) MergedWith(override *ProxyConfig) *ProxyConfig {
+	merged := &ProxyConfig{Mode: ProxyModeSystem, Credentials: make(map[string]*ProxyCredentialsConfig)}
+	for _, c := range []*ProxyConfig{proxyConfig, override} {
+		if c == nil {
+			continue
+		}
+		if c.Mode != "" {
+			merged.Mode, merged.URL, merged.NoProxy, merged.PACURL = c.Mode, c.URL, c.NoProxy, c.PACURL
+		}
+		for host, credentials := range c.Credentials {
+			merged.Credentials[strings.ToLower(host)] = credentials
+		}
+	}
+	return merged
+}
+
+// Validate returns an error if the config lacks a field its mode requires.
+func (proxyConfig *ProxyConfig) Validate() error {
+	switch proxyConfig.Mode {
+	case "", ProxyModeSystem, ProxyModeDirect:
+	case ProxyModeManual:
+		proxyURL, err := url.Parse(proxyConfig.URL)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("URL \"%s\" is not a valid proxy URL", proxyConfig.URL)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("URL \"%s\" has unsupported scheme \"%s\"", proxyConfig.URL, proxyURL.Scheme)
+		}
+	case ProxyModePAC:
+		if proxyConfig.PACURL == "" {
+			return fmt.Errorf("PACURL is not set")
+		}
+	default:
+		return fmt.Errorf("Mode must be one of \"%s\", \"%s\", \"%s\" and \"%s\", not \"%s\"",
+			ProxyModeSystem, ProxyModeDirect, ProxyModeManual, ProxyModePAC, proxyConfig.Mode)
+	}
+	for host, credentials := range proxyConfig.Credentials {
+		if credentials == nil || credentials.Username == "" {
+			return fmt.Errorf("no Username given for proxy \"%s\"", host)
+		}
+	}
+	return nil
+}
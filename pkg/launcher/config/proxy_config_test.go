@@ -0,0 +1,37 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func TestMergeProxyConfigs(t *testing.T) {
+	compiledIn := &config.ProxyConfig{Mode: config.ProxyModeManual, URL: "http://proxy.example.com:8080"}
+	local := &config.ProxyConfig{Credentials: map[string]*config.ProxyCredentialsConfig{"Proxy.example.com": {Username: "user"}}}
+	merged := compiledIn.MergedWith(local)
+	if merged.Mode != config.ProxyModeManual || merged.URL != compiledIn.URL || merged.Credentials["proxy.example.com"] == nil {
+		t.Errorf("Expected route of launcher-config and local credentials. Got %+v", merged)
+	}
+	local.Mode, local.PACURL = config.ProxyModePAC, "proxy.pac"
+	if merged = compiledIn.MergedWith(local); merged.Mode != config.ProxyModePAC || merged.URL != "" {
+		t.Errorf("Expected local route to override the one of the launcher-config. Got %+v", merged)
+	}
+	if merged = (*config.ProxyConfig)(nil).MergedWith(nil); merged.Mode != config.ProxyModeSystem {
+		t.Errorf("Expected system proxy by default. Got %+v", merged)
+	}
+}
+
+func TestValidateProxyConfig(t *testing.T) {
+	for _, proxyConfig := range []*config.ProxyConfig{
+		{Mode: "automatic"},
+		{Mode: config.ProxyModeManual, URL: "ftp://proxy.example.com"},
+		{Mode: config.ProxyModeManual},
+		{Mode: config.ProxyModePAC},
+		{Credentials: map[string]*config.ProxyCredentialsConfig{"proxy.example.com": {Password: "secret"}}},
+	} {
+		if err := proxyConfig.Validate(); err == nil {
+			t.Errorf("Expected proxy config %+v to be invalid.", proxyConfig)
+		}
+	}
+}
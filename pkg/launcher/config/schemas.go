@@ -17,6 +17,37 @@ const deploymentConfigSchema = `{
 		"URL": {
 			"type": "string",
 			"pattern": "^(https?|file)://.*$"
+		},
+		"Weight": {
+			"type": "integer",
+			"minimum": 0
+		},
+		"BandwidthLimit": {
+			"type": "integer",
+			"minimum": 0
+		},
+		"ConcurrentDownloads": {
+			"type": "integer",
+			"minimum": 1,
+			"maximum": 16
+		},
+		"MirrorsArray": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"BundleInfoURL": {
+						"$ref": "#/definitions/URL"
+					},
+					"BaseURL": {
+						"$ref": "#/definitions/URL"
+					},
+					"Weight": {
+						"$ref": "#/definitions/Weight"
+					}
+				},
+				"required": [ "BundleInfoURL" ]
+			}
 		}
     },
 	"properties": {
@@ -38,6 +69,12 @@ const deploymentConfigSchema = `{
 					"IsUpdateMandatory": {
 						"type": "boolean"
 					},
+					"Mirrors": {
+						"$ref": "#/definitions/MirrorsArray"
+					},
+					"Weight": {
+						"$ref": "#/definitions/Weight"
+					},
 					"TargetPlatforms": {
 						"$ref": "#/definitions/TargetPlatformsArray"
 					}
@@ -59,6 +96,12 @@ const deploymentConfigSchema = `{
 					"IsUpdateMandatory": {
 						"type": "boolean"
 					},
+					"Mirrors": {
+						"$ref": "#/definitions/MirrorsArray"
+					},
+					"Weight": {
+						"$ref": "#/definitions/Weight"
+					},
 					"TargetPlatforms": {
 						"$ref": "#/definitions/TargetPlatformsArray"
 					},
@@ -71,6 +114,9 @@ const deploymentConfigSchema = `{
 						"items": {
 							"type": "string"
 						}
+					},
+					"BandwidthLimit": {
+						"$ref": "#/definitions/BandwidthLimit"
 					}
 				},
 				"required": [ "BundleInfoURL", "LocalDirectory" ]
@@ -78,6 +124,15 @@ const deploymentConfigSchema = `{
 			"minItems": 1,
 			"uniqueItems": true
 		},
+		"BandwidthLimit": {
+			"$ref": "#/definitions/BandwidthLimit"
+		},
+		"MinConcurrentDownloads": {
+			"$ref": "#/definitions/ConcurrentDownloads"
+		},
+		"MaxConcurrentDownloads": {
+			"$ref": "#/definitions/ConcurrentDownloads"
+		},
 		"Execution": {
 			"type": "object",
 			"properties": {
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// TLSConfig adds trusted certificate authorities and client certificates to the TLS connections of downloads.
+type TLSConfig struct {
+	// Certificates of certificate authorities to trust in addition to those trusted by the system.
+	// Each is either PEM-encoded or the path of a PEM-file.
+	CACertificates []string `json:"CACertificates,omitempty"`
+
+	// Maps host names to the client certificate to present to them if they ask for one.
+	ClientCertificates map[string]*ClientCertificateConfig `json:"ClientCertificates,omitempty"`
+}
+
+type ClientCertificateConfig struct {
+	Certificate string `json:"Certificate"` // PEM-encoded certificate chain or the path of a PEM-file containing it.
+	Key         string `json:"Key"`         // PEM-encoded private key or the path of a PEM-file containing it.
+}
+
+// ReadTLSConfig reads a TLSConfig from the JSON-file at filePath. Relative paths of PEM-files are resolved
+// relative to the directory of the file.
+func ReadTLSConfig(filePath string) (*TLSConfig, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &TLSConfig{}
+	if err = json.Unmarshal(data, tlsConfig); err != nil {
+		return nil, fmt.Errorf("could not parse \"%s\": %w", filePath, err)
+	}
+	directoryPath := filepath.Dir(filePath)
+	for i, caCertificate := range tlsConfig.CACertificates {
+		tlsConfig.CACertificates[i] = resolvePEMPath(caCertificate, directoryPath)
+	}
+	for _, clientCertificate := range tlsConfig.ClientCertificates {
+		if clientCertificate != nil {
+			clientCertificate.Certificate = resolvePEMPath(clientCertificate.Certificate, directoryPath)
+			clientCertificate.Key = resolvePEMPath(clientCertificate.Key, directoryPath)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func resolvePEMPath(pemOrPath string, directoryPath string) string {
+	if pemOrPath == "" || IsPEM(pemOrPath) || filepath.IsAbs(pemOrPath) {
+		return pemOrPath
+	}
+	return filepath.Join(directoryPath, pemOrPath)
+}
+
+// IsPEM returns true if pemOrPath is PEM-encoded data rather than the path of a PEM-file.
+func IsPEM(pemOrPath string) bool {
+	return strings.HasPrefix(strings.TrimSpace(pemOrPath), "-----BEGIN ")
+}
+
+// LoadPEM returns the PEM-encoded data pemOrPath either is or points to.
+func LoadPEM(pemOrPath string) ([]byte, error) {
+	if IsPEM(pemOrPath) {
+		return []byte(pemOrPath), nil
+	}
+	return ioutil.ReadFile(pemOrPath)
+}
+
+// MergedWith returns a TLSConfig which trusts the certificate authorities of both tlsConfig and override and which
+// uses the client certificates of override in place of those of tlsConfig for the same host. Either may be nil.
+func (tlsConfig *TLSConfig) MergedWith(override *TLSConfig) *TLSConfig {
+	merged := &TLSConfig{ClientCertificates: make(map[string]*ClientCertificateConfig)}
+	for _, c := range []*TLSConfig{tlsConfig, override} {
+		if c == nil {
+			continue
+		}
+		merged.CACertificates = append(merged.CACertificates, c.CACertificates...)
+		for host, clientCertificate := range c.ClientCertificates {
+			merged.ClientCertificates[strings.ToLower(host)] = clientCertificate
+		}
+	}
+	return merged
+}
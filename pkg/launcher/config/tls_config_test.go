@@ -0,0 +1,52 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func TestReadTLSConfigResolvesRelativePaths(t *testing.T) {
+	d, err := ioutil.TempDir("", "trivrost-tls-config-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	content := `{"CACertificates": ["ca.pem", "-----BEGIN CERTIFICATE-----\n..."],
+		"ClientCertificates": {"example.com": {"Certificate": "client/cert.pem", "Key": "/etc/client.key"}}}`
+	filePath := filepath.Join(d, "tls-policy.json")
+	if err = ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := config.ReadTLSConfig(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.CACertificates[0] != filepath.Join(d, "ca.pem") || !config.IsPEM(tlsConfig.CACertificates[1]) {
+		t.Errorf("Unexpected CA certificates: %q", tlsConfig.CACertificates)
+	}
+	clientCertificate := tlsConfig.ClientCertificates["example.com"]
+	if clientCertificate.Certificate != filepath.Join(d, "client", "cert.pem") || clientCertificate.Key != "/etc/client.key" {
+		t.Errorf("Unexpected client certificate: %+v", clientCertificate)
+	}
+}
+
+func TestMergeTLSConfigs(t *testing.T) {
+	compiledIn := &config.TLSConfig{CACertificates: []string{"a.pem"}, ClientCertificates: map[string]*config.ClientCertificateConfig{
+		"a.example.com": {Certificate: "a.pem"}, "b.example.com": {Certificate: "b.pem"}}}
+	local := &config.TLSConfig{CACertificates: []string{"c.pem"}, ClientCertificates: map[string]*config.ClientCertificateConfig{
+		"B.example.com": {Certificate: "local.pem"}}}
+	merged := compiledIn.MergedWith(local)
+	if len(merged.CACertificates) != 2 {
+		t.Errorf("Expected CA certificates of both configs. Got %q", merged.CACertificates)
+	}
+	if merged.ClientCertificates["a.example.com"].Certificate != "a.pem" || merged.ClientCertificates["b.example.com"].Certificate != "local.pem" {
+		t.Errorf("Expected local client certificates to override compiled-in ones. Got %+v", merged.ClientCertificates)
+	}
+	if merged = (*config.TLSConfig)(nil).MergedWith(nil); len(merged.CACertificates) != 0 || len(merged.ClientCertificates) != 0 {
+		t.Errorf("Expected empty config. Got %+v", merged)
+	}
+}
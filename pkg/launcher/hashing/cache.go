@@ -0,0 +1,150 @@
+package hashing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/system"
+)
+
+// racyModTimeInterval is how long after its last modification a file's hash is not cached. Without it, a file which is
+// changed again within the resolution of the file system's modification times could keep a stale hash.
+const racyModTimeInterval = time.Second * 2
+
+// hashCache maps the metadata of the files under a path to their hashes, so that files whose metadata did not change
+// since they have last been hashed need not be read again.
+type hashCache struct {
+	RootPath      string                     `json:"RootPath"`
+	HashAlgorithm string                     `json:"HashAlgorithm,omitempty"` // Of all hashes in Files. config.DefaultHashAlgorithm if empty.
+	VerifiedAt    time.Time                  `json:"VerifiedAt"`              // When all files have last been hashed instead of being looked up.
+	Files         map[string]*cachedFileHash `json:"Files"`                   // Keys are file paths relative to RootPath.
+
+	previousFiles map[string]*cachedFileHash
+	startedAt     time.Time
+	mutex         sync.Mutex // Guards Files and the counts, which are updated by multiple goroutines.
+	lookUpCount   int
+	hashCount     int
+}
+
+type cachedFileHash struct {
+	Size          int64  `json:"Size"`
+	ModTime       int64  `json:"ModTime"` // In nanoseconds since the Unix epoch.
+	FileID        uint64 `json:"FileID"`  // See system.GetFileID(). 0 if unknown.
+	Hash          string `json:"Hash"`
+	HashAlgorithm string `json:"HashAlgorithm"` // Entries hashed with another algorithm, or which predate this field, are misses.
+}
+
+// MustHashWithCache is like MustHashWithProgress, but looks up the hashes of files whose size, modification time and file ID did not
+// change since they have last been hashed in the cache file at cacheFilePath, which it updates afterwards. If all files
+// have last been hashed more than fullVerificationInterval ago, or if fullVerificationInterval is <= 0, all of them are
+// hashed again, as are all files if the cache holds hashes of another algorithm. Problems with the cache file are logged,
+// but otherwise ignored. onProgress may be nil.
+func MustHashWithCache(ctx context.Context, hashFilePath, cacheFilePath, hashAlgorithm string, fullVerificationInterval time.Duration,
+	onProgress ProgressFunc) config.FileInfoMap {
+	hashAlgorithm = config.NormalizeHashAlgorithm(hashAlgorithm)
+	log.Infof("Hash \"%s\" with %s and cache \"%s\".", hashFilePath, hashAlgorithm, cacheFilePath)
+	cache := loadHashCache(cacheFilePath, hashFilePath, hashAlgorithm, fullVerificationInterval)
+	fileMap := mustHashRelativelyWithCache(ctx, ioutil.ReadDir, fopen, stat, hashFilePath, hashAlgorithm, cache, onProgress)
+	log.Infof("Looked up the hashes of %d files and hashed %d files in \"%s\".", cache.lookUpCount, cache.hashCount, hashFilePath)
+	cache.save(cacheFilePath)
+	return fileMap
+}
+
+// CachedHashAlgorithm returns the algorithm of the hashes in the cache file at cacheFilePath, or config.DefaultHashAlgorithm
+// if there is no readable cache file. It is the best guess for the algorithm a bundle needs to be hashed with before its
+// bundle info has been retrieved.
+func CachedHashAlgorithm(cacheFilePath string) string {
+	cache, err := readHashCache(cacheFilePath)
+	if err != nil {
+		return config.DefaultHashAlgorithm
+	}
+	return config.NormalizeHashAlgorithm(cache.HashAlgorithm)
+}
+
+func readHashCache(cacheFilePath string) (*hashCache, error) {
+	data, err := ioutil.ReadFile(cacheFilePath)
+	if err != nil {
+		return nil, err
+	}
+	cache := &hashCache{}
+	if err = json.Unmarshal(data, cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+func loadHashCache(cacheFilePath, rootPath, hashAlgorithm string, fullVerificationInterval time.Duration) *hashCache {
+	cache := &hashCache{RootPath: rootPath, HashAlgorithm: hashAlgorithm, Files: make(map[string]*cachedFileHash), startedAt: time.Now()}
+	previousCache, err := readHashCache(cacheFilePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Could not read hash cache \"%s\": %v", cacheFilePath, err)
+		}
+	} else if previousCache.RootPath != rootPath {
+		log.Infof("Not using hash cache \"%s\" because it belongs to \"%s\".", cacheFilePath, previousCache.RootPath)
+	} else if config.NormalizeHashAlgorithm(previousCache.HashAlgorithm) != hashAlgorithm {
+		log.Infof("Not using hash cache \"%s\" because it holds %s hashes.", cacheFilePath, config.NormalizeHashAlgorithm(previousCache.HashAlgorithm))
+	} else if fullVerificationInterval <= 0 || cache.startedAt.Sub(previousCache.VerifiedAt) >= fullVerificationInterval {
+		log.Infof("Verifying all files of \"%s\", which have last been verified at %v.", rootPath, previousCache.VerifiedAt)
+	} else {
+		cache.VerifiedAt, cache.previousFiles = previousCache.VerifiedAt, previousCache.Files
+		return cache
+	}
+	cache.VerifiedAt = cache.startedAt
+	return cache
+}
+
+func (cache *hashCache) save(cacheFilePath string) {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		panic(err)
+	}
+	if err = system.PutFileAtomically(cacheFilePath, data); err != nil {
+		log.Warnf("Could not write hash cache: %v", err)
+	}
+}
+
+// calculateHash is like the function of the same name, but looks the hash up in the cache if possible. cache may be nil.
+func (cache *hashCache) calculateHash(ctx context.Context, filePath string, info os.FileInfo, hashAlgorithm string, readFile readFileFunc,
+	onProgress ProgressFunc) (hash string, n int64, err error) {
+	if cache == nil {
+		return calculateHash(ctx, filePath, hashAlgorithm, readFile, onProgress)
+	}
+	relativePath, err := filepath.Rel(cache.RootPath, filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	fileID, _ := system.GetFileID(filePath, info)
+	entry := &cachedFileHash{Size: info.Size(), ModTime: info.ModTime().UnixNano(), FileID: fileID, HashAlgorithm: hashAlgorithm}
+	if previousEntry := cache.previousFiles[relativePath]; previousEntry != nil && previousEntry.Size == entry.Size &&
+		previousEntry.ModTime == entry.ModTime && previousEntry.FileID == entry.FileID && previousEntry.HashAlgorithm == entry.HashAlgorithm {
+		cache.mutex.Lock()
+		cache.Files[relativePath] = previousEntry
+		cache.lookUpCount++
+		cache.mutex.Unlock()
+		if onProgress != nil {
+			onProgress(uint64(previousEntry.Size))
+		}
+		return previousEntry.Hash, previousEntry.Size, nil
+	}
+	hash, n, err = calculateHash(ctx, filePath, hashAlgorithm, readFile, onProgress)
+	if err != nil {
+		return "", n, err
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.hashCount++
+	if n == entry.Size && info.ModTime().Before(cache.startedAt.Add(-racyModTimeInterval)) {
+		entry.Hash = hash
+		cache.Files[relativePath] = entry
+	}
+	return hash, n, nil
+}
@@ -0,0 +1,121 @@
+package hashing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func writeFileModifiedAt(t *testing.T, filePath, content string, modTime time.Time) {
+	if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMustHashWithCacheOnlyHashesChangedFiles(t *testing.T) {
+	bundlePath, cacheFilePath := t.TempDir(), filepath.Join(t.TempDir(), "bundle.json")
+	modTime := time.Now().Add(-time.Hour)
+	writeFileModifiedAt(t, filepath.Join(bundlePath, "foo"), "abc", modTime)
+	writeFileModifiedAt(t, filepath.Join(bundlePath, "bar"), "def", modTime)
+	fileMap := MustHashWithCache(context.Background(), bundlePath, cacheFilePath, config.HashAlgorithmSHA256, time.Hour, nil)
+	if fileMap["foo"].Hash != infoForContent["abc"].Hash || fileMap["bar"].Hash != infoForContent["def"].Hash {
+		t.Fatalf("Unexpected hashes: %v", fileMap)
+	}
+
+	// Changing content without changing size and modification time goes unnoticed until the next full verification.
+	writeFileModifiedAt(t, filepath.Join(bundlePath, "foo"), "ghi", modTime)
+	writeFileModifiedAt(t, filepath.Join(bundlePath, "bar"), "jkl", modTime.Add(time.Second))
+	fileMap = MustHashWithCache(context.Background(), bundlePath, cacheFilePath, config.HashAlgorithmSHA256, time.Hour, nil)
+	if fileMap["foo"].Hash != infoForContent["abc"].Hash {
+		t.Errorf("Expected hash of unchanged file to be looked up. Got %s", fileMap["foo"].Hash)
+	}
+	if fileMap["bar"].Hash != infoForContent["jkl"].Hash {
+		t.Errorf("Expected file with new modification time to be hashed. Got %s", fileMap["bar"].Hash)
+	}
+
+	fileMap = MustHashWithCache(context.Background(), bundlePath, cacheFilePath, config.HashAlgorithmSHA256, 0, nil)
+	if fileMap["foo"].Hash != infoForContent["ghi"].Hash {
+		t.Errorf("Expected full verification to hash all files. Got %s", fileMap["foo"].Hash)
+	}
+}
+
+func TestMustHashWithCacheDoesNotCacheRecentlyModifiedFiles(t *testing.T) {
+	bundlePath, cacheFilePath := t.TempDir(), filepath.Join(t.TempDir(), "bundle.json")
+	modTime := time.Now()
+	writeFileModifiedAt(t, filepath.Join(bundlePath, "foo"), "abc", modTime)
+	MustHashWithCache(context.Background(), bundlePath, cacheFilePath, config.HashAlgorithmSHA256, time.Hour, nil)
+
+	writeFileModifiedAt(t, filepath.Join(bundlePath, "foo"), "def", modTime)
+	if fileMap := MustHashWithCache(context.Background(), bundlePath, cacheFilePath, config.HashAlgorithmSHA256, time.Hour, nil); fileMap["foo"].Hash != infoForContent["def"].Hash {
+		t.Errorf("Expected recently modified file to be hashed again. Got %s", fileMap["foo"].Hash)
+	}
+}
+
+func TestMustHashWithCacheRehashesFilesWithOtherAlgorithm(t *testing.T) {
+	bundlePath, cacheFilePath := t.TempDir(), filepath.Join(t.TempDir(), "bundle.json")
+	writeFileModifiedAt(t, filepath.Join(bundlePath, "foo"), "abc", time.Now().Add(-time.Hour))
+	if algorithm := CachedHashAlgorithm(cacheFilePath); algorithm != config.DefaultHashAlgorithm {
+		t.Errorf("Expected default algorithm without cache. Got %s", algorithm)
+	}
+	MustHashWithCache(context.Background(), bundlePath, cacheFilePath, config.HashAlgorithmSHA256, time.Hour, nil)
+
+	fileMap := MustHashWithCache(context.Background(), bundlePath, cacheFilePath, config.HashAlgorithmBLAKE3, time.Hour, nil)
+	const blake3OfABC = "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"
+	if fileMap["foo"].Hash != blake3OfABC || fileMap["foo"].HashAlgorithm != config.HashAlgorithmBLAKE3 {
+		t.Errorf("Expected BLAKE3 hash %s. Got %s hash %s", blake3OfABC, fileMap["foo"].HashAlgorithm, fileMap["foo"].Hash)
+	}
+	if algorithm := CachedHashAlgorithm(cacheFilePath); algorithm != config.HashAlgorithmBLAKE3 {
+		t.Errorf("Expected cache to hold BLAKE3 hashes. Got %s", algorithm)
+	}
+}
+
+func TestMustHashWithCacheMissesEntriesOfOtherAlgorithm(t *testing.T) {
+	for name, rewriteEntry := range map[string]func(entry map[string]interface{}){
+		"entry predating HashAlgorithm": func(entry map[string]interface{}) {
+			entry["SHA256"] = entry["Hash"]
+			delete(entry, "Hash")
+			delete(entry, "HashAlgorithm")
+		},
+		"entry of other algorithm": func(entry map[string]interface{}) {
+			entry["HashAlgorithm"] = config.HashAlgorithmBLAKE3
+		},
+	} {
+		bundlePath, cacheFilePath := t.TempDir(), filepath.Join(t.TempDir(), "bundle.json")
+		modTime := time.Now().Add(-time.Hour)
+		writeFileModifiedAt(t, filepath.Join(bundlePath, "foo"), "abc", modTime)
+		MustHashWithCache(context.Background(), bundlePath, cacheFilePath, config.HashAlgorithmSHA256, time.Hour, nil)
+
+		data, err := ioutil.ReadFile(cacheFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache := make(map[string]interface{})
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber() // Keeps modification times in nanoseconds exact.
+		if err = decoder.Decode(&cache); err != nil {
+			t.Fatal(err)
+		}
+		rewriteEntry(cache["Files"].(map[string]interface{})["foo"].(map[string]interface{}))
+		if data, err = json.Marshal(cache); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(cacheFilePath, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		writeFileModifiedAt(t, filepath.Join(bundlePath, "foo"), "def", modTime)
+		if fileMap := MustHashWithCache(context.Background(), bundlePath, cacheFilePath, config.HashAlgorithmSHA256, time.Hour, nil); fileMap["foo"].Hash != infoForContent["def"].Hash {
+			t.Errorf("Expected file with %s to be hashed again. Got %s", name, fileMap["foo"].Hash)
+		}
+	}
+}
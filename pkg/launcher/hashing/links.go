@@ -0,0 +1,55 @@
+package hashing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// LinksAndDirectories describes the symbolic links and directories within a folder. Paths are relative to the folder and,
+// like the targets of the links, use forward slashes.
+type LinksAndDirectories struct {
+	Symlinks         map[string]string // Values are the targets of the links as they are stored.
+	Directories      []string          // All directories within the folder, sorted.
+	EmptyDirectories []string          // The directories which contain nothing at all, sorted.
+}
+
+// MustListLinksAndDirectories returns the symbolic links and directories within the folder at folderPath and its subfolders,
+// which MustHash skips. Symbolic links are not followed. The result is empty if there is no folder at folderPath.
+func MustListLinksAndDirectories(folderPath string) *LinksAndDirectories {
+	result := &LinksAndDirectories{Symlinks: make(map[string]string)}
+	if info, err := os.Stat(folderPath); err != nil || !info.IsDir() {
+		if err != nil && !os.IsNotExist(err) {
+			panic(fmt.Errorf("Failed listing folder \"%s\": %w", folderPath, err))
+		}
+		return result
+	}
+	result.appendEntriesInDir(folderPath, "")
+	sort.Strings(result.Directories)
+	sort.Strings(result.EmptyDirectories)
+	return result
+}
+
+func (result *LinksAndDirectories) appendEntriesInDir(folderPath, relativeDirPath string) {
+	infos := mustReadDir(ioutil.ReadDir, filepath.Join(folderPath, filepath.FromSlash(relativeDirPath)))
+	if len(infos) == 0 && relativeDirPath != "" {
+		result.EmptyDirectories = append(result.EmptyDirectories, relativeDirPath)
+	}
+	for _, info := range infos {
+		relativePath := path.Join(relativeDirPath, info.Name())
+		if info.Mode()&os.ModeSymlink != 0 {
+			linkPath := filepath.Join(folderPath, filepath.FromSlash(relativePath))
+			target, err := os.Readlink(linkPath)
+			if err != nil {
+				panic(fmt.Errorf("Could not read symbolic link \"%s\": %w", linkPath, err))
+			}
+			result.Symlinks[relativePath] = filepath.ToSlash(target)
+		} else if info.IsDir() {
+			result.Directories = append(result.Directories, relativePath)
+			result.appendEntriesInDir(folderPath, relativePath)
+		}
+	}
+}
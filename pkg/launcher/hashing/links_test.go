@@ -0,0 +1,46 @@
+package hashing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+func symlinkOrSkip(t *testing.T, target, linkPath string) {
+	if err := os.Symlink(target, linkPath); err != nil {
+		t.Skipf("Cannot create symbolic links: %v", err)
+	}
+}
+
+func TestMustListLinksAndDirectoriesDoesNotFollowLinks(t *testing.T) {
+	bundlePath := t.TempDir()
+	for _, directoryPath := range []string{"lib/real", "logs", "cache/empty"} {
+		if err := os.MkdirAll(filepath.Join(bundlePath, filepath.FromSlash(directoryPath)), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFileModifiedAt(t, filepath.Join(bundlePath, "lib", "real", "libjvm.so"), "abc", time.Now())
+	symlinkOrSkip(t, filepath.Join("real", "libjvm.so"), filepath.Join(bundlePath, "lib", "libjvm.so"))
+	symlinkOrSkip(t, "lib", filepath.Join(bundlePath, "bin"))
+
+	result := MustListLinksAndDirectories(bundlePath)
+	if expected := map[string]string{"lib/libjvm.so": "real/libjvm.so", "bin": "lib"}; !reflect.DeepEqual(result.Symlinks, expected) {
+		t.Errorf("Expected symlinks %v. Got %v", expected, result.Symlinks)
+	}
+	if expected := []string{"cache", "cache/empty", "lib", "lib/real", "logs"}; !reflect.DeepEqual(result.Directories, expected) {
+		t.Errorf("Expected directories %v. Got %v", expected, result.Directories)
+	}
+	if expected := []string{"cache/empty", "logs"}; !reflect.DeepEqual(result.EmptyDirectories, expected) {
+		t.Errorf("Expected empty directories %v. Got %v", expected, result.EmptyDirectories)
+	}
+
+	fileMap := MustHash(context.Background(), bundlePath, config.HashAlgorithmSHA256)
+	if len(fileMap) != 1 || fileMap[filepath.Join("lib", "real", "libjvm.so")] == nil {
+		t.Errorf("Expected only the file itself to be hashed, not the symlinks to it. Got %v", fileMap)
+	}
+}
@@ -2,16 +2,18 @@ package hashing
 
 import (
 	"context"
-	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"github.com/setlog/trivrost/pkg/misc"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
 
 	"github.com/setlog/trivrost/pkg/launcher/config"
+	"github.com/setlog/trivrost/pkg/misc"
+	"github.com/setlog/trivrost/pkg/system"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -23,16 +25,78 @@ func stat(filePath string) (os.FileInfo, error) {
 	return os.Stat(filePath)
 }
 
-func MustHash(ctx context.Context, hashFilePath string) config.FileInfoMap {
-	log.Infof("Hash \"%s\".", hashFilePath)
-	return mustHashRelatively(ctx, ioutil.ReadDir, fopen, stat, hashFilePath)
+// ProgressFunc is called with the number of bytes which have been hashed, or whose hashes have been looked up, since it
+// has last been called. It is called from multiple goroutines at once.
+type ProgressFunc func(byteCount uint64)
+
+// maxConcurrency bounds the number of files which are hashed at once. More would rather keep disks busy seeking than
+// speed hashing up any further.
+const maxConcurrency = 8
+
+// MustHash returns the hashes of the file at hashFilePath or of all files in the folder at hashFilePath, with paths
+// relative to hashFilePath, calculated with the given algorithm (see config.NewHash()). Files are hashed in parallel.
+func MustHash(ctx context.Context, hashFilePath, hashAlgorithm string) config.FileInfoMap {
+	return MustHashWithProgress(ctx, hashFilePath, hashAlgorithm, nil)
+}
+
+// MustHashWithProgress is like MustHash, but reports progress to onProgress, which may be nil.
+func MustHashWithProgress(ctx context.Context, hashFilePath, hashAlgorithm string, onProgress ProgressFunc) config.FileInfoMap {
+	log.Infof("Hash \"%s\" with %s.", hashFilePath, config.NormalizeHashAlgorithm(hashAlgorithm))
+	return mustHashRelativelyWithCache(ctx, ioutil.ReadDir, fopen, stat, hashFilePath, hashAlgorithm, nil, onProgress)
+}
+
+// MustGetSize returns the number of bytes MustHash would hash at hashFilePath, i.e. the size of the file or the total size
+// of all files in the folder at hashFilePath, without reading any of them.
+func MustGetSize(hashFilePath string) uint64 {
+	files := mustListFiles(ioutil.ReadDir, stat, hashFilePath)
+	var total uint64
+	for _, file := range files {
+		total += uint64(file.info.Size())
+	}
+	return total
 }
 
 type readDirFunc func(dirPath string) ([]os.FileInfo, error)
 type readFileFunc func(filePath string) (io.ReadCloser, error)
 type statFunc func(filePath string) (os.FileInfo, error)
 
+type fileToHash struct {
+	path string
+	info os.FileInfo
+}
+
 func mustHashRelatively(ctx context.Context, readDir readDirFunc, readFile readFileFunc, stat statFunc, hashFilePath string) config.FileInfoMap {
+	return mustHashRelativelyWithCache(ctx, readDir, readFile, stat, hashFilePath, config.DefaultHashAlgorithm, nil, nil)
+}
+
+func mustHashRelativelyWithCache(ctx context.Context, readDir readDirFunc, readFile readFileFunc, stat statFunc, hashFilePath, hashAlgorithm string,
+	cache *hashCache, onProgress ProgressFunc) config.FileInfoMap {
+	hashAlgorithm = config.NormalizeHashAlgorithm(hashAlgorithm)
+	files := mustListFiles(readDir, stat, hashFilePath)
+	if files == nil {
+		return nil
+	}
+	fileInfos, err := hashFiles(ctx, files, readFile, hashAlgorithm, cache, onProgress)
+	if err != nil {
+		panic(err)
+	}
+	fileMap := make(config.FileInfoMap)
+	for i, file := range files {
+		rel, err := filepath.Rel(hashFilePath, file.path)
+		if err != nil {
+			panic(fmt.Errorf("Could not create relative path for \"%s\" in \"%s\": %w", file.path, hashFilePath, err))
+		}
+		if rel == "." {
+			rel = ""
+		}
+		fileMap[rel] = fileInfos[i]
+	}
+	return fileMap
+}
+
+// mustListFiles returns the file at hashFilePath or all files in the folder at hashFilePath and its subfolders, without
+// following symbolic links within the folder. It returns nil if there is nothing at hashFilePath.
+func mustListFiles(readDir readDirFunc, stat statFunc, hashFilePath string) []fileToHash {
 	info, err := stat(hashFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -41,40 +105,89 @@ func mustHashRelatively(ctx context.Context, readDir readDirFunc, readFile readF
 		panic(fmt.Errorf("Failed hashing file or folder \"%s\": %w", hashFilePath, err))
 	}
 	if !info.IsDir() {
-		fileInfo := &config.FileInfo{}
-		fileInfo.SHA256, fileInfo.Size, err = calculateSha256(ctx, hashFilePath, readFile)
-		if err != nil {
-			panic(fmt.Errorf("failed hashing file \"%s\": %w", hashFilePath, err))
-		}
-		return config.FileInfoMap{"": fileInfo}
+		return []fileToHash{{path: hashFilePath, info: info}}
 	}
-	fileMap := mustHashDir(ctx, readDir, readFile, stat, hashFilePath)
-	fileMapR := make(config.FileInfoMap)
-	for k, v := range fileMap {
-		rel, err := filepath.Rel(hashFilePath, k)
-		if err != nil {
-			panic(fmt.Errorf("Could not create relative path for \"%s\" in \"%s\": %w", k, hashFilePath, err))
-		}
-		fileMapR[rel] = v
-	}
-	return fileMapR
+	return appendFilesInDir(make([]fileToHash, 0), readDir, hashFilePath)
 }
 
-func mustHashDir(ctx context.Context, readDir readDirFunc, readFile readFileFunc, stat statFunc, hashFilePath string) config.FileInfoMap {
-	fm := make(config.FileInfoMap)
-	for _, info := range mustReadDir(readDir, hashFilePath) {
+func appendFilesInDir(files []fileToHash, readDir readDirFunc, dirPath string) []fileToHash {
+	for _, info := range mustReadDir(readDir, dirPath) {
+		if info.Mode()&os.ModeSymlink != 0 {
+			continue // See MustListLinksAndDirectories().
+		}
 		if info.IsDir() {
-			fm.Join(mustHashDir(ctx, readDir, readFile, stat, filepath.Join(hashFilePath, info.Name())))
+			files = appendFilesInDir(files, readDir, filepath.Join(dirPath, info.Name()))
 		} else {
-			filePath := filepath.Join(hashFilePath, info.Name())
-			sha, size, err := calculateSha256(ctx, filePath, readFile)
-			if err != nil {
-				panic(fmt.Errorf("failed hashing file \"%s\": %w", hashFilePath, err))
+			files = append(files, fileToHash{path: filepath.Join(dirPath, info.Name()), info: info})
+		}
+	}
+	return files
+}
+
+// hashFiles hashes files with up to maxConcurrency goroutines and returns their hashes in the same order. It stops at the
+// first error, which it returns, or once ctx is done.
+func hashFiles(ctx context.Context, files []fileToHash, readFile readFileFunc, hashAlgorithm string, cache *hashCache, onProgress ProgressFunc) ([]*config.FileInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fileInfos := make([]*config.FileInfo, len(files))
+	indices := make(chan int)
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrencyFor(len(files)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				file := files[index]
+				hash, size, err := cache.calculateHash(ctx, file.path, file.info, hashAlgorithm, readFile, onProgress)
+				if err != nil {
+					select {
+					case errs <- fmt.Errorf("failed hashing file \"%s\": %w", file.path, err):
+					default:
+					}
+					cancel()
+					continue
+				}
+				fileInfos[index] = &config.FileInfo{Hash: hash, HashAlgorithm: hashAlgorithm, Size: size, Mode: fileModeOf(file.info)}
 			}
-			fm[filePath] = &config.FileInfo{SHA256: sha, Size: size}
+		}()
+	}
+dispatch:
+	for index := range files {
+		select {
+		case indices <- index:
+		case <-ctx.Done():
+			break dispatch
 		}
 	}
-	return fm
+	close(indices)
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+		return fileInfos, ctx.Err()
+	}
+}
+
+// fileModeOf returns the mode of the file described by info in the format of config.FileInfo.Mode, or "" if the operating
+// system does not support file modes.
+func fileModeOf(info os.FileInfo) string {
+	if !system.SupportsFileModes {
+		return ""
+	}
+	return config.FormatFileMode(info.Mode())
+}
+
+func concurrencyFor(fileCount int) int {
+	concurrency := runtime.NumCPU()
+	if concurrency > maxConcurrency {
+		concurrency = maxConcurrency
+	}
+	if concurrency > fileCount {
+		concurrency = fileCount
+	}
+	return concurrency
 }
 
 func mustReadDir(readDir readDirFunc, directoryPath string) []os.FileInfo {
@@ -89,19 +202,41 @@ func mustReadDir(readDir readDirFunc, directoryPath string) []os.FileInfo {
 }
 
 func CalculateSha256(ctx context.Context, filePath string) (sha string, n int64, err error) {
-	return calculateSha256(ctx, filePath, fopen)
+	return CalculateHash(ctx, filePath, config.HashAlgorithmSHA256)
+}
+
+// CalculateHash returns the hex-encoded hash of the file at filePath, calculated with the given algorithm (see
+// config.NewHash()), and its size.
+func CalculateHash(ctx context.Context, filePath, hashAlgorithm string) (hash string, n int64, err error) {
+	return calculateHash(ctx, filePath, hashAlgorithm, fopen, nil)
 }
 
-func calculateSha256(ctx context.Context, filePath string, readFile readFileFunc) (sha string, n int64, err error) {
+func calculateHash(ctx context.Context, filePath, hashAlgorithm string, readFile readFileFunc, onProgress ProgressFunc) (hash string, n int64, err error) {
 	file, err := readFile(filePath)
 	if err != nil {
 		return "", n, fmt.Errorf("could not open file \"%s\": %w", filePath, err)
 	}
 	defer file.Close()
-	hash := sha256.New()
-	if n, err = misc.IOCopyWithContext(ctx, hash, file); err != nil {
+	var reader io.Reader = file
+	if onProgress != nil {
+		reader = &progressReader{reader: file, onProgress: onProgress}
+	}
+	hasher := config.NewHash(hashAlgorithm)
+	if n, err = misc.IOCopyWithContext(ctx, hasher, reader); err != nil {
 		return "", n, fmt.Errorf("could not read file \"%s\": %w", filePath, err)
 	}
-	shaSlice := hash.Sum(nil)
-	return hex.EncodeToString(shaSlice), n, nil
+	return hex.EncodeToString(hasher.Sum(nil)), n, nil
+}
+
+type progressReader struct {
+	reader     io.Reader
+	onProgress ProgressFunc
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.onProgress(uint64(n))
+	}
+	return n, err
 }
@@ -3,24 +3,29 @@ package hashing
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sync/atomic"
 	"testing"
 
 	"github.com/setlog/trivrost/pkg/dummy"
 	"github.com/setlog/trivrost/pkg/launcher/config"
 )
 
+var dummyMode = fileModeOf(dummy.NewFileInfo("", false))
+
 var infoForContent = config.FileInfoMap{
-	"abc": &config.FileInfo{SHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", Size: 3},
-	"def": &config.FileInfo{SHA256: "cb8379ac2098aa165029e3938a51da0bcecfc008fd6795f401178647f96c5b34", Size: 3},
-	"ghi": &config.FileInfo{SHA256: "50ae61e841fac4e8f9e40baf2ad36ec868922ea48368c18f9535e47db56dd7fb", Size: 3},
-	"jkl": &config.FileInfo{SHA256: "268f277c6d766d31334fda0f7a5533a185598d269e61c76a805870244828a5f1", Size: 3},
-	"mno": &config.FileInfo{SHA256: "cf63b8eb216845d24edd4b249b146957b42199cd12759647df90cb57525b4e90", Size: 3},
-	"pqr": &config.FileInfo{SHA256: "d24bd97b5fb24761112354dec329c70a5c6e2dedcc9a6df160eefd1d671efe56", Size: 3},
+	"abc": &config.FileInfo{Hash: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", HashAlgorithm: config.HashAlgorithmSHA256, Size: 3, Mode: dummyMode},
+	"def": &config.FileInfo{Hash: "cb8379ac2098aa165029e3938a51da0bcecfc008fd6795f401178647f96c5b34", HashAlgorithm: config.HashAlgorithmSHA256, Size: 3, Mode: dummyMode},
+	"ghi": &config.FileInfo{Hash: "50ae61e841fac4e8f9e40baf2ad36ec868922ea48368c18f9535e47db56dd7fb", HashAlgorithm: config.HashAlgorithmSHA256, Size: 3, Mode: dummyMode},
+	"jkl": &config.FileInfo{Hash: "268f277c6d766d31334fda0f7a5533a185598d269e61c76a805870244828a5f1", HashAlgorithm: config.HashAlgorithmSHA256, Size: 3, Mode: dummyMode},
+	"mno": &config.FileInfo{Hash: "cf63b8eb216845d24edd4b249b146957b42199cd12759647df90cb57525b4e90", HashAlgorithm: config.HashAlgorithmSHA256, Size: 3, Mode: dummyMode},
+	"pqr": &config.FileInfo{Hash: "d24bd97b5fb24761112354dec329c70a5c6e2dedcc9a6df160eefd1d671efe56", HashAlgorithm: config.HashAlgorithmSHA256, Size: 3, Mode: dummyMode},
 }
 
 func TestMustHashRelatively(t *testing.T) {
@@ -31,6 +36,38 @@ func TestMustHashRelatively(t *testing.T) {
 	}
 }
 
+func TestMustHashWithProgressReportsAllBytes(t *testing.T) {
+	dirPath := t.TempDir()
+	for i := 0; i < 50; i++ {
+		subDirPath := filepath.Join(dirPath, fmt.Sprintf("dir%d", i%5))
+		if err := os.MkdirAll(subDirPath, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(subDirPath, fmt.Sprintf("file%d", i)), bytes.Repeat([]byte("x"), i*1000), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var progress uint64
+	fileMap := MustHashWithProgress(context.Background(), dirPath, config.HashAlgorithmSHA256, func(byteCount uint64) { atomic.AddUint64(&progress, byteCount) })
+	if len(fileMap) != 50 || fileMap[filepath.Join("dir1", "file1")].Size != 1000 {
+		t.Errorf("Expected 50 hashed files. Got %v", fileMap)
+	}
+	if size := MustGetSize(dirPath); progress != size || size != 1225000 {
+		t.Errorf("Expected progress of %d bytes to match size 1225000. Got %d", progress, size)
+	}
+}
+
+func TestMustHashStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	defer func() {
+		if err, ok := recover().(error); !ok || !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected panic with context.Canceled. Got %v", err)
+		}
+	}()
+	mustHashRelatively(ctx, dummyListDirectory, dummyReadFile, dummyStatFile, "x")
+}
+
 func dummyListDirectory(dirPath string) ([]os.FileInfo, error) {
 	switch dirPath {
 	case "x":
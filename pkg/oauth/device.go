@@ -0,0 +1,72 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+var (
+	defaultPollInterval = time.Second * 5 // RFC 8628, section 3.2.
+	slowDownIncrement   = time.Second * 5 // RFC 8628, section 3.5.
+)
+
+// DeviceAuthorization is the response of a device authorization endpoint. The user has to visit VerificationURI and
+// enter UserCode there, or visit VerificationURIComplete if present.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
+// AuthorizeDevice starts the device authorization grant.
+func (client *Client) AuthorizeDevice(ctx context.Context) (*DeviceAuthorization, error) {
+	values := url.Values{}
+	if scope := client.scope(); scope != "" {
+		values.Set("scope", scope)
+	}
+	authorization := &DeviceAuthorization{}
+	if err := client.postForm(ctx, client.config.DeviceAuthorizationURL, values, authorization); err != nil {
+		return nil, err
+	}
+	if authorization.DeviceCode == "" || authorization.UserCode == "" || authorization.VerificationURI == "" {
+		return nil, fmt.Errorf("device authorization response of \"%s\" is incomplete", client.config.DeviceAuthorizationURL)
+	}
+	return authorization, nil
+}
+
+// AwaitDeviceToken polls the token endpoint until the user has approved or denied authorization, or until it expires.
+func (client *Client) AwaitDeviceToken(ctx context.Context, authorization *DeviceAuthorization) (*Token, error) {
+	interval := defaultPollInterval
+	if authorization.Interval > 0 {
+		interval = time.Duration(authorization.Interval) * time.Second
+	}
+	if authorization.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(authorization.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	values := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:device_code"}, "device_code": {authorization.DeviceCode}}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("device authorization was not completed in time: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+		token, err := client.requestToken(ctx, values)
+		if oauthErr, ok := err.(*Error); ok {
+			switch oauthErr.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += slowDownIncrement
+				continue
+			}
+		}
+		return token, err
+	}
+}
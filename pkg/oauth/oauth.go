@@ -0,0 +1,131 @@
+// Package oauth signs users in with OAuth 2.0 for native applications, either with the device authorization grant or with
+// the authorization code grant secured with PKCE, and keeps their access tokens fresh.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+// Access tokens are refreshed this long before they expire, so that requests do not race their expiry.
+const expiryLeeway = time.Second * 30
+
+// Token is the response of a token endpoint.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+
+	Expiry time.Time `json:"-"` // Zero if the access token does not expire.
+}
+
+func (token *Token) isExpired() bool {
+	return !token.Expiry.IsZero() && time.Now().After(token.Expiry)
+}
+
+// Error is an error response of an authorization server as defined in RFC 6749, section 5.2.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (err *Error) Error() string {
+	if err.Description != "" {
+		return fmt.Sprintf("authorization server responded with error \"%s\": %s", err.Code, err.Description)
+	}
+	return fmt.Sprintf("authorization server responded with error \"%s\"", err.Code)
+}
+
+// IsInvalidGrant returns true if err is an Error telling that a refresh token or authorization code is not valid (anymore).
+func IsInvalidGrant(err error) bool {
+	var oauthErr *Error
+	return errors.As(err, &oauthErr) && oauthErr.Code == "invalid_grant"
+}
+
+// Client talks to the authorization server of an OAuthConfig.
+type Client struct {
+	config     *config.OAuthConfig
+	httpClient *http.Client
+}
+
+// NewClient returns a Client which sends its requests with httpClient. The requests must not carry other credentials.
+func NewClient(oauthConfig *config.OAuthConfig, httpClient *http.Client) *Client {
+	return &Client{config: oauthConfig, httpClient: httpClient}
+}
+
+// Refresh exchanges refreshToken for a new access token. If the response carries no new refresh token, the returned
+// Token keeps refreshToken.
+func (client *Client) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	token, err := client.requestToken(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}})
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return token, nil
+}
+
+func (client *Client) requestToken(ctx context.Context, values url.Values) (*Token, error) {
+	token := &Token{}
+	if err := client.postForm(ctx, client.config.TokenURL, values, token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token response of \"%s\" contains no access token", client.config.TokenURL)
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return nil, fmt.Errorf("token response of \"%s\" contains unsupported token type \"%s\"", client.config.TokenURL, token.TokenType)
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - expiryLeeway)
+	}
+	return token, nil
+}
+
+// postForm posts values and the client ID to endpointURL and decodes the JSON-response into result. Error responses
+// of the authorization server are returned as *Error.
+func (client *Client) postForm(ctx context.Context, endpointURL string, values url.Values, result interface{}) error {
+	values.Set("client_id", client.config.ClientID)
+	req, err := http.NewRequest(http.MethodPost, endpointURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response of \"%s\": %w", endpointURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		oauthErr := &Error{}
+		if json.Unmarshal(data, oauthErr) == nil && oauthErr.Code != "" {
+			return oauthErr
+		}
+		return fmt.Errorf("\"%s\" responded with status %d", endpointURL, resp.StatusCode)
+	}
+	if err = json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("could not parse response of \"%s\": %w", endpointURL, err)
+	}
+	return nil
+}
+
+func (client *Client) scope() string {
+	return strings.Join(client.config.Scopes, " ")
+}
@@ -0,0 +1,209 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/launcher/config"
+)
+
+// testAuthorizationServer is a stand-in authorization server which approves device codes after a number of polls and
+// hands out authorization codes to whoever asks.
+type testAuthorizationServer struct {
+	*httptest.Server
+	mutex             sync.Mutex
+	pendingPolls      int
+	codeChallenge     string
+	refreshTokenCount int
+	revoked           bool
+}
+
+func newTestAuthorizationServer(t *testing.T) *testAuthorizationServer {
+	server := &testAuthorizationServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &DeviceAuthorization{DeviceCode: "device-code", UserCode: "ABCD-EFGH",
+			VerificationURI: server.URL + "/activate", ExpiresIn: 60})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		server.codeChallenge = r.URL.Query().Get("code_challenge")
+		server.mutex.Unlock()
+		redirectURI, _ := url.Parse(r.URL.Query().Get("redirect_uri"))
+		redirectURI.RawQuery = url.Values{"code": {"authorization-code"}, "state": {r.URL.Query().Get("state")}}.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", server.handleToken)
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *testAuthorizationServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if r.PostFormValue("client_id") != "trivrost" {
+		writeJSON(w, http.StatusUnauthorized, &Error{Code: "invalid_client"})
+		return
+	}
+	switch r.PostFormValue("grant_type") {
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		if server.pendingPolls > 0 {
+			server.pendingPolls--
+			writeJSON(w, http.StatusBadRequest, &Error{Code: "authorization_pending"})
+			return
+		}
+	case "authorization_code":
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "authorization-code" || base64.RawURLEncoding.EncodeToString(challenge[:]) != server.codeChallenge {
+			writeJSON(w, http.StatusBadRequest, &Error{Code: "invalid_grant"})
+			return
+		}
+	case "refresh_token":
+		if server.revoked {
+			writeJSON(w, http.StatusBadRequest, &Error{Code: "invalid_grant", Description: "refresh token revoked"})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusBadRequest, &Error{Code: "unsupported_grant_type"})
+		return
+	}
+	server.refreshTokenCount++
+	writeJSON(w, http.StatusOK, &Token{AccessToken: "access-" + r.PostFormValue("grant_type"), TokenType: "Bearer",
+		RefreshToken: "refresh-" + string(rune('0'+server.refreshTokenCount)), ExpiresIn: 3600})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(value)
+}
+
+func newTestClient(server *testAuthorizationServer, flow string) *Client {
+	return NewClient(&config.OAuthConfig{Flow: flow, ClientID: "trivrost", DeviceAuthorizationURL: server.URL + "/device",
+		AuthorizationURL: server.URL + "/authorize", TokenURL: server.URL + "/token", Scopes: []string{"bundles"}, Hosts: []string{"example.com"}},
+		server.Client())
+}
+
+func TestDeviceFlow(t *testing.T) {
+	defer func(interval time.Duration) { defaultPollInterval = interval }(defaultPollInterval)
+	defaultPollInterval = time.Millisecond
+	server := newTestAuthorizationServer(t)
+	server.pendingPolls = 2
+	client := newTestClient(server, config.OAuthFlowDevice)
+	authorization, err := client.AuthorizeDevice(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization.UserCode != "ABCD-EFGH" {
+		t.Errorf("Unexpected user code %q", authorization.UserCode)
+	}
+	token, err := client.AwaitDeviceToken(context.Background(), authorization)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-urn:ietf:params:oauth:grant-type:device_code" || server.pendingPolls != 0 {
+		t.Errorf("Unexpected token %+v after %d pending polls", token, server.pendingPolls)
+	}
+	if token.isExpired() || token.Expiry.IsZero() {
+		t.Errorf("Expected token to expire in the future. Expiry: %v", token.Expiry)
+	}
+}
+
+func TestDeviceFlowIsCancelable(t *testing.T) {
+	server := newTestAuthorizationServer(t)
+	server.pendingPolls = 1000
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := newTestClient(server, config.OAuthFlowDevice).AwaitDeviceToken(ctx, &DeviceAuthorization{DeviceCode: "device-code", Interval: 1})
+	if err == nil {
+		t.Fatal("Expected polling to end with the context.")
+	}
+}
+
+func TestPKCEFlow(t *testing.T) {
+	server := newTestAuthorizationServer(t)
+	client := newTestClient(server, config.OAuthFlowPKCE)
+	token, err := client.AuthorizeInBrowser(context.Background(), func(authorizationURL string) error {
+		go func() { // The "browser" follows the redirect to the loopback listener of the client.
+			resp, err := http.Get(authorizationURL)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-authorization_code" {
+		t.Errorf("Unexpected token %+v", token)
+	}
+}
+
+func TestTokenSourceRefreshesExpiredToken(t *testing.T) {
+	server := newTestAuthorizationServer(t)
+	var storedRefreshTokens []string
+	source := NewTokenSource(newTestClient(server, config.OAuthFlowDevice), &Token{AccessToken: "old", RefreshToken: "refresh-0",
+		Expiry: time.Now().Add(-time.Second)}, func(refreshToken string) { storedRefreshTokens = append(storedRefreshTokens, refreshToken) })
+	accessToken, err := source.AccessToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken != "access-refresh_token" || len(storedRefreshTokens) != 1 || storedRefreshTokens[0] != "refresh-1" {
+		t.Errorf("Unexpected access token %q and stored refresh tokens %q", accessToken, storedRefreshTokens)
+	}
+	if accessToken, _ = source.AccessToken(context.Background()); accessToken != "access-refresh_token" || server.refreshTokenCount != 1 {
+		t.Errorf("Expected valid access token to be reused. Got %q after %d refreshes", accessToken, server.refreshTokenCount)
+	}
+}
+
+func TestTokenSourceForgetsRevokedRefreshToken(t *testing.T) {
+	server := newTestAuthorizationServer(t)
+	server.revoked = true
+	storedRefreshTokens := []string{"refresh-0"}
+	source := NewTokenSource(newTestClient(server, config.OAuthFlowDevice), &Token{AccessToken: "old", RefreshToken: "refresh-0",
+		Expiry: time.Now().Add(-time.Second)}, func(refreshToken string) { storedRefreshTokens = append(storedRefreshTokens, refreshToken) })
+	if _, err := source.AccessToken(context.Background()); !IsInvalidGrant(err) {
+		t.Fatalf("Expected invalid_grant error. Got %v", err)
+	}
+	if storedRefreshTokens[len(storedRefreshTokens)-1] != "" {
+		t.Errorf("Expected revoked refresh token to be forgotten. Got %q", storedRefreshTokens)
+	}
+}
+
+func TestRefreshTokenStore(t *testing.T) {
+	d, err := ioutil.TempDir("", "trivrost-oauth-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	filePath := filepath.Join(d, "refresh-token")
+	if err = WriteRefreshToken(filePath, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if refreshToken, err := ReadRefreshToken(filePath); err != nil || refreshToken != "secret" {
+		t.Errorf("Expected refresh token \"secret\". Got %q, %v", refreshToken, err)
+	}
+	if info, err := os.Stat(filePath); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0600) {
+		t.Errorf("Expected refresh token file to be readable by its owner only. Got %v, %v", info.Mode(), err)
+	}
+	if err = WriteRefreshToken(filePath, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadRefreshToken(filePath); !os.IsNotExist(err) {
+		t.Errorf("Expected refresh token file to be deleted. Got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+const callbackPath = "/callback"
+
+const callbackPage = `<!DOCTYPE html><html><head><meta charset="utf-8"><title>%[1]s</title></head><body><p>%[1]s</p></body></html>`
+
+type authorizationResult struct {
+	code string
+	err  error
+}
+
+// AuthorizeInBrowser runs the authorization code grant with PKCE: it calls openBrowser with the URL the user has to visit
+// to sign in, receives the authorization code on a loopback redirect and exchanges it for a token.
+func (client *Client) AuthorizeInBrowser(ctx context.Context, openBrowser func(authorizationURL string) error) (*Token, error) {
+	verifier, state := randomString(), randomString()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("could not listen for the authorization redirect: %w", err)
+	}
+	redirectURI := fmt.Sprintf("http://%s%s", listener.Addr().String(), callbackPath)
+	results := make(chan authorizationResult, 1)
+	server := &http.Server{Handler: callbackHandler(state, results)}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authorizationURL, err := client.authorizationURL(redirectURI, state, verifier)
+	if err != nil {
+		return nil, err
+	}
+	if err = openBrowser(authorizationURL); err != nil {
+		return nil, fmt.Errorf("could not open browser: %w", err)
+	}
+	var result authorizationResult
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result = <-results:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	return client.requestToken(ctx, url.Values{"grant_type": {"authorization_code"}, "code": {result.code},
+		"redirect_uri": {redirectURI}, "code_verifier": {verifier}})
+}
+
+func (client *Client) authorizationURL(redirectURI, state, verifier string) (string, error) {
+	authorizationURL, err := url.Parse(client.config.AuthorizationURL)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := authorizationURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", client.config.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("state", state)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	if scope := client.scope(); scope != "" {
+		query.Set("scope", scope)
+	}
+	authorizationURL.RawQuery = query.Encode()
+	return authorizationURL.String(), nil
+}
+
+func callbackHandler(state string, results chan<- authorizationResult) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("state") != state { // Not the response to our request; maybe forged. Keep waiting for the real one.
+			http.Error(w, "Invalid state.", http.StatusBadRequest)
+			return
+		}
+		var result authorizationResult
+		if code := query.Get("error"); code != "" {
+			result.err = &Error{Code: code, Description: query.Get("error_description")}
+			fmt.Fprintf(w, callbackPage, "Sign-in failed. You can close this window.")
+		} else if result.code = query.Get("code"); result.code == "" {
+			result.err = fmt.Errorf("authorization redirect contains no code")
+			fmt.Fprintf(w, callbackPage, "Sign-in failed. You can close this window.")
+		} else {
+			fmt.Fprintf(w, callbackPage, "Sign-in complete. You can close this window.")
+		}
+		select {
+		case results <- result:
+		default: // Only the first result counts.
+		}
+	})
+	return mux
+}
+
+// randomString returns 256 random bits, which suffice for both state and code verifier (RFC 7636, section 7.1).
+func randomString() string {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		panic(fmt.Sprintf("Could not generate random data: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,51 @@
+package oauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadRefreshToken returns the refresh token stored in the file at filePath.
+func ReadRefreshToken(filePath string) (string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// WriteRefreshToken stores refreshToken in the file at filePath, which only the current user may read. An empty refreshToken
+// deletes the file.
+func WriteRefreshToken(filePath string, refreshToken string) error {
+	if refreshToken == "" {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return err
+	}
+	// Write to a new file first, so that the permissions are in place before the token is and an interrupted write
+	// does not destroy the previous token.
+	tempFilePath := filePath + ".new"
+	if err := os.Remove(tempFilePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.OpenFile(tempFilePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(refreshToken)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFilePath)
+		return fmt.Errorf("could not write refresh token: %w", err)
+	}
+	return os.Rename(tempFilePath, filePath)
+}
@@ -0,0 +1,47 @@
+package oauth
+
+import (
+	"context"
+	"sync"
+)
+
+// TokenSource provides the access token of a Token and refreshes it once it has expired. It is safe for concurrent use.
+type TokenSource struct {
+	client         *Client
+	token          *Token
+	onRefreshToken func(refreshToken string)
+	mutex          sync.Mutex
+}
+
+// NewTokenSource returns a TokenSource starting out with token. onRefreshToken, if not nil, is called with each new
+// refresh token so that it can be stored, and with an empty string once the refresh token has become invalid.
+func NewTokenSource(client *Client, token *Token, onRefreshToken func(refreshToken string)) *TokenSource {
+	return &TokenSource{client: client, token: token, onRefreshToken: onRefreshToken}
+}
+
+// AccessToken returns a valid access token, refreshing it first if it has expired.
+func (source *TokenSource) AccessToken(ctx context.Context) (string, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	if source.token.isExpired() && source.token.RefreshToken != "" {
+		token, err := source.client.Refresh(ctx, source.token.RefreshToken)
+		if err != nil {
+			if IsInvalidGrant(err) {
+				source.token.RefreshToken = ""
+				source.notify("")
+			}
+			return "", err
+		}
+		if token.RefreshToken != source.token.RefreshToken {
+			source.notify(token.RefreshToken)
+		}
+		source.token = token
+	}
+	return source.token.AccessToken, nil
+}
+
+func (source *TokenSource) notify(refreshToken string) {
+	if source.onRefreshToken != nil {
+		source.onRefreshToken(refreshToken)
+	}
+}
@@ -0,0 +1,44 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+// EWMA is an exponentially weighted moving average of values which are observed at irregular intervals. The weight of
+// a value halves with every half-life which passes after it has been observed.
+type EWMA struct {
+	halfLife      time.Duration
+	value         float64
+	isInitialized bool
+}
+
+// NewEWMA constructs a new EWMA with the given half-life.
+func NewEWMA(halfLife time.Duration) *EWMA {
+	return &EWMA{halfLife: halfLife}
+}
+
+// Add adds value, which has been observed over the given duration, to the average. The first value is taken as it is.
+func (ewma *EWMA) Add(value float64, duration time.Duration) {
+	if !ewma.isInitialized {
+		ewma.value, ewma.isInitialized = value, true
+		return
+	}
+	weight := 1 - math.Exp2(-duration.Seconds()/ewma.halfLife.Seconds())
+	ewma.value += weight * (value - ewma.value)
+}
+
+// Value returns the average, or 0 if Add() has not been called yet.
+func (ewma *EWMA) Value() float64 {
+	return ewma.value
+}
+
+// IsInitialized returns true if Add() has been called.
+func (ewma *EWMA) IsInitialized() bool {
+	return ewma.isInitialized
+}
+
+// Reset returns this EWMA to its initial state, as if it had just been returned from NewEWMA().
+func (ewma *EWMA) Reset() {
+	ewma.value, ewma.isInitialized = 0, false
+}
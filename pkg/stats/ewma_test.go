@@ -0,0 +1,32 @@
+package stats_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/stats"
+)
+
+func TestEWMAHalvesWeightPerHalfLife(t *testing.T) {
+	ewma := stats.NewEWMA(time.Second * 2)
+	if ewma.IsInitialized() || ewma.Value() != 0 {
+		t.Fatalf("Expected uninitialized EWMA with value 0. Got %v, %f", ewma.IsInitialized(), ewma.Value())
+	}
+	ewma.Add(1000, time.Second)
+	if ewma.Value() != 1000 {
+		t.Fatalf("Expected first value to be taken as it is. Got %f", ewma.Value())
+	}
+	ewma.Add(0, time.Second*2)
+	if math.Abs(ewma.Value()-500) > 0.001 {
+		t.Errorf("Expected value 500 after one half-life. Got %f", ewma.Value())
+	}
+	ewma.Add(0, time.Second*4)
+	if math.Abs(ewma.Value()-125) > 0.001 {
+		t.Errorf("Expected value 125 after two more half-lives. Got %f", ewma.Value())
+	}
+	ewma.Reset()
+	if ewma.IsInitialized() || ewma.Value() != 0 {
+		t.Errorf("Expected reset EWMA to be uninitialized with value 0. Got %v, %f", ewma.IsInitialized(), ewma.Value())
+	}
+}
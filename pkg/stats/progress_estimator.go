@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ProgressEstimate describes how far a stage of work, such as downloading a number of bytes, has come and how long the
+// rest of it will probably take.
+type ProgressEstimate struct {
+	Done          uint64
+	Total         uint64
+	Remaining     uint64
+	RatePerSecond float64       // Smoothed rate at which units are done.
+	TimeRemaining time.Duration // Only valid if HasTimeRemaining is true.
+
+	HasTimeRemaining bool // False until the rate has been measured, while the rate is 0, and if the total is unknown.
+}
+
+// ProgressEstimator estimates the rate and remaining time of a stage of work with a known total from samples of the
+// number of units done. It is safe for concurrent use.
+type ProgressEstimator struct {
+	mutex          sync.Mutex
+	rate           *EWMA
+	total          uint64
+	done           uint64 // The most units which have been done, so that the estimate does not jump back when work starts over.
+	lastDone       uint64
+	lastSampleTime time.Time
+	isStalled      bool
+}
+
+// NewProgressEstimator constructs a new ProgressEstimator which smoothes the rate with the given half-life.
+func NewProgressEstimator(halfLife time.Duration) *ProgressEstimator {
+	return &ProgressEstimator{rate: NewEWMA(halfLife)}
+}
+
+// Reset starts a new stage with the given total.
+func (estimator *ProgressEstimator) Reset(total uint64) {
+	estimator.mutex.Lock()
+	defer estimator.mutex.Unlock()
+	estimator.rate.Reset()
+	estimator.total, estimator.done, estimator.lastDone = total, 0, 0
+	estimator.lastSampleTime = time.Time{}
+	estimator.isStalled = false
+}
+
+// SetStalled marks the work as stalled, e.g. while failed requests are being retried, or as running again. While the
+// work is stalled, samples without progress leave the rate and thus the remaining time as they are, so that the
+// estimate holds steady through short interruptions instead of growing towards infinity.
+func (estimator *ProgressEstimator) SetStalled(isStalled bool) {
+	estimator.mutex.Lock()
+	defer estimator.mutex.Unlock()
+	estimator.isStalled = isStalled
+}
+
+// Sample records that done units have been done at time now and returns the resulting estimate. A decrease of done,
+// e.g. because a download started over, counts as no progress, and the estimate keeps the most units done so far.
+func (estimator *ProgressEstimator) Sample(done uint64, now time.Time) ProgressEstimate {
+	estimator.mutex.Lock()
+	defer estimator.mutex.Unlock()
+	if !estimator.lastSampleTime.IsZero() && now.After(estimator.lastSampleTime) {
+		var delta uint64
+		if done > estimator.lastDone {
+			delta = done - estimator.lastDone
+		}
+		if delta > 0 || !estimator.isStalled {
+			elapsed := now.Sub(estimator.lastSampleTime)
+			estimator.rate.Add(float64(delta)/elapsed.Seconds(), elapsed)
+		}
+	}
+	if done > estimator.done {
+		estimator.done = done
+	}
+	estimator.lastDone, estimator.lastSampleTime = done, now
+	return estimator.estimate()
+}
+
+// Estimate returns the estimate as of the last sample.
+func (estimator *ProgressEstimator) Estimate() ProgressEstimate {
+	estimator.mutex.Lock()
+	defer estimator.mutex.Unlock()
+	return estimator.estimate()
+}
+
+func (estimator *ProgressEstimator) estimate() ProgressEstimate {
+	estimate := ProgressEstimate{Done: estimator.done, Total: estimator.total, RatePerSecond: estimator.rate.Value()}
+	if estimator.done < estimator.total {
+		estimate.Remaining = estimator.total - estimator.done
+	}
+	if estimator.total > 0 && estimator.rate.IsInitialized() && estimate.RatePerSecond > 0 {
+		seconds := math.Min(float64(estimate.Remaining)/estimate.RatePerSecond, math.MaxInt64/float64(time.Second))
+		estimate.TimeRemaining = time.Duration(seconds * float64(time.Second))
+		estimate.HasTimeRemaining = true
+	}
+	return estimate
+}
@@ -0,0 +1,61 @@
+package stats_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/setlog/trivrost/pkg/stats"
+)
+
+func TestProgressEstimatorConstantRate(t *testing.T) {
+	estimator := stats.NewProgressEstimator(time.Second * 5)
+	estimator.Reset(10000)
+	now := time.Unix(1500000000, 0)
+	estimate := estimator.Sample(0, now)
+	if estimate.HasTimeRemaining || estimate.Remaining != 10000 {
+		t.Fatalf("Expected no time remaining before the rate is known and 10000 units remaining. Got %+v", estimate)
+	}
+	for done := uint64(1000); done <= 4000; done += 1000 {
+		now = now.Add(time.Second)
+		estimate = estimator.Sample(done, now)
+	}
+	if int(estimate.RatePerSecond+0.5) != 1000 || estimate.Remaining != 6000 {
+		t.Errorf("Expected rate of 1000 and 6000 units remaining. Got %+v", estimate)
+	}
+	if !estimate.HasTimeRemaining || estimate.TimeRemaining.Round(time.Second) != time.Second*6 {
+		t.Errorf("Expected 6 seconds remaining. Got %+v", estimate)
+	}
+}
+
+func TestProgressEstimatorHoldsSteadyWhileStalled(t *testing.T) {
+	estimator := stats.NewProgressEstimator(time.Second * 5)
+	estimator.Reset(10000)
+	now := time.Unix(1500000000, 0)
+	estimator.Sample(0, now)
+	now = now.Add(time.Second)
+	before := estimator.Sample(1000, now)
+
+	estimator.SetStalled(true)
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		estimator.Sample(500, now) // A download which started over does not make the rate negative.
+	}
+	if after := estimator.Estimate(); after.RatePerSecond != before.RatePerSecond || after.TimeRemaining != before.TimeRemaining {
+		t.Errorf("Expected estimate to hold steady while stalled. Got %+v before and %+v after", before, after)
+	}
+
+	estimator.SetStalled(false)
+	now = now.Add(time.Second)
+	if after := estimator.Sample(500, now); after.RatePerSecond >= before.RatePerSecond {
+		t.Errorf("Expected rate to drop without progress once no longer stalled. Got %+v before and %+v after", before, after)
+	}
+}
+
+func TestProgressEstimatorWithoutTotal(t *testing.T) {
+	estimator := stats.NewProgressEstimator(time.Second * 5)
+	now := time.Unix(1500000000, 0)
+	estimator.Sample(0, now)
+	if estimate := estimator.Sample(1000, now.Add(time.Second)); estimate.HasTimeRemaining || estimate.RatePerSecond != 1000 {
+		t.Errorf("Expected rate but no time remaining without total. Got %+v", estimate)
+	}
+}
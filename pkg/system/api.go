@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 
 	"github.com/shirou/gopsutil/v4/process"
 	log "github.com/sirupsen/logrus"
@@ -143,3 +144,34 @@ func buildEnvironmentVariables(extraEnvs map[string]*string) []string {
 func ShowLocalFileInFileManager(path string) error {
 	return showLocalFileInFileManager(path)
 }
+
+// OpenURL opens url with the default browser of the user.
+func OpenURL(url string) error {
+	return openURL(url)
+}
+
+// GetFreeDiskSpace returns the number of bytes which are available to the current user on the volume which contains the
+// file or folder at path. If path does not exist yet, the volume of its nearest existing ancestor is used.
+func GetFreeDiskSpace(path string) (uint64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err = os.Stat(path); err == nil || !os.IsNotExist(err) {
+			break
+		}
+		parentPath := filepath.Dir(path)
+		if parentPath == path {
+			break
+		}
+		path = parentPath
+	}
+	return getFreeDiskSpace(path)
+}
+
+// GetFileID returns a number which identifies the file at filePath on its volume for as long as it exists, e.g. its inode
+// number. info must have been obtained for filePath. Replacing a file, e.g. by renaming another one over it, changes its ID.
+func GetFileID(filePath string, info os.FileInfo) (uint64, error) {
+	return getFileID(filePath, info)
+}
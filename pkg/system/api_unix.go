@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"golang.org/x/sys/unix"
 )
@@ -44,6 +45,28 @@ func showLocalFileInFileManager(path string) error {
 	return cmd.Run()
 }
 
+func openURL(url string) error {
+	if runtime.GOOS == OsMac {
+		return exec.Command("open", url).Run()
+	}
+	return exec.Command("xdg-open", url).Run()
+}
+
 func isProcessRunning(p *os.Process) bool {
 	return p.Signal(unix.Signal(0)) == nil
 }
+
+func getFreeDiskSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+func getFileID(filePath string, info os.FileInfo) (uint64, error) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino), nil
+	}
+	return 0, fmt.Errorf("no inode number known for \"%s\"", filePath)
+}
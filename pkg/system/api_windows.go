@@ -49,6 +49,10 @@ func showLocalFileInFileManager(path string) error {
 	return nil
 }
 
+func openURL(url string) error {
+	return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+}
+
 func isProcessRunning(p *os.Process) bool {
 	handle := C.OpenProcess(C.PROCESS_QUERY_INFORMATION, C.FALSE, C.DWORD(p.Pid))
 	if handle == C.HANDLE(C.NULL) {
@@ -59,3 +63,33 @@ func isProcessRunning(p *os.Process) bool {
 	result := C.GetExitCodeProcess(handle, &lpExitCode)
 	return (result != 0) && (lpExitCode == C.STILL_ACTIVE)
 }
+
+func getFreeDiskSpace(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var freeBytesAvailable uint64
+	if err = windows.GetDiskFreeSpaceEx(pathPtr, &freeBytesAvailable, nil, nil); err != nil {
+		return 0, err
+	}
+	return freeBytesAvailable, nil
+}
+
+func getFileID(filePath string, info os.FileInfo) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(filePath)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := windows.CreateFile(pathPtr, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(handle)
+	var fileInformation windows.ByHandleFileInformation
+	if err = windows.GetFileInformationByHandle(handle, &fileInformation); err != nil {
+		return 0, err
+	}
+	return uint64(fileInformation.FileIndexHigh)<<32 | uint64(fileInformation.FileIndexLow), nil
+}
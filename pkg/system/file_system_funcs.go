@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 
 	log "github.com/sirupsen/logrus"
@@ -76,6 +77,15 @@ func MustMakeDir(dirPath string) {
 	}
 }
 
+// MustCreateSymlink creates a symbolic link at linkPath which points to target, and the directories leading to it. On Windows,
+// this requires the privilege to create symbolic links, which users have in developer mode.
+func MustCreateSymlink(linkPath, target string) {
+	MustMakeDir(filepath.Dir(linkPath))
+	if err := os.Symlink(target, linkPath); err != nil {
+		panic(&FileSystemError{fmt.Sprintf("Could not create symbolic link \"%s\" pointing to \"%s\"", linkPath, target), err})
+	}
+}
+
 func MustPutFile(localFilePath string, bytes []byte) {
 	os.Remove(localFilePath)
 	dir := filepath.Dir(localFilePath)
@@ -98,6 +108,46 @@ func MustPutFile(localFilePath string, bytes []byte) {
 	}
 }
 
+// MustPutFileAtomically is like PutFileAtomically, but panics with a *FileSystemError if the file cannot be written.
+func MustPutFileAtomically(localFilePath string, bytes []byte) {
+	if err := PutFileAtomically(localFilePath, bytes); err != nil {
+		panic(err)
+	}
+}
+
+// PutFileAtomically writes bytes to a temporary file next to localFilePath, flushes it to disk and then renames
+// it to localFilePath, so that a crash leaves either the previous or the new content in place, but never a mix of both.
+func PutFileAtomically(localFilePath string, bytes []byte) error {
+	dir := filepath.Dir(localFilePath)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return &FileSystemError{fmt.Sprintf("Could not create nested directory structure \"%s\" to put file \"%s\"", dir, localFilePath), err}
+	}
+	tempFilePath := localFilePath + ".tmp"
+	file, err := os.OpenFile(tempFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return &FileSystemError{fmt.Sprintf("Could not open file \"%s\" for writing", tempFilePath), err}
+	}
+	_, err = file.Write(bytes)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFilePath)
+		return &FileSystemError{fmt.Sprintf("Could not write file \"%s\"", tempFilePath), err}
+	}
+	err = os.Rename(tempFilePath, localFilePath)
+	if err != nil {
+		os.Remove(tempFilePath)
+		return &FileSystemError{fmt.Sprintf("Could not rename file \"%s\" to \"%s\"", tempFilePath, localFilePath), err}
+	}
+	return nil
+}
+
 func MustReadFile(filePath string) []byte {
 	data, err := ioutil.ReadFile(filePath)
 	if err != nil {
@@ -122,6 +172,20 @@ func MustCopyFile(from, to string) {
 	}
 }
 
+// SupportsFileModes is false on Windows, where the permission bits of files only control whether they are read-only.
+const SupportsFileModes = runtime.GOOS != OsWindows
+
+// SetFileMode changes the permission bits of the file at filePath to those of mode. It does nothing unless SupportsFileModes.
+func SetFileMode(filePath string, mode os.FileMode) error {
+	if !SupportsFileModes {
+		return nil
+	}
+	if err := os.Chmod(filePath, mode.Perm()); err != nil {
+		return NewFileSystemError(fmt.Sprintf("Could not change mode of file \"%s\" to %04o", filePath, mode.Perm()), err)
+	}
+	return nil
+}
+
 // Move the file or folder at src to dst. If dst is taken by an existing file or folder, it will be removed beforehand.
 func MustMoveAll(src, dst string) {
 	srcInfo, _ := mustPrepareFileSystemOperation(src, dst)